@@ -8,8 +8,6 @@ import (
 	"strings"
 	"text-editor/editor"
 
-	"github.com/alecthomas/chroma"
-	"github.com/alecthomas/chroma/lexers"
 	"github.com/fatih/color"
 )
 
@@ -21,6 +19,9 @@ func highlightAndPrint(code, filename string) {
 	padding := len(fmt.Sprintf("%d", len(lines)))
 	
 	fmt.Println() // Add a newline before content
+
+	// Detect the language once using the same rules as the editor
+	lexer := editor.LexerFor(filename, lines)
 	
 	// Print each line with its number
 	for i, line := range lines {
@@ -28,18 +29,6 @@ func highlightAndPrint(code, filename string) {
 		lineNum := color.New(color.FgYellow).Sprintf("%*d", padding, i+1)
 		fmt.Printf("%s │ ", lineNum)
 		
-		// Detect language and highlight the line
-		var lexer chroma.Lexer
-		if filename != "" && len(filename) > 0 {
-			ext := strings.ToLower(filepath.Ext(filename))
-			if ext != "" {
-				lexer = lexers.Get(ext[1:])
-			}
-		}
-		if lexer == nil {
-			lexer = lexers.Fallback
-		}
-
 		iterator, err := lexer.Tokenise(nil, line)
 		if err != nil {
 			fmt.Println(line)
@@ -0,0 +1,126 @@
+package editor
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// Autosave.
+//
+// Changed files can be saved without :w, each trigger turned on by its
+// own option: autoSaveDelay seconds after typing stops, saveOnFocusLost
+// when the terminal loses focus, saveOnSwitch when going to another
+// buffer and saveOnExit when quitting (but not with :q!). They save like
+// :w does, to a temporary file that is renamed over the file, and only
+// files that exist already; a new file is created with :w.
+//
+// The status bar tells when the buffer was last saved this way.
+
+// autoSave saves the current buffer if it has changes and can be saved
+// automatically.
+func (e *Editor) autoSave() error {
+	if !e.isDirty || e.readOnly || e.filename == "" {
+		return nil
+	}
+	if _, err := os.Stat(e.filename); err != nil {
+		return nil
+	}
+	if err := e.writeBuffer(); err != nil {
+		return fmt.Errorf("autosave of %s failed: %v", bufferName(e.filename), err)
+	}
+	e.autoSaved = time.Now()
+	return nil
+}
+
+// autoSaveBuffer is autoSave for a buffer other than the current one,
+// saved from where it is stashed so the current one isn't disturbed.
+func (e *Editor) autoSaveBuffer(b *Buffer) error {
+	if !b.isDirty || b.readOnly || b.filename == "" {
+		return nil
+	}
+	if _, err := os.Stat(b.filename); err != nil {
+		return nil
+	}
+	if b.settings == nil {
+		b.settings = e.bufferSettings(b)
+	}
+	data, err := e.encodeLines(b.lines, b.settings)
+	if err == nil {
+		err = writeFileThrough(b.filename, data)
+	}
+	if err != nil {
+		return fmt.Errorf("autosave of %s failed: %v", bufferName(b.filename), err)
+	}
+	b.isDirty = false
+	b.autoSaved = time.Now()
+	e.refreshGitStatus()
+	e.lspSavedFile(b.filename)
+	return nil
+}
+
+// autoSaveAll saves every buffer that autoSave would. It stops at the
+// first that fails.
+func (e *Editor) autoSaveAll() error {
+	for i, b := range e.buffers {
+		if i == e.bufferIndex {
+			continue
+		}
+		if err := e.autoSaveBuffer(b); err != nil {
+			return err
+		}
+	}
+	return e.autoSave()
+}
+
+// syncAutoSave saves the current buffer once typing has stopped for
+// autoSaveDelay seconds.
+func (e *Editor) syncAutoSave() {
+	delay := time.Duration(e.intOption("autoSaveDelay")) * time.Second
+	if delay == 0 || !e.isDirty {
+		return
+	}
+	if wait := delay - time.Since(e.lastInput); wait > 0 {
+		e.wakeAfter(wait)
+		return
+	}
+	if err := e.autoSave(); err != nil {
+		e.setStatusMessage(err.Error())
+	}
+}
+
+// autoSaveOn runs the trigger turned on by option, telling of a failure.
+func (e *Editor) autoSaveOn(option string) {
+	if !e.boolOption(option) {
+		return
+	}
+	var err error
+	if option == "saveOnSwitch" {
+		err = e.autoSave()
+	} else {
+		err = e.autoSaveAll()
+	}
+	if err != nil {
+		e.setStatusMessage(err.Error())
+	}
+}
+
+// wakeAfter makes the main loop run again after d, for work that is due
+// once typing stops. Only the earliest of the times asked for is kept.
+func (e *Editor) wakeAfter(d time.Duration) {
+	at := time.Now().Add(d)
+	if !e.wakeAt.IsZero() && !at.Before(e.wakeAt) {
+		return
+	}
+	e.wakeAt = at
+	if e.mainQueue == nil {
+		e.mainQueue = make(chan func(), 256)
+	}
+	time.AfterFunc(d, func() {
+		e.post(func() {
+			if e.wakeAt.Equal(at) {
+				e.wakeAt = time.Time{}
+			}
+		})
+	})
+}
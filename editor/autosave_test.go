@@ -0,0 +1,142 @@
+package editor
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAutoSave(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "a.txt", "a\n")
+	writeTestFile(t, dir, "b.txt", "b\n")
+	os.Chmod(filepath.Join(dir, "a.txt"), 0600)
+	os.Symlink("b.txt", filepath.Join(dir, "link.txt"))
+	contents := func(name string) string {
+		data, _ := os.ReadFile(filepath.Join(dir, name))
+		return string(data)
+	}
+	open := func(settings map[string]string, names ...string) *Editor {
+		ed := &Editor{mode: "normal", settings: settings}
+		ed.applySettings()
+		for _, name := range names {
+			if err := ed.openFile(filepath.Join(dir, name)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return ed
+	}
+
+	// After typing stops
+	ed := open(map[string]string{"autoSaveDelay": "5"}, "a.txt")
+	typeKeys(ed, "i1<Esc>")
+	ed.lastInput = time.Now()
+	ed.syncAutoSave()
+	if contents("a.txt") != "a\n" || ed.wakeAt.IsZero() {
+		t.Errorf("saved while typing")
+	}
+	ed.lastInput = time.Now().Add(-5 * time.Second)
+	ed.syncAutoSave()
+	if contents("a.txt") != "1a\n" || ed.isDirty {
+		t.Errorf("not saved once typing stopped: %q", contents("a.txt"))
+	}
+	ed.updateStatus()
+	if !strings.Contains(ed.statusLine, "autosaved") {
+		t.Errorf("status %q", ed.statusLine)
+	}
+	if info, _ := os.Stat(filepath.Join(dir, "a.txt")); info.Mode().Perm() != 0600 {
+		t.Errorf("saving made the file %v", info.Mode())
+	}
+
+	// Going to another buffer, through a symlink
+	ed = open(map[string]string{"saveOnSwitch": "true"}, "a.txt", "link.txt")
+	typeKeys(ed, "i2<Esc>")
+	ed.switchBuffer(0)
+	if contents("b.txt") != "2b\n" {
+		t.Errorf("not saved on switching: %q", contents("b.txt"))
+	}
+	if info, _ := os.Lstat(filepath.Join(dir, "link.txt")); info.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("saving replaced the symlink")
+	}
+
+	// Losing focus saves the other buffers where they are, leaving the
+	// current one's search and completion alone
+	ed = open(map[string]string{"saveOnFocusLost": "true"}, "a.txt", "b.txt")
+	typeKeys(ed, "i4<Esc>")
+	ed.switchBuffer(0)
+	typeKeys(ed, "i4<Esc>")
+	ed.searchMatches = []struct{ y, x int }{{0, 0}}
+	ed.completionActive = true
+	ed.autoSaveOn("saveOnFocusLost")
+	if contents("a.txt") != "41a\n" || contents("b.txt") != "42b\n" || ed.buffers[1].isDirty {
+		t.Errorf("focus lost saved %q and %q", contents("a.txt"), contents("b.txt"))
+	}
+	if ed.bufferIndex != 0 || ed.searchMatches == nil || !ed.completionActive {
+		t.Errorf("saving disturbed buffer %d", ed.bufferIndex)
+	}
+
+	// Quitting saves every buffer, but not with :q! or new files
+	ed = open(map[string]string{"saveOnExit": "true"}, "a.txt", "b.txt")
+	for i := range ed.buffers {
+		ed.switchBuffer(i)
+		typeKeys(ed, "i3<Esc>")
+	}
+	ed.switchBuffer(1)
+	ed.commandBuffer = "q!"
+	ed.handleCommand()
+	if contents("a.txt") != "41a\n" {
+		t.Errorf(":q! saved")
+	}
+	ed.quit = false
+	ed.commandBuffer = "q"
+	ed.handleCommand()
+	if contents("a.txt") != "341a\n" || contents("b.txt") != "342b\n" || ed.bufferIndex != 1 {
+		t.Errorf("quitting saved %q and %q, in buffer %d", contents("a.txt"), contents("b.txt"), ed.bufferIndex)
+	}
+	if !ed.quit {
+		t.Errorf(":q didn't quit")
+	}
+	ed = open(map[string]string{"saveOnExit": "true"})
+	ed.SetFilename(filepath.Join(dir, "new.txt"))
+	typeKeys(ed, "i3<Esc>")
+	ed.commandBuffer = "q"
+	ed.handleCommand()
+	if _, err := os.Stat(filepath.Join(dir, "new.txt")); err == nil || ed.quit {
+		t.Errorf("new file autosaved")
+	}
+}
+
+func TestBackupFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "a.txt", "a\n")
+	path := filepath.Join(dir, "a.txt")
+	ed := &Editor{mode: "normal"}
+	ed.applySettings()
+	if err := ed.openFile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	typeKeys(ed, "i1<Esc>")
+	ed.commandBuffer = "w"
+	ed.handleCommand()
+	if data, _ := os.ReadFile(path + ".bak"); string(data) != "a\n" {
+		t.Errorf("backup has %q", data)
+	}
+
+	// Each save backs up the one before, unless turned off
+	typeKeys(ed, "i2<Esc>")
+	ed.commandBuffer = "w"
+	ed.handleCommand()
+	if data, _ := os.ReadFile(path + ".bak"); string(data) != "1a\n" {
+		t.Errorf("second backup has %q", data)
+	}
+	ed.setCommand("nobackup")
+	typeKeys(ed, "i3<Esc>")
+	ed.commandBuffer = "w"
+	ed.handleCommand()
+	if data, _ := os.ReadFile(path + ".bak"); string(data) != "1a\n" {
+		t.Errorf("backed up with backupFiles off: %q", data)
+	}
+}
@@ -0,0 +1,166 @@
+package editor
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Persistent directory bookmarks for the file tree
+
+type bookmark struct {
+	name string
+	path string
+}
+
+func bookmarksFile() string {
+	return filepath.Join(configDir(), "bookmarks")
+}
+
+// loadBookmarks reads the bookmarks file, one "name<TAB>path" per line.
+func loadBookmarks() ([]bookmark, error) {
+	file, err := os.Open(bookmarksFile())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	var bookmarks []bookmark
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), "\t", 2)
+		if len(parts) == 2 && parts[0] != "" {
+			bookmarks = append(bookmarks, bookmark{name: parts[0], path: parts[1]})
+		}
+	}
+	return bookmarks, scanner.Err()
+}
+
+func saveBookmarks(bookmarks []bookmark) error {
+	path := bookmarksFile()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	var sb strings.Builder
+	for _, b := range bookmarks {
+		fmt.Fprintf(&sb, "%s\t%s\n", b.name, b.path)
+	}
+	return os.WriteFile(path, []byte(sb.String()), 0644)
+}
+
+// addBookmark bookmarks the selected directory (or the directory of the
+// selected file). An empty name defaults to the directory's base name.
+func (e *Editor) addBookmark(name string) {
+	dir := e.currentPath
+	if node := e.getSelectedNode(); node != nil {
+		dir = node.name
+		if !node.isDir {
+			dir = filepath.Dir(node.name)
+		}
+	}
+	if name == "" {
+		name = filepath.Base(dir)
+	}
+	if strings.ContainsAny(name, "\t\n") {
+		e.SetStatusMessage("Invalid bookmark name")
+		return
+	}
+
+	bookmarks, err := loadBookmarks()
+	if err != nil {
+		e.SetStatusMessage(fmt.Sprintf("Error reading bookmarks: %v", err))
+		return
+	}
+	replaced := false
+	for i := range bookmarks {
+		if bookmarks[i].name == name {
+			bookmarks[i].path = dir
+			replaced = true
+		}
+	}
+	if !replaced {
+		bookmarks = append(bookmarks, bookmark{name: name, path: dir})
+	}
+
+	if err := saveBookmarks(bookmarks); err != nil {
+		e.SetStatusMessage(fmt.Sprintf("Error saving bookmarks: %v", err))
+		return
+	}
+	e.SetStatusMessage(fmt.Sprintf("Bookmarked %s as '%s'", dir, name))
+}
+
+func (e *Editor) removeBookmark(name string) {
+	bookmarks, err := loadBookmarks()
+	if err != nil {
+		e.SetStatusMessage(fmt.Sprintf("Error reading bookmarks: %v", err))
+		return
+	}
+
+	kept := bookmarks[:0]
+	for _, b := range bookmarks {
+		if b.name != name {
+			kept = append(kept, b)
+		}
+	}
+	if len(kept) == len(bookmarks) {
+		e.SetStatusMessage(fmt.Sprintf("No bookmark named '%s'", name))
+		return
+	}
+
+	if err := saveBookmarks(kept); err != nil {
+		e.SetStatusMessage(fmt.Sprintf("Error saving bookmarks: %v", err))
+		return
+	}
+	e.SetStatusMessage(fmt.Sprintf("Removed bookmark '%s'", name))
+}
+
+// jumpToBookmark makes the bookmarked directory the new tree root.
+func (e *Editor) jumpToBookmark(name string) {
+	bookmarks, err := loadBookmarks()
+	if err != nil {
+		e.SetStatusMessage(fmt.Sprintf("Error reading bookmarks: %v", err))
+		return
+	}
+
+	for _, b := range bookmarks {
+		if b.name == name {
+			if info, err := os.Stat(b.path); err != nil || !info.IsDir() {
+				e.SetStatusMessage(fmt.Sprintf("Bookmark '%s' points to a missing directory", name))
+				return
+			}
+			e.currentPath = b.path
+			e.treeFilter = ""
+			e.treeMatches, e.treeExpanded = nil, nil
+			e.treeSelectedLine = 0
+			e.treeVisible = true
+			e.refreshFileTree()
+			e.SetStatusMessage(fmt.Sprintf("Tree root: %s", b.path))
+			return
+		}
+	}
+	e.SetStatusMessage(fmt.Sprintf("No bookmark named '%s'", name))
+}
+
+func (e *Editor) listBookmarks() {
+	bookmarks, err := loadBookmarks()
+	if err != nil {
+		e.SetStatusMessage(fmt.Sprintf("Error reading bookmarks: %v", err))
+		return
+	}
+	if len(bookmarks) == 0 {
+		e.SetStatusMessage("No bookmarks (use :bookmark [name] to add one)")
+		return
+	}
+
+	names := make([]string, len(bookmarks))
+	for i, b := range bookmarks {
+		names[i] = b.name
+	}
+	e.SetStatusMessage("Bookmarks: " + strings.Join(names, ", ") + " (:bm <name> to jump)")
+}
@@ -0,0 +1,271 @@
+package editor
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Open buffers.
+//
+// The buffer being edited lives in the Editor's own fields (lines, cursor,
+// undo history, ...). Switching files stashes those fields in a Buffer and
+// restores the other one, so several files can stay open with their
+// unsaved changes.
+
+type Buffer struct {
+	filename  string
+	lines     []string
+	cursorX   int
+	cursorY   int
+	scrollY   int
+	scrollRow int
+	scrollX   int
+	isDirty   bool
+	version   int
+	undoStack []Action
+	redoStack []Action
+	fileType  string
+	settings  map[string]string
+
+	closedFolds map[int]bool
+	manualFolds []fold
+	marks       map[rune]Position
+	breakpoints map[int]bool
+	gitDiff     *gitDiff
+	blame       *blameView
+	gitView     *gitView
+	readOnly    bool
+	swap        *swapState
+	autoSaved   time.Time
+}
+
+// syncBuffers makes sure the current buffer has an entry in the list.
+func (e *Editor) syncBuffers() {
+	if len(e.buffers) == 0 {
+		e.buffers = []*Buffer{{}}
+		e.bufferIndex = 0
+	}
+}
+
+// stashBuffer saves the current buffer's state in its list entry.
+func (e *Editor) stashBuffer() {
+	e.syncBuffers()
+	b := e.buffers[e.bufferIndex]
+	b.filename = e.filename
+	b.lines = e.lines
+	b.cursorX, b.cursorY, b.scrollY = e.cursorX, e.cursorY, e.scrollY
+	b.scrollRow, b.scrollX = e.scrollRow, e.scrollX
+	b.isDirty, b.version = e.isDirty, e.version
+	b.undoStack, b.redoStack = e.undoStack, e.redoStack
+	b.fileType = e.fileType
+	b.settings = e.localSettings
+	b.closedFolds, b.manualFolds = e.closedFolds, e.manualFolds
+	b.marks, b.breakpoints = e.marks, e.breakpoints
+	b.gitDiff, b.blame, b.gitView = e.gitDiff, e.blame, e.gitView
+	b.readOnly, b.swap, b.autoSaved = e.readOnly, e.swap, e.autoSaved
+}
+
+// restoreBuffer makes buffer i the current one.
+func (e *Editor) restoreBuffer(i int) {
+	b := e.buffers[i]
+	e.bufferIndex = i
+	e.filename = b.filename
+	e.lines = b.lines
+	if len(e.lines) == 0 {
+		e.lines = []string{""}
+	}
+	e.cursorX, e.cursorY, e.scrollY = b.cursorX, b.cursorY, b.scrollY
+	e.scrollRow, e.scrollX = b.scrollRow, b.scrollX
+	e.isDirty, e.version = b.isDirty, b.version
+	e.undoStack, e.redoStack = b.undoStack, b.redoStack
+	e.fileType = b.fileType
+	e.localSettings = b.settings
+	e.closedFolds, e.manualFolds = b.closedFolds, b.manualFolds
+	e.marks, e.breakpoints = b.marks, b.breakpoints
+	e.gitDiff, e.blame, e.gitView = b.gitDiff, b.blame, b.gitView
+	e.readOnly, e.swap, e.autoSaved = b.readOnly, b.swap, b.autoSaved
+	e.syncedLineCount, e.syncedCursorY = len(e.lines), e.cursorY
+	e.completionActive = false
+	e.searchMatches = nil
+	e.resetLexer()
+	if e.localSettings == nil {
+		e.loadFileSettings()
+	} else {
+		e.applySettings()
+	}
+}
+
+// findBuffer returns the index of the buffer editing filename, or -1.
+func (e *Editor) findBuffer(filename string) int {
+	e.syncBuffers()
+	abs, _ := filepath.Abs(filename)
+	for i, b := range e.buffers {
+		if i == e.bufferIndex {
+			b = &Buffer{filename: e.filename}
+		}
+		if b.filename == "" {
+			continue
+		}
+		if other, _ := filepath.Abs(b.filename); other == abs {
+			return i
+		}
+	}
+	return -1
+}
+
+// openFile edits filename, switching to its buffer if it is already open.
+// The current buffer stays open unless it is an empty, unnamed one.
+func (e *Editor) openFile(filename string) error {
+	if i := e.findBuffer(filename); i >= 0 {
+		e.switchBuffer(i)
+		return nil
+	}
+
+	e.autoSaveOn("saveOnSwitch")
+	e.stashBuffer()
+	current := e.buffers[e.bufferIndex]
+	replace := current.filename == "" && !current.isDirty &&
+		len(current.lines) <= 1 && (len(current.lines) == 0 || current.lines[0] == "")
+
+	// The stashed copy puts things back if loading fails
+	e.SetFilename(filename)
+	e.fileType = ""
+	if err := e.LoadFile(filename); err != nil {
+		e.restoreBuffer(e.bufferIndex)
+		return err
+	}
+	if len(e.lines) == 0 {
+		e.lines = []string{""}
+	}
+	e.cursorX, e.cursorY, e.scrollY = 0, 0, 0
+	e.scrollRow, e.scrollX = 0, 0
+	e.isDirty = false
+	e.autoSaved = time.Time{}
+	e.undoStack, e.redoStack = nil, nil
+	e.searchMatches = nil
+	e.completionActive = false
+
+	if !replace {
+		e.buffers = append(e.buffers, &Buffer{})
+		e.bufferIndex = len(e.buffers) - 1
+	}
+	e.stashBuffer()
+	return nil
+}
+
+// switchBuffer makes buffer i the current one.
+func (e *Editor) switchBuffer(i int) {
+	if i == e.bufferIndex {
+		return
+	}
+	e.autoSaveOn("saveOnSwitch")
+	e.stashBuffer()
+	e.restoreBuffer(i)
+}
+
+// closeBuffer closes the current buffer, refusing to drop unsaved changes
+// unless force is set.
+func (e *Editor) closeBuffer(force bool) error {
+	e.syncBuffers()
+	if e.isDirty && !force {
+		return fmt.Errorf("unsaved changes, use :bd! to discard them")
+	}
+	e.lspClose(e.filename)
+	e.removeSwap(e.swap)
+	// Buffer numbers shift, and a diff needs both its sides anyway
+	e.diff = nil
+	e.buffers = append(e.buffers[:e.bufferIndex], e.buffers[e.bufferIndex+1:]...)
+	if len(e.buffers) == 0 {
+		e.buffers = []*Buffer{{}}
+	}
+	if e.bufferIndex >= len(e.buffers) {
+		e.bufferIndex = len(e.buffers) - 1
+	}
+	e.restoreBuffer(e.bufferIndex)
+	return nil
+}
+
+// otherBuffers returns the open buffers other than the current one.
+func (e *Editor) otherBuffers() []*Buffer {
+	var others []*Buffer
+	for i, b := range e.buffers {
+		if i != e.bufferIndex {
+			others = append(others, b)
+		}
+	}
+	return others
+}
+
+// modifiedBuffer returns another open buffer with unsaved changes, if any.
+func (e *Editor) modifiedBuffer() *Buffer {
+	for _, b := range e.otherBuffers() {
+		if b.isDirty {
+			return b
+		}
+	}
+	return nil
+}
+
+// bufferName is how a buffer is shown in lists.
+func bufferName(filename string) string {
+	if filename == "" {
+		return "[No Name]"
+	}
+	return filepath.Base(filename)
+}
+
+// listBuffers shows the open buffers, marking the current and modified ones.
+func (e *Editor) listBuffers() {
+	e.stashBuffer()
+	var entries []string
+	for i, b := range e.buffers {
+		entry := fmt.Sprintf("%d:%s", i+1, bufferName(b.filename))
+		if b.isDirty {
+			entry += "+"
+		}
+		if i == e.bufferIndex {
+			entry = "[" + entry + "]"
+		}
+		entries = append(entries, entry)
+	}
+	e.setStatusMessage("Buffers: " + strings.Join(entries, "  "))
+}
+
+// selectBuffer switches to a buffer given by number or file name.
+func (e *Editor) selectBuffer(arg string) error {
+	e.syncBuffers()
+	if n, err := strconv.Atoi(arg); err == nil {
+		if n < 1 || n > len(e.buffers) {
+			return fmt.Errorf("no buffer %d", n)
+		}
+		e.switchBuffer(n - 1)
+		return nil
+	}
+
+	e.stashBuffer()
+	match := -1
+	for i, b := range e.buffers {
+		if b.filename == "" || !strings.Contains(b.filename, arg) {
+			continue
+		}
+		if match >= 0 {
+			return fmt.Errorf("more than one buffer matches %q", arg)
+		}
+		match = i
+	}
+	if match < 0 {
+		return fmt.Errorf("no buffer matches %q", arg)
+	}
+	e.switchBuffer(match)
+	return nil
+}
+
+// cycleBuffer moves to the next (1) or previous (-1) buffer.
+func (e *Editor) cycleBuffer(dir int) {
+	e.syncBuffers()
+	n := len(e.buffers)
+	e.switchBuffer(((e.bufferIndex+dir)%n + n) % n)
+}
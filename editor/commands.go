@@ -1,11 +1,14 @@
 package editor
 
 import (
-	"bufio"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
+
+	"github.com/alecthomas/chroma/lexers"
 )
 
 // Command mode functionality
@@ -21,9 +24,13 @@ func (e *Editor) handleCommand() {
 			if err := e.saveFileAs(newFilename); err != nil {
 				e.setStatusMessage(fmt.Sprintf("Error saving as: %v", err))
 			} else {
+				e.lspClose(e.filename)
 				e.SetFilename(newFilename) // Update the current filename
 				e.setStatusMessage(fmt.Sprintf("File saved as %s", newFilename))
 				e.isDirty = false
+				e.gitView, e.readOnly = nil, false
+				e.refreshGitStatus()
+				e.loadGitDiff()
 			}
 		} else {
 			e.setStatusMessage("Usage: saveas <filename>")
@@ -41,110 +48,62 @@ func (e *Editor) handleCommand() {
 			e.SetStatusMessage("Usage: line <number>")
 		}
 	case "w":
-		if err := e.saveFile(); err != nil {
+		// Set first, as saving may tell of merge conflicts left instead
+		e.setStatusMessage("File saved")
+		if err := e.writeBuffer(); err != nil {
 			e.setStatusMessage(fmt.Sprintf("Error saving: %v", err))
 		} else {
-			e.setStatusMessage("File saved")
-			e.isDirty = false
+			e.autoSaved = time.Time{}
 		}
 	case "q":
+		if e.boolOption("saveOnExit") {
+			if err := e.autoSaveAll(); err != nil {
+				e.setStatusMessage(err.Error())
+				return
+			}
+		}
 		if e.isDirty {
 			e.setStatusMessage("Unsaved changes! Use :q! to force quit")
+		} else if b := e.modifiedBuffer(); b != nil {
+			e.setStatusMessage(fmt.Sprintf("Unsaved changes in %s! Use :q! to force quit", bufferName(b.filename)))
 		} else {
 			e.quit = true
 		}
 	case "q!":
 		e.quit = true
+	case "cq":
+		e.exitCode = 1
+		e.quit = true
 	case "wq":
-		if err := e.saveFile(); err == nil {
+		if err := e.writeBuffer(); err == nil {
 			e.quit = true
 		} else {
 			e.setStatusMessage(fmt.Sprintf("Error saving: %v", err))
 		}
 	case "set":
+		args := ""
 		if len(parts) > 1 {
-			settingParts := strings.SplitN(parts[1], " ", 2)
-			settingName := settingParts[0]
-
-			switch settingName {
-			case "tabsize":
-				if len(settingParts) > 1 {
-					var newTabSize int
-					_, err := fmt.Sscan(settingParts[1], &newTabSize)
-					if err == nil && newTabSize > 0 {
-						e.tabSize = newTabSize
-						e.setSetting("tabSize", settingParts[1])
-						e.setStatusMessage(fmt.Sprintf("Tab size set to %d", newTabSize))
-					} else {
-						e.setStatusMessage("Invalid tab size")
-					}
-				} else {
-					e.setStatusMessage("Usage: set tabsize <number>")
-				}
-			case "syntax":
-				if len(settingParts) > 1 {
-					if settingParts[1] == "on" {
-						e.syntaxHighlight = true
-						e.setSetting("syntaxHighlight", "true")
-						e.setStatusMessage("Syntax highlighting enabled")
-					} else if settingParts[1] == "off" {
-						e.syntaxHighlight = false
-						e.setSetting("syntaxHighlight", "false")
-						e.setStatusMessage("Syntax highlighting disabled")
-					} else {
-						e.setStatusMessage("Usage: set syntax on|off")
-					}
-				} else {
-					e.setStatusMessage("Usage: set syntax on|off")
-				}
-			case "number":
-				e.showLineNumbers = !e.showLineNumbers
-				e.setStatusMessage(fmt.Sprintf("Line numbers %s", map[bool]string{true: "enabled", false: "disabled"}[e.showLineNumbers]))
-			case "nonumber":
-				e.showLineNumbers = false
-				e.setSetting("showLineNumbers", "false")
-				e.setStatusMessage("Line numbers disabled")
-			case "autoindent":
-				if len(settingParts) > 1 {
-					if settingParts[1] == "on" {
-						e.setSetting("autoIndent", "true")
-						e.setStatusMessage("Auto-indent enabled")
-					} else if settingParts[1] == "off" {
-						e.setSetting("autoIndent", "false")
-						e.setStatusMessage("Auto-indent disabled")
-					} else {
-						e.setStatusMessage("Usage: set autoindent on|off")
-					}
-				} else {
-					e.setStatusMessage("Usage: set autoindent on|off")
-				}
-			case "autocomplete":
-				if len(settingParts) > 1 {
-					if settingParts[1] == "on" {
-						e.setSetting("autoComplete", "true")
-						e.setStatusMessage("Auto-complete enabled")
-					} else if settingParts[1] == "off" {
-						e.setSetting("autoComplete", "false")
-						e.setStatusMessage("Auto-complete disabled")
-					} else {
-						e.setStatusMessage("Usage: set autocomplete on|off")
-					}
-				} else {
-					e.setStatusMessage("Usage: set autocomplete on|off")
-				}
-			case "wrap":
-				e.wordWrap = !e.wordWrap
-				e.setStatusMessage(fmt.Sprintf("Word wrap %s", map[bool]string{true: "enabled", false: "disabled"}[e.wordWrap]))
-			default:
-				e.setStatusMessage(fmt.Sprintf("Unknown setting: %s", settingName))
-			}
-		} else {
-			// Show all settings
-			settingsStr := "Current settings:\n"
-			for k, v := range e.settings {
-				settingsStr += fmt.Sprintf("%s = %s\n", k, v)
-			}
-			e.setStatusMessage(settingsStr)
+			args = parts[1]
+		}
+		e.setCommand(args)
+	case "wrap":
+		e.setCommand("wrap!")
+	case "reflow":
+		args := ""
+		if len(parts) > 1 {
+			args = parts[1]
+		}
+		e.reflowCommand(args)
+	case "map", "nmap", "imap", "tmap", "noremap", "nnoremap", "inoremap", "tnoremap",
+		"unmap", "nunmap", "iunmap", "tunmap":
+		args := ""
+		if len(parts) > 1 {
+			args = parts[1]
+		}
+		e.mapCommand(command, args)
+	case "config":
+		if err := e.openFile(configPath()); err != nil {
+			e.setStatusMessage(fmt.Sprintf("Error opening %s: %v", configPath(), err))
 		}
 	case "rm":
 		if len(parts) > 1 {
@@ -209,13 +168,153 @@ func (e *Editor) handleCommand() {
 			e.setStatusMessage("Usage: find <text>")
 		}
 	case "replace":
-		if len(parts) > 2 {
+		if len(parts) <= 2 {
+			e.setStatusMessage("Usage: replace <old> <new>")
+		} else if e.editable() {
 			oldText := parts[1]
 			newText := parts[2]
 			count := e.replaceAll(oldText, newText)
 			e.setStatusMessage(fmt.Sprintf("Replaced %d occurrences", count))
+		}
+	case "e", "edit":
+		if len(parts) > 1 {
+			if err := e.openFile(strings.TrimSpace(parts[1])); err != nil {
+				e.setStatusMessage(fmt.Sprintf("Error opening file: %v", err))
+			}
 		} else {
-			e.setStatusMessage("Usage: replace <old> <new>")
+			e.setStatusMessage("Usage: e <filename>")
+		}
+	case "ls", "buffers":
+		e.listBuffers()
+	case "b", "buffer":
+		if len(parts) > 1 {
+			if err := e.selectBuffer(strings.TrimSpace(parts[1])); err != nil {
+				e.setStatusMessage(err.Error())
+			}
+		} else {
+			e.listBuffers()
+		}
+	case "bn", "bnext":
+		e.cycleBuffer(1)
+	case "bp", "bprev":
+		e.cycleBuffer(-1)
+	case "bd", "bd!":
+		if err := e.closeBuffer(command == "bd!"); err != nil {
+			e.setStatusMessage(err.Error())
+		}
+	case "colorscheme", "colo":
+		if len(parts) > 1 {
+			name := strings.TrimSpace(parts[1])
+			if err := e.setOption("theme", name); err != nil {
+				e.setStatusMessage(fmt.Sprintf("Theme error: %v", err))
+			} else {
+				e.setStatusMessage(fmt.Sprintf("Theme set to %s", name))
+			}
+		} else {
+			e.setStatusMessage(fmt.Sprintf("Theme: %s (available: %s)",
+				e.currentTheme().Name, strings.Join(availableThemes(), ", ")))
+		}
+	case "hover":
+		e.hover()
+	case "def", "definition":
+		e.gotoDefinition()
+	case "refs", "references":
+		e.findReferences()
+	case "cn", "cnext":
+		e.nextLocation(1)
+	case "cp", "cprev":
+		e.nextLocation(-1)
+	case "rename":
+		if len(parts) > 1 && strings.TrimSpace(parts[1]) != "" {
+			e.rename(strings.TrimSpace(parts[1]))
+		} else {
+			e.setStatusMessage("Usage: rename <new name>")
+		}
+	case "format", "fmt":
+		e.format()
+	case "diag", "diagnostics":
+		e.listDiagnostics()
+	case "lsp":
+		e.setStatusMessage(e.lspStatus())
+	case "filetype", "ft", "setf":
+		if len(parts) > 1 {
+			name := strings.TrimSpace(parts[1])
+			if lexers.Get(name) == nil {
+				e.setStatusMessage(fmt.Sprintf("Unknown filetype: %s", name))
+			} else {
+				e.fileType = name
+				e.resetLexer()
+				e.loadFileSettings()
+				e.setStatusMessage(fmt.Sprintf("Filetype: %s", e.languageName()))
+			}
+		} else {
+			e.setStatusMessage(fmt.Sprintf("Filetype: %s", e.languageName()))
+		}
+	case ">", "<":
+		// An optional count shifts that many lines from the cursor
+		count := 1
+		if len(parts) > 1 {
+			n, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+			if err != nil || n < 1 {
+				e.setStatusMessage(fmt.Sprintf("Usage: %s [count]", command))
+				return
+			}
+			count = n
+		}
+		dir := 1
+		if command == "<" {
+			dir = -1
+		}
+		e.shiftLines(e.cursorY, e.cursorY+count-1, dir)
+	case "marks":
+		e.listMarks()
+	case "delmarks", "delm", "delmarks!", "delm!":
+		e.deleteMarks(strings.Join(parts[1:], " "), strings.HasSuffix(command, "!"))
+	case "stagehunk":
+		e.stageHunk()
+	case "reverthunk", "undohunk":
+		e.revertHunk()
+	case "previewhunk":
+		e.previewHunk()
+	case "diffsplit", "diffs":
+		if len(parts) < 2 || strings.TrimSpace(parts[1]) == "" {
+			e.setStatusMessage("Usage: diffsplit <filename>")
+		} else if err := e.diffSplit(strings.TrimSpace(parts[1])); err != nil {
+			e.setStatusMessage(fmt.Sprintf("Error opening file: %v", err))
+		}
+	case "conflict":
+		e.resolveConflict(strings.TrimSpace(strings.Join(parts[1:], " ")))
+	case "diffoff":
+		e.endDiff()
+	case "recover":
+		e.promptRecovery()
+	case "blame":
+		e.toggleBlame()
+	case "log":
+		e.showLog()
+	case "breakpoint", "break":
+		e.breakpointCommand(strings.Join(parts[1:], " "))
+	case "reveal":
+		e.revealFile(e.filename)
+	case "bookmark":
+		if len(parts) > 1 {
+			e.addBookmark(strings.TrimSpace(parts[1]))
+		} else {
+			e.addBookmark("")
+		}
+	case "bookmarks":
+		e.listBookmarks()
+	case "bm":
+		if len(parts) > 1 {
+			e.jumpToBookmark(strings.TrimSpace(parts[1]))
+		} else {
+			e.listBookmarks()
+		}
+	case "delbookmark":
+		if len(parts) > 1 {
+			e.removeBookmark(strings.TrimSpace(parts[1]))
+		} else {
+			e.setStatusMessage("Usage: delbookmark <name>")
 		}
 	case "help":
 		e.showHelp()
@@ -241,34 +340,100 @@ func (e *Editor) saveFile() error {
 	if e.filename == "" {
 		return fmt.Errorf("no filename specified")
 	}
+	if e.readOnly {
+		return fmt.Errorf("read-only buffer, use :saveas to write it to a file")
+	}
 
-	file, err := os.Create(e.filename)
+	data, err := e.fileContents()
 	if err != nil {
 		return err
 	}
-	defer file.Close()
+	return writeFileThrough(e.filename, data)
+}
 
-	writer := bufio.NewWriter(file)
-	for _, line := range e.lines {
-		if _, err := writer.WriteString(line + "\n"); err != nil {
-			return err
+// writeFileThrough writes data to filename atomically, through a symlink
+// and keeping the file's permissions.
+func writeFileThrough(filename string, data []byte) error {
+	path, perm := filename, os.FileMode(0644)
+	if target, err := filepath.EvalSymlinks(path); err == nil {
+		path = target
+	}
+	if info, err := os.Stat(path); err == nil {
+		perm = info.Mode().Perm()
+	}
+	return writeFileAtomic(path, data, perm)
+}
+
+// writeBuffer is :w, saving the buffer and updating what depends on it.
+// With backupFiles the file as it was is kept in a .bak file beside it.
+func (e *Editor) writeBuffer() error {
+	if e.boolOption("backupFiles") && !e.readOnly {
+		if err := backupFile(e.filename); err != nil {
+			return fmt.Errorf("backup failed: %v", err)
 		}
 	}
-	return writer.Flush()
+	if err := e.saveFile(); err != nil {
+		return err
+	}
+	e.isDirty = false
+	e.refreshGitStatus()
+	e.loadGitDiff()
+	e.lspSaved()
+	e.reportConflicts()
+	return nil
 }
 
-func (e *Editor) saveFileAs(filename string) error {
-	file, err := os.Create(filename)
+// backupFile copies the file at filename, through a symlink, to a .bak
+// file beside it. A file that doesn't exist yet needs no backup.
+func backupFile(filename string) error {
+	path := filename
+	if target, err := filepath.EvalSymlinks(path); err == nil {
+		path = target
+	}
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(path)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
+	return writeFileAtomic(path+".bak", data, info.Mode().Perm())
+}
 
-	writer := bufio.NewWriter(file)
-	for _, line := range e.lines {
-		if _, err := writer.WriteString(line + "\n"); err != nil {
-			return err
-		}
+// writeFileAtomic writes data to a temporary file next to path and renames
+// it over path, so a crash leaves either the old contents or the new.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), perm)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+func (e *Editor) saveFileAs(filename string) error {
+	data, err := e.fileContents()
+	if err != nil {
+		return err
 	}
-	return writer.Flush()
+	return os.WriteFile(filename, data, 0644)
 }
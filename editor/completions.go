@@ -1,76 +1,402 @@
 package editor
 
 import (
+	"os"
+	"path/filepath"
+	"sort"
 	"strings"
 	"unicode"
-
-	"github.com/gdamore/tcell/v2"
+	"unicode/utf8"
 )
 
+// Completion engine.
+//
+// Candidates come from a list of sources: the language server, words in
+// the current buffer and the other open buffers, file paths, the language
+// keyword lists and snippets. Each source returns candidates with a bonus for things like
+// proximity to the cursor or frequency; the engine fuzzy-matches them
+// against the text before the cursor, adds the bonuses and sorts.
+
 type Completion struct {
 	Text        string
 	Description string
+
+	kind    string   // source that offered it
+	start   int      // byte offset in the line where the replaced text begins
+	score   int      // source bonus, then the final rank
+	snippet *snippet // expanded instead of inserting Text
 }
 
-func (e *Editor) getCompletions() []Completion {
-	// Get the word under cursor
+// completionContext describes the text being completed.
+type completionContext struct {
+	line      string
+	cursor    int
+	word      string // identifier before the cursor
+	wordStart int
+	path      string // path-like text before the cursor containing a '/'
+	pathStart int    // start of the path's last component
+
+	afterTrigger bool // completing after a server trigger such as '.'
+}
+
+type completionSource struct {
+	name  string
+	fetch func(e *Editor, ctx *completionContext) []Completion
+}
+
+// Sources in the order they are asked; paths come first so that file names
+// can replace everything else when the cursor is inside a path.
+var completionSources = []completionSource{
+	{"path", (*Editor).pathCompletions},
+	{"lsp", (*Editor).lspCompletions},
+	{"buffer", (*Editor).bufferWordCompletions},
+	{"buffers", (*Editor).otherBufferCompletions},
+	{"keyword", (*Editor).keywordCompletions},
+	{"snippet", (*Editor).snippetCompletions},
+}
+
+const (
+	maxCompletions        = 50
+	maxCompletionRows     = 10
+	minAutoCompleteLength = 2    // identifier length that opens the popup automatically
+	completionScanLines   = 5000 // lines scanned around the cursor for buffer words
+)
+
+func (e *Editor) completionContext() *completionContext {
 	if e.cursorY >= len(e.lines) {
 		return nil
 	}
-
 	line := e.lines[e.cursorY]
-	if e.cursorX > len(line) {
-		return nil
+	cursor := e.cursorX
+	if cursor > len(line) {
+		cursor = len(line)
+	}
+	ctx := &completionContext{line: line, cursor: cursor}
+
+	start := cursor
+	for start > 0 {
+		r, size := utf8.DecodeLastRuneInString(line[:start])
+		if !isIdentChar(r) {
+			break
+		}
+		start -= size
 	}
+	ctx.word, ctx.wordStart = line[start:cursor], start
 
-	start := e.cursorX
-	for start > 0 && isIdentChar(rune(line[start-1])) {
+	start = cursor
+	for start > 0 && isPathChar(line[start-1]) {
 		start--
 	}
-	prefix := line[start:e.cursorX]
+	if token := line[start:cursor]; strings.Contains(token, "/") {
+		ctx.path = token
+		ctx.pathStart = start + strings.LastIndex(token, "/") + 1
+	}
+	return ctx
+}
+
+func isPathChar(c byte) bool {
+	return c > ' ' && !strings.ContainsRune("\"'`()<>[]{},;=|&:", rune(c))
+}
 
-	// Skip if prefix is too short
-	if len(prefix) < 2 {
+// getCompletions collects and ranks the candidates for the text before the
+// cursor.
+func (e *Editor) getCompletions() []Completion {
+	ctx := e.completionContext()
+	if ctx == nil {
 		return nil
 	}
 
-	// Get language-specific completions
-	var completions []Completion
+	var results []Completion
+	index := map[string]int{}
+	for _, source := range completionSources {
+		if (ctx.path != "" || ctx.afterTrigger) && len(results) > 0 {
+			// Inside an existing directory only file names make sense,
+			// and after a '.' only the server knows the members
+			break
+		}
+
+		for _, c := range source.fetch(e, ctx) {
+			pattern := ctx.line[c.start:ctx.cursor]
+			match, ok := fuzzyMatch(pattern, c.Text)
+			if !ok || (c.Text == pattern && c.snippet == nil) {
+				continue
+			}
+			c.kind = source.name
+			c.score += match*4 + min(e.completionUses[c.Text]*3, 15)
+
+			if i, seen := index[c.Text]; seen {
+				// Keep the best rank, and a keyword or snippet over a plain word
+				prev := results[i]
+				c.score = max(c.score, prev.score)
+				if c.kind == "buffer" || c.kind == "buffers" || prev.snippet != nil {
+					c.kind, c.Description, c.snippet = prev.kind, prev.Description, prev.snippet
+				}
+				results[i] = c
+				continue
+			}
+			index[c.Text] = len(results)
+			results = append(results, c)
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].score != results[j].score {
+			return results[i].score > results[j].score
+		}
+		return results[i].Text < results[j].Text
+	})
+	if len(results) > maxCompletions {
+		results = results[:maxCompletions]
+	}
+	return results
+}
+
+// fuzzyMatch reports whether pattern's characters appear in order in text,
+// scoring prefix matches, consecutive runs, word starts and exact case
+// higher, and long candidates a little lower.
+func fuzzyMatch(pattern, text string) (int, bool) {
+	if pattern == "" {
+		return 0, true
+	}
+	p, t := []rune(pattern), []rune(text)
+	score, pi, last := 0, 0, -2
+	for ti := 0; ti < len(t) && pi < len(p); ti++ {
+		if unicode.ToLower(t[ti]) != unicode.ToLower(p[pi]) {
+			continue
+		}
+		score++
+		if t[ti] == p[pi] {
+			score++
+		}
+		if ti == last+1 {
+			score += 4
+		}
+		if ti == 0 || !isIdentChar(t[ti-1]) || (unicode.IsUpper(t[ti]) && unicode.IsLower(t[ti-1])) {
+			score += 6
+		}
+		last = ti
+		pi++
+	}
+	if pi < len(p) {
+		return 0, false
+	}
+	if strings.HasPrefix(strings.ToLower(text), strings.ToLower(pattern)) {
+		score += 10
+	}
+	return score - (len(t)-len(p))/4, true
+}
+
+type wordInfo struct {
+	count    int
+	distance int
+}
+
+// collectWords counts the identifiers in lines, remembering how close each
+// one comes to line near. skip excludes one occurrence (the word being typed).
+func collectWords(lines []string, near int, words map[string]*wordInfo, skipLine, skipStart int) {
+	for y, line := range lines {
+		distance := y - near
+		if distance < 0 {
+			distance = -distance
+		}
+		for x := 0; x < len(line); {
+			r, size := utf8.DecodeRuneInString(line[x:])
+			if !isIdentChar(r) {
+				x += size
+				continue
+			}
+			end := x
+			for end < len(line) {
+				r, size := utf8.DecodeRuneInString(line[end:])
+				if !isIdentChar(r) {
+					break
+				}
+				end += size
+			}
+			if utf8.RuneCountInString(line[x:end]) >= 2 && !(y == skipLine && x == skipStart) && !unicode.IsDigit(r) {
+				word := line[x:end]
+				info := words[word]
+				if info == nil {
+					info = &wordInfo{distance: distance}
+					words[word] = info
+				}
+				info.count++
+				if distance < info.distance {
+					info.distance = distance
+				}
+			}
+			x = end
+		}
+	}
+}
+
+// bufferWordCompletions offers words from the current buffer, nearer and
+// more frequent words first.
+func (e *Editor) bufferWordCompletions(ctx *completionContext) []Completion {
+	if ctx.word == "" {
+		return nil
+	}
+	from := max(0, e.cursorY-completionScanLines)
+	to := min(len(e.lines), e.cursorY+completionScanLines)
+
+	words := map[string]*wordInfo{}
+	collectWords(e.lines[from:to], e.cursorY-from, words, e.cursorY-from, ctx.wordStart)
+
+	var candidates []Completion
+	for word, info := range words {
+		proximity := max(0, 10-info.distance/10)
+		candidates = append(candidates, Completion{
+			Text:  word,
+			start: ctx.wordStart,
+			score: proximity + min(info.count, 10)/2,
+		})
+	}
+	return candidates
+}
+
+// otherBufferCompletions offers words from the other open buffers.
+func (e *Editor) otherBufferCompletions(ctx *completionContext) []Completion {
+	if ctx.word == "" {
+		return nil
+	}
+	var candidates []Completion
+	for _, b := range e.otherBuffers() {
+		lines := b.lines
+		if len(lines) > 2*completionScanLines {
+			lines = lines[:2*completionScanLines]
+		}
+		words := map[string]*wordInfo{}
+		collectWords(lines, 0, words, -1, -1)
+		for word, info := range words {
+			candidates = append(candidates, Completion{
+				Text:        word,
+				Description: bufferName(b.filename),
+				start:       ctx.wordStart,
+				score:       min(info.count, 10) / 2,
+			})
+		}
+	}
+	return candidates
+}
+
+// keywordCompletions offers the language's keywords and common calls.
+func (e *Editor) keywordCompletions(ctx *completionContext) []Completion {
+	if ctx.word == "" {
+		return nil
+	}
+	var list []Completion
 	switch e.detectLanguage() {
 	case LangGo:
-		completions = goCompletions
+		list = goCompletions
 	case LangPython:
-		completions = pythonCompletions
+		list = pythonCompletions
 	case LangJavaScript:
-		completions = jsCompletions
+		list = jsCompletions
 	case LangRust:
-		completions = rustCompletions
+		list = rustCompletions
 	}
 
-	// Filter completions based on prefix
-	if prefix != "" {
-		filtered := []Completion{}
-		for _, c := range completions {
-			if strings.HasPrefix(strings.ToLower(c.Text), strings.ToLower(prefix)) {
-				filtered = append(filtered, c)
-			}
-		}
-		return filtered
+	candidates := make([]Completion, len(list))
+	for i, c := range list {
+		c.start = ctx.wordStart
+		c.score = 2
+		candidates[i] = c
 	}
+	return candidates
+}
 
-	return completions
+// snippetCompletions offers the snippets whose trigger matches.
+func (e *Editor) snippetCompletions(ctx *completionContext) []Completion {
+	if ctx.word == "" {
+		return nil
+	}
+	var candidates []Completion
+	for _, s := range e.snippets() {
+		candidates = append(candidates, Completion{
+			Text:        s.trigger,
+			Description: s.description,
+			start:       ctx.wordStart,
+			score:       3,
+			snippet:     s,
+		})
+	}
+	return candidates
 }
 
-func (e *Editor) showCompletions() {
-	completions := e.getCompletions()
-	if len(completions) == 0 {
-		return
+// pathCompletions offers the entries of the directory typed before the
+// cursor. Relative paths are taken from the open file's directory.
+func (e *Editor) pathCompletions(ctx *completionContext) []Completion {
+	if ctx.path == "" {
+		return nil
+	}
+	dir := ctx.path[:strings.LastIndex(ctx.path, "/")+1]
+	fragment := ctx.path[len(dir):]
+
+	switch {
+	case strings.HasPrefix(dir, "~/"):
+		dir = filepath.Join(os.Getenv("HOME"), dir[2:])
+	case filepath.IsAbs(dir):
+	case e.filename != "":
+		dir = filepath.Join(filepath.Dir(e.filename), dir)
+	}
+	if dir == "" {
+		dir = "/"
 	}
 
-	// Store completions for selection
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var candidates []Completion
+	for i, entry := range entries {
+		if i >= 1000 {
+			break
+		}
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") && !strings.HasPrefix(fragment, ".") {
+			continue
+		}
+		c := Completion{Text: name, Description: "file", start: ctx.pathStart, score: 5}
+		if entry.IsDir() {
+			c.Text += "/"
+			c.Description = "dir"
+		}
+		candidates = append(candidates, c)
+	}
+	return candidates
+}
+
+// autoCompleteEnabled reports whether the popup opens while typing.
+func (e *Editor) autoCompleteEnabled() bool {
+	return e.boolOption("autoComplete")
+}
+
+// setCompletions shows a list in the popup, or hides the popup if it is empty.
+func (e *Editor) setCompletions(completions []Completion) {
 	e.completions = completions
 	e.completionIndex = 0
-	e.completionActive = true
+	e.completionScroll = 0
+	e.completionActive = len(completions) > 0
+}
+
+func (e *Editor) showCompletions() {
+	e.setCompletions(e.getCompletions())
+}
+
+// refreshCompletions re-ranks the popup after the text before the cursor
+// changes, opening it once enough has been typed if autoComplete is on.
+func (e *Editor) refreshCompletions() {
+	ctx := e.completionContext()
+	if ctx == nil || (ctx.word == "" && ctx.path == "") {
+		e.completionActive = false
+		return
+	}
+	if !e.completionActive {
+		if !e.autoCompleteEnabled() || (ctx.path == "" && utf8.RuneCountInString(ctx.word) < minAutoCompleteLength) {
+			return
+		}
+	}
+	e.showCompletions()
 }
 
 // Apply the selected completion
@@ -78,33 +404,46 @@ func (e *Editor) applyCompletion() {
 	if !e.completionActive || len(e.completions) == 0 {
 		return
 	}
-
-	// Get the selected completion
 	completion := e.completions[e.completionIndex]
+	e.completionActive = false
+	if completion.kind == "choice" && e.snippetField() != nil {
+		e.setSnippetField(completion.Text, len(completion.Text))
+		return
+	}
 
-	// Find the start of the current word
 	line := e.lines[e.cursorY]
-	wordStart := e.cursorX
-	for wordStart > 0 && isIdentChar(rune(line[wordStart-1])) {
-		wordStart--
+	start, end := completion.start, min(e.cursorX, len(line))
+	if start > end {
+		return
 	}
 
-	// Replace the current word with the completion
-	newLine := line[:wordStart] + completion.Text + line[e.cursorX:]
 	e.addUndo(Action{
 		Type:    "insert",
 		action:  "insert",
-		lines:   e.lines,
+		lines:   append([]string{}, e.lines...),
 		cursorX: e.cursorX,
 		cursorY: e.cursorY,
 		text:    completion.Text,
 	})
-	e.lines[e.cursorY] = newLine
-	e.cursorX = wordStart + len(completion.Text)
-	e.isDirty = true
+	if e.completionUses == nil {
+		e.completionUses = make(map[string]int)
+	}
+	e.completionUses[completion.Text]++
 
-	// Clear completion state
-	e.completionActive = false
+	if completion.snippet != nil {
+		e.expandSnippet(completion.snippet, start, end)
+		return
+	}
+
+	// Replace the typed text with the completion
+	e.lines[e.cursorY] = line[:start] + completion.Text + line[end:]
+	e.cursorX = start + len(completion.Text)
+	e.markChanged()
+
+	// Keep completing inside a directory
+	if completion.kind == "path" && strings.HasSuffix(completion.Text, "/") {
+		e.refreshCompletions()
+	}
 }
 
 // Navigate through completions
@@ -124,40 +463,44 @@ func (e *Editor) prevCompletion() {
 }
 
 func (e *Editor) drawCompletions() {
-	// Calculate position for completion popup
-	popupX := e.cursorX
-	if e.showLineNumbers {
-		popupX += 5
-	}
-	if e.treeVisible {
-		popupX += e.treeWidth + 1
+	rows := min(len(e.completions), maxCompletionRows)
+
+	// Keep the selected entry in view
+	if e.completionIndex < e.completionScroll {
+		e.completionScroll = e.completionIndex
+	} else if e.completionIndex >= e.completionScroll+rows {
+		e.completionScroll = e.completionIndex - rows + 1
 	}
 
-	popupY := e.cursorY - e.scrollY + 1 // Show below cursor
+	// Calculate position for completion popup, aligned with the replaced text
+	popupX, _, _ := e.screenPosition(e.cursorY, e.completions[0].start)
+	_, cursorY, _ := e.screenPosition(e.cursorY, e.cursorX)
+	popupY := cursorY + 1 // Show below cursor
 
 	// Ensure popup fits on screen
-	if popupY >= e.screenHeight-3 {
-		popupY = e.cursorY - e.scrollY - len(e.completions) - 1 // Show above cursor
+	if popupY+rows > e.screenHeight-1 {
+		popupY = cursorY - rows // Show above cursor
 	}
 
 	// Calculate max width needed
-	maxWidth := 0
+	textWidth, maxWidth := 0, 0
 	for _, c := range e.completions {
-		width := len(c.Text) + len(c.Description) + 3 // +3 for spacing
-		if width > maxWidth {
-			maxWidth = width
-		}
+		textWidth = max(textWidth, len(c.Text))
+	}
+	for _, c := range e.completions {
+		maxWidth = max(maxWidth, textWidth+len(completionLabel(c))+3)
+	}
+	if popupX+maxWidth > e.screenWidth {
+		popupX = max(0, e.screenWidth-maxWidth)
 	}
 
 	// Draw popup background
-	popupStyle := tcell.StyleDefault.Background(tcell.ColorDarkBlue).Foreground(tcell.ColorWhite)
-	selectedStyle := tcell.StyleDefault.Background(tcell.ColorBlue).Foreground(tcell.ColorWhite)
+	popupStyle := e.uiStyle("completion")
+	selectedStyle := e.uiStyle("completion.selected")
 
-	for i, comp := range e.completions {
-		// Limit number of displayed completions
-		if i >= 10 {
-			break
-		}
+	for row := 0; row < rows; row++ {
+		i := e.completionScroll + row
+		comp := e.completions[i]
 
 		// Choose style based on selection
 		style := popupStyle
@@ -167,20 +510,30 @@ func (e *Editor) drawCompletions() {
 
 		// Draw background
 		for x := 0; x < maxWidth; x++ {
-			e.screen.SetContent(popupX+x, popupY+i, ' ', nil, style)
+			e.screen.SetContent(popupX+x, popupY+row, ' ', nil, style)
 		}
 
 		// Draw completion text
-		drawText(e.screen, popupX, popupY+i, style, comp.Text)
+		drawText(e.screen, popupX+1, popupY+row, style, comp.Text)
 
 		// Draw description
-		descStyle := style.Foreground(tcell.ColorLightGray)
-		drawText(e.screen, popupX+len(comp.Text)+2, popupY+i, descStyle, comp.Description)
+		descStyle := e.currentTheme().Over("completion.description", style)
+		drawText(e.screen, popupX+textWidth+3, popupY+row, descStyle, completionLabel(comp))
+	}
+}
+
+// completionLabel is the text shown next to a completion in the popup.
+func completionLabel(c Completion) string {
+	if c.Description != "" {
+		return c.Description
 	}
+	return c.kind
 }
 
+// isIdentChar reports whether r can be part of an identifier. Combining
+// marks count, so a decomposed "é" stays in its word.
 func isIdentChar(r rune) bool {
-	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || unicode.In(r, unicode.Mn, unicode.Mc)
 }
 
 // Language-specific completions
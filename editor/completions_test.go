@@ -0,0 +1,187 @@
+package editor
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+func completionTexts(completions []Completion) []string {
+	var texts []string
+	for _, c := range completions {
+		texts = append(texts, c.Text)
+	}
+	return texts
+}
+
+func TestFuzzyMatch(t *testing.T) {
+	if _, ok := fuzzyMatch("hdlr", "handler"); !ok {
+		t.Errorf("expected subsequence match")
+	}
+	if _, ok := fuzzyMatch("xyz", "handler"); ok {
+		t.Errorf("unexpected match")
+	}
+	prefix, _ := fuzzyMatch("han", "handler")
+	scattered, _ := fuzzyMatch("han", "thisAndNothing")
+	if prefix <= scattered {
+		t.Errorf("prefix match should rank higher: %d <= %d", prefix, scattered)
+	}
+	boundary, _ := fuzzyMatch("gN", "getName")
+	middle, _ := fuzzyMatch("gN", "signing")
+	if boundary <= middle {
+		t.Errorf("camelCase boundary should rank higher: %d <= %d", boundary, middle)
+	}
+}
+
+func TestBufferWordCompletions(t *testing.T) {
+	ed := &Editor{filename: "notes.txt", settings: map[string]string{}}
+	ed.lines = []string{
+		"counterFar = 1",
+		"", "", "", "", "", "", "", "", "", "", "", "", "", "", "", "", "", "", "", "",
+		"", "", "", "", "", "", "", "", "", "", "", "", "", "", "", "", "", "", "", "",
+		"counterNear = 2",
+		"co",
+	}
+	ed.cursorY = len(ed.lines) - 1
+	ed.cursorX = 2
+
+	texts := completionTexts(ed.getCompletions())
+	if len(texts) != 2 || texts[0] != "counterNear" {
+		t.Fatalf("completions = %v, want counterNear first", texts)
+	}
+
+	// Words from other open buffers are offered too
+	ed.buffers = []*Buffer{{}, {filename: "other.txt", lines: []string{"collaborator"}}}
+	texts = completionTexts(ed.getCompletions())
+	found := false
+	for _, text := range texts {
+		found = found || text == "collaborator"
+	}
+	if !found {
+		t.Errorf("expected a word from another buffer in %v", texts)
+	}
+
+	// Accepting a completion makes it rank higher next time
+	ed.completionUses = map[string]int{"counterFar": 5}
+	if texts = completionTexts(ed.getCompletions()); texts[0] != "counterFar" {
+		t.Errorf("frequently used completion should rank first, got %v", texts)
+	}
+}
+
+func TestPathCompletions(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "src/main.go", "package main\n")
+	writeTestFile(t, dir, "src/main_test.go", "package main\n")
+	writeTestFile(t, dir, "src/.hidden", "\n")
+
+	ed := &Editor{filename: filepath.Join(dir, "README.md"), lines: []string{"see ./src/ma"}}
+	ed.cursorX = len(ed.lines[0])
+	ed.showCompletions()
+	if texts := completionTexts(ed.completions); len(texts) != 2 || texts[0] != "main.go" {
+		t.Fatalf("path completions = %v", texts)
+	}
+
+	ed.applyCompletion()
+	if ed.lines[0] != "see ./src/main.go" {
+		t.Errorf("applied path completion: %q", ed.lines[0])
+	}
+}
+
+func TestInsertModeCompletionKeys(t *testing.T) {
+	ed := &Editor{filename: "main.go", mode: "insert", tabSize: 4, settings: map[string]string{}}
+	ed.lines = []string{"alphaOne alphaTwo alphaThree", ""}
+	ed.cursorY = 1
+
+	typeRunes := func(s string) {
+		for _, r := range s {
+			ed.handleInsertMode(tcell.NewEventKey(tcell.KeyRune, r, tcell.ModNone))
+		}
+	}
+	typeRunes("alp")
+	if !ed.completionActive || len(ed.completions) != 3 {
+		t.Fatalf("expected popup with 3 entries, got %v", completionTexts(ed.completions))
+	}
+
+	ed.handleInsertMode(tcell.NewEventKey(tcell.KeyTab, 0, tcell.ModNone))
+	ed.handleInsertMode(tcell.NewEventKey(tcell.KeyCtrlN, 0, tcell.ModNone))
+	ed.handleInsertMode(tcell.NewEventKey(tcell.KeyBacktab, 0, tcell.ModNone))
+	if ed.completionIndex != 1 {
+		t.Errorf("completion index = %d, want 1", ed.completionIndex)
+	}
+	want := ed.completions[1].Text
+	ed.handleInsertMode(tcell.NewEventKey(tcell.KeyEnter, 0, tcell.ModNone))
+	if ed.lines[1] != want || ed.completionActive {
+		t.Errorf("Enter should accept %q, line is %q", want, ed.lines[1])
+	}
+
+	// With autoComplete off the popup only opens on request
+	ed.settings["autoComplete"] = "false"
+	typeRunes(" alp")
+	if ed.completionActive {
+		t.Errorf("popup opened with autoComplete off")
+	}
+	ed.handleInsertMode(tcell.NewEventKey(tcell.KeyCtrlN, 0, tcell.ModNone))
+	if !ed.completionActive {
+		t.Errorf("Ctrl-N should open the popup")
+	}
+}
+
+func TestSnippetCompletion(t *testing.T) {
+	ed := &Editor{filename: "main.go", tabSize: 4}
+	ed.lines = []string{"func f() error {", "    iferr", "}"}
+	ed.cursorY, ed.cursorX = 1, len(ed.lines[1])
+
+	ed.showCompletions()
+	if len(ed.completions) == 0 || ed.completions[0].snippet == nil {
+		t.Fatalf("expected the iferr snippet, got %v", completionTexts(ed.completions))
+	}
+	ed.applyCompletion()
+
+	want := []string{"func f() error {", "    if err != nil {", "        return err", "    }", "}"}
+	if len(ed.lines) != len(want) {
+		t.Fatalf("expanded to %q", ed.lines)
+	}
+	for i := range want {
+		if ed.lines[i] != want[i] {
+			t.Fatalf("expanded to %q", ed.lines)
+		}
+	}
+	if ed.cursorY != 2 || ed.cursorX != len("        return ") {
+		t.Errorf("cursor at %d,%d", ed.cursorY, ed.cursorX)
+	}
+}
+
+func TestBuffers(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "a.txt", "first\n")
+	writeTestFile(t, dir, "b.txt", "second\n")
+	a, b := filepath.Join(dir, "a.txt"), filepath.Join(dir, "b.txt")
+
+	ed := &Editor{lines: []string{""}}
+	if err := ed.openFile(a); err != nil {
+		t.Fatal(err)
+	}
+	ed.lines[0] = "changed"
+	ed.isDirty = true
+	if err := ed.openFile(b); err != nil {
+		t.Fatal(err)
+	}
+	if len(ed.buffers) != 2 || ed.lines[0] != "second" {
+		t.Fatalf("expected two buffers with b current, have %d", len(ed.buffers))
+	}
+
+	ed.cycleBuffer(1)
+	if ed.filename != a || ed.lines[0] != "changed" || !ed.isDirty {
+		t.Errorf("switching back lost the unsaved change")
+	}
+	if err := ed.closeBuffer(false); err == nil {
+		t.Errorf("closing a modified buffer should fail")
+	}
+	if err := ed.selectBuffer("b.txt"); err != nil || ed.filename != b {
+		t.Errorf("selectBuffer: %v", err)
+	}
+	if ed.modifiedBuffer() == nil {
+		t.Errorf("expected a.txt to be reported as modified")
+	}
+}
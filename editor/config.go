@@ -2,54 +2,312 @@ package editor
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 )
 
-type Config struct {
-	TabSize         int  `json:"tabSize"`
-	ShowLineNumbers bool `json:"showLineNumbers"`
-	SyntaxHighlight bool `json:"syntaxHighlight"`
-	WordWrap        bool `json:"wordWrap"`
+// Configuration file.
+//
+// Settings live in $XDG_CONFIG_HOME/kiki-editor/config.toml (by default
+// ~/.config/kiki-editor), next to the themes and snippets directories and
+// the bookmarks file:
+//
+//	tabSize = 2
+//	theme = "gruvbox"
+//
+//	[lsp]
+//	go = "gopls"
+//
+//	[filetype.yaml]
+//	tabSize = 2
+//
+// The first time it is missing, the settings of older versions in
+// ~/.kiki_editor.json are carried over, and ~/.kiki-editor is moved in.
+
+func configDir() string {
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return filepath.Join(dir, "kiki-editor")
+	}
+	return filepath.Join(os.Getenv("HOME"), ".config", "kiki-editor")
 }
 
-func (e *Editor) loadConfig() error {
-	configPath := filepath.Join(os.Getenv("HOME"), ".kiki_editor.json")
-	data, err := os.ReadFile(configPath)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return e.saveDefaultConfig()
+// dataDir is where state that isn't configuration is kept, like swap files.
+func dataDir() string {
+	if dir := os.Getenv("XDG_DATA_HOME"); dir != "" {
+		return filepath.Join(dir, "kiki-editor")
+	}
+	return filepath.Join(os.Getenv("HOME"), ".local", "share", "kiki-editor")
+}
+
+func configPath() string {
+	return filepath.Join(configDir(), "config.toml")
+}
+
+// Where older versions kept their settings and data
+func legacyConfigPath() string {
+	return filepath.Join(os.Getenv("HOME"), ".kiki_editor.json")
+}
+
+func legacyDataDir() string {
+	return filepath.Join(os.Getenv("HOME"), ".kiki-editor")
+}
+
+// loadConfig reads the config file and applies it. Problems with single
+// options don't stop the others from being used; they are all returned.
+func (e *Editor) loadConfig() []error {
+	data, err := os.ReadFile(configPath())
+	if os.IsNotExist(err) {
+		var migrated bool
+		data, migrated, err = migrateConfig()
+		if err == nil && migrated {
+			e.setStatusMessage(fmt.Sprintf("Settings moved to %s", configPath()))
 		}
-		return err
 	}
+	if err != nil {
+		e.settings = make(map[string]string)
+		e.applySettings()
+		return []error{err}
+	}
+
+	settings, errs := parseConfig(string(data))
+	e.settings = settings
+	e.applySettings()
+	e.loadKeymaps()
+	return errs
+}
+
+// showConfigErrors reports problems found in the config file.
+func (e *Editor) showConfigErrors(errs []error) {
+	if len(errs) == 0 {
+		return
+	}
+	lines := make([]string, len(errs))
+	for i, err := range errs {
+		lines[i] = err.Error()
+	}
+	e.showPopup("Errors in "+configPath(), lines)
+}
 
-	var config Config
-	if err := json.Unmarshal(data, &config); err != nil {
-		return err
+// parseConfig reads the options in a config file.
+func parseConfig(data string) (map[string]string, []error) {
+	settings := make(map[string]string)
+	table, err := parseTOML(data)
+	if err != nil {
+		return settings, []error{err}
 	}
+	values := make(map[string]any)
+	flattenConfig(table, "", values)
 
-	// Apply config
-	e.tabSize = config.TabSize
-	e.showLineNumbers = config.ShowLineNumbers
-	e.syntaxHighlight = config.SyntaxHighlight
-	e.wordWrap = config.WordWrap
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
 
-	return nil
+	var errs []error
+	for _, key := range keys {
+		// [keys.<mode>] tables map keys
+		if rest, ok := strings.CutPrefix(key, "keys."); ok {
+			name, err := keymapSetting(rest, values[key])
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %v", key, err))
+				continue
+			}
+			settings[name] = values[key].(string)
+			continue
+		}
+
+		// [filetype.<name>] tables override options for one language
+		prefix, option := "", key
+		if rest, ok := strings.CutPrefix(key, "filetype."); ok {
+			if filetype, name, ok := strings.Cut(rest, "."); ok {
+				prefix, option = "filetype."+strings.ToLower(filetype)+".", name
+			}
+		}
+
+		spec, name := lookupOption(option)
+		if spec == nil {
+			errs = append(errs, fmt.Errorf("%s: unknown option", key))
+			continue
+		}
+		if prefix != "" && spec.global {
+			errs = append(errs, fmt.Errorf("%s: can't be set for a filetype", key))
+			continue
+		}
+		value, err := configValue(spec, values[key])
+		if err == nil {
+			value, err = spec.parseValue(value)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", key, err))
+			continue
+		}
+		settings[prefix+name] = value
+	}
+	return settings, errs
 }
 
-func (e *Editor) saveDefaultConfig() error {
-	config := Config{
-		TabSize:         4,
-		ShowLineNumbers: true,
-		SyntaxHighlight: true,
-		WordWrap:        false,
+func flattenConfig(table map[string]any, prefix string, out map[string]any) {
+	for key, value := range table {
+		if sub, ok := value.(map[string]any); ok {
+			flattenConfig(sub, prefix+key+".", out)
+		} else {
+			out[prefix+key] = value
+		}
 	}
+}
 
-	data, err := json.MarshalIndent(config, "", "    ")
+// keymapSetting checks a "<mode>.<keys>" mapping, returning its key in the
+// settings.
+func keymapSetting(name string, value any) (string, error) {
+	mode, lhs, _ := strings.Cut(name, ".")
+	mode, err := parseKeymapMode(mode)
 	if err != nil {
-		return err
+		return "", err
+	}
+	rhs, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("expected a string")
+	}
+	for _, keys := range []string{lhs, rhs} {
+		if _, err := parseKeys(keys, nil); err != nil {
+			return "", err
+		}
 	}
+	if lhs == "" {
+		return "", fmt.Errorf("no keys to map")
+	}
+	return "keys." + mode + "." + lhs, nil
+}
+
+// configValue checks a TOML value has the option's type.
+func configValue(spec *optionSpec, value any) (string, error) {
+	switch spec.kind {
+	case optionBool:
+		if b, ok := value.(bool); ok {
+			return fmt.Sprint(b), nil
+		}
+		return "", fmt.Errorf("expected true or false")
+	case optionInt:
+		if n, ok := value.(int64); ok {
+			return fmt.Sprint(n), nil
+		}
+		return "", fmt.Errorf("expected a number")
+	default:
+		if s, ok := value.(string); ok {
+			return s, nil
+		}
+		return "", fmt.Errorf("expected a string")
+	}
+}
+
+// formatConfig writes settings as a config file, listing the options left
+// at their defaults as comments.
+func formatConfig(settings map[string]string) string {
+	var sb strings.Builder
+	sb.WriteString("# Kiki's Text Editor configuration\n")
+	sb.WriteString("# Options can also be changed with :set; :set all lists them.\n\n")
+	for _, spec := range optionSpecs {
+		value, ok := settings[spec.name]
+		if !ok {
+			value = spec.def
+		}
+		if spec.kind == optionString {
+			value = tomlQuote(value)
+		}
+		if !ok {
+			sb.WriteString("# ")
+		}
+		fmt.Fprintf(&sb, "%s = %s  # %s\n", spec.name, value, spec.help)
+	}
+
+	var languages []string
+	for key := range settings {
+		if language, ok := strings.CutPrefix(key, "lsp."); ok {
+			languages = append(languages, language)
+		}
+	}
+	sort.Strings(languages)
+	sb.WriteString("\n[lsp]\n")
+	if len(languages) == 0 {
+		sb.WriteString("# go = \"gopls\"\n")
+	}
+	for _, language := range languages {
+		fmt.Fprintf(&sb, "%s = %s\n", language, tomlQuote(settings["lsp."+language]))
+	}
+	sb.WriteString("\n# Options for one language\n# [filetype.python]\n# tabSize = 4\n")
+	sb.WriteString("\n# Key mappings for normal, insert and tree mode\n# [keys.insert]\n# jk = \"<Esc>\"\n")
+	return sb.String()
+}
+
+// migrateConfig writes the first config file, with any settings from
+// ~/.kiki_editor.json, and moves ~/.kiki-editor's themes, snippets and
+// bookmarks over. It returns the new file's contents.
+func migrateConfig() ([]byte, bool, error) {
+	settings := make(map[string]string)
+	migrated := false
+	if data, err := os.ReadFile(legacyConfigPath()); err == nil {
+		settings = parseLegacySettings(data)
+		migrated = true
+	}
+
+	dir := configDir()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, false, err
+	}
+	for _, name := range []string{"themes", "snippets", "bookmarks"} {
+		from, to := filepath.Join(legacyDataDir(), name), filepath.Join(dir, name)
+		if _, err := os.Stat(from); err != nil {
+			continue
+		}
+		if _, err := os.Stat(to); os.IsNotExist(err) {
+			if err := os.Rename(from, to); err != nil {
+				return nil, false, err
+			}
+			migrated = true
+		}
+	}
+
+	data := []byte(formatConfig(settings))
+	if err := os.WriteFile(configPath(), data, 0644); err != nil {
+		return nil, false, err
+	}
+	return data, migrated, nil
+}
 
-	configPath := filepath.Join(os.Getenv("HOME"), ".kiki_editor.json")
-	return os.WriteFile(configPath, data, 0644)
+// parseLegacySettings reads the old JSON config, or the "key = value" lines
+// older versions also wrote. Invalid values are dropped.
+func parseLegacySettings(data []byte) map[string]string {
+	values := make(map[string]string)
+	var object map[string]any
+	if json.Unmarshal(data, &object) == nil {
+		for key, value := range object {
+			values[key] = fmt.Sprint(value)
+		}
+	} else {
+		for _, line := range strings.Split(string(data), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			if key, value, ok := strings.Cut(line, "="); ok {
+				values[strings.TrimSpace(key)] = strings.TrimSpace(value)
+			}
+		}
+	}
+
+	settings := make(map[string]string)
+	for key, value := range values {
+		spec, name := lookupOption(key)
+		if spec == nil {
+			continue
+		}
+		if value, err := spec.parseValue(value); err == nil && value != spec.defaultValue(name) {
+			settings[name] = value
+		}
+	}
+	return settings
 }
@@ -0,0 +1,130 @@
+package editor
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseConfig(t *testing.T) {
+	settings, errs := parseConfig(`
+tabSize = 2
+syntaxHighlight = false
+wordWrap = "yes"
+tabsize = 40
+colour = "red"
+
+[lsp]
+go = "gopls -remote=auto"
+`)
+	if settings["tabSize"] != "2" {
+		t.Errorf("tabSize = %q", settings["tabSize"])
+	}
+	if settings["syntaxHighlight"] != "false" || settings["lsp.go"] != "gopls -remote=auto" {
+		t.Errorf("settings = %v", settings)
+	}
+	if _, ok := settings["wordWrap"]; ok {
+		t.Errorf("wordWrap with a string value was accepted")
+	}
+
+	var messages []string
+	for _, err := range errs {
+		messages = append(messages, err.Error())
+	}
+	want := []string{
+		"colour: unknown option",
+		"tabsize: must be between 1 and 16",
+		"wordWrap: expected true or false",
+	}
+	if strings.Join(messages, "\n") != strings.Join(want, "\n") {
+		t.Errorf("errors = %q, want %q", messages, want)
+	}
+
+	if _, errs := parseConfig("tabSize = \n"); len(errs) != 1 || errs[0].Error() != "line 1: missing value" {
+		t.Errorf("syntax error = %v", errs)
+	}
+}
+
+func TestSetCommand(t *testing.T) {
+	ed := &Editor{}
+	ed.applySettings()
+
+	tests := []struct {
+		args    string
+		message string
+	}{
+		{"ts=2", "tabSize=2"},
+		{"tabsize?", "tabSize=2"},
+		{"tabsize 8", "tabSize=8"},
+		{"ts&", "tabSize=4"},
+		{"ts=0", "tabSize: must be between 1 and 16"},
+		{"nonumber", "showLineNumbers=false"},
+		{"number", "showLineNumbers=true"},
+		{"nu!", "showLineNumbers=false"},
+		{"invnumber", "showLineNumbers=true"},
+		{"syntax off", "syntaxHighlight=false"},
+		{"wrap ai?", "wordWrap=true  autoIndent=true"},
+		{"theme", "theme=default"},
+		{"colorscheme=gruvbox", "theme=gruvbox"},
+		{"theme=nosuch", `theme: unknown theme "nosuch"`},
+		{"lsp.go=", "lsp.go="},
+		{"bogus", "unknown option: bogus"},
+		{"notheme", "unknown option: notheme"},
+	}
+	for _, test := range tests {
+		ed.setCommand(test.args)
+		if ed.statusMessage != test.message {
+			t.Errorf(":set %s: message %q, want %q", test.args, ed.statusMessage, test.message)
+		}
+	}
+
+	if ed.tabSize != 4 || !ed.showLineNumbers || ed.syntaxHighlight || !ed.wordWrap {
+		t.Errorf("settings not applied: tabSize=%d number=%v syntax=%v wrap=%v",
+			ed.tabSize, ed.showLineNumbers, ed.syntaxHighlight, ed.wordWrap)
+	}
+	if ed.currentTheme().Name != "gruvbox" {
+		t.Errorf("theme = %s", ed.currentTheme().Name)
+	}
+	if _, ok := ed.settings["lsp.go"]; !ok || ed.option("lsp.go") != "" {
+		t.Errorf("lsp.go not turned off")
+	}
+}
+
+func TestConfigMigration(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", "")
+	writeTestFile(t, home, ".kiki_editor.json", `{"tabSize": 2, "showLineNumbers": true, "wordWrap": true}`)
+	writeTestFile(t, home, ".kiki-editor/themes/night.toml", `inherits = "gruvbox"`)
+
+	ed := &Editor{}
+	if errs := ed.loadConfig(); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if ed.tabSize != 2 || !ed.wordWrap {
+		t.Errorf("settings not migrated: tabSize=%d wrap=%v", ed.tabSize, ed.wordWrap)
+	}
+
+	dir := filepath.Join(home, ".config", "kiki-editor")
+	if _, err := os.Stat(filepath.Join(dir, "themes", "night.toml")); err != nil {
+		t.Errorf("themes not moved: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "config.toml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Only the values that differ from the defaults are written
+	for _, line := range []string{"tabSize = 2  #", "wordWrap = true  #", "# showLineNumbers = true  #"} {
+		if !strings.Contains(string(data), line) {
+			t.Errorf("config.toml has no %q:\n%s", line, data)
+		}
+	}
+
+	// The written file loads back the same
+	ed = &Editor{}
+	ed.loadConfig()
+	if ed.tabSize != 2 || !ed.wordWrap || ed.statusMessage != "" {
+		t.Errorf("reloaded: tabSize=%d wrap=%v message=%q", ed.tabSize, ed.wordWrap, ed.statusMessage)
+	}
+}
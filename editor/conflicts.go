@@ -0,0 +1,224 @@
+package editor
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// Merge conflicts.
+//
+// Conflicts git left in a file are highlighted: our side, the common base
+// when there is one (merge.conflictStyle diff3 or zdiff3) and their side.
+// ]x and [x jump between them, and co, ct, cb and c0 (or :conflict ours,
+// theirs, both, none) resolve the one at the cursor. They can be edited
+// by hand as well, since the markers are read again as the text changes.
+//
+// kiki-editor -merge LOCAL BASE REMOTE MERGED is the mergetool mode: the
+// first three open as buffers to compare with and MERGED is edited. The
+// exit status tells git whether the merge was resolved.
+
+// conflict is the marker lines of a conflict; base is -1 without a base
+// section.
+type conflict struct {
+	start, base, separator, end int
+}
+
+func isConflictMarker(line, marker string) bool {
+	return line == marker || strings.HasPrefix(line, marker+" ")
+}
+
+// parseConflicts finds the complete conflicts in lines.
+func parseConflicts(lines []string) []conflict {
+	var conflicts []conflict
+	c := conflict{start: -1}
+	for y, line := range lines {
+		switch {
+		case isConflictMarker(line, "<<<<<<<"):
+			c = conflict{start: y, base: -1, separator: -1}
+		case c.start < 0:
+		case isConflictMarker(line, "|||||||") && c.base < 0 && c.separator < 0:
+			c.base = y
+		case line == "=======" && c.separator < 0:
+			c.separator = y
+		case isConflictMarker(line, ">>>>>>>") && c.separator >= 0:
+			c.end = y
+			conflicts = append(conflicts, c)
+			c = conflict{start: -1}
+		}
+	}
+	return conflicts
+}
+
+// ours and theirs are where each side's lines are, to the line before to.
+func (c conflict) ours() (from, to int) {
+	if c.base >= 0 {
+		return c.start + 1, c.base
+	}
+	return c.start + 1, c.separator
+}
+
+func (c conflict) theirs() (from, to int) {
+	return c.separator + 1, c.end
+}
+
+// conflictStyle is the theme key of line y, "" outside conflicts.
+func conflictStyle(conflicts []conflict, y int) string {
+	for _, c := range conflicts {
+		if y < c.start || y > c.end {
+			continue
+		}
+		switch {
+		case y == c.start || y == c.base || y == c.separator || y == c.end:
+			return "conflict.marker"
+		case c.base >= 0 && y > c.base && y < c.separator:
+			return "conflict.base"
+		case y < c.separator:
+			return "conflict.ours"
+		default:
+			return "conflict.theirs"
+		}
+	}
+	return ""
+}
+
+// markConflict shades a line of a conflict with the style of its section.
+func (e *Editor) markConflict(key string, styles []tcell.Style) []tcell.Style {
+	theme := e.currentTheme()
+	marked := make([]tcell.Style, len(styles))
+	for x, style := range styles {
+		marked[x] = theme.Over(key, style)
+	}
+	return marked
+}
+
+// conflicts is parseConflicts of the buffer, parsed again only once it has
+// changed.
+func (e *Editor) conflicts() []conflict {
+	if e.version == 0 || e.version != e.conflictVersion {
+		e.conflictCache, e.conflictVersion = parseConflicts(e.lines), e.version
+	}
+	return e.conflictCache
+}
+
+// reportConflicts tells how many conflicts the buffer has, if any.
+func (e *Editor) reportConflicts() {
+	if n := len(e.conflicts()); n > 0 {
+		e.setStatusMessage(fmt.Sprintf("%d merge conflicts: ]x/[x to move, co/ct/cb/c0 to take ours/theirs/both/none", n))
+	}
+}
+
+// jumpToConflict moves the cursor to the next conflict below it, or above
+// it when dir is negative.
+func (e *Editor) jumpToConflict(dir int) {
+	conflicts := parseConflicts(e.lines)
+	if len(conflicts) == 0 {
+		e.setStatusMessage("No conflicts")
+		return
+	}
+	target := -1
+	for _, c := range conflicts {
+		if dir > 0 && c.start > e.cursorY {
+			target = c.start
+			break
+		}
+		if dir < 0 && c.end < e.cursorY {
+			target = c.start
+		}
+	}
+	if target < 0 {
+		e.setStatusMessage("No more conflicts")
+		return
+	}
+	e.cursorY, e.cursorX = target, 0
+}
+
+// resolveConflict replaces the conflict at the cursor with our side,
+// their side, both, the base or nothing.
+func (e *Editor) resolveConflict(keep string) {
+	if !e.editable() {
+		return
+	}
+	var c conflict
+	found := false
+	for _, c = range parseConflicts(e.lines) {
+		if found = e.cursorY >= c.start && e.cursorY <= c.end; found {
+			break
+		}
+	}
+	if !found {
+		e.setStatusMessage("No conflict here")
+		return
+	}
+	oursFrom, oursTo := c.ours()
+	theirsFrom, theirsTo := c.theirs()
+	ours, theirs := e.lines[oursFrom:oursTo], e.lines[theirsFrom:theirsTo]
+	var lines []string
+	switch keep {
+	case "ours":
+		lines = ours
+	case "theirs":
+		lines = theirs
+	case "both":
+		lines = append(append([]string{}, ours...), theirs...)
+	case "base":
+		if c.base < 0 {
+			e.setStatusMessage("This conflict has no base (set merge.conflictStyle to diff3)")
+			return
+		}
+		lines = e.lines[c.base+1 : c.separator]
+	case "none":
+	default:
+		e.setStatusMessage("Usage: conflict ours|theirs|both|base|none")
+		return
+	}
+
+	e.addUndo(Action{
+		Type:    "resolve",
+		action:  "resolve",
+		lines:   append([]string{}, e.lines...),
+		cursorX: e.cursorX,
+		cursorY: e.cursorY,
+	})
+	e.lines = spliceLines(e.lines, c.start, c.end-c.start+1, lines)
+	e.cursorY, e.cursorX = min(c.start, len(e.lines)-1), 0
+	e.markChanged()
+	if n := len(parseConflicts(e.lines)); n > 0 {
+		e.setStatusMessage(fmt.Sprintf("%d conflicts left", n))
+	} else {
+		e.setStatusMessage("All conflicts resolved")
+	}
+}
+
+// MergeFiles starts the mergetool mode: local, base and remote open as
+// buffers and merged is the one edited.
+func (e *Editor) MergeFiles(local, base, remote, merged string) error {
+	for _, name := range []string{local, base, remote, merged} {
+		if err := e.openFile(name); err != nil {
+			return err
+		}
+	}
+	e.treeVisible = false
+	e.mergeFile = merged
+	e.setStatusMessage("Merging: LOCAL, BASE and REMOTE are open as buffers, :diffsplit compares with one")
+	e.reportConflicts()
+	return nil
+}
+
+// ExitCode is the status to exit with: 1 after :cq, or in the mergetool
+// mode while the merged file still has conflicts, so git doesn't take it
+// as resolved.
+func (e *Editor) ExitCode() int {
+	if e.exitCode != 0 {
+		return e.exitCode
+	}
+	if e.mergeFile != "" {
+		data, err := os.ReadFile(e.mergeFile)
+		if err != nil || len(parseConflicts(splitBlob(data))) > 0 {
+			return 1
+		}
+	}
+	return 0
+}
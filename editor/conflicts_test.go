@@ -0,0 +1,138 @@
+package editor
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+const conflictText = `package main
+<<<<<<< HEAD
+var a = 1
+||||||| base
+var a = 0
+=======
+var a = 2
+>>>>>>> feature
+func main() {}
+<<<<<<< HEAD
+// ours
+=======
+// theirs
+>>>>>>> feature
+`
+
+func TestParseConflicts(t *testing.T) {
+	lines := strings.Split(conflictText, "\n")
+	conflicts := parseConflicts(lines)
+	if want := []conflict{{1, 3, 5, 7}, {9, -1, 11, 13}}; !slices.Equal(conflicts, want) {
+		t.Fatalf("conflicts %v, want %v", conflicts, want)
+	}
+	var styles []string
+	for y := range 9 {
+		styles = append(styles, strings.TrimPrefix(conflictStyle(conflicts, y), "conflict."))
+	}
+	want := []string{"", "marker", "ours", "marker", "base", "marker", "theirs", "marker", ""}
+	if !slices.Equal(styles, want) {
+		t.Errorf("styles %q, want %q", styles, want)
+	}
+
+	// Unfinished ones aren't conflicts
+	if c := parseConflicts([]string{"<<<<<<< HEAD", "x", "======="}); len(c) != 0 {
+		t.Errorf("unfinished conflict parsed: %v", c)
+	}
+}
+
+func TestResolveConflicts(t *testing.T) {
+	for _, test := range []struct {
+		keys string
+		want []string
+	}{
+		{"]xco", []string{"var a = 1", "// ours", "// theirs"}},
+		{"]xct", []string{"var a = 2", "// ours", "// theirs"}},
+		{"]xcb", []string{"var a = 1", "var a = 2", "// ours", "// theirs"}},
+		{"]xc0", []string{"// ours", "// theirs"}},
+		{"]x]xct", []string{"var a = 1", "var a = 0", "var a = 2", "// theirs"}},
+	} {
+		ed := &Editor{mode: "normal", lines: strings.Split(conflictText, "\n")}
+		ed.applySettings()
+		typeKeys(ed, test.keys)
+		var got []string
+		for _, line := range ed.lines {
+			if strings.HasPrefix(line, "var") || strings.HasPrefix(line, "// ") {
+				got = append(got, line)
+			}
+		}
+		if !slices.Equal(got, test.want) {
+			t.Errorf("%s kept %q, want %q", test.keys, got, test.want)
+		}
+	}
+
+	ed := &Editor{mode: "normal", lines: strings.Split(conflictText, "\n"), cursorY: 4}
+	ed.applySettings()
+	ed.commandBuffer = "conflict base"
+	ed.handleCommand()
+	if ed.lines[1] != "var a = 0" || len(parseConflicts(ed.lines)) != 1 {
+		t.Errorf(":conflict base left %q", ed.lines)
+	}
+	ed.undo()
+	if len(parseConflicts(ed.lines)) != 2 {
+		t.Errorf("undo left %q", ed.lines)
+	}
+
+	// What's drawn is parsed again only after a change
+	conflicts := ed.conflicts()
+	if len(conflicts) != 2 || &ed.conflicts()[0] != &conflicts[0] {
+		t.Errorf("conflicts parsed again without a change")
+	}
+	ed.cursorY = 1
+	typeKeys(ed, "co")
+	if n := len(ed.conflicts()); n != 1 {
+		t.Errorf("%d conflicts after resolving one", n)
+	}
+}
+
+func TestMergeTool(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"LOCAL", "BASE", "REMOTE"} {
+		writeTestFile(t, dir, name, strings.ToLower(name)+"\n")
+	}
+	writeTestFile(t, dir, "MERGED", conflictText)
+	path := func(name string) string { return filepath.Join(dir, name) }
+
+	ed := &Editor{mode: "normal"}
+	ed.applySettings()
+	if err := ed.MergeFiles(path("LOCAL"), path("BASE"), path("REMOTE"), path("MERGED")); err != nil {
+		t.Fatal(err)
+	}
+	if ed.filename != path("MERGED") || len(ed.buffers) != 4 {
+		t.Fatalf("editing %s with %d buffers", ed.filename, len(ed.buffers))
+	}
+	if ed.ExitCode() != 1 {
+		t.Errorf("exit code 0 with conflicts left")
+	}
+
+	// :w tells of the conflicts left
+	ed.commandBuffer = "w"
+	ed.handleCommand()
+	if !strings.HasPrefix(ed.statusMessage, "2 merge conflicts") {
+		t.Errorf(":w with conflicts left: %q", ed.statusMessage)
+	}
+	typeKeys(ed, "]xco]xcb")
+	ed.commandBuffer = "w"
+	ed.handleCommand()
+	if ed.statusMessage != "File saved" {
+		t.Errorf(":w once resolved: %q", ed.statusMessage)
+	}
+	if ed.ExitCode() != 0 {
+		data, _ := os.ReadFile(path("MERGED"))
+		t.Errorf("exit code 1 once resolved:\n%s", data)
+	}
+	ed.commandBuffer = "cq"
+	ed.handleCommand()
+	if !ed.quit || ed.ExitCode() != 1 {
+		t.Errorf(":cq exits with %d", ed.ExitCode())
+	}
+}
@@ -3,27 +3,28 @@ package editor
 import (
 	"slices"
 	"strings"
+	"unicode/utf8"
 )
 
 // Cursor movement and manipulation
 func (e *Editor) moveCursor(dx, dy int) {
-	// Calculate new position
-	newY := e.cursorY + dy
-	newX := e.cursorX + dx
+	// Calculate new position, stepping over closed folds
+	newY := e.cursorY
+	for i := 0; i < dy; i++ {
+		newY = e.nextLine(newY)
+	}
+	for i := 0; i > dy; i-- {
+		newY = e.prevLine(newY)
+	}
 
 	// Ensure we stay within valid lines
 	if newY >= 0 && newY < len(e.lines) {
-		e.cursorY = newY
-
-		// Handle scrolling
-		if e.cursorY < e.scrollY {
-			// Scroll up if cursor moves above visible area
-			e.scrollY = e.cursorY
-		} else if e.cursorY >= e.scrollY+e.screenHeight-2 {
-			// Scroll down if cursor moves below visible area
-			// -2 accounts for status bars
-			e.scrollY = e.cursorY - (e.screenHeight - 3)
+		// Keep the screen column, which differs from the byte offset
+		// when either line has tabs or wide characters
+		if dy != 0 && dx == 0 && e.cursorY < len(e.lines) {
+			e.cursorX = byteForColumn(e.lines[newY], e.cursorColumn(), e.tabSize)
 		}
+		e.cursorY = newY
 
 		// Adjust X position based on new line length
 		if e.cursorX > len(e.lines[e.cursorY]) {
@@ -31,10 +32,15 @@ func (e *Editor) moveCursor(dx, dy int) {
 		}
 	}
 
-	// Ensure X position is valid
-	if newX >= 0 && newX <= len(e.lines[e.cursorY]) {
-		e.cursorX = newX
+	// Move a whole character at a time
+	line := e.lines[e.cursorY]
+	for ; dx > 0 && e.cursorX < len(line); dx-- {
+		e.cursorX = nextGrapheme(line, e.cursorX)
 	}
+	for ; dx < 0 && e.cursorX > 0; dx++ {
+		e.cursorX = prevGrapheme(line, e.cursorX)
+	}
+	e.keepCursorVisible()
 }
 
 func (e *Editor) insertRune(ch rune) {
@@ -64,8 +70,8 @@ func (e *Editor) insertRune(ch rune) {
 	}
 
 	e.lines[e.cursorY] = line[:e.cursorX] + string(ch) + line[e.cursorX:]
-	e.cursorX++
-	e.isDirty = true
+	e.cursorX += utf8.RuneLen(ch)
+	e.markChanged()
 }
 
 func (e *Editor) insertNewLine() {
@@ -93,9 +99,7 @@ func (e *Editor) insertNewLine() {
 	// Check for additional indentation triggers
 	if strings.HasSuffix(strings.TrimSpace(currentLine), "{") {
 		// Add one level of indentation after opening brace
-		for i := 0; i < e.tabSize; i++ {
-			indent += " "
-		}
+		indent += e.indentUnit()
 	}
 
 	// Split the line at cursor position
@@ -108,7 +112,7 @@ func (e *Editor) insertNewLine() {
 	// Move cursor to the beginning of the new line (after indentation)
 	e.cursorY++
 	e.cursorX = len(indent)
-	e.isDirty = true
+	e.markChanged()
 }
 
 func (e *Editor) backspace() {
@@ -125,9 +129,10 @@ func (e *Editor) backspace() {
 
 	if e.cursorX > 0 {
 		line := e.lines[e.cursorY]
-		e.lines[e.cursorY] = line[:e.cursorX-1] + line[e.cursorX:]
-		e.cursorX--
-		e.isDirty = true
+		start := prevGrapheme(line, e.cursorX)
+		e.lines[e.cursorY] = line[:start] + line[e.cursorX:]
+		e.cursorX = start
+		e.markChanged()
 	} else if e.cursorY > 0 {
 		// Join with previous line
 		newX := len(e.lines[e.cursorY-1])
@@ -135,6 +140,6 @@ func (e *Editor) backspace() {
 		e.lines = slices.Delete(e.lines, e.cursorY, e.cursorY+1)
 		e.cursorY--
 		e.cursorX = newX
-		e.isDirty = true
+		e.markChanged()
 	}
 }
@@ -0,0 +1,186 @@
+package editor
+
+// Line diffs.
+//
+// diffLines compares two lists of lines with Myers' O(ND) algorithm, in
+// its linear space form: find the middle snake of the shortest edit
+// script, then diff the halves before and after it. Lines are numbered by
+// content first so comparing them is cheap.
+
+// diffHunk is a run of changed lines: old lines oldStart.. replaced by new
+// lines newStart... A count of 0 is a pure insertion or deletion, and the
+// start is then the line the other side's lines come before.
+type diffHunk struct {
+	oldStart, oldCount int
+	newStart, newCount int
+}
+
+type differ struct {
+	a, b              []int
+	deleted, inserted []bool
+}
+
+// diffLines is the hunks that turn a into b.
+func diffLines(a, b []string) []diffHunk {
+	ids := map[string]int{}
+	number := func(lines []string) []int {
+		out := make([]int, len(lines))
+		for i, line := range lines {
+			id, ok := ids[line]
+			if !ok {
+				id = len(ids)
+				ids[line] = id
+			}
+			out[i] = id
+		}
+		return out
+	}
+	d := &differ{
+		a:        number(a),
+		b:        number(b),
+		deleted:  make([]bool, len(a)),
+		inserted: make([]bool, len(b)),
+	}
+	d.compare(0, len(a), 0, len(b))
+
+	// Lines neither deleted nor inserted pair up in order
+	var hunks []diffHunk
+	for i, j := 0, 0; i < len(a) || j < len(b); {
+		if i < len(a) && j < len(b) && !d.deleted[i] && !d.inserted[j] {
+			i, j = i+1, j+1
+			continue
+		}
+		h := diffHunk{oldStart: i, newStart: j}
+		for i < len(a) && d.deleted[i] {
+			i++
+		}
+		for j < len(b) && d.inserted[j] {
+			j++
+		}
+		h.oldCount, h.newCount = i-h.oldStart, j-h.newStart
+		hunks = append(hunks, h)
+	}
+	return hunks
+}
+
+// compare marks the lines that differ between a[aLo:aHi] and b[bLo:bHi].
+func (d *differ) compare(aLo, aHi, bLo, bHi int) {
+	for aLo < aHi && bLo < bHi && d.a[aLo] == d.b[bLo] {
+		aLo, bLo = aLo+1, bLo+1
+	}
+	for aLo < aHi && bLo < bHi && d.a[aHi-1] == d.b[bHi-1] {
+		aHi, bHi = aHi-1, bHi-1
+	}
+	switch {
+	case aLo == aHi:
+		for j := bLo; j < bHi; j++ {
+			d.inserted[j] = true
+		}
+	case bLo == bHi:
+		for i := aLo; i < aHi; i++ {
+			d.deleted[i] = true
+		}
+	default:
+		x, y := d.middleSnake(aLo, aHi, bLo, bHi)
+		if (x == aLo && y == bLo) || (x == aHi && y == bHi) {
+			// No progress, which shouldn't happen: all of it changed
+			d.compare(aLo, aHi, bHi, bHi)
+			d.compare(aHi, aHi, bLo, bHi)
+			return
+		}
+		d.compare(aLo, x, bLo, y)
+		d.compare(x, aHi, y, bHi)
+	}
+}
+
+// middleSnake runs the search forward from the start and backward from the
+// end until the two meet, and returns a point where they do. The ranges
+// differ in their first and last lines, so it's neither end.
+func (d *differ) middleSnake(aLo, aHi, bLo, bHi int) (x, y int) {
+	n, m := aHi-aLo, bHi-bLo
+	maxD := (n + m + 1) / 2
+	offset := maxD + 1
+	forward := make([]int, 2*offset+1)
+	backward := make([]int, 2*offset+1)
+	for i := range forward {
+		forward[i], backward[i] = -1, -1
+	}
+	forward[offset+1], backward[offset+1] = 0, 0
+	delta := n - m
+	// With an odd delta the paths meet going forward, else going backward
+	odd := delta%2 != 0
+	// Diagonals trimmed off either side once a path leaves the grid
+	var fLow, fHigh, bLow, bHigh int
+
+	for step := 0; step <= maxD; step++ {
+		for k := -step + fLow; k <= step-fHigh; k += 2 {
+			i := offset + k
+			var x int
+			if k == -step || (k != step && forward[i-1] < forward[i+1]) {
+				x = forward[i+1]
+			} else {
+				x = forward[i-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && d.a[aLo+x] == d.b[bLo+y] {
+				x, y = x+1, y+1
+			}
+			forward[i] = x
+			switch {
+			case x > n:
+				fHigh += 2
+			case y > m:
+				fLow += 2
+			case odd:
+				// The backward path on this diagonal
+				if j := offset + delta - k; j >= 0 && j < len(backward) && backward[j] >= 0 && x >= n-backward[j] {
+					return aLo + x, bLo + y
+				}
+			}
+		}
+		for k := -step + bLow; k <= step-bHigh; k += 2 {
+			i := offset + k
+			var x int
+			if k == -step || (k != step && backward[i-1] < backward[i+1]) {
+				x = backward[i+1]
+			} else {
+				x = backward[i-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && d.a[aHi-1-x] == d.b[bHi-1-y] {
+				x, y = x+1, y+1
+			}
+			backward[i] = x
+			switch {
+			case x > n:
+				bHigh += 2
+			case y > m:
+				bLow += 2
+			case !odd:
+				if j := offset + delta - k; j >= 0 && j < len(forward) && forward[j] >= 0 && forward[j] >= n-x {
+					fx := forward[j]
+					return aLo + fx, bLo + fx - (j - offset)
+				}
+			}
+		}
+	}
+	// Not reached: the paths meet by the middle
+	return aLo, bLo
+}
+
+// matchLines maps each line of b to the line of a it's unchanged from, or
+// -1 if it's new, given the hunks that turn a into b.
+func matchLines(a, b []string, hunks []diffHunk) []int {
+	out := make([]int, len(b))
+	i, j := 0, 0
+	for _, h := range append(hunks, diffHunk{oldStart: len(a), newStart: len(b)}) {
+		for ; j < h.newStart; i, j = i+1, j+1 {
+			out[j] = i
+		}
+		for ; j < h.newStart+h.newCount; j++ {
+			out[j] = -1
+		}
+		i = h.oldStart + h.oldCount
+	}
+	return out
+}
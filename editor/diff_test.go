@@ -0,0 +1,82 @@
+package editor
+
+import (
+	"math/rand"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// applyHunks turns a into b with the hunks of diffLines(a, b).
+func applyHunks(a, b []string, hunks []diffHunk) []string {
+	var out []string
+	i := 0
+	for _, h := range hunks {
+		out = append(out, a[i:h.oldStart]...)
+		out = append(out, b[h.newStart:h.newStart+h.newCount]...)
+		i = h.oldStart + h.oldCount
+	}
+	return append(out, a[i:]...)
+}
+
+// lcsLength is the length of the longest common subsequence of a and b.
+func lcsLength(a, b []string) int {
+	prev := make([]int, len(b)+1)
+	for i := range a {
+		cur := make([]int, len(b)+1)
+		for j := range b {
+			if a[i] == b[j] {
+				cur[j+1] = prev[j] + 1
+			} else {
+				cur[j+1] = max(prev[j+1], cur[j])
+			}
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want []diffHunk
+	}{
+		{"a b c", "a b c", nil},
+		{"a b c", "a x c", []diffHunk{{1, 1, 1, 1}}},
+		{"a b c", "a b c d", []diffHunk{{3, 0, 3, 1}}},
+		{"a b c", "b c", []diffHunk{{0, 1, 0, 0}}},
+		{"", "a b", []diffHunk{{0, 0, 0, 2}}},
+		{"a b c d e", "a c d x e f", []diffHunk{{1, 1, 1, 0}, {4, 0, 3, 1}, {5, 0, 5, 1}}},
+	}
+	for _, test := range tests {
+		a, b := strings.Fields(test.a), strings.Fields(test.b)
+		if got := diffLines(a, b); !slices.Equal(got, test.want) {
+			t.Errorf("%q -> %q: %v, want %v", test.a, test.b, got, test.want)
+		}
+	}
+
+	// Random edits come out as short as they can be
+	r := rand.New(rand.NewSource(1))
+	words := []string{"a", "b", "c", "d"}
+	for n := 0; n < 500; n++ {
+		a := make([]string, r.Intn(12))
+		b := make([]string, r.Intn(12))
+		for i := range a {
+			a[i] = words[r.Intn(len(words))]
+		}
+		for i := range b {
+			b[i] = words[r.Intn(len(words))]
+		}
+		hunks := diffLines(a, b)
+		if got := applyHunks(a, b, hunks); !slices.Equal(got, b) {
+			t.Fatalf("%v -> %v: hunks %v give %v", a, b, hunks, got)
+		}
+		changed := 0
+		for _, h := range hunks {
+			changed += h.oldCount + h.newCount
+		}
+		if want := len(a) + len(b) - 2*lcsLength(a, b); changed != want {
+			t.Fatalf("%v -> %v: %d lines changed, want %d", a, b, changed, want)
+		}
+	}
+}
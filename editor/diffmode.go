@@ -0,0 +1,526 @@
+package editor
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/alecthomas/chroma"
+	"github.com/alecthomas/chroma/lexers"
+	"github.com/gdamore/tcell/v2"
+)
+
+// Side by side diffs.
+//
+// kiki-editor -d a b and :diffsplit show two buffers next to each other
+// with their lines lined up: where one side has lines the other doesn't,
+// the other shows filler rows, so the two always scroll together. Changed
+// lines are shaded and the characters that changed in them more so. ]c
+// and [c jump between hunks, do takes the other side's lines for the hunk
+// at the cursor and dp puts this side's into the other. Ctrl-W w (or h, l)
+// goes to the other side and :diffoff back to editing one buffer.
+
+type diffView struct {
+	buffers    [2]int // left and right buffers
+	highlights [2]*highlighter
+	lines      [2][]string // the buffers as rows were worked out for
+	hunks      []diffHunk  // turning left into right
+	rows       []diffRow
+	lineRows   [2][]int // the row each line of a side is on
+	top        int      // first row on screen
+	drawing    int      // side being drawn, or -1 for the current one
+}
+
+// diffRow is a screen row of a diff: a line of each side, -1 for filler,
+// and the hunk it's part of, -1 if none.
+type diffRow struct {
+	lines [2]int
+	hunk  int
+}
+
+// diffSplit is :diffsplit, which diffs the current buffer with filename.
+func (e *Editor) diffSplit(filename string) error {
+	e.syncBuffers()
+	current := e.bufferIndex
+	if err := e.openFile(filename); err != nil {
+		return err
+	}
+	other := e.bufferIndex
+	if other == current {
+		return fmt.Errorf("%s is the current buffer", filename)
+	}
+	e.switchBuffer(current)
+	e.startDiff(current, other)
+	return nil
+}
+
+// DiffFiles opens a and b side by side with the file tree hidden, for
+// kiki-editor -d.
+func (e *Editor) DiffFiles(a, b string) error {
+	if err := e.openFile(a); err != nil {
+		return err
+	}
+	e.treeVisible = false
+	return e.diffSplit(b)
+}
+
+// startDiff shows buffers left and right side by side, with the cursor in
+// the current one.
+func (e *Editor) startDiff(left, right int) {
+	e.stashBuffer()
+	view := &diffView{buffers: [2]int{left, right}, drawing: -1}
+	if e.syntaxHighlight {
+		for s, i := range view.buffers {
+			b := e.buffers[i]
+			var lexer chroma.Lexer
+			if b.fileType != "" {
+				lexer = lexers.Get(b.fileType)
+			}
+			if lexer == nil {
+				lexer = LexerFor(b.filename, b.lines)
+			}
+			view.highlights[s] = newHighlighter(lexer, e.currentTheme())
+		}
+	}
+	e.diff = view
+	e.syncDiff()
+	e.setStatusMessage(fmt.Sprintf("%d hunks, ]c/[c to move, do/dp to copy, Ctrl-W w to switch sides", len(view.hunks)))
+}
+
+// endDiff is :diffoff.
+func (e *Editor) endDiff() {
+	e.diff = nil
+	e.drawnCursorX, e.drawnCursorY = -1, -1
+}
+
+// diffSide is the side the current buffer is on, or -1 if it's not in
+// the diff.
+func (e *Editor) diffSide() int {
+	return slices.Index(e.diff.buffers[:], e.bufferIndex)
+}
+
+// diffSideLines is side s's lines as they are now.
+func (e *Editor) diffSideLines(s int) []string {
+	if e.diff.buffers[s] == e.bufferIndex {
+		return e.lines
+	}
+	return e.buffers[e.diff.buffers[s]].lines
+}
+
+// syncDiff lines the two sides up again if either changed.
+func (e *Editor) syncDiff() {
+	view := e.diff
+	left, right := e.diffSideLines(0), e.diffSideLines(1)
+	if view.rows != nil && slices.Equal(view.lines[0], left) && slices.Equal(view.lines[1], right) {
+		return
+	}
+	view.lines = [2][]string{slices.Clone(left), slices.Clone(right)}
+	view.hunks = diffLines(left, right)
+	view.rows = view.rows[:0]
+	i, j := 0, 0
+	for n, h := range append(view.hunks, diffHunk{oldStart: len(left), newStart: len(right)}) {
+		for ; i < h.oldStart; i, j = i+1, j+1 {
+			view.rows = append(view.rows, diffRow{lines: [2]int{i, j}, hunk: -1})
+		}
+		for k := range max(h.oldCount, h.newCount) {
+			row := diffRow{lines: [2]int{-1, -1}, hunk: n}
+			if k < h.oldCount {
+				row.lines[0] = i + k
+			}
+			if k < h.newCount {
+				row.lines[1] = j + k
+			}
+			view.rows = append(view.rows, row)
+		}
+		i, j = h.oldStart+h.oldCount, h.newStart+h.newCount
+	}
+	for s := range view.lineRows {
+		view.lineRows[s] = make([]int, len(view.lines[s]))
+	}
+	for r, row := range view.rows {
+		for s, y := range row.lines {
+			if y >= 0 {
+				view.lineRows[s][y] = r
+			}
+		}
+	}
+	view.top = min(view.top, max(0, len(view.rows)-1))
+}
+
+// diffCursorRow is the row the cursor's line is on.
+func (e *Editor) diffCursorRow() int {
+	return e.diffRowOf(e.cursorY)
+}
+
+// diffRowOf is the row line y of the current buffer is on.
+func (e *Editor) diffRowOf(y int) int {
+	e.syncDiff()
+	rows := e.diff.lineRows[max(e.diffSide(), 0)]
+	if len(rows) == 0 {
+		return 0
+	}
+	return rows[min(y, len(rows)-1)]
+}
+
+// diffLineAt is side s's line on row r, or the closest one after it, or
+// before it when there's none after.
+func (e *Editor) diffLineAt(s, r int) int {
+	rows := e.diff.rows
+	for i := r; i < len(rows); i++ {
+		if y := rows[i].lines[s]; y >= 0 {
+			return y
+		}
+	}
+	for i := min(r, len(rows)) - 1; i >= 0; i-- {
+		if y := rows[i].lines[s]; y >= 0 {
+			return y
+		}
+	}
+	return 0
+}
+
+// diffTextArea is where side s's text is on screen, s -1 being the
+// current buffer's side.
+func (e *Editor) diffTextArea(s int) (x, width int) {
+	if s < 0 {
+		s = e.diff.drawing
+	}
+	if s < 0 {
+		s = max(e.diffSide(), 0)
+	}
+	if e.treeVisible {
+		x = e.treeWidth + 1
+	}
+	pane := max(2, (e.screenWidth-x-1)/2)
+	number := e.diffNumberWidth()
+	x += s*(pane+1) + number
+	return x, max(1, pane-number)
+}
+
+// diffNumberWidth is the width of the line numbers in front of each side.
+func (e *Editor) diffNumberWidth() int {
+	if !e.showLineNumbers {
+		return 0
+	}
+	lines := max(len(e.diffSideLines(0)), len(e.diffSideLines(1)))
+	return len(fmt.Sprint(lines)) + 1
+}
+
+// diffScreenPosition is where byte x of the current buffer's line y is
+// drawn, like screenPosition.
+func (e *Editor) diffScreenPosition(y, x int) (sx, sy int, ok bool) {
+	if y < 0 || y >= len(e.lines) {
+		return 0, 0, false
+	}
+	sy = e.diffRowOf(y) - e.diff.top
+	left, width := e.diffTextArea(-1)
+	col := displayColumn(e.lines[y], min(x, len(e.lines[y])), e.tabSize) - e.scrollX
+	return left + col, sy, sy >= 0 && sy < e.textHeight() && col >= 0 && col < width
+}
+
+// drawDiff draws both sides, a row at a time.
+func (e *Editor) drawDiff() {
+	view := e.diff
+	e.syncDiff()
+	height := e.textHeight()
+	if e.cursorX != e.drawnCursorX || e.cursorY != e.drawnCursorY {
+		row := e.diffCursorRow()
+		if row < view.top {
+			view.top = row
+		} else if row >= view.top+height {
+			view.top = row - height + 1
+		}
+		e.sideScrollToCursor()
+		e.drawnCursorX, e.drawnCursorY = e.cursorX, e.cursorY
+	}
+
+	// Rows don't wrap, so the sides stay lined up
+	wrap := e.wordWrap
+	e.wordWrap = false
+	defer func() {
+		e.wordWrap = wrap
+		view.drawing = -1
+	}()
+	end := min(view.top+height, len(view.rows))
+	for s := range view.buffers {
+		view.drawing = s
+		lines := e.diffSideLines(s)
+		if h := view.highlights[s]; h != nil {
+			h.ensure(lines, view.lineAtEnd(s, end))
+		}
+		left, width := e.diffTextArea(s)
+		number := e.diffNumberWidth()
+		if s == 1 {
+			separator := e.uiStyle("tree.separator")
+			for sy := range height {
+				e.screen.SetContent(left-number-1, sy, '│', nil, separator)
+			}
+		}
+		for r := view.top; r < end; r++ {
+			sy := r - view.top
+			row := view.rows[r]
+			y := row.lines[s]
+			if y < 0 {
+				drawText(e.screen, left-number, sy, e.uiStyle("diff.removed"), strings.Repeat("-", width+number))
+				continue
+			}
+			if number > 0 {
+				drawText(e.screen, left-number, sy, e.uiStyle("linenumber"), fmt.Sprintf("%*d ", number-1, y+1))
+			}
+			line := lines[y]
+			styles := e.diffStyles(s, row, line)
+			if row.hunk >= 0 {
+				// Shade the rest of the row like the line
+				fill := styles[len(styles)-1]
+				for c := range width {
+					e.screen.SetContent(left+c, sy, ' ', nil, fill)
+				}
+				styles = styles[:len(line)]
+			}
+			e.drawRow(line, wrapRow{0, len(line), 0}, styles, sy)
+		}
+	}
+}
+
+// lineAtEnd is side s's line after the ones on rows before end.
+func (view *diffView) lineAtEnd(s, end int) int {
+	for r := end - 1; r >= 0; r-- {
+		if y := view.rows[r].lines[s]; y >= 0 {
+			return y + 1
+		}
+	}
+	return 0
+}
+
+// diffStyles styles side s's line on row: highlighted, and shaded as
+// added or changed if it's part of a hunk. For a hunk there's one style
+// more than there are bytes, for the rest of the row.
+func (e *Editor) diffStyles(s int, row diffRow, line string) []tcell.Style {
+	var base []tcell.Style
+	if h := e.diff.highlights[s]; h != nil {
+		if styles := h.styles(row.lines[s]); len(styles) == len(line) {
+			base = styles
+		}
+	}
+	if base == nil {
+		base = e.plainStyles(line)
+	}
+	if row.hunk < 0 {
+		return base
+	}
+
+	theme := e.currentTheme()
+	key := "diff.changed"
+	var changed []bool
+	if other := row.lines[1-s]; other < 0 {
+		key = "diff.added"
+	} else if s == 0 {
+		changed, _ = changedBytes(line, e.diffSideLines(1)[other])
+	} else {
+		_, changed = changedBytes(e.diffSideLines(0)[other], line)
+	}
+	styles := make([]tcell.Style, len(line)+1)
+	for i := range styles {
+		style := e.uiStyle("text")
+		if i < len(line) {
+			style = base[i]
+		}
+		style = theme.Over(key, style)
+		if i < len(changed) && changed[i] {
+			style = theme.Over("diff.text", style)
+		}
+		styles[i] = style
+	}
+	return styles
+}
+
+// changedBytes marks the bytes of a and b that differ between the two
+// lines, diffing them a character at a time.
+func changedBytes(a, b string) (inA, inB []bool) {
+	split := func(s string) (clusters []string, offsets []int) {
+		for i := 0; i < len(s); {
+			cluster, _ := firstGrapheme(s[i:])
+			clusters, offsets = append(clusters, cluster), append(offsets, i)
+			i += len(cluster)
+		}
+		return clusters, append(offsets, len(s))
+	}
+	ca, oa := split(a)
+	cb, ob := split(b)
+	inA, inB = make([]bool, len(a)), make([]bool, len(b))
+	for _, h := range diffLines(ca, cb) {
+		for i := oa[h.oldStart]; i < oa[h.oldStart+h.oldCount]; i++ {
+			inA[i] = true
+		}
+		for i := ob[h.newStart]; i < ob[h.newStart+h.newCount]; i++ {
+			inB[i] = true
+		}
+	}
+	return inA, inB
+}
+
+// scrollDiff scrolls both sides n rows, down if n is positive.
+func (e *Editor) scrollDiff(n int) {
+	e.syncDiff()
+	e.diff.top = max(0, min(e.diff.top+n, len(e.diff.rows)-1))
+}
+
+// switchDiffSide goes to side s, to the line on the cursor's row.
+func (e *Editor) switchDiffSide(s int) {
+	if s == e.diffSide() {
+		return
+	}
+	row := e.diffCursorRow()
+	col, scrollX := e.cursorColumn(), e.scrollX
+	e.switchBuffer(e.diff.buffers[s])
+	e.cursorY = e.diffLineAt(s, row)
+	e.cursorX = byteForColumn(e.lines[e.cursorY], col, e.tabSize)
+	e.scrollX = scrollX
+}
+
+// diffMouse handles the mouse over the two sides: the wheel scrolls both
+// and a click goes to the line clicked on.
+func (e *Editor) diffMouse(x, y int, button tcell.ButtonMask, pressed bool) {
+	switch {
+	case button&tcell.WheelUp != 0:
+		e.scrollDiff(-3)
+	case button&tcell.WheelDown != 0:
+		e.scrollDiff(3)
+	case pressed && y < e.textHeight():
+		s := 0
+		if right, _ := e.diffTextArea(1); x >= right-e.diffNumberWidth() {
+			s = 1
+		}
+		row := e.diff.top + y
+		if row >= len(e.diff.rows) {
+			return
+		}
+		if s != e.diffSide() {
+			e.switchBuffer(e.diff.buffers[s])
+		}
+		left, _ := e.diffTextArea(s)
+		e.cursorY = e.diffLineAt(s, row)
+		e.cursorX = byteForColumn(e.lines[e.cursorY], max(0, x-left)+e.scrollX, e.tabSize)
+	}
+}
+
+// jumpToDiffHunk moves the cursor to the next hunk below it, or above it
+// when dir is negative.
+func (e *Editor) jumpToDiffHunk(dir int) {
+	e.syncDiff()
+	view := e.diff
+	if len(view.hunks) == 0 {
+		e.setStatusMessage("No differences")
+		return
+	}
+	current := e.diffCursorRow()
+	target := -1
+	for r, row := range view.rows {
+		if row.hunk < 0 || (r > 0 && view.rows[r-1].hunk == row.hunk) {
+			continue
+		}
+		if dir > 0 && r > current {
+			target = r
+			break
+		}
+		if dir < 0 && r < current && view.rows[current].hunk != row.hunk {
+			target = r
+		}
+	}
+	if target < 0 {
+		e.setStatusMessage("No more hunks")
+		return
+	}
+	e.cursorY, e.cursorX = e.diffLineAt(e.diffSide(), target), 0
+}
+
+// diffHunkAtCursor is the hunk on the cursor line, or next to it where
+// this side has filler.
+func (e *Editor) diffHunkAtCursor() (diffHunk, bool) {
+	view := e.diff
+	s := e.diffSide()
+	r := e.diffCursorRow()
+	for _, near := range []int{r, r - 1, r + 1} {
+		if near < 0 || near >= len(view.rows) {
+			continue
+		}
+		if row := view.rows[near]; row.hunk >= 0 && (near == r || row.lines[s] < 0) {
+			return view.hunks[row.hunk], true
+		}
+	}
+	e.setStatusMessage("No hunk here")
+	return diffHunk{}, false
+}
+
+// diffObtain is do: the other side's version of the hunk at the cursor
+// replaces this side's.
+func (e *Editor) diffObtain() {
+	if !e.editable() {
+		return
+	}
+	s := e.diffSide()
+	h, ok := e.diffHunkAtCursor()
+	if !ok {
+		return
+	}
+	start, count, from := hunkSide(h, s)
+	e.addUndo(Action{
+		Type:    "diffget",
+		action:  "diffget",
+		lines:   append([]string{}, e.lines...),
+		cursorX: e.cursorX,
+		cursorY: e.cursorY,
+	})
+	e.lines = spliceLines(e.lines, start, count, from(e.diffSideLines(1-s)))
+	e.cursorY, e.cursorX = min(start, len(e.lines)-1), 0
+	e.markChanged()
+}
+
+// diffPut is dp: this side's version of the hunk at the cursor replaces
+// the other side's.
+func (e *Editor) diffPut() {
+	s := e.diffSide()
+	h, ok := e.diffHunkAtCursor()
+	if !ok {
+		return
+	}
+	_, _, from := hunkSide(h, s)
+	start, count, _ := hunkSide(h, 1-s)
+	b := e.buffers[e.diff.buffers[1-s]]
+	if b.readOnly {
+		e.setStatusMessage("The other side is read-only")
+		return
+	}
+	b.undoStack = append(b.undoStack, Action{
+		Type:    "diffput",
+		action:  "diffput",
+		lines:   append([]string{}, b.lines...),
+		cursorX: b.cursorX,
+		cursorY: b.cursorY,
+	})
+	b.redoStack = nil
+	b.lines = spliceLines(b.lines, start, count, from(e.lines))
+	b.cursorY, b.cursorX = min(start, len(b.lines)-1), 0
+	b.isDirty = true
+	e.lastVersion++
+	b.version = e.lastVersion
+}
+
+// hunkSide is side s's lines of h, and a function picking those of the
+// other side's out of its buffer.
+func hunkSide(h diffHunk, s int) (start, count int, other func([]string) []string) {
+	if s == 0 {
+		return h.oldStart, h.oldCount, func(lines []string) []string { return lines[h.newStart : h.newStart+h.newCount] }
+	}
+	return h.newStart, h.newCount, func(lines []string) []string { return lines[h.oldStart : h.oldStart+h.oldCount] }
+}
+
+// spliceLines replaces count lines at start with with, keeping one line
+// at least.
+func spliceLines(lines []string, start, count int, with []string) []string {
+	out := slices.Concat(lines[:start], with, lines[start+count:])
+	if len(out) == 0 {
+		out = []string{""}
+	}
+	return out
+}
@@ -0,0 +1,126 @@
+package editor
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+func TestChangedBytes(t *testing.T) {
+	inA, inB := changedBytes("x := 1 // é", "x := 42 // é")
+	mark := func(s string, changed []bool) string {
+		var b strings.Builder
+		for i := range s {
+			if changed[i] {
+				b.WriteByte('^')
+			} else {
+				b.WriteByte(' ')
+			}
+		}
+		return b.String()
+	}
+	if got := mark("x := 1 // é", inA); got != "     ^     " {
+		t.Errorf("a: %q", got)
+	}
+	if got := mark("x := 42 // é", inB); got != "     ^^     " {
+		t.Errorf("b: %q", got)
+	}
+}
+
+func TestDiffMode(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "old.txt", "one\ntwo\nthree\nfour\nfive\n")
+	writeTestFile(t, dir, "new.txt", "one\nTWO\nthree\nfive\nsix\n")
+	screen := tcell.NewSimulationScreen("")
+	screen.Init()
+	screen.SetSize(30, 8)
+	ed := &Editor{
+		mode:         "normal",
+		screen:       screen,
+		screenWidth:  30,
+		screenHeight: 8,
+		settings:     map[string]string{"syntaxHighlight": "false"},
+	}
+	ed.applySettings()
+	if err := ed.DiffFiles(filepath.Join(dir, "old.txt"), filepath.Join(dir, "new.txt")); err != nil {
+		t.Fatal(err)
+	}
+	ed.Draw()
+
+	// Lines only one side has face filler on the other
+	want := []string{
+		"1 one         │1 one",
+		"2 two         │2 TWO",
+		"3 three       │3 three",
+		"4 four        │--------------",
+		"5 five        │4 five",
+		"--------------│5 six",
+	}
+	for sy, line := range want {
+		if got := strings.TrimRight(screenText(screen, sy), " "); got != line {
+			t.Errorf("row %d is %q, want %q", sy, got, line)
+		}
+	}
+
+	var stops []int
+	for _, keys := range []string{"]c", "]c", "]c", "[c"} {
+		typeKeys(ed, keys)
+		stops = append(stops, ed.cursorY)
+	}
+	if want := []int{1, 3, 4, 3}; !slices.Equal(stops, want) {
+		t.Errorf("]c ]c ]c [c stopped at %v, want %v", stops, want)
+	}
+
+	// do takes the right's (missing) line, dp gives the left's
+	typeKeys(ed, "do")
+	if want := []string{"one", "two", "three", "five"}; !slices.Equal(ed.lines, want) {
+		t.Errorf("after do: %q", ed.lines)
+	}
+	ed.cursorY = 1
+	typeKeys(ed, "dp")
+	right := ed.buffers[ed.diff.buffers[1]]
+	if want := []string{"one", "two", "three", "five", "six"}; !slices.Equal(right.lines, want) || !right.isDirty {
+		t.Errorf("after dp the right has %q", right.lines)
+	}
+
+	// The cursor crosses to the same row on the other side, where undo
+	// works on what dp did
+	typeKeys(ed, "<C-w>w")
+	if ed.diffSide() != 1 || ed.cursorY != 1 {
+		t.Errorf("Ctrl-W w went to side %d line %d", ed.diffSide(), ed.cursorY)
+	}
+	ed.undo()
+	if ed.lines[1] != "TWO" {
+		t.Errorf("undo left %q", ed.lines)
+	}
+	ed.syncDiff()
+	if got := fmt.Sprint(len(ed.diff.hunks)); got != "2" {
+		t.Errorf("%s hunks after undo", got)
+	}
+
+	// Neither side of a read-only view changes
+	left := ed.buffers[ed.diff.buffers[0]]
+	left.readOnly = true
+	ed.cursorY = 1
+	typeKeys(ed, "dp")
+	if left.lines[1] != "two" || len(left.undoStack) != 1 {
+		t.Errorf("dp changed a read-only side to %q", left.lines)
+	}
+	ed.readOnly = true
+	undos := len(ed.undoStack)
+	typeKeys(ed, "do")
+	if ed.lines[1] != "TWO" || len(ed.undoStack) != undos {
+		t.Errorf("do changed a read-only side to %q", ed.lines)
+	}
+	left.readOnly, ed.readOnly = false, false
+
+	ed.commandBuffer = "diffoff"
+	ed.handleCommand()
+	if left, _ := ed.textArea(); ed.diff != nil || left > 5 {
+		t.Errorf(":diffoff left the text at %d", left)
+	}
+}
@@ -5,10 +5,8 @@ import (
 	"path/filepath"
 	"strings"
 	"time"
-	"unicode"
 
 	"github.com/gdamore/tcell/v2"
-	"github.com/mattn/go-runewidth"
 )
 
 const VERSION = "v0.2.0.4"
@@ -16,193 +14,139 @@ const VERSION = "v0.2.0.4"
 // Display-related functionality
 func (e *Editor) Draw() {
 	// Clear screen only once per frame
+	e.screen.SetStyle(e.uiStyle("text"))
 	e.screen.Clear()
 
-	// Calculate content area
-	contentStartX := 0
-
 	// Draw file tree if visible
 	if e.treeVisible {
 		e.drawFileTree()
-		contentStartX = e.treeWidth + 1 // Add 1 for separator
 	}
 
-	// Calculate visible region based on scroll position
-	startLine := e.scrollY
-	endLine := startLine + e.screenHeight - 2 // Account for status bars
-
-	if endLine > len(e.lines) {
-		endLine = len(e.lines)
+	if e.diff != nil && e.diffSide() < 0 {
+		// A buffer outside the diff was opened
+		e.diff = nil
 	}
-
-	// Draw only visible content
-	for y := startLine; y < endLine; y++ {
-		screenY := y - startLine
-		lineNum := y + 1
-
-		// Draw line numbers if enabled
-		if e.showLineNumbers {
-			lineNumStr := fmt.Sprintf("%4d ", lineNum)
-			lineNumStyle := tcell.StyleDefault.Foreground(tcell.ColorDarkGray)
-			drawText(e.screen, contentStartX, screenY, lineNumStyle, lineNumStr)
-		}
-
-		// Calculate line offset based on line numbers
-		xOffset := contentStartX
-		if e.showLineNumbers {
-			xOffset += 5
-		}
-
-		// Draw the line content with syntax highlighting
-		if y < len(e.lines) {
-			line := e.lines[y]
-			styles := e.syntaxStyle(line)
-
-			// Draw each character with its style
-			for x, r := range line {
-				if x < len(styles) {
-					e.screen.SetContent(xOffset+x, screenY, r, nil, styles[x])
-				}
-			}
-		}
+	if e.diff != nil {
+		e.drawDiff()
+	} else {
+		e.drawBuffer()
 	}
 
 	// Draw completions if active
 	if e.completionActive && len(e.completions) > 0 {
 		e.drawCompletions()
 	}
+	if e.popup != nil {
+		e.drawPopup()
+	}
 
 	// Draw status bars
 	e.drawStatusBar()
 	e.drawMessageBar()
 
-	// Position cursor
-	cursorX := e.cursorX
-	if e.showLineNumbers {
-		cursorX += 5
-	}
-	if e.treeVisible {
-		cursorX += e.treeWidth + 1
-	}
-
 	// Only show cursor if it's in the visible area
-	if e.cursorY >= e.scrollY && e.cursorY < e.scrollY+e.screenHeight-2 {
-		e.screen.ShowCursor(cursorX, e.cursorY-e.scrollY)
+	if x, y, ok := e.screenPosition(e.cursorY, e.cursorX); ok {
+		e.screen.ShowCursor(x, y)
+	} else {
+		e.screen.HideCursor()
 	}
 
 	// Update screen in one go
 	e.screen.Show()
 }
 
-func drawText(screen tcell.Screen, x, y int, style tcell.Style, text string) {
-	for _, r := range text {
-		screen.SetContent(x, y, r, nil, style)
-		x += runewidth.RuneWidth(r)
+// drawBuffer draws the current buffer's lines in the text area.
+func (e *Editor) drawBuffer() {
+	// Scroll to the cursor if it moved, so scrolling with the mouse wheel
+	// can leave it behind. A jump into a closed fold opens it.
+	moved := e.cursorX != e.drawnCursorX || e.cursorY != e.drawnCursorY
+	if moved {
+		e.openFoldsAt(e.cursorY)
+	}
+	e.cacheFrame()
+	defer e.clearFrame()
+	if moved {
+		e.keepCursorVisible()
+		e.drawnCursorX, e.drawnCursorY = e.cursorX, e.cursorY
 	}
-}
-
-func (e *Editor) setStatusMessage(msg string) {
-	e.statusMessage = msg
-	e.statusTimeout = time.Now().Add(3 * time.Second)
-}
-
-func (e *Editor) syntaxStyle(line string) []tcell.Style {
-	styles := make([]tcell.Style, len(line))
-	defaultStyle := tcell.StyleDefault
-
-	// Define syntax styles
-	keywordStyle := defaultStyle.Foreground(tcell.ColorPurple)
-	typeStyle := defaultStyle.Foreground(tcell.ColorTeal)
-	stringStyle := defaultStyle.Foreground(tcell.ColorGreen)
-	numberStyle := defaultStyle.Foreground(tcell.ColorRed)
-	commentStyle := defaultStyle.Foreground(tcell.ColorGray)
-	functionStyle := defaultStyle.Foreground(tcell.ColorYellow)
-	operatorStyle := defaultStyle.Foreground(tcell.ColorOrange)
-
-	// Language keywords
-	keywords := map[string]bool{
-		"func": true, "return": true, "if": true, "else": true,
-		"for": true, "range": true, "break": true, "continue": true,
-		"switch": true, "case": true, "default": true,
-		"package": true, "import": true, "type": true,
-		"var": true, "const": true, "struct": true, "interface": true,
-		"map": true, "chan": true, "go": true, "defer": true,
-	}
-
-	// Types
-	types := map[string]bool{
-		"string": true, "int": true, "bool": true, "float": true,
-		"byte": true, "rune": true, "error": true, "uint": true,
-		"int8": true, "int16": true, "int32": true, "int64": true,
-		"uint8": true, "uint16": true, "uint32": true, "uint64": true,
-	}
-
-	// Fill with default style first
-	for i := range styles {
-		styles[i] = defaultStyle
-	}
-
-	inString := false
-	inComment := false
-	word := ""
-
-	for i, char := range line {
-		if inComment {
-			styles[i] = commentStyle
-			continue
-		}
-
-		if i < len(line)-1 && line[i:i+2] == "//" {
-			inComment = true
-			styles[i] = commentStyle
-			continue
-		}
-
-		if char == '"' && (i == 0 || line[i-1] != '\\') {
-			inString = !inString
-			styles[i] = stringStyle
-			continue
-		}
 
-		if inString {
-			styles[i] = stringStyle
-			continue
+	// Calculate visible region based on scroll position
+	height := e.textHeight()
+	endLine := e.scrollY
+	for n := 0; n < height && endLine < len(e.lines); n++ {
+		endLine = e.nextLine(endLine)
+	}
+	e.prepareHighlight(min(endLine, len(e.lines)))
+	e.syncBlame()
+	diagnostics := e.currentDiagnostics()
+	conflicts := e.conflicts()
+	left, width := e.textArea()
+
+	// Draw only visible content, a row at a time
+	screenY := 0
+	for y := e.scrollY; y < len(e.lines) && screenY < height; y = e.nextLine(y) {
+		line := e.lines[y]
+		styles := e.lineStyles(y)
+		conflict := conflictStyle(conflicts, y)
+		if conflict != "" {
+			styles = e.markConflict(conflict, styles)
 		}
-
-		// Handle numbers
-		if unicode.IsDigit(char) || (char == '.' && i+1 < len(line) && unicode.IsDigit(rune(line[i+1]))) {
-			styles[i] = numberStyle
-			continue
+		styles = e.markSearchMatches(y, styles)
+		if len(diagnostics) > 0 {
+			styles = e.markDiagnostics(diagnostics, y, styles)
 		}
 
-		// Handle operators
-		if strings.ContainsRune("+-*/%=<>!&|^~:;,.", char) {
-			styles[i] = operatorStyle
-			continue
+		rows := e.lineRows(y)
+		first := 0
+		if y == e.scrollY {
+			first = min(e.scrollRow, len(rows)-1)
 		}
-
-		// Handle words (keywords, types, functions)
-		if unicode.IsLetter(char) || char == '_' {
-			word += string(char)
-		} else if word != "" {
-			style := defaultStyle
-			if keywords[word] {
-				style = keywordStyle
-			} else if types[word] {
-				style = typeStyle
-			} else if i < len(line) && line[i] == '(' {
-				style = functionStyle
+		for r := first; r < len(rows) && screenY < height; r++ {
+			// The gutter is drawn on the first row of the line
+			if r == 0 {
+				e.drawGutter(y, screenY)
+			}
+			if e.blame != nil {
+				if r == 0 {
+					e.drawBlame(y, screenY)
+				} else {
+					e.drawBlame(-1, screenY)
+				}
 			}
 
-			// Apply style to the whole word
-			for j := i - len(word); j < i; j++ {
-				styles[j] = style
+			if f, ok := e.closedFoldAt(y); ok {
+				// A closed fold is one line filling the row
+				summary := e.foldSummary(f)
+				summary += strings.Repeat("·", max(0, width-stringWidth(summary)))
+				drawText(e.screen, left, screenY, e.uiStyle("fold"), summary)
+			} else {
+				if conflict != "" {
+					// Conflict sections are shaded across the row
+					fill := e.currentTheme().Over(conflict, e.uiStyle("text"))
+					for c := range width {
+						e.screen.SetContent(left+c, screenY, ' ', nil, fill)
+					}
+				}
+				e.drawRow(line, rows[r], styles, screenY)
 			}
-			word = ""
+			screenY++
 		}
 	}
+}
 
-	return styles
+func drawText(screen tcell.Screen, x, y int, style tcell.Style, text string) {
+	for text != "" {
+		var cluster string
+		cluster, text = firstGrapheme(text)
+		runes := []rune(cluster)
+		screen.SetContent(x, y, runes[0], runes[1:], style)
+		x += clusterWidth(cluster)
+	}
+}
+
+func (e *Editor) setStatusMessage(msg string) {
+	e.statusMessage = msg
+	e.statusTimeout = time.Now().Add(3 * time.Second)
 }
 
 func (e *Editor) drawStatusBar() {
@@ -218,11 +162,7 @@ func (e *Editor) drawStatusBar() {
 		status = status[:maxWidth-3] + "..."
 	}
 
-	style := tcell.StyleDefault.
-		Background(tcell.ColorDarkBlue).
-		Foreground(tcell.ColorWhite)
-
-	drawText(e.screen, 0, e.screenHeight-1, style, status)
+	drawText(e.screen, 0, e.screenHeight-1, e.uiStyle("statusbar"), status)
 }
 
 func (e *Editor) showHelp() {
@@ -238,11 +178,61 @@ func (e *Editor) showHelp() {
 		"",
 		"Navigation:",
 		"  h,j,k,l - Move cursor (left, down, up, right)",
+		"  gj, gk  - Move down/up a screen row of a wrapped line",
 		"  t       - Toggle file tree",
 		"",
+		"Folds:",
+		"  za      - Toggle the fold at the cursor",
+		"  zo, zc  - Open / close the fold at the cursor",
+		"  zR, zM  - Open / close every fold",
+		"  zf{motion} - Fold lines by hand (zfj, zfap, zfG); zd, zE delete",
+		"",
+		"Marks and breakpoints:",
+		"  m{a-z}  - Mark the cursor position",
+		"  '{a-z}  - Go to a mark's line (` goes to its column too)",
+		"  :marks, :delmarks {a-z} - List / delete marks (:delmarks! all)",
+		"  :breakpoint [clear] - Toggle a breakpoint on the line (or click the sign column)",
+		"",
+		"Git:",
+		"  ]c, [c  - Next / previous changed hunk",
+		"  :previewhunk  - Show what the hunk at the cursor replaced",
+		"  :reverthunk   - Put back the hunk's lines from HEAD",
+		"  :stagehunk    - Add the hunk to the index",
+		"  :blame        - Show who last changed each line",
+		"  :log          - List the file's commits (Enter opens the file as of one)",
+		"",
+		"Swap files:",
+		"  r, d, x - When a file has one: recover it, diff it, delete it",
+		"  :recover - Decide again after Esc",
+		"  :set noswapfile - Stop writing them (updateTime, swapInterval: when)",
+		"",
+		"Autosave:",
+		"  :set autoSaveDelay=5 - Save 5 seconds after typing stops",
+		"  :set saveOnFocusLost, saveOnSwitch, saveOnExit - Save on leaving",
+		"",
+		"Merge conflicts:",
+		"  ]x, [x  - Next / previous conflict",
+		"  co, ct  - Keep ours / theirs (cb both, c0 neither)",
+		"  :conflict base - Keep the common base (diff3 conflicts)",
+		"  :cq     - Quit with an error, so git mergetool keeps the conflict",
+		"",
+		"Diffs:",
+		"  :diffsplit {file} - Show the buffer and file side by side (:diffoff ends)",
+		"  ]c, [c  - Next / previous hunk",
+		"  do, dp  - Take the other side's hunk / put this side's in the other",
+		"  Ctrl-W w - Go to the other side (Ctrl-W h, l: left, right)",
+		"",
 		"Editing:",
 		"  i       - Start typing (insert mode)",
-		"  Tab     - Show code completions (in insert mode)",
+		"  Tab     - Indent after whitespace, else show completions (insert mode)",
+		"  S-Tab   - Previous completion; Ctrl-N/Ctrl-P also move",
+		"  Enter   - Accept the selected completion",
+		"  prefix+Tab - Expand a snippet; Tab/S-Tab move between its fields",
+		"  :> [n], :< [n] - Shift n lines one shiftwidth right / left",
+		"  gq{motion} - Rewrap lines to textWidth (gqq, gqap, gqj, gq}, gqG)",
+		"  :reflow [width] - Rewrap the paragraph at the cursor",
+		"  K       - Show documentation for the symbol under the cursor",
+		"  Ctrl-]  - Go to definition",
 		"  u       - Undo",
 		"  r       - Redo",
 		"",
@@ -252,6 +242,11 @@ func (e *Editor) showHelp() {
 		"  n       - Create new file",
 		"  D       - Delete file",
 		"  r       - Rename file",
+		"  I       - Show/hide ignored files",
+		"  /       - Filter tree (Esc clears)",
+		"  R       - Reveal current file",
+		"  b / B   - Bookmark directory / list bookmarks",
+		"  :bm <name> - Jump tree root to a bookmark",
 		"",
 		"File Operations:",
 		"  :w      - Save file",
@@ -262,12 +257,31 @@ func (e *Editor) showHelp() {
 		"  :info   - Show file information",
 		"  :wc     - Count lines, words, and characters",
 		"  :reload - Reload the current file",
+		"  :e <file> - Open a file in a new buffer",
+		"  :ls     - List open buffers",
+		"  :b <n|name>, :bn, :bp - Switch buffer",
+		"  :bd     - Close buffer (:bd! discards changes)",
 		"",
 		"Settings:",
-		"  :set number   - Show line numbers",
-		"  :set nonumber - Hide line numbers",
-		"  :set tabsize <n> - Set tab size",
-		"  :set syntax on|off - Toggle syntax highlighting",
+		"  :set          - Show changed options (:set all for every one)",
+		"  :set ts=4     - Set an option (also :set tabsize 4)",
+		"  :set nu, :set nonu, :set nu! - Turn on, off, toggle",
+		"  :set ts?, :set ts& - Show / reset an option",
+		"  :set sw=4 sts=-1 et - Indent width, soft tab stop, spaces for tabs",
+		"  :wrap         - Toggle soft wrapping (also :set wrap)",
+		"  :config       - Edit the configuration file",
+		"  :nmap, :imap, :tmap <keys> <keys> - Map keys (:nnoremap etc. don't remap)",
+		"  :nunmap, :iunmap, :tunmap <keys> - Remove a mapping",
+		"  :filetype <name> - Override the detected language",
+		"  :colorscheme <name> - Switch color theme",
+		"",
+		"Language Server:",
+		"  :def, :refs   - Go to definition / list references",
+		"  :cn, :cp      - Next / previous location",
+		"  :hover        - Show documentation (also K)",
+		"  :rename <new> - Rename the symbol under the cursor",
+		"  :format       - Format the file",
+		"  :diag, :lsp   - List diagnostics / show servers",
 		"",
 		"Search and Replace:",
 		"  :find <text>  - Find text in file",
@@ -277,6 +291,7 @@ func (e *Editor) showHelp() {
 	}
 
 	// Save current screen
+	e.screen.SetStyle(e.uiStyle("text"))
 	e.screen.Clear()
 
 	// Center help text
@@ -285,7 +300,13 @@ func (e *Editor) showHelp() {
 		if x < 0 {
 			x = 0
 		}
-		drawText(e.screen, x, i, tcell.StyleDefault.Foreground(tcell.ColorWhite), line)
+		style := e.uiStyle("text")
+		if i == 0 {
+			style = e.uiStyle("title")
+		} else if strings.HasSuffix(line, ":") {
+			style = e.uiStyle("heading")
+		}
+		drawText(e.screen, x, i, style, line)
 	}
 
 	e.screen.Show()
@@ -302,29 +323,45 @@ func (e *Editor) showHelp() {
 
 func (e *Editor) drawMessageBar() {
 	// Clear the message bar
+	messageStyle := e.uiStyle("message")
 	for x := 0; x < e.screenWidth; x++ {
-		e.screen.SetContent(x, e.screenHeight-1, ' ', nil, tcell.StyleDefault)
+		e.screen.SetContent(x, e.screenHeight-1, ' ', nil, messageStyle)
 	}
 
 	// Show status message if it exists
 	if e.statusMessage != "" && time.Now().Before(e.statusTimeout) {
-		drawText(e.screen, 0, e.screenHeight-1, tcell.StyleDefault, e.statusMessage)
+		drawText(e.screen, 0, e.screenHeight-1, messageStyle, e.statusMessage)
 		return
 	}
 
+	// Then any problem reported on the cursor line
+	if e.mode == "normal" || e.mode == "insert" {
+		if d := lineDiagnostic(e.currentDiagnostics(), e.cursorY); d != nil {
+			style := e.uiStyle("sign." + severityNames[severityRank(d.Severity)])
+			drawText(e.screen, 0, e.screenHeight-1, style, diagnosticText(d))
+			return
+		}
+	}
+
 	// Otherwise show context-sensitive key hints
-	hintStyle := tcell.StyleDefault.Foreground(tcell.ColorDarkGray)
+	hintStyle := e.uiStyle("hint")
 	var hints string
 
 	switch e.mode {
 	case "normal":
 		if e.treeVisible {
-			hints = "j/k:navigate  Enter:open  n:new  D:delete  r:rename  t:hide  ?:help"
+			hints = "j/k:navigate  Enter:open  /:filter  R:reveal  n:new  D:delete  r:rename  t:hide  ?:help"
 		} else {
 			hints = "i:insert  /:search  t:files  :w:save  :q:quit  ?:help"
 		}
 	case "insert":
-		hints = "Tab:complete  Esc:normal mode"
+		if e.snippetSession != nil {
+			hints = "Tab:next field  S-Tab:previous field  Esc:normal mode"
+		} else if e.completionActive {
+			hints = "Tab/C-n:next  S-Tab/C-p:prev  Enter:accept  Esc:normal mode"
+		} else {
+			hints = "Tab:complete  Esc:normal mode"
+		}
 	case "command":
 		hints = "Enter:execute  Esc:cancel"
 	case "search":
@@ -333,6 +370,10 @@ func (e *Editor) drawMessageBar() {
 		hints = "Enter:create  Esc:cancel"
 	case "rename":
 		hints = "Enter:rename  Esc:cancel"
+	case "treefilter":
+		hints = "Type to filter  Enter:keep  Esc:clear"
+	case "recover":
+		hints = "Swap file found  r:recover  d:diff  x:delete it  Esc:decide later with :recover"
 	}
 
 	// Truncate if too long
@@ -344,15 +385,16 @@ func (e *Editor) drawMessageBar() {
 }
 
 func (e *Editor) showWelcomeScreen() {
+	e.screen.SetStyle(e.uiStyle("text"))
 	e.screen.Clear()
 
 	// Title
 	title := "Welcome to Kiki's Text Editor"
-	drawText(e.screen, (e.screenWidth-len(title))/2, 2, tcell.StyleDefault.Foreground(tcell.ColorYellow).Bold(true), title)
+	drawText(e.screen, (e.screenWidth-len(title))/2, 2, e.uiStyle("title"), title)
 
 	// Version
 	version := VERSION
-	drawText(e.screen, (e.screenWidth-len(version))/2, 4, tcell.StyleDefault.Foreground(tcell.ColorGray), version)
+	drawText(e.screen, (e.screenWidth-len(version))/2, 4, e.uiStyle("muted"), version)
 
 	// Quick start guide
 	startY := 7
@@ -366,13 +408,13 @@ func (e *Editor) showWelcomeScreen() {
 	}
 
 	for i, line := range quickStart {
-		drawText(e.screen, 10, startY+i, tcell.StyleDefault.Foreground(tcell.ColorWhite), line)
+		drawText(e.screen, 10, startY+i, e.uiStyle("text"), line)
 	}
 
 	// Settings section
 	settingsY := startY + len(quickStart) + 2
 	settingsTitle := "Current Settings:"
-	drawText(e.screen, 10, settingsY, tcell.StyleDefault.Foreground(tcell.ColorGreen).Bold(true), settingsTitle)
+	drawText(e.screen, 10, settingsY, e.uiStyle("heading"), settingsTitle)
 
 	// Display key settings
 	settings := []struct {
@@ -380,11 +422,11 @@ func (e *Editor) showWelcomeScreen() {
 		key   string
 		value string
 	}{
-		{"Tab Size", "tabSize", e.settings["tabSize"]},
-		{"Line Numbers", "showLineNumbers", e.settings["showLineNumbers"]},
-		{"Syntax Highlighting", "syntaxHighlight", e.settings["syntaxHighlight"]},
-		{"Auto Indent", "autoIndent", e.settings["autoIndent"]},
-		{"Auto Complete", "autoComplete", e.settings["autoComplete"]},
+		{"Tab Size", "tabSize", e.option("tabSize")},
+		{"Line Numbers", "showLineNumbers", e.option("showLineNumbers")},
+		{"Syntax Highlighting", "syntaxHighlight", e.option("syntaxHighlight")},
+		{"Auto Indent", "autoIndent", e.option("autoIndent")},
+		{"Auto Complete", "autoComplete", e.option("autoComplete")},
 	}
 
 	for i, setting := range settings {
@@ -397,16 +439,16 @@ func (e *Editor) showWelcomeScreen() {
 		}
 
 		settingText := fmt.Sprintf("  • %-20s: %s", setting.name, displayValue)
-		drawText(e.screen, 10, settingsY+i+2, tcell.StyleDefault.Foreground(tcell.ColorWhite), settingText)
+		drawText(e.screen, 10, settingsY+i+2, e.uiStyle("text"), settingText)
 	}
 
 	// Configuration tip
-	configTip := fmt.Sprintf("Configuration file: %s", e.configFile)
-	drawText(e.screen, 10, settingsY+len(settings)+4, tcell.StyleDefault.Foreground(tcell.ColorGray), configTip)
+	configTip := fmt.Sprintf("Configuration file: %s", configPath())
+	drawText(e.screen, 10, settingsY+len(settings)+4, e.uiStyle("muted"), configTip)
 
 	// Footer
 	footer := "Press any key to continue..."
-	drawText(e.screen, (e.screenWidth-len(footer))/2, e.screenHeight-2, tcell.StyleDefault.Foreground(tcell.ColorGray), footer)
+	drawText(e.screen, (e.screenWidth-len(footer))/2, e.screenHeight-2, e.uiStyle("muted"), footer)
 
 	e.screen.Show()
 
@@ -429,15 +471,23 @@ func (e *Editor) showWelcomeScreen() {
 func (e *Editor) updateStatus() {
 	status := []string{
 		fmt.Sprintf("Line %d/%d", e.cursorY+1, len(e.lines)),
-		fmt.Sprintf("Col %d", e.cursorX+1),
+		fmt.Sprintf("Col %d", e.cursorColumn()+1),
 	}
 
 	if e.filename != "" {
 		status = append(status, filepath.Base(e.filename))
 	}
 
+	if e.syntaxHighlight {
+		if name := e.languageName(); name != "plaintext" {
+			status = append(status, name)
+		}
+	}
+
 	if e.isDirty {
 		status = append(status, "[modified]")
+	} else if !e.autoSaved.IsZero() {
+		status = append(status, "autosaved "+e.autoSaved.Format("15:04"))
 	}
 
 	if e.mode != "normal" {
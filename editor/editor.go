@@ -3,13 +3,14 @@ package editor
 
 import (
 	"bufio"
-	"fmt"
 	"log"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/alecthomas/chroma"
 	"github.com/gdamore/tcell/v2"
 )
 
@@ -37,29 +38,111 @@ type Editor struct {
 	redoStack        []Action
 	commandBuffer    string
 	quit             bool
+	exitCode         int    // set by :cq, see ExitCode
+	mergeFile        string // MERGED in the mergetool mode, see conflicts.go
 	treeVisible      bool
 	treeWidth        int
 	currentPath      string
 	fileTree         *FileNode
 	treeSelectedLine int
+	treeScrollY      int // first tree row shown in the panel
+	treeResizing     bool
+	lastTreeClick    time.Time
+	lastTreeClickRow int
+	lastMouseButtons tcell.ButtonMask
+	showIgnored      bool // show .gitignore'd files (dimmed) in the tree
+	gitRepo          *gitRepo
+	gitStatus        *gitStatusSnapshot
+	ignore           *ignoreMatcher
+	treeFilter       string             // narrows the tree to matching paths
+	treeMatches      map[*FileNode]bool // nodes kept visible by treeFilter
+	treeExpanded     map[*FileNode]bool // directories expanded before filtering
 	screenWidth      int
 	screenHeight     int
 	newFileDir       string
 	isWelcomeScreen  bool
 	confirmAction    func()
-	scrollY          int // Vertical scroll position
+	scrollY          int    // Vertical scroll position
+	scrollRow        int    // wrapped row of line scrollY at the top
+	scrollX          int    // columns scrolled off to the left without wrapping
+	normalPrefix     string // first key of a two-key normal mode command
+
+	// Folds, see folds.go, and marks and breakpoints, see marks.go. They
+	// move with the lines around them in syncLines.
+	closedFolds     map[int]bool // computed folds closed, by first line
+	manualFolds     []fold
+	marks           map[rune]Position // set with m
+	breakpoints     map[int]bool
+	syncedLineCount int // line count and cursor line when they were synced
+	syncedCursorY   int
+	version         int // changes with the lines, see markChanged
+	lastVersion     int // the latest version any buffer has had
+	conflictCache   []conflict
+	conflictVersion int // the version conflictCache was parsed from
+
+	// Worked out once for the frame while drawing
+	frameCached    bool
+	cachedFolds    []fold // closed folds
+	cachedAllFolds []fold // for the fold column
+	cachedSigns    map[int]gutterSign
+
+	gitDiff *gitDiff   // the file against HEAD, see gitdiff.go
+	blame   *blameView // the :blame panel, see githistory.go
+	gitView *gitView   // what a read-only :log or revision buffer shows
+	diff    *diffView  // two buffers side by side, see diffmode.go
+
+	readOnly  bool       // a buffer opened with openView
+	swap      *swapState // see swap.go
+	autoSaved time.Time  // when the buffer was last saved by autosave.go
+	lastInput time.Time  // of the last key or mouse event
+	wakeAt    time.Time  // when a timer runs the main loop, see wakeAfter
+
+	// Where the cursor was last drawn, to scroll only when it moves
+	drawnCursorX, drawnCursorY int
+
+	// Open buffers other than the one in the fields above
+	buffers     []*Buffer
+	bufferIndex int
 
 	// Auto-completion fields
 	completions      []Completion
 	completionIndex  int
 	completionActive bool
+	completionScroll int            // first entry shown in the popup
+	completionUses   map[string]int // how often each completion was accepted
+
+	// Snippets
+	snippetSession *snippetSession       // tab stops of the snippet being filled in
+	snippetFiles   map[string][]*snippet // user snippet files by language, once read
+
+	// Language servers, see lsp.go
+	lspClients    map[string]*lspClient   // by language
+	lspFailed     map[string]bool         // languages whose server is missing or died
+	lspDocs       map[string]*lspDocument // by absolute path
+	lspCompletion *lspCompletionCache
+	diagnostics   map[string][]lspDiagnostic // by document URI
+	mainQueue     chan func()                // work posted from other goroutines
+	overflowMu    sync.Mutex
+	overflow      []func()      // posted while mainQueue was full, run after it
+	locations     []lspLocation // from :refs, :def and :diag
+	locationIndex int
+	popup         *popup
 
 	// User settings
-	settings   map[string]string
-	configFile string
+	settings      map[string]string // see optionSpecs
+	localSettings map[string]string // the current file's, see loadFileSettings
+
+	// Key mappings by mode, and keys waiting to be matched against them
+	keymaps           map[string][]*keymap
+	pendingKeys       []*tcell.EventKey
+	pendingGeneration int // invalidates earlier timeouts
+	configWatcher     *configWatcher
 
 	lineCache       map[int]string // Cache for long lines
-	syntaxCache     map[string][]tcell.Style
+	lexer           chroma.Lexer   // detected lazily by currentLexer
+	highlight       *highlighter
+	theme           *Theme // loaded lazily by currentTheme
+	fileType        string // language forced with :filetype
 	isLargeFile     bool
 	showLineNumbers bool
 	syntaxHighlight bool
@@ -71,6 +154,7 @@ type Editor struct {
 
 func (e *Editor) SetFilename(name string) {
 	e.filename = name
+	e.resetLexer()
 }
 
 func NewEditor() (*Editor, error) {
@@ -85,6 +169,7 @@ func NewEditor() (*Editor, error) {
 
 	// Enable mouse support
 	screen.EnableMouse()
+	screen.EnableFocus()
 
 	// Get screen dimensions
 	width, height := screen.Size()
@@ -113,11 +198,14 @@ func NewEditor() (*Editor, error) {
 		},
 	}
 
+	configErrors := ed.loadConfig()
+	ed.startConfigWatcher()
 	ed.initFileTree()
 	ed.SetStatusMessage("Welcome! Press '?' for help, 'i' for insert mode, ':' for commands")
 
 	// Show welcome screen
 	ed.showWelcomeScreen()
+	ed.showConfigErrors(configErrors)
 
 	ed.initHistory()
 
@@ -132,9 +220,15 @@ func (e *Editor) Run() {
 
 	// Defer screen cleanup
 	defer e.screen.Fini()
+	defer e.stopLSP()
+	defer e.stopConfigWatcher()
 
 	for {
 		e.updateScreenSize()
+		e.syncLSP()
+		e.syncGitDiff()
+		e.syncAutoSave()
+		e.syncSwap()
 		e.Draw()
 
 		// Handle events
@@ -142,17 +236,27 @@ func (e *Editor) Run() {
 		switch ev := ev.(type) {
 		case *tcell.EventKey:
 			if ev.Key() == tcell.KeyCtrlC {
+				e.autoSaveOn("saveOnExit")
 				return
 			}
-			e.handleInput(ev)
+			e.lastInput = time.Now()
+			e.handleKey(ev)
 		case *tcell.EventMouse:
+			e.lastInput = time.Now()
 			e.handleMouseEvent(ev)
+		case *tcell.EventFocus:
+			if !ev.Focused {
+				e.autoSaveOn("saveOnFocusLost")
+			}
 		case *tcell.EventResize:
 			e.screen.Sync()
 			e.updateScreenSize()
+		case *tcell.EventInterrupt:
+			e.runQueued()
 		}
 
 		if e.quit {
+			e.removeSwaps()
 			return
 		}
 	}
@@ -173,9 +277,10 @@ func (e *Editor) deleteChar() {
 	}
 
 	line := e.lines[e.cursorY]
-	e.lines[e.cursorY] = line[:e.cursorX-1] + line[e.cursorX:]
-	e.cursorX--
-	e.isDirty = true
+	start := prevGrapheme(line, e.cursorX)
+	e.lines[e.cursorY] = line[:start] + line[e.cursorX:]
+	e.cursorX = start
+	e.markChanged()
 
 	e.undoStack = append(e.undoStack, Action{
 		lines:   e.lines,
@@ -194,7 +299,7 @@ func (e *Editor) joinLines() {
 		e.lines[e.cursorY-1] = prevLine + currentLine
 		e.lines = append(e.lines[:e.cursorY], e.lines[e.cursorY+1:]...)
 		e.cursorY--
-		e.isDirty = true
+		e.markChanged()
 
 		// Record action for undo
 		e.undoStack = append(e.undoStack, Action{
@@ -231,11 +336,37 @@ func (e *Editor) getFileSize() int64 {
 }
 
 func (e *Editor) addUndo(action Action) {
+	if action.timestamp.IsZero() {
+		action.timestamp = time.Now()
+	}
 	e.undoStack = append(e.undoStack, action)
 	// Clear redo stack when new change is made
 	e.redoStack = nil
 }
 
+// markChanged records a change to the buffer's lines.
+func (e *Editor) markChanged() {
+	e.isDirty = true
+	e.newVersion()
+}
+
+// newVersion gives the buffer's lines a version no buffer has had, so what
+// is worked out from them can tell when to work it out again.
+func (e *Editor) newVersion() {
+	e.lastVersion++
+	e.version = e.lastVersion
+}
+
+// editable reports whether the buffer may be changed, saying why not in
+// the status bar when it's a read-only view.
+func (e *Editor) editable() bool {
+	if e.readOnly {
+		e.setStatusMessage("Read-only buffer")
+		return false
+	}
+	return true
+}
+
 func (e *Editor) undo() {
 	if len(e.undoStack) == 0 {
 		return
@@ -256,78 +387,46 @@ func (e *Editor) undo() {
 	e.lines = append([]string{}, action.lines...)
 	e.cursorX = action.cursorX
 	e.cursorY = action.cursorY
+	e.newVersion()
 }
 
+// SaveFile saves the current buffer like :w.
 func (e *Editor) SaveFile() error {
-	if e.filename == "" {
-		return fmt.Errorf("no filename specified")
-	}
-
-	// Create backup before saving
-	if err := e.createBackup(); err != nil {
-		return fmt.Errorf("backup failed: %v", err)
-	}
-
-	// Write to temporary file first
-	tempFile := e.filename + ".tmp"
-	if err := e.writeToFile(tempFile); err != nil {
-		os.Remove(tempFile)
-		return fmt.Errorf("write failed: %v", err)
-	}
-
-	// Rename temporary file to actual file
-	if err := os.Rename(tempFile, e.filename); err != nil {
-		os.Remove(tempFile)
-		return fmt.Errorf("rename failed: %v", err)
-	}
-
-	e.isDirty = false
-	return nil
-}
-
-func (e *Editor) createBackup() error {
-	if e.filename == "" {
-		return fmt.Errorf("no filename specified")
-	}
-
-	backupFile := e.filename + ".bak"
-	if _, err := os.Stat(backupFile); os.IsNotExist(err) {
-		return nil
-	}
-
-	return fmt.Errorf("backup file already exists")
-}
-
-func (e *Editor) writeToFile(filename string) error {
-	file, err := os.Create(filename)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	for _, line := range e.lines {
-		if _, err := file.WriteString(line + "\n"); err != nil {
-			return err
-		}
-	}
-
-	return nil
+	return e.writeBuffer()
 }
 
 func (e *Editor) LoadFile(filename string) error {
 	info, err := os.Stat(filename)
 	if err != nil {
+		// A new file still gets its project's settings, and its unsaved
+		// contents may be in a swap file
+		e.loadFileSettings()
+		if os.IsNotExist(err) {
+			e.loadSwap()
+		}
 		return err
 	}
 
 	// Check file size
 	if info.Size() > maxFileSize {
 		e.isLargeFile = true
-		return e.loadLargeFile(filename)
+		e.resetLexer()
+		err = e.loadLargeFile(filename)
+	} else {
+		// Normal file loading...
+		e.resetLexer()
+		err = e.loadNormalFile(filename)
 	}
-
-	// Normal file loading...
-	return e.loadNormalFile(filename)
+	if err != nil {
+		return err
+	}
+	e.loadFileSettings()
+	e.decodeLines()
+	e.newVersion()
+	e.resetFolds()
+	e.loadGitDiff()
+	e.loadSwap()
+	return nil
 }
 
 func (e *Editor) loadLargeFile(filename string) error {
@@ -378,7 +477,7 @@ func (e *Editor) replaceAll(old, new string) int {
 		if strings.Contains(line, old) {
 			e.lines[i] = strings.ReplaceAll(line, old, new)
 			count += strings.Count(line, old)
-			e.isDirty = true
+			e.markChanged()
 		}
 	}
 	return count
@@ -0,0 +1,575 @@
+package editor
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/alecthomas/chroma"
+	"github.com/alecthomas/chroma/lexers"
+)
+
+// Settings for the file being edited.
+//
+// When a file is loaded the options for it are worked out in layers, each
+// overriding the one before:
+//
+//	defaults < user config < [filetype.<name>] in the user config
+//	         < .kiki.toml in the file's directory or above
+//	         < .editorconfig files < a vim modeline in the file
+//
+// Everything above the user config goes in e.localSettings, which is kept
+// with the buffer. Options marked global (the theme, language servers)
+// can't be set this way.
+
+const projectConfigName = ".kiki.toml"
+
+// Lines searched for a modeline at each end of the file
+const modelineLines = 5
+
+// loadFileSettings works out the current file's settings and applies them.
+func (e *Editor) loadFileSettings() {
+	local, problems := e.fileSettings(e.filename, e.lines, e.fileTypeNames())
+	e.localSettings = local
+	e.applySettings()
+	if len(problems) > 0 {
+		e.setStatusMessage(strings.Join(problems, "; "))
+	}
+}
+
+// reloadFileSettings works out the current file's settings again, keeping
+// what was found in the file when it was loaded.
+func (e *Editor) reloadFileSettings() {
+	loaded := e.localSettings
+	e.loadFileSettings()
+	keepDetectedSettings(loaded, e.localSettings)
+}
+
+// bufferSettings works out the settings of a buffer other than the current
+// one, keeping what was found in its file when it was loaded.
+func (e *Editor) bufferSettings(b *Buffer) map[string]string {
+	var filetypes []string
+	if b.filename != "" || b.fileType != "" {
+		var lexer chroma.Lexer
+		if b.fileType != "" {
+			lexer = lexers.Get(b.fileType)
+		}
+		if lexer == nil {
+			lexer = LexerFor(b.filename, b.lines)
+		}
+		filetypes = lexerNames(lexer)
+	}
+	local, _ := e.fileSettings(b.filename, b.lines, filetypes)
+	keepDetectedSettings(b.settings, local)
+	return local
+}
+
+// keepDetectedSettings copies the settings decodeLines found in the file
+// from loaded to local, unless local sets them itself.
+func keepDetectedSettings(loaded, local map[string]string) {
+	if _, ok := local["charset"]; !ok && loaded["charset"] == "utf-8-bom" {
+		local["charset"] = "utf-8-bom"
+	}
+}
+
+// fileSettings works out the layers above the user config for a file
+// with filetypes as its names for [filetype.<name>].
+func (e *Editor) fileSettings(filename string, lines []string, filetypes []string) (map[string]string, []string) {
+	local := make(map[string]string)
+	var problems []string
+
+	addFiletype := func(settings map[string]string) {
+		for _, filetype := range filetypes {
+			prefix := "filetype." + filetype + "."
+			for key, value := range settings {
+				if name, ok := strings.CutPrefix(key, prefix); ok {
+					local[name] = value
+				}
+			}
+		}
+	}
+	addFiletype(e.settings)
+
+	if filename != "" {
+		path, _ := filepath.Abs(filename)
+
+		if project := findUp(filepath.Dir(path), projectConfigName); project != "" {
+			settings, errs := loadProjectConfig(project)
+			for key, value := range settings {
+				if !strings.HasPrefix(key, "filetype.") {
+					local[key] = value
+				}
+			}
+			addFiletype(settings)
+			for _, err := range errs {
+				problems = append(problems, fmt.Sprintf("%s: %v", project, err))
+			}
+		}
+
+		properties, err := editorConfig(path)
+		if err != nil {
+			problems = append(problems, err.Error())
+		}
+		for _, err := range applyEditorConfig(properties, local) {
+			problems = append(problems, fmt.Sprintf(".editorconfig: %v", err))
+		}
+	}
+
+	for _, err := range applyModeline(lines, local) {
+		problems = append(problems, fmt.Sprintf("modeline: %v", err))
+	}
+	return local, problems
+}
+
+// fileTypeNames are the names [filetype.<name>] can use for the current
+// file: the language's aliases, then its name, which wins.
+func (e *Editor) fileTypeNames() []string {
+	if e.filename == "" && e.fileType == "" {
+		return nil
+	}
+	return lexerNames(e.currentLexer())
+}
+
+// lexerNames are a language's aliases, then its name.
+func lexerNames(lexer chroma.Lexer) []string {
+	if lexer == nil {
+		return nil
+	}
+	config := lexer.Config()
+	names := append([]string{}, config.Aliases...)
+	return append(names, strings.ToLower(config.Name))
+}
+
+// findUp looks for name in dir and the directories above it.
+func findUp(dir, name string) string {
+	for {
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// loadProjectConfig reads a .kiki.toml. It has the user config's format,
+// but can't start programs or change the theme.
+func loadProjectConfig(path string) (map[string]string, []error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, []error{err}
+	}
+	settings, errs := parseConfig(string(data))
+	for key := range settings {
+		if spec, _ := lookupOption(key); spec != nil && spec.global || strings.HasPrefix(key, "keys.") {
+			errs = append(errs, fmt.Errorf("%s: can only be set in %s", key, configPath()))
+			delete(settings, key)
+		}
+	}
+	return settings, errs
+}
+
+// EditorConfig (https://editorconfig.org)
+
+type editorConfigSection struct {
+	pattern    *regexp.Regexp
+	properties [][2]string
+}
+
+type editorConfigFile struct {
+	dir      string
+	root     bool
+	sections []editorConfigSection
+}
+
+// editorConfig returns the EditorConfig properties for path, read from the
+// .editorconfig files in its directory and above, up to one marked root.
+func editorConfig(path string) (map[string]string, error) {
+	var files []*editorConfigFile
+	for dir := filepath.Dir(path); ; {
+		data, err := os.ReadFile(filepath.Join(dir, ".editorconfig"))
+		if err == nil {
+			file := parseEditorConfig(dir, string(data))
+			files = append(files, file)
+			if file.root {
+				break
+			}
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+
+	// Nearer files override the ones above them
+	properties := make(map[string]string)
+	for i := len(files) - 1; i >= 0; i-- {
+		file := files[i]
+		rel, err := filepath.Rel(file.dir, path)
+		if err != nil {
+			continue
+		}
+		rel = filepath.ToSlash(rel)
+		for _, section := range file.sections {
+			if !section.pattern.MatchString(rel) {
+				continue
+			}
+			for _, property := range section.properties {
+				if property[1] == "unset" {
+					delete(properties, property[0])
+				} else {
+					properties[property[0]] = property[1]
+				}
+			}
+		}
+	}
+	return properties, nil
+}
+
+func parseEditorConfig(dir, data string) *editorConfigFile {
+	file := &editorConfigFile{dir: dir}
+	var section *editorConfigSection
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if line[0] == '[' && line[len(line)-1] == ']' {
+			file.sections = append(file.sections, editorConfigSection{pattern: editorConfigGlob(line[1 : len(line)-1])})
+			section = &file.sections[len(file.sections)-1]
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.ToLower(strings.TrimSpace(value))
+		if section == nil {
+			file.root = key == "root" && value == "true"
+		} else {
+			section.properties = append(section.properties, [2]string{key, value})
+		}
+	}
+	return file
+}
+
+// editorConfigGlob turns a section name into a pattern matching paths
+// relative to the .editorconfig's directory. A name without "/" matches
+// files in any directory.
+func editorConfigGlob(glob string) *regexp.Regexp {
+	var prefix string
+	switch {
+	case strings.HasPrefix(glob, "/"):
+		glob = glob[1:]
+	case !strings.Contains(glob, "/"):
+		prefix = "(?:.*/)?"
+	}
+	pattern, err := regexp.Compile("^" + prefix + globRegexp(glob) + "$")
+	if err != nil {
+		return regexp.MustCompile(`^\b$`) // matches nothing
+	}
+	return pattern
+}
+
+func globRegexp(glob string) string {
+	var sb strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch c {
+		case '\\':
+			if i+1 < len(glob) {
+				i++
+				sb.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+			}
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				sb.WriteString(".*")
+				i++
+			} else {
+				sb.WriteString("[^/]*")
+			}
+		case '?':
+			sb.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				sb.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			sb.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		case '{':
+			end := matchingBrace(glob, i)
+			if end < 0 {
+				sb.WriteString(`\{`)
+				continue
+			}
+			sb.WriteString(braceRegexp(glob[i+1 : end]))
+			i = end
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return sb.String()
+}
+
+func matchingBrace(glob string, open int) int {
+	depth := 0
+	for i := open; i < len(glob); i++ {
+		switch glob[i] {
+		case '\\':
+			i++
+		case '{':
+			depth++
+		case '}':
+			if depth--; depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+var numberRange = regexp.MustCompile(`^([+-]?\d+)\.\.([+-]?\d+)$`)
+
+// braceRegexp handles {a,b,c} and {1..10}.
+func braceRegexp(inner string) string {
+	if m := numberRange.FindStringSubmatch(inner); m != nil {
+		lo, _ := strconv.Atoi(m[1])
+		hi, _ := strconv.Atoi(m[2])
+		if lo > hi {
+			lo, hi = hi, lo
+		}
+		var numbers []string
+		for n := lo; n <= hi && len(numbers) < 1000; n++ {
+			numbers = append(numbers, strconv.Itoa(n))
+		}
+		return "(?:" + strings.Join(numbers, "|") + ")"
+	}
+
+	var alternatives []string
+	depth, start := 0, 0
+	for i := 0; i < len(inner); i++ {
+		switch inner[i] {
+		case '\\':
+			i++
+		case '{':
+			depth++
+		case '}':
+			depth--
+		case ',':
+			if depth == 0 {
+				alternatives = append(alternatives, globRegexp(inner[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	if alternatives == nil {
+		return `\{` + globRegexp(inner) + `\}`
+	}
+	alternatives = append(alternatives, globRegexp(inner[start:]))
+	return "(?:" + strings.Join(alternatives, "|") + ")"
+}
+
+// applyEditorConfig sets the options the EditorConfig properties map to.
+func applyEditorConfig(properties map[string]string, local map[string]string) []error {
+	var errs []error
+	set := func(property, name, value string) {
+		spec, key := lookupOption(name)
+		value, err := spec.parseValue(value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", property, err))
+			return
+		}
+		local[key] = value
+	}
+
+	switch style := properties["indent_style"]; style {
+	case "tab":
+		set("indent_style", "expandTab", "false")
+	case "space":
+		set("indent_style", "expandTab", "true")
+	case "":
+	default:
+		errs = append(errs, fmt.Errorf("indent_style: expected tab or space, got %q", style))
+	}
+
+	// tab_width defaults to indent_size, and indent_size = tab means a
+	// level is one tab
+	size, width := properties["indent_size"], properties["tab_width"]
+	if size == "tab" {
+		set("indent_size", "shiftWidth", "0")
+	} else if size != "" {
+		set("indent_size", "shiftWidth", size)
+		if width == "" {
+			width = size
+		}
+	}
+	if width != "" {
+		set("tab_width", "tabSize", width)
+	}
+
+	for property, name := range map[string]string{
+		"end_of_line":              "endOfLine",
+		"charset":                  "charset",
+		"trim_trailing_whitespace": "trimTrailingWhitespace",
+		"insert_final_newline":     "insertFinalNewline",
+	} {
+		if value, ok := properties[property]; ok {
+			set(property, name, value)
+		}
+	}
+	return errs
+}
+
+// Modelines
+
+var modelinePattern = regexp.MustCompile(`(?:^|\s)(?:vi|vim|Vim|ex):\s*(.*)`)
+
+// applyModeline reads vim modelines in the first and last lines of a file,
+// either "vim: ts=2 et" or "/* vim: set ts=2 et: */". Options the editor
+// doesn't have are ignored.
+func applyModeline(lines []string, local map[string]string) []error {
+	var errs []error
+	for i, line := range lines {
+		if i >= modelineLines && i < len(lines)-modelineLines {
+			continue
+		}
+		m := modelinePattern.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		var args []string
+		if rest, ok := cutSet(m[1]); ok {
+			// Options end at the next ":", the rest is a comment's end
+			rest, _, _ = strings.Cut(rest, ":")
+			args = strings.Fields(rest)
+		} else {
+			args = strings.FieldsFunc(m[1], func(r rune) bool { return r == ':' || r == ' ' || r == '\t' })
+		}
+		for _, arg := range args {
+			if err := modelineOption(arg, local); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errs
+}
+
+func cutSet(s string) (string, bool) {
+	for _, prefix := range []string{"set ", "se "} {
+		if rest, ok := strings.CutPrefix(s, prefix); ok {
+			return rest, true
+		}
+	}
+	return s, false
+}
+
+func modelineOption(arg string, local map[string]string) error {
+	name, value, hasValue := strings.Cut(arg, "=")
+	spec, key := lookupOption(name)
+	if !hasValue && spec == nil {
+		if name, ok := strings.CutPrefix(arg, "no"); ok {
+			if spec, key = lookupOption(name); spec != nil && spec.kind == optionBool {
+				value, hasValue = "false", true
+			}
+		}
+	}
+	if spec == nil {
+		return nil
+	}
+	if spec.global {
+		return fmt.Errorf("%s can't be set in a modeline", key)
+	}
+	if !hasValue {
+		if spec.kind != optionBool {
+			return nil
+		}
+		value = "true"
+	}
+	value, err := spec.parseValue(value)
+	if err != nil {
+		return fmt.Errorf("%s: %v", key, err)
+	}
+	local[key] = value
+	return nil
+}
+
+const utf8BOM = "\ufeff"
+
+// decodeLines converts lines read from disk following the charset option.
+// A UTF-8 byte order mark is dropped, and kept for saving.
+func (e *Editor) decodeLines() {
+	if len(e.lines) > 0 && strings.HasPrefix(e.lines[0], utf8BOM) {
+		e.lines[0] = strings.TrimPrefix(e.lines[0], utf8BOM)
+		if _, ok := e.localSettings["charset"]; !ok {
+			e.localSettings["charset"] = "utf-8-bom"
+		}
+	}
+	if e.option("charset") == "latin1" {
+		for i, line := range e.lines {
+			e.lines[i] = latin1ToUTF8(line)
+		}
+	}
+}
+
+// latin1ToUTF8 reads line's bytes as Latin-1 characters.
+func latin1ToUTF8(line string) string {
+	runes := make([]rune, len(line))
+	for j := 0; j < len(line); j++ {
+		runes[j] = rune(line[j])
+	}
+	return string(runes)
+}
+
+// fileContents is the buffer as it is written to disk.
+func (e *Editor) fileContents() ([]byte, error) {
+	return e.encodeLines(e.lines, e.localSettings)
+}
+
+// encodeLines is lines as they are written to disk by a buffer whose own
+// settings are local.
+func (e *Editor) encodeLines(lines []string, local map[string]string) ([]byte, error) {
+	eol := map[string]string{"lf": "\n", "crlf": "\r\n", "cr": "\r"}[e.bufferOption(local, "endOfLine")]
+	trim := e.bufferOption(local, "trimTrailingWhitespace") == "true"
+
+	var buf bytes.Buffer
+	charset := e.bufferOption(local, "charset")
+	if charset == "utf-8-bom" {
+		buf.WriteString(utf8BOM)
+	}
+	for i, line := range lines {
+		if trim {
+			line = strings.TrimRight(line, " \t")
+		}
+		if charset == "latin1" {
+			for _, r := range line {
+				if r >= 256 {
+					return nil, fmt.Errorf("line %d: %q can't be saved as latin1", i+1, r)
+				}
+				buf.WriteByte(byte(r))
+			}
+		} else {
+			buf.WriteString(line)
+		}
+		if i < len(lines)-1 || e.bufferOption(local, "insertFinalNewline") == "true" {
+			buf.WriteString(eol)
+		}
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,189 @@
+package editor
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEditorConfigGlob(t *testing.T) {
+	tests := []struct {
+		glob  string
+		path  string
+		match bool
+	}{
+		{"*", "main.go", true},
+		{"*", "cmd/main.go", true},
+		{"*.go", "cmd/main.go", true},
+		{"*.go", "main.gox", false},
+		{"*.{yml,yaml}", "ci/build.yaml", true},
+		{"*.{yml,yaml}", "ci/build.json", false},
+		{"/Makefile", "Makefile", true},
+		{"/Makefile", "sub/Makefile", false},
+		{"lib/*.js", "lib/a.js", true},
+		{"lib/*.js", "lib/x/a.js", false},
+		{"lib/**.js", "lib/x/a.js", true},
+		{"file?.txt", "file1.txt", true},
+		{"[!a]*.py", "b.py", true},
+		{"[!a]*.py", "a.py", false},
+		{"test{1..12}.c", "test7.c", true},
+		{"test{1..12}.c", "test13.c", false},
+		{"{single}.c", "{single}.c", true},
+	}
+	for _, test := range tests {
+		if got := editorConfigGlob(test.glob).MatchString(test.path); got != test.match {
+			t.Errorf("%q matching %q = %v, want %v", test.glob, test.path, got, test.match)
+		}
+	}
+}
+
+func TestFileSettingsLayers(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	root := t.TempDir()
+	writeTestFile(t, root, ".editorconfig", `root = true
+
+[*]
+end_of_line = lf
+insert_final_newline = true
+
+[*.py]
+indent_style = space
+indent_size = 4
+
+[Makefile]
+indent_style = tab
+tab_width = 8
+`)
+	writeTestFile(t, root, "proj/.kiki.toml", `
+wordWrap = true
+theme = "gruvbox"
+
+[filetype.python]
+tabSize = 3
+trimTrailingWhitespace = true
+`)
+	writeTestFile(t, root, "proj/.editorconfig", `[*.py]
+end_of_line = crlf
+indent_size = unset
+`)
+	writeTestFile(t, root, "proj/app.py", "import os\n")
+	writeTestFile(t, root, "proj/Makefile", "all:\n\tgo build\n")
+	writeTestFile(t, root, "proj/conf.yaml", "a: 1\n# vim: set ts=2 noet wrap! :\n")
+
+	ed := &Editor{settings: map[string]string{"filetype.python.autoComplete": "false"}}
+	ed.applySettings()
+
+	ed.SetFilename(filepath.Join(root, "proj/app.py"))
+	if err := ed.LoadFile(ed.filename); err != nil {
+		t.Fatal(err)
+	}
+	// The nearer .editorconfig unsets indent_size, so .kiki.toml's 3 stays
+	if ed.tabSize != 3 || ed.option("endOfLine") != "crlf" || !ed.boolOption("expandTab") {
+		t.Errorf("app.py: tabSize=%d eol=%s expandTab=%s", ed.tabSize, ed.option("endOfLine"), ed.option("expandTab"))
+	}
+	if !ed.wordWrap || ed.boolOption("autoComplete") || !ed.boolOption("trimTrailingWhitespace") {
+		t.Errorf("app.py: local settings %v", ed.localSettings)
+	}
+	// The theme can't be set by a project
+	if ed.option("theme") != "default" || ed.statusMessage == "" {
+		t.Errorf("app.py: theme=%s message=%q", ed.option("theme"), ed.statusMessage)
+	}
+
+	ed.SetFilename(filepath.Join(root, "proj/Makefile"))
+	ed.LoadFile(ed.filename)
+	if ed.tabSize != 8 || ed.indentUnit() != "\t" {
+		t.Errorf("Makefile: tabSize=%d indent=%q", ed.tabSize, ed.indentUnit())
+	}
+
+	ed.SetFilename(filepath.Join(root, "proj/conf.yaml"))
+	ed.LoadFile(ed.filename)
+	// wrap! isn't understood in a modeline; wordWrap stays as the project set it
+	if ed.tabSize != 2 || ed.boolOption("expandTab") || !ed.wordWrap {
+		t.Errorf("conf.yaml: tabSize=%d expandTab=%s wrap=%v", ed.tabSize, ed.option("expandTab"), ed.wordWrap)
+	}
+
+	// :set changes a value the file overrides
+	ed.setCommand("ts=6")
+	if ed.tabSize != 6 || ed.settings["tabSize"] != "6" {
+		t.Errorf(":set ts=6 gave tabSize=%d", ed.tabSize)
+	}
+}
+
+func TestModeline(t *testing.T) {
+	tests := []struct {
+		line string
+		want map[string]string
+		errs int
+	}{
+		{"// vim: ts=2 et", map[string]string{"tabSize": "2", "expandTab": "true"}, 0},
+		{"/* vim: set ts=8 noet: */", map[string]string{"tabSize": "8", "expandTab": "false"}, 0},
+		{"# vi:ff=dos:fenc=latin1", map[string]string{"endOfLine": "crlf", "charset": "latin1"}, 0},
+		{"# vim: ts=99 foldmethod=marker", map[string]string{}, 2},
+		{"# vim: lsp.go=evil colorscheme=gruvbox", map[string]string{}, 2},
+		{"Review: ts=2", map[string]string{}, 0},
+	}
+	for _, test := range tests {
+		local := map[string]string{}
+		errs := applyModeline([]string{test.line}, local)
+		if len(errs) != test.errs || len(local) != len(test.want) {
+			t.Errorf("%q: got %v, errors %v", test.line, local, errs)
+			continue
+		}
+		for key, value := range test.want {
+			if local[key] != value {
+				t.Errorf("%q: %s = %q, want %q", test.line, key, local[key], value)
+			}
+		}
+	}
+
+	// Only the first and last lines are searched
+	lines := make([]string, 20)
+	lines[10] = "vim: ts=2"
+	lines[17] = "vim: ts=3"
+	local := map[string]string{}
+	applyModeline(lines, local)
+	if local["tabSize"] != "3" {
+		t.Errorf("tabSize = %q", local["tabSize"])
+	}
+}
+
+func TestFileContents(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	dir := t.TempDir()
+	latin1 := filepath.Join(dir, "latin1.txt")
+	os.WriteFile(latin1, []byte("caf\xe9\r\n"), 0644)
+	bom := filepath.Join(dir, "bom.txt")
+	os.WriteFile(bom, []byte("\ufeffcafé\n"), 0644)
+
+	ed := &Editor{settings: map[string]string{"charset": "latin1"}}
+	ed.SetFilename(latin1)
+	if err := ed.LoadFile(latin1); err != nil || ed.lines[0] != "café" {
+		t.Fatalf("latin1 decoded as %q, %v", ed.lines, err)
+	}
+
+	ed = &Editor{}
+	ed.SetFilename(bom)
+	if err := ed.LoadFile(bom); err != nil || ed.lines[0] != "café" || ed.option("charset") != "utf-8-bom" {
+		t.Fatalf("BOM file decoded as %q, charset %s, %v", ed.lines, ed.option("charset"), err)
+	}
+
+	ed = &Editor{lines: []string{"café  ", "end"}, localSettings: map[string]string{
+		"endOfLine":              "crlf",
+		"trimTrailingWhitespace": "true",
+		"insertFinalNewline":     "false",
+		"charset":                "utf-8-bom",
+	}}
+	data, err := ed.fileContents()
+	if err != nil || string(data) != "\ufeffcafé\r\nend" {
+		t.Errorf("contents %q, %v", data, err)
+	}
+
+	ed.localSettings["charset"] = "latin1"
+	if data, _ := ed.fileContents(); string(data) != "caf\xe9\r\nend" {
+		t.Errorf("latin1 contents %q", data)
+	}
+	ed.lines[1] = "€"
+	if _, err := ed.fileContents(); err == nil {
+		t.Errorf("saving € as latin1 should fail")
+	}
+}
@@ -6,8 +6,16 @@ import (
 	"path/filepath"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/gdamore/tcell/v2"
+	"github.com/mattn/go-runewidth"
+)
+
+const (
+	minTreeWidth        = 10
+	minTextWidth        = 20
+	doubleClickInterval = 400 * time.Millisecond
 )
 
 type FileNode struct {
@@ -16,6 +24,8 @@ type FileNode struct {
 	expanded bool
 	children []*FileNode
 	parent   *FileNode
+	ignored  bool      // matched by .gitignore or .git/info/exclude
+	status   gitStatus // git status, rolled up for directories
 }
 
 func (e *Editor) initFileTree() {
@@ -26,6 +36,8 @@ func (e *Editor) initFileTree() {
 }
 
 func (e *Editor) refreshFileTree() {
+	e.loadGitState()
+
 	root := &FileNode{
 		name:     e.currentPath,
 		isDir:    true,
@@ -35,12 +47,95 @@ func (e *Editor) refreshFileTree() {
 	e.fileTree = root
 }
 
+// loadGitState finds the repository containing the tree root and reads its
+// ignore rules and status. Outside a repository both are left nil.
+func (e *Editor) loadGitState() {
+	e.gitRepo = findGitRepo(e.currentPath)
+	e.ignore = nil
+	e.gitStatus = nil
+	if e.gitRepo == nil {
+		return
+	}
+
+	e.ignore = newIgnoreMatcher(e.gitRepo)
+	status, err := e.gitRepo.computeStatus()
+	if err != nil {
+		e.SetStatusMessage(fmt.Sprintf("git status: %v", err))
+	}
+	e.gitStatus = status
+}
+
+// refreshGitStatus re-reads the git status and updates the decorations of
+// every loaded node without collapsing the tree.
+func (e *Editor) refreshGitStatus() {
+	if e.gitRepo == nil || e.fileTree == nil {
+		return
+	}
+	if status, err := e.gitRepo.computeStatus(); err == nil {
+		e.gitStatus = status
+	}
+
+	var update func(node *FileNode)
+	update = func(node *FileNode) {
+		node.status = gitStatusNone
+		for _, child := range node.children {
+			update(child)
+		}
+		e.decorateNode(node)
+	}
+	update(e.fileTree)
+}
+
+// decorateNode sets the ignored flag and git status of a node and rolls its
+// status up into the already loaded parents.
+func (e *Editor) decorateNode(node *FileNode) {
+	if e.gitRepo == nil {
+		return
+	}
+	rel, ok := e.gitRepo.relPath(node.name)
+	if !ok {
+		return
+	}
+
+	if node.parent != nil && node.parent.ignored {
+		node.ignored = true
+	} else if e.ignore != nil {
+		node.ignored = e.ignore.isIgnored(rel, node.isDir)
+	}
+	if e.gitStatus != nil {
+		if status := e.gitStatus.statusOf(rel, node.isDir, node.ignored); status > node.status {
+			node.status = status
+		}
+	}
+
+	for parent := node.parent; parent != nil && node.status > parent.status; parent = parent.parent {
+		parent.status = node.status
+	}
+}
+
+// treeChildren returns the children of node that should be shown, hiding
+// ignored files and, while a filter is active, anything that doesn't match.
+func (e *Editor) treeChildren(node *FileNode) []*FileNode {
+	visible := make([]*FileNode, 0, len(node.children))
+	for _, child := range node.children {
+		if child.ignored && !e.showIgnored {
+			continue
+		}
+		if e.treeFilter != "" && !e.treeMatches[child] {
+			continue
+		}
+		visible = append(visible, child)
+	}
+	return visible
+}
+
 func (e *Editor) loadDirectory(node *FileNode) {
 	entries, err := os.ReadDir(node.name)
 	if err != nil {
 		return
 	}
 
+	node.children = nil
 	for _, entry := range entries {
 		if entry.Name()[0] == '.' { // Skip hidden files
 			continue
@@ -52,6 +147,7 @@ func (e *Editor) loadDirectory(node *FileNode) {
 			expanded: false,
 			parent:   node,
 		}
+		e.decorateNode(child)
 		node.children = append(node.children, child)
 	}
 
@@ -65,10 +161,13 @@ func (e *Editor) loadDirectory(node *FileNode) {
 }
 
 func (e *Editor) drawFileTree() {
-	style := tcell.StyleDefault
-	dirStyle := style.Foreground(tcell.ColorYellow)
-	fileStyle := style.Foreground(tcell.ColorWhite)
-	selectedStyle := style.Background(tcell.ColorDarkBlue)
+	theme := e.currentTheme()
+	style := theme.Style("tree")
+	dirStyle := theme.Over("tree.directory", style)
+	fileStyle := theme.Over("tree.file", style)
+	selectedStyle := theme.Over("tree.selected", style)
+	ignoredStyle := theme.Over("tree.ignored", style)
+	separatorStyle := theme.Style("tree.separator")
 
 	// Draw tree background and separator
 	for y := 0; y < e.screenHeight; y++ {
@@ -78,48 +177,140 @@ func (e *Editor) drawFileTree() {
 		}
 
 		// Draw separator
-		e.screen.SetContent(e.treeWidth, y, '│', nil, style)
+		e.screen.SetContent(e.treeWidth, y, '│', nil, separatorStyle)
 	}
 
-	y := 0
-	e.drawTreeNode(e.fileTree, 0, &y, dirStyle, fileStyle, selectedStyle)
-}
+	rows := e.visibleTreeNodes()
+	e.followTreeSelection(len(rows))
 
-func (e *Editor) drawTreeNode(node *FileNode, depth int, y *int, dirStyle, fileStyle, selectedStyle tcell.Style) {
-	if *y >= e.screenHeight {
-		return
-	}
+	for y := 0; y < e.treeHeight() && e.treeScrollY+y < len(rows); y++ {
+		row := rows[e.treeScrollY+y]
+		node := row.node
 
-	// Draw the current node
-	prefix := strings.Repeat("  ", depth)
-	if node.isDir {
-		if node.expanded {
-			prefix += "▼ "
+		prefix := strings.Repeat("  ", row.depth)
+		if node.isDir {
+			if node.expanded {
+				prefix += "▼ "
+			} else {
+				prefix += "▶ "
+			}
+		} else {
+			prefix += "  "
+		}
+
+		name := filepath.Base(node.name)
+		style := fileStyle
+		if node.isDir {
+			style = dirStyle
+		}
+		if node.ignored {
+			style = ignoredStyle
 		} else {
-			prefix += "▶ "
+			style = theme.Over(node.status.themeKey(), style)
+		}
+		if e.treeScrollY+y == e.treeSelectedLine {
+			style = selectedStyle
+		}
+
+		// Leave room for the status mark and shorten long names
+		mark := ""
+		if node.parent != nil {
+			mark = node.status.mark()
+		}
+		width := e.treeWidth - 1
+		if mark != "" {
+			width -= 2
 		}
-	} else {
-		prefix += "  "
+		drawText(e.screen, 0, y, style, runewidth.Truncate(prefix+name, width, "…"))
+		if mark != "" {
+			drawText(e.screen, e.treeWidth-2, y, theme.Over(node.status.themeKey(), style), mark)
+		}
+	}
+}
+
+// treeHeight is the number of rows available to the tree panel.
+func (e *Editor) treeHeight() int {
+	if e.screenHeight > 1 {
+		return e.screenHeight - 1
 	}
+	return 1
+}
 
-	name := filepath.Base(node.name)
-	style := fileStyle
-	if node.isDir {
-		style = dirStyle
+// followTreeSelection clamps the selection to the visible rows and
+// scrolls the tree so the selected row stays on screen.
+func (e *Editor) followTreeSelection(rowCount int) {
+	if e.treeSelectedLine >= rowCount {
+		e.treeSelectedLine = rowCount - 1
 	}
-	if *y == e.treeSelectedLine {
-		style = selectedStyle
+	if e.treeSelectedLine < 0 {
+		e.treeSelectedLine = 0
 	}
 
-	drawText(e.screen, 0, *y, style, prefix+name)
-	*y++
+	height := e.treeHeight()
+	if e.treeSelectedLine < e.treeScrollY {
+		e.treeScrollY = e.treeSelectedLine
+	} else if e.treeSelectedLine >= e.treeScrollY+height {
+		e.treeScrollY = e.treeSelectedLine - height + 1
+	}
+	e.clampTreeScroll(rowCount)
+}
 
-	// Draw children if expanded
-	if node.expanded {
-		for _, child := range node.children {
-			e.drawTreeNode(child, depth+1, y, dirStyle, fileStyle, selectedStyle)
+func (e *Editor) clampTreeScroll(rowCount int) {
+	if maxScroll := rowCount - e.treeHeight(); e.treeScrollY > maxScroll {
+		e.treeScrollY = maxScroll
+	}
+	if e.treeScrollY < 0 {
+		e.treeScrollY = 0
+	}
+}
+
+// scrollTree scrolls the tree panel, dragging the selection along when it
+// would leave the screen.
+func (e *Editor) scrollTree(amount int) {
+	e.treeScrollY += amount
+	e.clampTreeScroll(len(e.visibleTreeNodes()))
+
+	if e.treeSelectedLine < e.treeScrollY {
+		e.treeSelectedLine = e.treeScrollY
+	} else if bottom := e.treeScrollY + e.treeHeight() - 1; e.treeSelectedLine > bottom {
+		e.treeSelectedLine = bottom
+	}
+}
+
+// setTreeWidth resizes the tree panel, keeping some room for the text.
+func (e *Editor) setTreeWidth(width int) {
+	if maxWidth := e.screenWidth - minTextWidth; width > maxWidth {
+		width = maxWidth
+	}
+	if width < minTreeWidth {
+		width = minTreeWidth
+	}
+	e.treeWidth = width
+}
+
+// treeRow is one line of the tree as shown on screen.
+type treeRow struct {
+	node  *FileNode
+	depth int
+}
+
+// visibleTreeNodes flattens the expanded part of the tree into the rows
+// that are drawn, navigated with j/k and clicked with the mouse.
+func (e *Editor) visibleTreeNodes() []treeRow {
+	var rows []treeRow
+	var walk func(node *FileNode, depth int)
+	walk = func(node *FileNode, depth int) {
+		rows = append(rows, treeRow{node: node, depth: depth})
+		if node.expanded {
+			for _, child := range e.treeChildren(node) {
+				walk(child, depth+1)
+			}
 		}
 	}
+	if e.fileTree != nil {
+		walk(e.fileTree, 0)
+	}
+	return rows
 }
 
 // Add tree navigation methods
@@ -129,8 +320,7 @@ func (e *Editor) handleTreeNavigation(ev *tcell.EventKey) {
 		switch ev.Rune() {
 		case 'j': // Move down
 			e.treeSelectedLine++
-			maxLine := 0
-			e.countVisibleNodes(e.fileTree, 0, &maxLine)
+			maxLine := len(e.visibleTreeNodes())
 			if e.treeSelectedLine >= maxLine {
 				e.treeSelectedLine = maxLine - 1
 			}
@@ -155,8 +345,7 @@ func (e *Editor) handleTreeNavigation(ev *tcell.EventKey) {
 						e.loadDirectory(node)
 					}
 				} else {
-					e.SetFilename(node.name)
-					if err := e.LoadFile(node.name); err == nil {
+					if err := e.openFile(node.name); err == nil {
 						e.treeVisible = false
 					}
 				}
@@ -210,6 +399,25 @@ func (e *Editor) handleTreeNavigation(ev *tcell.EventKey) {
 				e.SetStatusMessage("New name: ")
 				return
 			}
+		case 'I': // Toggle ignored files
+			e.showIgnored = !e.showIgnored
+			if e.showIgnored {
+				e.SetStatusMessage("Showing ignored files")
+			} else {
+				e.SetStatusMessage("Hiding ignored files")
+			}
+			e.treeSelectedLine = 0
+			if e.treeFilter != "" {
+				e.setTreeFilter(e.treeFilter)
+			}
+		case '/': // Filter the tree as you type
+			e.startTreeFilter()
+		case 'R': // Reveal the open file
+			e.revealFile(e.filename)
+		case 'b': // Bookmark the selected directory
+			e.addBookmark("")
+		case 'B': // List bookmarks
+			e.listBookmarks()
 		}
 	case tcell.KeyEnter:
 		node := e.getSelectedNode()
@@ -220,8 +428,7 @@ func (e *Editor) handleTreeNavigation(ev *tcell.EventKey) {
 					e.loadDirectory(node)
 				}
 			} else {
-				e.SetFilename(node.name)
-				if err := e.LoadFile(node.name); err == nil {
+				if err := e.openFile(node.name); err == nil {
 					e.treeVisible = false
 				}
 			}
@@ -230,32 +437,9 @@ func (e *Editor) handleTreeNavigation(ev *tcell.EventKey) {
 }
 
 func (e *Editor) getSelectedNode() *FileNode {
-	y := 0
-	return e.findNodeAtLine(e.fileTree, &y)
-}
-
-func (e *Editor) findNodeAtLine(node *FileNode, y *int) *FileNode {
-	if *y == e.treeSelectedLine {
-		return node
-	}
-	*y++
-
-	if node.expanded {
-		for _, child := range node.children {
-			if found := e.findNodeAtLine(child, y); found != nil {
-				return found
-			}
-		}
-	}
-	return nil
-}
-
-// Add this helper function to count visible nodes
-func (e *Editor) countVisibleNodes(node *FileNode, depth int, count *int) {
-	*count++
-	if node.expanded {
-		for _, child := range node.children {
-			e.countVisibleNodes(child, depth+1, count)
-		}
+	rows := e.visibleTreeNodes()
+	if e.treeSelectedLine < 0 || e.treeSelectedLine >= len(rows) {
+		return nil
 	}
+	return rows[e.treeSelectedLine].node
 }
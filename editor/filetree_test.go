@@ -0,0 +1,87 @@
+package editor
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+func TestTreeFilterAndReveal(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "cmd/app/main.go", "package main\n")
+	writeTestFile(t, dir, "internal/store/store.go", "package store\n")
+	writeTestFile(t, dir, "README.md", "# readme\n")
+
+	ed := &Editor{currentPath: dir, treeVisible: true}
+	ed.refreshFileTree()
+
+	ed.setTreeFilter("store")
+	var names []string
+	for _, row := range ed.visibleTreeNodes() {
+		names = append(names, filepath.Base(row.node.name))
+	}
+	want := []string{filepath.Base(dir), "internal", "store", "store.go"}
+	if len(names) != len(want) {
+		t.Fatalf("filtered rows = %v, want %v", names, want)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Fatalf("filtered rows = %v, want %v", names, want)
+		}
+	}
+	if node := ed.getSelectedNode(); node == nil || filepath.Base(node.name) != "store" {
+		t.Errorf("expected first match to be selected, got %v", node)
+	}
+
+	// Typing narrows it, Backspace takes off a whole character, and
+	// clearing it collapses what the filter expanded
+	ed.setTreeFilter("")
+	ed.startTreeFilter()
+	for _, r := range "stö" {
+		ed.handleTreeFilterMode(tcell.NewEventKey(tcell.KeyRune, r, tcell.ModNone))
+	}
+	ed.handleTreeFilterMode(tcell.NewEventKey(tcell.KeyBackspace2, 0, tcell.ModNone))
+	if ed.treeFilter != "st" {
+		t.Errorf("Backspace left the filter %q", ed.treeFilter)
+	}
+	ed.setTreeFilter("")
+	if rows := ed.visibleTreeNodes(); len(rows) != 4 {
+		t.Errorf("%d rows after clearing the filter, want 4", len(rows))
+	}
+
+	ed.revealFile(filepath.Join(dir, "cmd", "app", "main.go"))
+	node := ed.getSelectedNode()
+	if node == nil || node.name != filepath.Join(dir, "cmd", "app", "main.go") {
+		t.Errorf("reveal selected %v", node)
+	}
+}
+
+func TestTreeMouseHitTesting(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.txt", "b.txt", "c.txt", "d.txt", "e.txt"} {
+		writeTestFile(t, dir, "nested/"+name, name+"\n")
+	}
+
+	ed := &Editor{currentPath: dir, treeVisible: true, treeWidth: 30, screenWidth: 80, screenHeight: 4}
+	ed.refreshFileTree()
+
+	// Click on "nested" expands it
+	ed.handleTreeMouseEvent(2, 1, tcell.Button1, true)
+	if rows := ed.visibleTreeNodes(); len(rows) != 7 {
+		t.Fatalf("expected nested to expand, got %d rows", len(rows))
+	}
+
+	// Scroll so the tree starts at row 3 and click the second visible row
+	ed.scrollTree(3)
+	ed.handleTreeMouseEvent(2, 1, tcell.Button1, true)
+	node := ed.getSelectedNode()
+	if node == nil || filepath.Base(node.name) != "c.txt" {
+		t.Errorf("click selected %v, want c.txt", node)
+	}
+
+	ed.setTreeWidth(200)
+	if ed.treeWidth != ed.screenWidth-minTextWidth {
+		t.Errorf("tree width not clamped: %d", ed.treeWidth)
+	}
+}
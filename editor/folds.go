@@ -0,0 +1,420 @@
+package editor
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Folding.
+//
+// Folds come from the indentation by default, or from matching braces and
+// brackets in C-like languages (foldMethod), plus any made by hand with zf.
+// A closed fold shows as a single summary line; the lines in it are skipped
+// by cursor motions and by scrolling, and a jump into one opens it.
+//
+// Closed folds are remembered by their first line. When an edit adds or
+// removes lines, folds below the edit move with them.
+
+type fold struct {
+	start, end int // the first and last line, start is the summary
+	closed     bool
+}
+
+// braceLanguages fold on brackets with foldMethod auto.
+var braceLanguages = []string{
+	"go", "c", "c++", "cpp", "c#", "csharp", "java", "javascript", "js", "typescript", "ts",
+	"tsx", "jsx", "rust", "swift", "kotlin", "scala", "php", "css", "scss", "less", "json",
+	"dart", "groovy", "objective-c", "zig",
+}
+
+func parseFoldMethod(value string) (string, error) {
+	switch value {
+	case "auto", "indent", "brace", "manual":
+		return value, nil
+	}
+	return "", fmt.Errorf("expected auto, indent, brace or manual, got %q", value)
+}
+
+// foldMethod is how folds are found: indent, brace or manual.
+func (e *Editor) foldMethod() string {
+	method := e.option("foldMethod")
+	if method != "auto" {
+		return method
+	}
+	for _, name := range e.fileTypeNames() {
+		if slices.Contains(braceLanguages, name) {
+			return "brace"
+		}
+	}
+	return "indent"
+}
+
+// syncLines moves folds, marks and breakpoints after lines were added or
+// removed since the last call. The edit is taken to be at the cursor.
+func (e *Editor) syncLines() {
+	delta := len(e.lines) - e.syncedLineCount
+	at := min(e.syncedCursorY, e.cursorY)
+	e.syncedLineCount, e.syncedCursorY = len(e.lines), e.cursorY
+	if delta == 0 {
+		return
+	}
+	if len(e.closedFolds) > 0 {
+		closed := make(map[int]bool, len(e.closedFolds))
+		for start := range e.closedFolds {
+			if start, ok := shiftLine(start, at, delta, len(e.lines)); ok {
+				closed[start] = true
+			}
+		}
+		e.closedFolds = closed
+	}
+
+	var manual []fold
+	for _, f := range e.manualFolds {
+		if f.start > at {
+			f.start += delta
+		}
+		if f.end >= at {
+			f.end += delta
+		}
+		if f.start >= 0 && f.end < len(e.lines) && f.end > f.start {
+			manual = append(manual, f)
+		}
+	}
+	e.manualFolds = manual
+	e.shiftSigns(at, delta)
+}
+
+// shiftLine is where line y is after delta lines were added or removed
+// after line at, or false if it was removed.
+func shiftLine(y, at, delta, lineCount int) (int, bool) {
+	if y > at {
+		if y+delta <= at {
+			return 0, false
+		}
+		y += delta
+	}
+	return y, y >= 0 && y < lineCount
+}
+
+// computedFolds finds the folds of the fold method, outermost first.
+func (e *Editor) computedFolds() []fold {
+	switch e.foldMethod() {
+	case "indent":
+		return indentFolds(e.lines, e.tabSize)
+	case "brace":
+		return braceFolds(e.lines)
+	}
+	return nil
+}
+
+// allFolds is every fold with whether it's closed, sorted by start and
+// outermost first.
+func (e *Editor) allFolds() []fold {
+	e.syncLines()
+	folds := e.computedFolds()
+	for i := range folds {
+		folds[i].closed = e.closedFolds[folds[i].start]
+	}
+	folds = append(folds, e.manualFolds...)
+	sort.SliceStable(folds, func(i, j int) bool {
+		if folds[i].start != folds[j].start {
+			return folds[i].start < folds[j].start
+		}
+		return folds[i].end > folds[j].end
+	})
+	return folds
+}
+
+// closedRanges is the outermost closed folds, in order. Only these matter
+// for what is on screen. Draw works them out once for the frame.
+func (e *Editor) closedRanges() []fold {
+	if e.frameCached {
+		return e.cachedFolds
+	}
+	e.syncLines()
+	if len(e.closedFolds) == 0 && !slices.ContainsFunc(e.manualFolds, func(f fold) bool { return f.closed }) {
+		return nil
+	}
+	var ranges []fold
+	for _, f := range e.allFolds() {
+		if !f.closed {
+			continue
+		}
+		if n := len(ranges); n > 0 && f.start <= ranges[n-1].end {
+			continue // inside the previous one
+		}
+		ranges = append(ranges, f)
+	}
+	return ranges
+}
+
+// closedFoldAt is the outermost closed fold that line y is in.
+func (e *Editor) closedFoldAt(y int) (fold, bool) {
+	for _, f := range e.closedRanges() {
+		if f.start > y {
+			break
+		}
+		if y <= f.end {
+			return f, true
+		}
+	}
+	return fold{}, false
+}
+
+// isFoldSummary reports whether line y is drawn as the summary of a closed
+// fold.
+func (e *Editor) isFoldSummary(y int) bool {
+	f, ok := e.closedFoldAt(y)
+	return ok && f.start == y
+}
+
+// nextLine is the next line on screen after y, which is past the end of the
+// closed fold y is in. It's len(e.lines) after the last line.
+func (e *Editor) nextLine(y int) int {
+	if f, ok := e.closedFoldAt(y); ok {
+		return f.end + 1
+	}
+	return y + 1
+}
+
+// prevLine is the line on screen before y, or -1.
+func (e *Editor) prevLine(y int) int {
+	if y <= 0 {
+		return -1
+	}
+	if f, ok := e.closedFoldAt(y - 1); ok {
+		return f.start
+	}
+	return y - 1
+}
+
+// visibleLine is the line shown for y: the start of a closed fold it's in.
+func (e *Editor) visibleLine(y int) int {
+	if f, ok := e.closedFoldAt(y); ok {
+		return f.start
+	}
+	return y
+}
+
+// openFoldsAt opens the closed folds that hide line y.
+func (e *Editor) openFoldsAt(y int) {
+	for {
+		f, ok := e.closedFoldAt(y)
+		if !ok || f.start == y {
+			return
+		}
+		e.setFoldClosed(f, false)
+	}
+}
+
+func (e *Editor) setFoldClosed(f fold, closed bool) {
+	for i, m := range e.manualFolds {
+		if m.start == f.start && m.end == f.end {
+			e.manualFolds[i].closed = closed
+			return
+		}
+	}
+	if closed {
+		if e.closedFolds == nil {
+			e.closedFolds = map[int]bool{}
+		}
+		e.closedFolds[f.start] = true
+	} else {
+		delete(e.closedFolds, f.start)
+	}
+}
+
+// foldCommand runs za, zo, zc, zR, zM, zd and zE.
+func (e *Editor) foldCommand(key rune) {
+	folds := e.allFolds()
+	y := e.cursorY
+	// The folds around the cursor, innermost last
+	var around []fold
+	for _, f := range folds {
+		if f.start <= y && y <= f.end {
+			around = append(around, f)
+		}
+	}
+
+	switch key {
+	case 'o', 'c', 'a':
+		if len(around) == 0 {
+			e.setStatusMessage("No fold found")
+			return
+		}
+		outerClosed := slices.IndexFunc(around, func(f fold) bool { return f.closed })
+		if key == 'o' || (key == 'a' && outerClosed >= 0) {
+			if outerClosed >= 0 {
+				e.setFoldClosed(around[outerClosed], false)
+			}
+			return
+		}
+		// Close the innermost open fold
+		for i := len(around) - 1; i >= 0; i-- {
+			if !around[i].closed {
+				e.setFoldClosed(around[i], true)
+				e.cursorY, e.cursorX = around[i].start, 0
+				return
+			}
+		}
+	case 'R':
+		e.closedFolds = nil
+		for i := range e.manualFolds {
+			e.manualFolds[i].closed = false
+		}
+	case 'M':
+		for _, f := range folds {
+			e.setFoldClosed(f, true)
+		}
+		e.cursorY = e.visibleLine(e.cursorY)
+	case 'd':
+		for i := len(around) - 1; i >= 0; i-- {
+			f := around[i]
+			if j := slices.IndexFunc(e.manualFolds, func(m fold) bool { return m.start == f.start && m.end == f.end }); j >= 0 {
+				e.manualFolds = slices.Delete(e.manualFolds, j, j+1)
+				return
+			}
+		}
+		e.setStatusMessage("No manual fold here")
+	case 'E':
+		e.manualFolds = nil
+	}
+}
+
+// createFold folds lines from..to by hand (zf), closed.
+func (e *Editor) createFold(from, to int) {
+	e.syncLines()
+	from, to = max(from, 0), min(to, len(e.lines)-1)
+	if to <= from {
+		e.setStatusMessage("A fold needs at least two lines")
+		return
+	}
+	e.manualFolds = append(e.manualFolds, fold{start: from, end: to, closed: true})
+	e.cursorY, e.cursorX = from, 0
+}
+
+// indentFolds folds each line with the more indented lines after it.
+// Blank lines inside a fold belong to it, trailing ones don't.
+func indentFolds(lines []string, tabSize int) []fold {
+	type open struct{ start, indent int }
+	var folds []fold
+	var stack []open
+	last := -1 // the last line that isn't blank
+	for y, line := range lines {
+		rest := strings.TrimLeft(line, " \t")
+		if rest == "" {
+			continue
+		}
+		indent := displayColumn(line, len(line)-len(rest), tabSize)
+		for len(stack) > 0 && indent <= stack[len(stack)-1].indent {
+			top := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if last > top.start {
+				folds = append(folds, fold{start: top.start, end: last})
+			}
+		}
+		stack = append(stack, open{y, indent})
+		last = y
+	}
+	for i := len(stack) - 1; i >= 0; i-- {
+		if last > stack[i].start {
+			folds = append(folds, fold{start: stack[i].start, end: last})
+		}
+	}
+	sortFolds(folds)
+	return folds
+}
+
+// braceFolds folds from a line with an opening bracket to the line with the
+// bracket that closes it. Brackets in strings and comments don't count.
+func braceFolds(lines []string) []fold {
+	var folds []fold
+	var stack []int // lines of the open brackets
+	comment := false
+	var quote byte
+	for y, line := range lines {
+		for i := 0; i < len(line); i++ {
+			c := line[i]
+			switch {
+			case comment:
+				if strings.HasPrefix(line[i:], "*/") {
+					comment = false
+					i++
+				}
+			case quote != 0:
+				if c == '\\' {
+					i++
+				} else if c == quote {
+					quote = 0
+				}
+			case strings.HasPrefix(line[i:], "//"):
+				i = len(line)
+			case strings.HasPrefix(line[i:], "/*"):
+				comment = true
+				i++
+			case c == '"' || c == '\'' || c == '`':
+				quote = c
+			case c == '{' || c == '[' || c == '(':
+				stack = append(stack, y)
+			case c == '}' || c == ']' || c == ')':
+				if len(stack) == 0 {
+					continue
+				}
+				start := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				// Several brackets opening on one line make one fold
+				if y > start && (len(folds) == 0 || folds[len(folds)-1].start != start) {
+					folds = append(folds, fold{start: start, end: y})
+				}
+			}
+		}
+		// Raw strings in backquotes go on over lines, others don't
+		if quote != '`' {
+			quote = 0
+		}
+	}
+	sortFolds(folds)
+	return folds
+}
+
+func sortFolds(folds []fold) {
+	sort.Slice(folds, func(i, j int) bool {
+		if folds[i].start != folds[j].start {
+			return folds[i].start < folds[j].start
+		}
+		return folds[i].end > folds[j].end
+	})
+}
+
+// resetFolds forgets the folds, marks and breakpoints, for a newly loaded
+// file.
+func (e *Editor) resetFolds() {
+	e.closedFolds, e.manualFolds = nil, nil
+	e.marks, e.breakpoints = nil, nil
+	e.syncedLineCount, e.syncedCursorY = len(e.lines), 0
+}
+
+// foldSummary is the text shown for a closed fold.
+func (e *Editor) foldSummary(f fold) string {
+	text := strings.TrimSpace(strings.ReplaceAll(e.lines[f.start], "\t", " "))
+	return fmt.Sprintf("+-- %d lines: %s ", f.end-f.start+1, text)
+}
+
+// foldMarker is what the fold column shows for line y: + for a closed
+// fold, - where an open one starts.
+func (e *Editor) foldMarker(y int, folds []fold) rune {
+	for _, f := range folds {
+		if f.start > y {
+			break
+		}
+		if f.start == y {
+			if f.closed {
+				return '+'
+			}
+			return '-'
+		}
+	}
+	return ' '
+}
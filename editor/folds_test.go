@@ -0,0 +1,163 @@
+package editor
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestFoldRanges(t *testing.T) {
+	indented := []string{
+		"def a():",  // 0
+		"    x = 1", // 1
+		"",          // 2
+		"    if x:", // 3
+		"        y", // 4
+		"",          // 5
+		"def b():",  // 6
+		"\tpass",    // 7
+		"end",       // 8
+	}
+	if got := fmt.Sprint(indentFolds(indented, 4)); got != "[{0 4 false} {3 4 false} {6 7 false}]" {
+		t.Errorf("indent folds %s", got)
+	}
+
+	braces := []string{
+		"func main() {",           // 0
+		"\ts := \"{\" // {",       // 1
+		"\tfor {",                 // 2
+		"\t\tf(a, /* ( */ []int{", // 3
+		"\t\t\t1,",                // 4
+		"\t\t})",                  // 5
+		"\t}",                     // 6
+		"}",                       // 7
+	}
+	if got := fmt.Sprint(braceFolds(braces)); got != "[{0 7 false} {2 6 false} {3 5 false}]" {
+		t.Errorf("brace folds %s", got)
+	}
+}
+
+func TestFoldCommands(t *testing.T) {
+	ed := &Editor{
+		mode:         "normal",
+		filename:     "main.go",
+		screenWidth:  40,
+		screenHeight: 6,
+		lines: []string{
+			"package main", // 0
+			"func a() {",   // 1
+			"\tif x {",     // 2
+			"\t\ty()",      // 3
+			"\t}",          // 4
+			"}",            // 5
+			"func b() {",   // 6
+			"}",            // 7
+		},
+		settings: map[string]string{"showLineNumbers": "false", "foldColumn": "false"},
+	}
+	ed.applySettings()
+	if ed.foldMethod() != "brace" {
+		t.Fatalf("Go folds by %s", ed.foldMethod())
+	}
+
+	// zc closes the innermost fold and j steps over it
+	ed.cursorY = 3
+	typeKeys(ed, "zc")
+	if ed.cursorY != 2 || !ed.isFoldSummary(2) {
+		t.Errorf("zc: cursor on %d, folds %v", ed.cursorY, ed.closedRanges())
+	}
+	typeKeys(ed, "j")
+	if ed.cursorY != 5 {
+		t.Errorf("j over the fold went to %d", ed.cursorY)
+	}
+	typeKeys(ed, "k")
+	if ed.cursorY != 2 {
+		t.Errorf("k onto the fold went to %d", ed.cursorY)
+	}
+
+	// zc again closes the fold around it, za opens it back
+	typeKeys(ed, "zc")
+	if ed.cursorY != 1 || ed.nextLine(1) != 6 {
+		t.Errorf("zc zc: cursor on %d, next line %d", ed.cursorY, ed.nextLine(1))
+	}
+	if sx, sy, ok := ed.screenPosition(7, 0); !ok || sx != 0 || sy != 3 {
+		t.Errorf("line 7 drawn at %d,%d (%v)", sx, sy, ok)
+	}
+	if y, _, ok := ed.screenLine(2, 0); !ok || y != 6 {
+		t.Errorf("row 2 is line %d", y)
+	}
+	typeKeys(ed, "za")
+	if !ed.isFoldSummary(2) || ed.isFoldSummary(1) {
+		t.Errorf("za left %v", ed.closedRanges())
+	}
+
+	// zR opens everything, zM closes everything
+	typeKeys(ed, "zR")
+	if len(ed.closedRanges()) != 0 {
+		t.Errorf("zR left %v", ed.closedRanges())
+	}
+	ed.cursorY = 4
+	typeKeys(ed, "zM")
+	if got := fmt.Sprint(ed.closedRanges()); got != "[{1 5 true} {6 7 true}]" || ed.cursorY != 1 {
+		t.Errorf("zM: closed %s, cursor on %d", got, ed.cursorY)
+	}
+
+	// Searching opens the folds hiding the match
+	ed.searchTerm = "y()"
+	ed.findMatches()
+	if _, hidden := ed.closedFoldAt(3); ed.cursorY != 3 || hidden {
+		t.Errorf("search went to %d, folds %v", ed.cursorY, ed.closedRanges())
+	}
+}
+
+func TestFoldsFollowEdits(t *testing.T) {
+	ed := &Editor{
+		mode:     "normal",
+		lines:    []string{"a", "b", "  c", "  d", "e"},
+		settings: map[string]string{"foldMethod": "indent"},
+	}
+	ed.applySettings()
+	ed.resetFolds()
+	ed.cursorY = 1
+	typeKeys(ed, "zc")
+	if !ed.isFoldSummary(1) {
+		t.Fatalf("zc: %v", ed.closedRanges())
+	}
+
+	// A line added above the fold moves it down. Draw between keys sees
+	// where the cursor was before the edit.
+	ed.cursorY, ed.cursorX = 0, 1
+	ed.closedRanges()
+	ed.insertNewLine()
+	if got := fmt.Sprint(ed.closedRanges()); got != "[{2 4 true}]" {
+		t.Errorf("after a new line above: %s", got)
+	}
+}
+
+func TestManualFolds(t *testing.T) {
+	ed := &Editor{
+		mode:     "normal",
+		lines:    []string{"one", "two", "", "three", "four", "five"},
+		settings: map[string]string{"foldMethod": "manual"},
+	}
+	ed.applySettings()
+	ed.resetFolds()
+
+	ed.cursorY = 4
+	typeKeys(ed, "zfap")
+	if got := fmt.Sprint(ed.closedRanges()); got != "[{3 5 true}]" || ed.cursorY != 3 {
+		t.Errorf("zfap: %s, cursor on %d", got, ed.cursorY)
+	}
+	ed.cursorY = 0
+	typeKeys(ed, "zfj")
+	if got := fmt.Sprint(ed.closedRanges()); got != "[{0 1 true} {3 5 true}]" {
+		t.Errorf("zfj: %s", got)
+	}
+	typeKeys(ed, "zd")
+	if got := fmt.Sprint(ed.closedRanges()); got != "[{3 5 true}]" {
+		t.Errorf("zd: %s", got)
+	}
+	typeKeys(ed, "zE")
+	if len(ed.manualFolds) != 0 {
+		t.Errorf("zE left %v", ed.manualFolds)
+	}
+}
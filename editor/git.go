@@ -0,0 +1,644 @@
+package editor
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Read-only access to a git repository. This is just enough of git's
+// on-disk format (refs, loose and packed objects, the index) to decorate
+// the file tree without shelling out to the git binary.
+
+type gitRepo struct {
+	root   string // working tree root
+	gitDir string
+	packs  []*gitPack
+	loaded bool // packs scanned
+}
+
+type gitIndexEntry struct {
+	path      string
+	hash      string
+	mode      uint32
+	size      uint32
+	mtimeSec  uint32
+	mtimeNsec uint32
+	stage     int
+}
+
+// findGitRepo walks up from path looking for a .git directory (or a .git
+// file pointing at one, as used by worktrees and submodules).
+func findGitRepo(path string) *gitRepo {
+	dir, err := filepath.Abs(path)
+	if err != nil {
+		return nil
+	}
+	if info, err := os.Stat(dir); err == nil && !info.IsDir() {
+		dir = filepath.Dir(dir)
+	}
+
+	for {
+		dotGit := filepath.Join(dir, ".git")
+		if info, err := os.Stat(dotGit); err == nil {
+			if info.IsDir() {
+				return &gitRepo{root: dir, gitDir: dotGit}
+			}
+			if data, err := os.ReadFile(dotGit); err == nil {
+				line := strings.TrimSpace(string(data))
+				if strings.HasPrefix(line, "gitdir:") {
+					gitDir := strings.TrimSpace(strings.TrimPrefix(line, "gitdir:"))
+					if !filepath.IsAbs(gitDir) {
+						gitDir = filepath.Join(dir, gitDir)
+					}
+					return &gitRepo{root: dir, gitDir: gitDir}
+				}
+			}
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil
+		}
+		dir = parent
+	}
+}
+
+// relPath converts an absolute path into a slash separated path relative
+// to the working tree root, as used by the index and tree objects.
+func (r *gitRepo) relPath(path string) (string, bool) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", false
+	}
+	rel, err := filepath.Rel(r.root, abs)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	if rel == "." {
+		return "", true
+	}
+	return filepath.ToSlash(rel), true
+}
+
+// commonDir is where objects and refs live; linked worktrees keep only
+// HEAD and the index in their own git directory.
+func (r *gitRepo) commonDir() string {
+	data, err := os.ReadFile(filepath.Join(r.gitDir, "commondir"))
+	if err != nil {
+		return r.gitDir
+	}
+	dir := strings.TrimSpace(string(data))
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(r.gitDir, dir)
+	}
+	return dir
+}
+
+// resolveRef follows symbolic refs until it reaches a commit hash. An
+// unborn branch (fresh repository) resolves to "" without an error.
+func (r *gitRepo) resolveRef(name string) (string, error) {
+	for depth := 0; depth < 10; depth++ {
+		if isHexHash(name) {
+			return name, nil
+		}
+
+		dir := r.commonDir()
+		if name == "HEAD" {
+			dir = r.gitDir
+		}
+		data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+		if err == nil {
+			content := strings.TrimSpace(string(data))
+			if strings.HasPrefix(content, "ref:") {
+				name = strings.TrimSpace(strings.TrimPrefix(content, "ref:"))
+				continue
+			}
+			return content, nil
+		}
+
+		hash, err := r.packedRef(name)
+		if err != nil {
+			return "", err
+		}
+		return hash, nil
+	}
+	return "", fmt.Errorf("too many levels of symbolic refs")
+}
+
+func (r *gitRepo) packedRef(name string) (string, error) {
+	file, err := os.Open(filepath.Join(r.commonDir(), "packed-refs"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || line[0] == '#' || line[0] == '^' {
+			continue
+		}
+		parts := strings.SplitN(line, " ", 2)
+		if len(parts) == 2 && parts[1] == name {
+			return parts[0], nil
+		}
+	}
+	return "", scanner.Err()
+}
+
+func isHexHash(s string) bool {
+	if len(s) != 40 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// readObject returns the type ("blob", "tree", "commit", "tag") and the
+// inflated content of an object.
+func (r *gitRepo) readObject(hash string) (string, []byte, error) {
+	if !isHexHash(hash) {
+		return "", nil, fmt.Errorf("invalid object name %q", hash)
+	}
+
+	path := filepath.Join(r.commonDir(), "objects", hash[:2], hash[2:])
+	if file, err := os.Open(path); err == nil {
+		defer file.Close()
+		zr, err := zlib.NewReader(file)
+		if err != nil {
+			return "", nil, err
+		}
+		defer zr.Close()
+		raw, err := io.ReadAll(zr)
+		if err != nil {
+			return "", nil, err
+		}
+		nul := bytes.IndexByte(raw, 0)
+		if nul < 0 {
+			return "", nil, fmt.Errorf("corrupt object %s", hash)
+		}
+		header := strings.SplitN(string(raw[:nul]), " ", 2)
+		return header[0], raw[nul+1:], nil
+	}
+
+	r.loadPacks()
+	raw, _ := hex.DecodeString(hash)
+	for _, pack := range r.packs {
+		if offset, ok := pack.find(raw); ok {
+			return pack.readAt(r, offset)
+		}
+	}
+	return "", nil, fmt.Errorf("object %s not found", hash)
+}
+
+// readCommit returns the tree hash, parent hashes and raw headers/message
+// of a commit object.
+func (r *gitRepo) readCommit(hash string) (tree string, parents []string, body string, err error) {
+	kind, data, err := r.readObject(hash)
+	if err != nil {
+		return "", nil, "", err
+	}
+	if kind != "commit" {
+		return "", nil, "", fmt.Errorf("%s is a %s, not a commit", hash, kind)
+	}
+
+	body = string(data)
+	for _, line := range strings.Split(body, "\n") {
+		if line == "" {
+			break
+		}
+		if strings.HasPrefix(line, "tree ") {
+			tree = line[5:]
+		} else if strings.HasPrefix(line, "parent ") {
+			parents = append(parents, line[7:])
+		}
+	}
+	return tree, parents, body, nil
+}
+
+// readTree flattens a tree object into a map of slash separated paths to
+// blob hashes.
+func (r *gitRepo) readTree(hash, prefix string, out map[string]string) error {
+	kind, data, err := r.readObject(hash)
+	if err != nil {
+		return err
+	}
+	if kind != "tree" {
+		return fmt.Errorf("%s is a %s, not a tree", hash, kind)
+	}
+
+	for len(data) > 0 {
+		space := bytes.IndexByte(data, ' ')
+		nul := bytes.IndexByte(data, 0)
+		if space < 0 || nul < space || nul+21 > len(data) {
+			return fmt.Errorf("corrupt tree %s", hash)
+		}
+		mode := string(data[:space])
+		name := prefix + string(data[space+1:nul])
+		child := hex.EncodeToString(data[nul+1 : nul+21])
+		data = data[nul+21:]
+
+		switch mode {
+		case "40000":
+			if err := r.readTree(child, name+"/", out); err != nil {
+				return err
+			}
+		case "160000":
+			// Submodule commits are not part of this repository's objects.
+		default:
+			out[name] = child
+		}
+	}
+	return nil
+}
+
+// headTree returns the flattened tree of the commit HEAD points at. A
+// repository without commits yields an empty map.
+func (r *gitRepo) headTree() (map[string]string, error) {
+	files := make(map[string]string)
+	head, err := r.resolveRef("HEAD")
+	if err != nil || head == "" {
+		return files, err
+	}
+	tree, _, _, err := r.readCommit(head)
+	if err != nil {
+		return files, err
+	}
+	return files, r.readTree(tree, "", files)
+}
+
+// fileAt returns the content of the file at slash separated path rel in
+// commit rev (a hash or a ref such as "HEAD"). It fails with
+// os.ErrNotExist when the commit has no such file.
+func (r *gitRepo) fileAt(rev, rel string) ([]byte, error) {
+	hash, err := r.resolveRef(rev)
+	if err != nil {
+		return nil, err
+	}
+	if hash == "" {
+		return nil, os.ErrNotExist
+	}
+	blob, err := r.pathHash(hash, rel)
+	if err != nil {
+		return nil, err
+	}
+	kind, data, err := r.readObject(blob)
+	if err != nil {
+		return nil, err
+	}
+	if kind != "blob" {
+		return nil, os.ErrNotExist
+	}
+	return data, nil
+}
+
+// pathHash is the object name of path rel in a commit. Each directory is
+// looked up in its parent rather than reading the whole tree.
+func (r *gitRepo) pathHash(commit, rel string) (string, error) {
+	hash, _, _, err := r.readCommit(commit)
+	if err != nil {
+		return "", err
+	}
+	for _, name := range strings.Split(rel, "/") {
+		tree := hash
+		kind, data, err := r.readObject(tree)
+		if err != nil {
+			return "", err
+		}
+		if kind != "tree" {
+			return "", os.ErrNotExist
+		}
+		hash = ""
+		for len(data) > 0 {
+			space := bytes.IndexByte(data, ' ')
+			nul := bytes.IndexByte(data, 0)
+			if space < 0 || nul < space || nul+21 > len(data) {
+				return "", fmt.Errorf("corrupt tree %s", tree)
+			}
+			if string(data[space+1:nul]) == name {
+				hash = hex.EncodeToString(data[nul+1 : nul+21])
+				break
+			}
+			data = data[nul+21:]
+		}
+		if hash == "" {
+			return "", os.ErrNotExist
+		}
+	}
+	return hash, nil
+}
+
+// readIndex parses .git/index (versions 2 to 4).
+func (r *gitRepo) readIndex() ([]gitIndexEntry, error) {
+	data, err := os.ReadFile(filepath.Join(r.gitDir, "index"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if len(data) < 12 || string(data[:4]) != "DIRC" {
+		return nil, fmt.Errorf("invalid index file")
+	}
+
+	version := binary.BigEndian.Uint32(data[4:8])
+	count := int(binary.BigEndian.Uint32(data[8:12]))
+	if version < 2 || version > 4 {
+		return nil, fmt.Errorf("unsupported index version %d", version)
+	}
+
+	entries := make([]gitIndexEntry, 0, count)
+	pos := 12
+	prevName := ""
+	for i := 0; i < count; i++ {
+		if pos+62 > len(data) {
+			return nil, fmt.Errorf("truncated index")
+		}
+		start := pos
+		entry := gitIndexEntry{
+			mtimeSec:  binary.BigEndian.Uint32(data[pos+8:]),
+			mtimeNsec: binary.BigEndian.Uint32(data[pos+12:]),
+			mode:      binary.BigEndian.Uint32(data[pos+24:]),
+			size:      binary.BigEndian.Uint32(data[pos+36:]),
+			hash:      hex.EncodeToString(data[pos+40 : pos+60]),
+		}
+		flags := binary.BigEndian.Uint16(data[pos+60:])
+		entry.stage = int(flags>>12) & 3
+		pos += 62
+		if version >= 3 && flags&0x4000 != 0 {
+			pos += 2
+		}
+
+		if version == 4 {
+			strip, n := binary.Uvarint(data[pos:])
+			if n <= 0 || int(strip) > len(prevName) {
+				return nil, fmt.Errorf("corrupt index path")
+			}
+			pos += n
+			end := bytes.IndexByte(data[pos:], 0)
+			if end < 0 {
+				return nil, fmt.Errorf("corrupt index path")
+			}
+			entry.path = prevName[:len(prevName)-int(strip)] + string(data[pos:pos+end])
+			pos += end + 1
+		} else {
+			end := bytes.IndexByte(data[pos:], 0)
+			if end < 0 {
+				return nil, fmt.Errorf("corrupt index path")
+			}
+			entry.path = string(data[pos : pos+end])
+			// Entries are NUL padded to a multiple of eight bytes.
+			pos = start + ((pos + end - start + 8) &^ 7)
+		}
+
+		prevName = entry.path
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// gitBlobHash computes the object name git would give data as a blob.
+func gitBlobHash(data []byte) string {
+	h := sha1.New()
+	fmt.Fprintf(h, "blob %d\x00", len(data))
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Packfiles
+
+type gitPack struct {
+	path    string
+	hashes  []byte // sorted 20 byte object names
+	offsets []uint64
+}
+
+func (r *gitRepo) loadPacks() {
+	if r.loaded {
+		return
+	}
+	r.loaded = true
+
+	matches, _ := filepath.Glob(filepath.Join(r.commonDir(), "objects", "pack", "*.idx"))
+	for _, idx := range matches {
+		if pack, err := loadPackIndex(idx); err == nil {
+			r.packs = append(r.packs, pack)
+		}
+	}
+}
+
+func loadPackIndex(idxPath string) (*gitPack, error) {
+	data, err := os.ReadFile(idxPath)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 8+256*4 || !bytes.Equal(data[:4], []byte{0xff, 't', 'O', 'c'}) ||
+		binary.BigEndian.Uint32(data[4:8]) != 2 {
+		return nil, fmt.Errorf("unsupported pack index %s", idxPath)
+	}
+
+	count := int(binary.BigEndian.Uint32(data[8+255*4:]))
+	hashStart := 8 + 256*4
+	offsetStart := hashStart + count*20 + count*4
+	largeStart := offsetStart + count*4
+	if largeStart > len(data) {
+		return nil, fmt.Errorf("truncated pack index %s", idxPath)
+	}
+
+	pack := &gitPack{
+		path:    strings.TrimSuffix(idxPath, ".idx") + ".pack",
+		hashes:  data[hashStart : hashStart+count*20],
+		offsets: make([]uint64, count),
+	}
+	for i := 0; i < count; i++ {
+		off := binary.BigEndian.Uint32(data[offsetStart+i*4:])
+		if off&0x80000000 != 0 {
+			pos := largeStart + int(off&0x7fffffff)*8
+			if pos+8 > len(data) {
+				return nil, fmt.Errorf("truncated pack index %s", idxPath)
+			}
+			pack.offsets[i] = binary.BigEndian.Uint64(data[pos:])
+		} else {
+			pack.offsets[i] = uint64(off)
+		}
+	}
+	return pack, nil
+}
+
+func (p *gitPack) find(hash []byte) (uint64, bool) {
+	count := len(p.offsets)
+	i := sort.Search(count, func(i int) bool {
+		return bytes.Compare(p.hashes[i*20:i*20+20], hash) >= 0
+	})
+	if i < count && bytes.Equal(p.hashes[i*20:i*20+20], hash) {
+		return p.offsets[i], true
+	}
+	return 0, false
+}
+
+var gitObjectTypes = map[byte]string{1: "commit", 2: "tree", 3: "blob", 4: "tag"}
+
+func (p *gitPack) readAt(r *gitRepo, offset uint64) (string, []byte, error) {
+	file, err := os.Open(p.path)
+	if err != nil {
+		return "", nil, err
+	}
+	defer file.Close()
+	return p.readEntry(r, file, offset, 0)
+}
+
+func (p *gitPack) readEntry(r *gitRepo, file *os.File, offset uint64, depth int) (string, []byte, error) {
+	if depth > 50 {
+		return "", nil, fmt.Errorf("delta chain too long")
+	}
+
+	reader := bufio.NewReader(io.NewSectionReader(file, int64(offset), 1<<62))
+	c, err := reader.ReadByte()
+	if err != nil {
+		return "", nil, err
+	}
+	kind := (c >> 4) & 7
+	size := uint64(c & 0x0f)
+	for shift := 4; c&0x80 != 0; shift += 7 {
+		if c, err = reader.ReadByte(); err != nil {
+			return "", nil, err
+		}
+		size |= uint64(c&0x7f) << shift
+	}
+
+	var baseType string
+	var base []byte
+	switch kind {
+	case 6: // OFS_DELTA
+		c, err = reader.ReadByte()
+		if err != nil {
+			return "", nil, err
+		}
+		rel := uint64(c & 0x7f)
+		for c&0x80 != 0 {
+			if c, err = reader.ReadByte(); err != nil {
+				return "", nil, err
+			}
+			rel = ((rel + 1) << 7) | uint64(c&0x7f)
+		}
+		if baseType, base, err = p.readEntry(r, file, offset-rel, depth+1); err != nil {
+			return "", nil, err
+		}
+	case 7: // REF_DELTA
+		ref := make([]byte, 20)
+		if _, err := io.ReadFull(reader, ref); err != nil {
+			return "", nil, err
+		}
+		if baseType, base, err = r.readObject(hex.EncodeToString(ref)); err != nil {
+			return "", nil, err
+		}
+	}
+
+	zr, err := zlib.NewReader(reader)
+	if err != nil {
+		return "", nil, err
+	}
+	defer zr.Close()
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if kind == 6 || kind == 7 {
+		out, err := applyGitDelta(base, data)
+		return baseType, out, err
+	}
+	name, ok := gitObjectTypes[kind]
+	if !ok {
+		return "", nil, fmt.Errorf("unknown pack object type %d", kind)
+	}
+	if uint64(len(data)) != size {
+		return "", nil, fmt.Errorf("pack object size mismatch")
+	}
+	return name, data, nil
+}
+
+func applyGitDelta(base, delta []byte) ([]byte, error) {
+	readSize := func() uint64 {
+		var size uint64
+		for shift := 0; len(delta) > 0; shift += 7 {
+			c := delta[0]
+			delta = delta[1:]
+			size |= uint64(c&0x7f) << shift
+			if c&0x80 == 0 {
+				break
+			}
+		}
+		return size
+	}
+
+	if readSize() != uint64(len(base)) {
+		return nil, fmt.Errorf("delta base size mismatch")
+	}
+	out := make([]byte, 0, readSize())
+
+	for len(delta) > 0 {
+		op := delta[0]
+		delta = delta[1:]
+		if op&0x80 != 0 {
+			var offset, size uint64
+			for i := 0; i < 4; i++ {
+				if op&(1<<i) != 0 {
+					if len(delta) == 0 {
+						return nil, fmt.Errorf("truncated delta")
+					}
+					offset |= uint64(delta[0]) << (8 * i)
+					delta = delta[1:]
+				}
+			}
+			for i := 0; i < 3; i++ {
+				if op&(0x10<<i) != 0 {
+					if len(delta) == 0 {
+						return nil, fmt.Errorf("truncated delta")
+					}
+					size |= uint64(delta[0]) << (8 * i)
+					delta = delta[1:]
+				}
+			}
+			if size == 0 {
+				size = 0x10000
+			}
+			if offset+size > uint64(len(base)) {
+				return nil, fmt.Errorf("delta copy out of range")
+			}
+			out = append(out, base[offset:offset+size]...)
+		} else if op != 0 {
+			if int(op) > len(delta) {
+				return nil, fmt.Errorf("truncated delta")
+			}
+			out = append(out, delta[:op]...)
+			delta = delta[op:]
+		} else {
+			return nil, fmt.Errorf("invalid delta opcode")
+		}
+	}
+	return out, nil
+}
+
+// modeIsSymlink reports whether an index mode describes a symbolic link.
+func modeIsSymlink(mode uint32) bool {
+	return mode&0170000 == 0120000
+}
+
+// modeIsGitlink reports whether an index mode describes a submodule.
+func modeIsGitlink(mode uint32) bool {
+	return mode&0170000 == 0160000
+}
@@ -0,0 +1,142 @@
+package editor
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// initTestRepo creates a throwaway git repository with the given files
+// committed. Tests are skipped when git is not installed.
+func initTestRepo(t *testing.T, files map[string]string) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+
+	dir := t.TempDir()
+	runGit(t, dir, "init", "-q")
+	runGit(t, dir, "config", "user.name", "Test")
+	runGit(t, dir, "config", "user.email", "test@example.com")
+	for name, content := range files {
+		writeTestFile(t, dir, name, content)
+	}
+	runGit(t, dir, "add", "-A")
+	runGit(t, dir, "commit", "-q", "-m", "initial")
+	return dir
+}
+
+func runGit(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %v: %v\n%s", args, err, out)
+	}
+	return string(out)
+}
+
+func writeTestFile(t *testing.T, dir, name, content string) {
+	t.Helper()
+	path := filepath.Join(dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGitStatus(t *testing.T) {
+	dir := initTestRepo(t, map[string]string{
+		"main.go":        "package main\n",
+		"pkg/clean.go":   "package pkg\n",
+		"pkg/change.go":  "package pkg\n",
+		".gitignore":     "*.log\nbuild/\n",
+		"docs/readme.md": "# docs\n",
+	})
+
+	writeTestFile(t, dir, "pkg/change.go", "package pkg\n\nvar x = 1\n")
+	writeTestFile(t, dir, "new.go", "package main\n")
+	writeTestFile(t, dir, "staged.go", "package main\n")
+	runGit(t, dir, "add", "staged.go")
+	writeTestFile(t, dir, "debug.log", "noise\n")
+	writeTestFile(t, dir, "build/out.bin", "binary\n")
+
+	repo := findGitRepo(filepath.Join(dir, "pkg"))
+	if repo == nil {
+		t.Fatal("repository not found")
+	}
+	snap, err := repo.computeStatus()
+	if err != nil {
+		t.Fatalf("computeStatus: %v", err)
+	}
+	ignore := newIgnoreMatcher(repo)
+
+	tests := []struct {
+		path  string
+		isDir bool
+		want  gitStatus
+	}{
+		{"main.go", false, gitStatusNone},
+		{"pkg/clean.go", false, gitStatusNone},
+		{"pkg/change.go", false, gitStatusModified},
+		{"pkg", true, gitStatusModified},
+		{"docs", true, gitStatusNone},
+		{"staged.go", false, gitStatusAdded},
+		{"new.go", false, gitStatusUntracked},
+		{"debug.log", false, gitStatusNone},
+		{"build", true, gitStatusNone},
+	}
+	for _, tt := range tests {
+		ignored := ignore.isIgnored(tt.path, tt.isDir)
+		if got := snap.statusOf(tt.path, tt.isDir, ignored); got != tt.want {
+			t.Errorf("statusOf(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+
+	if !ignore.isIgnored("debug.log", false) || !ignore.isIgnored("build/out.bin", false) {
+		t.Error("expected ignored files to match .gitignore")
+	}
+
+	// Packed objects must read the same as loose ones
+	runGit(t, dir, "gc", "-q")
+	repo = findGitRepo(dir)
+	files, err := repo.headTree()
+	if err != nil {
+		t.Fatalf("headTree after gc: %v", err)
+	}
+	if _, ok := files["pkg/clean.go"]; !ok {
+		t.Errorf("pkg/clean.go missing from packed HEAD tree: %v", files)
+	}
+}
+
+func TestIgnorePatterns(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		isDir   bool
+		want    bool
+	}{
+		{"*.o", "a/b/c.o", false, true},
+		{"/root.txt", "root.txt", false, true},
+		{"/root.txt", "sub/root.txt", false, false},
+		{"doc/*.md", "doc/a.md", false, true},
+		{"doc/*.md", "doc/x/a.md", false, false},
+		{"**/logs", "a/b/logs", true, true},
+		{"a/**/z", "a/b/c/z", false, true},
+		{"out/", "out", false, false},
+		{"out/", "out", true, true},
+	}
+	for _, tt := range tests {
+		rule, ok := parseIgnoreLine(tt.pattern, "")
+		if !ok {
+			t.Fatalf("pattern %q not parsed", tt.pattern)
+		}
+		if got := rule.match(tt.path, tt.isDir); got != tt.want {
+			t.Errorf("%q match %q = %v, want %v", tt.pattern, tt.path, got, tt.want)
+		}
+	}
+}
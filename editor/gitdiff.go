@@ -0,0 +1,355 @@
+package editor
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Git diff signs.
+//
+// The sign column marks the lines added (+), changed (~) and removed (_ on
+// the line above) since the file's version in HEAD, which is read straight
+// from the repository. The buffer is diffed again in the background after
+// it changes. ]c and [c jump between the hunks, :previewhunk shows the
+// lines a hunk replaced, :reverthunk puts them back and :stagehunk adds
+// the hunk to the index.
+
+// gitDiff compares a buffer with its file in HEAD.
+type gitDiff struct {
+	repo    *gitRepo
+	path    string   // slash separated, relative to the repository root
+	base    []string // the file in HEAD
+	noEOL   bool     // HEAD's file doesn't end with a newline
+	lines   []string // the buffer as hunks were worked out for
+	hunks   []diffHunk
+	diffed  bool
+	running bool
+}
+
+// loadGitDiff reads the current file's version in HEAD. Files git doesn't
+// track get no signs; a file added since HEAD is all new lines.
+func (e *Editor) loadGitDiff() {
+	e.gitDiff = nil
+	if e.filename == "" || !e.boolOption("gitSigns") {
+		return
+	}
+	abs, _ := filepath.Abs(e.filename)
+	repo := findGitRepo(filepath.Dir(abs))
+	if repo == nil {
+		return
+	}
+	rel, ok := repo.relPath(abs)
+	if !ok {
+		return
+	}
+	data, err := repo.fileAt("HEAD", rel)
+	if errors.Is(err, os.ErrNotExist) {
+		entries, _ := repo.readIndex()
+		if !slices.ContainsFunc(entries, func(entry gitIndexEntry) bool { return entry.path == rel }) {
+			return
+		}
+		data, err = nil, nil
+	}
+	if err != nil {
+		return
+	}
+	e.gitDiff = &gitDiff{
+		repo:  repo,
+		path:  rel,
+		base:  e.blobLines(data),
+		noEOL: len(data) > 0 && data[len(data)-1] != '\n',
+	}
+}
+
+// blobLines splits a file from the repository into lines the way files are
+// loaded.
+func (e *Editor) blobLines(data []byte) []string {
+	lines := splitBlob(data)
+	if e.option("charset") == "latin1" {
+		for i, line := range lines {
+			lines[i] = latin1ToUTF8(line)
+		}
+	}
+	return lines
+}
+
+// splitBlob splits a file from the repository into lines, whatever their
+// line endings.
+func splitBlob(data []byte) []string {
+	text := strings.TrimPrefix(string(data), utf8BOM)
+	if text == "" {
+		return nil
+	}
+	lines := strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSuffix(line, "\r")
+	}
+	return lines
+}
+
+// syncGitDiff diffs the buffer again in the background if it changed
+// since the last time. It's called before each redraw.
+func (e *Editor) syncGitDiff() {
+	d := e.gitDiff
+	if d == nil || d.running || (d.diffed && slices.Equal(d.lines, e.lines)) {
+		return
+	}
+	if e.mainQueue == nil {
+		e.mainQueue = make(chan func(), 256)
+	}
+	lines := slices.Clone(e.lines)
+	d.running = true
+	go func() {
+		hunks := diffLines(d.base, lines)
+		e.post(func() {
+			d.lines, d.hunks, d.diffed, d.running = lines, hunks, true, false
+		})
+	}()
+}
+
+// gitHunks is the hunks for the buffer as it is now, worked out here if the
+// background diff is behind.
+func (e *Editor) gitHunks() []diffHunk {
+	d := e.gitDiff
+	if d == nil {
+		return nil
+	}
+	if !d.diffed || !slices.Equal(d.lines, e.lines) {
+		d.lines = slices.Clone(e.lines)
+		d.hunks, d.diffed = diffLines(d.base, d.lines), true
+	}
+	return d.hunks
+}
+
+// hunkLine is the line a hunk's sign is on: its first line, or the line
+// above lines that were removed.
+func hunkLine(h diffHunk) int {
+	if h.newCount == 0 {
+		return max(h.newStart-1, 0)
+	}
+	return h.newStart
+}
+
+func (e *Editor) gitSigns() map[int]gutterSign {
+	if e.gitDiff == nil {
+		return nil
+	}
+	signs := map[int]gutterSign{}
+	for _, h := range e.gitDiff.hunks {
+		switch {
+		case h.newCount == 0 && h.newStart == 0:
+			signs[0] = gutterSign{text: "‾", style: "sign.removed"}
+		case h.newCount == 0:
+			signs[h.newStart-1] = gutterSign{text: "_", style: "sign.removed"}
+		case h.oldCount == 0:
+			for y := h.newStart; y < h.newStart+h.newCount; y++ {
+				signs[y] = gutterSign{text: "+", style: "sign.added"}
+			}
+		default:
+			// Lines past the old ones are new, and fewer lines than
+			// before end in a removal
+			for i := range h.newCount {
+				sign := gutterSign{text: "~", style: "sign.changed"}
+				if i >= h.oldCount {
+					sign = gutterSign{text: "+", style: "sign.added"}
+				} else if i == h.newCount-1 && h.newCount < h.oldCount {
+					sign.text = "~_"
+				}
+				signs[h.newStart+i] = sign
+			}
+		}
+	}
+	return signs
+}
+
+// jumpToHunk moves the cursor to the next hunk below it, or above it when
+// dir is negative (]c and [c).
+func (e *Editor) jumpToHunk(dir int) {
+	hunks := e.gitHunks()
+	if len(hunks) == 0 {
+		e.setStatusMessage("No changes")
+		return
+	}
+	target := -1
+	for _, h := range hunks {
+		y := hunkLine(h)
+		if dir > 0 && y > e.cursorY {
+			target = y
+			break
+		}
+		if dir < 0 && y < e.cursorY {
+			target = y
+		}
+	}
+	if target < 0 {
+		e.setStatusMessage("No more hunks")
+		return
+	}
+	e.cursorY, e.cursorX = target, 0
+}
+
+// currentHunk is the hunk on the cursor line.
+func (e *Editor) currentHunk() (diffHunk, bool) {
+	for _, h := range e.gitHunks() {
+		if e.cursorY == hunkLine(h) || (e.cursorY >= h.newStart && e.cursorY < h.newStart+h.newCount) {
+			return h, true
+		}
+	}
+	e.setStatusMessage("No hunk here")
+	return diffHunk{}, false
+}
+
+// previewHunk shows the lines the hunk at the cursor replaced and the ones
+// it has now.
+func (e *Editor) previewHunk() {
+	h, ok := e.currentHunk()
+	if !ok {
+		return
+	}
+	var lines []string
+	for _, line := range e.gitDiff.base[h.oldStart : h.oldStart+h.oldCount] {
+		lines = append(lines, "-"+line)
+	}
+	for _, line := range e.lines[h.newStart : h.newStart+h.newCount] {
+		lines = append(lines, "+"+line)
+	}
+	e.showPopup(fmt.Sprintf("@@ -%s +%s @@", hunkRange(h.oldStart, h.oldCount), hunkRange(h.newStart, h.newCount)), lines)
+}
+
+// revertHunk puts back the lines in HEAD in place of the hunk at the
+// cursor.
+func (e *Editor) revertHunk() {
+	if !e.editable() {
+		return
+	}
+	h, ok := e.currentHunk()
+	if !ok {
+		return
+	}
+	e.addUndo(Action{
+		Type:    "revert",
+		action:  "revert",
+		lines:   append([]string{}, e.lines...),
+		cursorX: e.cursorX,
+		cursorY: e.cursorY,
+	})
+	old := e.gitDiff.base[h.oldStart : h.oldStart+h.oldCount]
+	e.lines = slices.Concat(e.lines[:h.newStart], old, e.lines[h.newStart+h.newCount:])
+	if len(e.lines) == 0 {
+		e.lines = []string{""}
+	}
+	e.cursorY, e.cursorX = min(h.newStart, len(e.lines)-1), 0
+	e.markChanged()
+}
+
+// stageHunk adds the hunk at the cursor to the index with git apply. The
+// patch is made against the index, which may differ from HEAD once other
+// hunks are staged, and has context lines so git checks where it goes.
+func (e *Editor) stageHunk() {
+	h, ok := e.currentHunk()
+	if !ok {
+		return
+	}
+	d := e.gitDiff
+	index, noEOL, err := e.indexVersion(d)
+	if err != nil {
+		e.setStatusMessage(fmt.Sprintf("Staging failed: %v", err))
+		return
+	}
+
+	// What the buffer changes from the index where the hunk is
+	var parts []diffHunk
+	for _, p := range diffLines(index, e.lines) {
+		if p.newStart <= h.newStart+h.newCount && h.newStart <= p.newStart+p.newCount {
+			parts = append(parts, p)
+		}
+	}
+	if len(parts) == 0 {
+		e.setStatusMessage("Hunk is staged already")
+		return
+	}
+
+	const context = 3
+	first, last := parts[0], &parts[len(parts)-1]
+	start := max(first.oldStart-context, 0)
+	end := min(last.oldStart+last.oldCount+context, len(index))
+	if noEOL && end == len(index) && len(index) > 0 {
+		// The index's last line gains a newline, so it can't be context
+		if last.oldCount == 0 && last.oldStart == len(index) {
+			last.oldStart, last.newStart = last.oldStart-1, last.newStart-1
+			last.oldCount, last.newCount = 1, last.newCount+1
+		}
+		tail := end - (last.oldStart + last.oldCount)
+		last.oldCount += tail
+		last.newCount += tail
+	}
+	oldCount, newCount := end-start, end-start
+	for _, p := range parts {
+		newCount += p.newCount - p.oldCount
+	}
+
+	eol := map[string]string{"lf": "\n", "crlf": "\r\n", "cr": "\r"}[e.option("endOfLine")]
+	var patch strings.Builder
+	fmt.Fprintf(&patch, "diff --git a/%s b/%s\n--- a/%s\n+++ b/%s\n", d.path, d.path, d.path, d.path)
+	fmt.Fprintf(&patch, "@@ -%s +%s @@\n", hunkRange(start, oldCount), hunkRange(start, newCount))
+	y := start
+	for _, p := range parts {
+		for ; y < p.oldStart; y++ {
+			patch.WriteString(" " + index[y] + eol)
+		}
+		for _, line := range index[p.oldStart : p.oldStart+p.oldCount] {
+			patch.WriteString("-" + line + eol)
+		}
+		if noEOL && p.oldCount > 0 && p.oldStart+p.oldCount == len(index) {
+			patch.WriteString("\\ No newline at end of file\n")
+		}
+		for _, line := range e.lines[p.newStart : p.newStart+p.newCount] {
+			patch.WriteString("+" + line + eol)
+		}
+		y = p.oldStart + p.oldCount
+	}
+	for ; y < end; y++ {
+		patch.WriteString(" " + index[y] + eol)
+	}
+
+	cmd := exec.Command("git", "apply", "--cached", "-")
+	cmd.Dir = d.repo.root
+	cmd.Stdin = strings.NewReader(patch.String())
+	if out, err := cmd.CombinedOutput(); err != nil {
+		e.setStatusMessage(fmt.Sprintf("Staging failed: %s", strings.TrimSpace(string(out))))
+		return
+	}
+	e.setStatusMessage(fmt.Sprintf("Staged %d lines", max(h.oldCount, h.newCount)))
+	e.refreshGitStatus()
+}
+
+// indexVersion is the file's lines as they are in the index.
+func (e *Editor) indexVersion(d *gitDiff) (lines []string, noEOL bool, err error) {
+	entries, err := d.repo.readIndex()
+	if err != nil {
+		return nil, false, err
+	}
+	i := slices.IndexFunc(entries, func(entry gitIndexEntry) bool { return entry.path == d.path && entry.stage == 0 })
+	if i < 0 {
+		return nil, false, fmt.Errorf("%s is not in the index", d.path)
+	}
+	_, data, err := d.repo.readObject(entries[i].hash)
+	if err != nil {
+		return nil, false, err
+	}
+	return e.blobLines(data), len(data) > 0 && data[len(data)-1] != '\n', nil
+}
+
+// hunkRange is a side of a unified diff hunk header. An empty side gives
+// the line before it.
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
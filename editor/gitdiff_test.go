@@ -0,0 +1,111 @@
+package editor
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestGitSigns(t *testing.T) {
+	dir := initTestRepo(t, map[string]string{"notes.txt": "a\nb\nc\nd\ne\n"})
+	ed := &Editor{mode: "normal"}
+	ed.applySettings()
+	path := filepath.Join(dir, "notes.txt")
+	ed.SetFilename(path)
+	if err := ed.LoadFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if ed.gitDiff == nil || len(ed.gitDiff.base) != 5 {
+		t.Fatalf("HEAD version not loaded: %+v", ed.gitDiff)
+	}
+
+	// The background diff posts its hunks back to the editor
+	ed.lines = []string{"a", "B", "d", "x", "e"}
+	ed.syncGitDiff()
+	(<-ed.mainQueue)()
+	signs := ed.gitSigns()
+	if got := fmt.Sprintf("%s %s %d", signs[1].text, signs[3].text, len(signs)); got != "~_ + 2" {
+		t.Errorf("signs %v", signs)
+	}
+
+	var stops []int
+	for _, dir := range []int{1, 1, 1, -1} {
+		ed.jumpToHunk(dir)
+		stops = append(stops, ed.cursorY)
+	}
+	if want := []int{1, 3, 3, 1}; !slices.Equal(stops, want) {
+		t.Errorf("]c ]c ]c [c stopped at %v, want %v", stops, want)
+	}
+
+	ed.previewHunk()
+	if ed.popup == nil || !slices.Equal(ed.popup.lines, []string{"-b", "-c", "+B"}) {
+		t.Errorf("preview %+v", ed.popup)
+	}
+
+	// Staging adds only that hunk to the index
+	ed.cursorY = 3
+	ed.stageHunk()
+	if staged := runGit(t, dir, "diff", "--cached"); !strings.Contains(staged, "+x") || strings.Contains(staged, "+B") {
+		t.Errorf("staged:\n%s", staged)
+	}
+
+	ed.cursorY = 1
+	ed.revertHunk()
+	if want := []string{"a", "b", "c", "d", "x", "e"}; !slices.Equal(ed.lines, want) {
+		t.Errorf("reverted to %q", ed.lines)
+	}
+	if hunks := ed.gitHunks(); len(hunks) != 1 {
+		t.Errorf("hunks after revert: %v", hunks)
+	}
+	ed.undo()
+	if ed.lines[1] != "B" {
+		t.Errorf("undo left %q", ed.lines)
+	}
+}
+
+func TestStageHunks(t *testing.T) {
+	tests := []struct {
+		name, head string
+		lines      []string
+		cursors    []int
+		want       string
+	}{
+		{
+			"after an earlier hunk", "a\nb\nc\nd\ne\n",
+			[]string{"x1", "x2", "a", "b", "c", "d", "y", "e"}, []int{0, 6},
+			"x1\nx2\na\nb\nc\nd\ny\ne\n",
+		},
+		{
+			"later hunk first", "a\nb\nc\nd\ne\nf\ng\nh\n",
+			[]string{"A", "b", "c", "d", "e", "f", "g", "h", "i"}, []int{8, 0},
+			"A\nb\nc\nd\ne\nf\ng\nh\ni\n",
+		},
+		{
+			"no newline at the end", "a\nb",
+			[]string{"x", "a", "b", "c"}, []int{0, 3},
+			"x\na\nb\nc\n",
+		},
+	}
+	for _, test := range tests {
+		dir := initTestRepo(t, map[string]string{"notes.txt": test.head})
+		ed := &Editor{mode: "normal"}
+		ed.applySettings()
+		path := filepath.Join(dir, "notes.txt")
+		if err := ed.openFile(path); err != nil {
+			t.Fatal(err)
+		}
+		ed.lines = test.lines
+		for i, y := range test.cursors {
+			ed.cursorY = y
+			ed.stageHunk()
+			if !strings.HasPrefix(ed.statusMessage, "Staged") {
+				t.Errorf("%s: staging hunk %d: %s", test.name, i+1, ed.statusMessage)
+			}
+		}
+		if got := runGit(t, dir, "show", ":notes.txt"); got != test.want {
+			t.Errorf("%s: index has %q, want %q", test.name, got, test.want)
+		}
+	}
+}
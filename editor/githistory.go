@@ -0,0 +1,404 @@
+package editor
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Blame and file history.
+//
+// :blame shows who last changed each line in a panel left of the text,
+// drawn with the lines so the two scroll together. :log lists the commits
+// that changed the file in a read-only buffer, and Enter on one opens the
+// file as it was in that commit, read-only too. Both follow first parents
+// from HEAD and read the repository in the background.
+
+// gitCommit is what blame and log show of a commit.
+type gitCommit struct {
+	hash    string
+	parents []string
+	author  string
+	time    time.Time
+	summary string // first line of the message
+}
+
+func (c *gitCommit) short() string {
+	return c.hash[:min(7, len(c.hash))]
+}
+
+// commitInfo reads a commit's author, date and summary.
+func (r *gitRepo) commitInfo(hash string) (*gitCommit, error) {
+	_, parents, body, err := r.readCommit(hash)
+	if err != nil {
+		return nil, err
+	}
+	c := &gitCommit{hash: hash, parents: parents}
+	headers, message, _ := strings.Cut(body, "\n\n")
+	for _, line := range strings.Split(headers, "\n") {
+		if signature, ok := strings.CutPrefix(line, "author "); ok {
+			c.author, c.time = parseSignature(signature)
+		}
+	}
+	c.summary, _, _ = strings.Cut(strings.TrimSpace(message), "\n")
+	return c, nil
+}
+
+// parseSignature splits "Name <email> 1700000000 +0100" into the name and
+// the time.
+func parseSignature(s string) (string, time.Time) {
+	name, rest, ok := strings.Cut(s, " <")
+	if !ok {
+		return strings.TrimSpace(s), time.Time{}
+	}
+	_, stamp, _ := strings.Cut(rest, "> ")
+	fields := strings.Fields(stamp)
+	if len(fields) != 2 {
+		return name, time.Time{}
+	}
+	secs, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return name, time.Time{}
+	}
+	offset := 0
+	if zone := fields[1]; len(zone) == 5 {
+		hours, _ := strconv.Atoi(zone[1:3])
+		minutes, _ := strconv.Atoi(zone[3:])
+		offset = hours*3600 + minutes*60
+		if zone[0] == '-' {
+			offset = -offset
+		}
+	}
+	return name, time.Unix(secs, 0).In(time.FixedZone(fields[1], offset))
+}
+
+// fileHistory calls visit with each commit that changed path rel, newest
+// first, and the file's object name in it, until visit returns false. A
+// commit changed the file when its first parent has a different version
+// or none.
+func (r *gitRepo) fileHistory(rel string, visit func(c *gitCommit, blob string) bool) error {
+	head, err := r.resolveRef("HEAD")
+	if err != nil {
+		return err
+	}
+	if head == "" {
+		return os.ErrNotExist
+	}
+	c, err := r.commitInfo(head)
+	if err != nil {
+		return err
+	}
+	blob, err := r.pathHash(head, rel)
+	if err != nil {
+		return err
+	}
+	for {
+		var parent *gitCommit
+		parentBlob := ""
+		if len(c.parents) > 0 {
+			if parent, err = r.commitInfo(c.parents[0]); err != nil {
+				return err
+			}
+			parentBlob, err = r.pathHash(parent.hash, rel)
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+		if parentBlob != blob && (!visit(c, blob) || parentBlob == "") {
+			return nil
+		}
+		c, blob = parent, parentBlob
+	}
+}
+
+// blame is the file rel in HEAD and the commit that last changed each of
+// its lines.
+func (r *gitRepo) blame(rel string) (lines []string, owners []*gitCommit, err error) {
+	var (
+		at      []int    // each HEAD line's line in version, or -1 once owned
+		version []string // the file as of newer
+		newer   *gitCommit
+		left    int
+	)
+	err = r.fileHistory(rel, func(c *gitCommit, blob string) bool {
+		_, data, err := r.readObject(blob)
+		if err != nil {
+			return false
+		}
+		text := splitBlob(data)
+		if newer == nil {
+			lines, owners = text, make([]*gitCommit, len(text))
+			at = make([]int, len(text))
+			for i := range at {
+				at[i] = i
+			}
+			version, newer, left = text, c, len(text)
+			return left > 0
+		}
+		// Lines that aren't in this older version came with the newer one
+		older := matchLines(text, version, diffLines(text, version))
+		for i, y := range at {
+			if y < 0 {
+				continue
+			}
+			if at[i] = older[y]; at[i] < 0 {
+				owners[i] = newer
+				left--
+			}
+		}
+		version, newer = text, c
+		return left > 0
+	})
+	// The rest has been there since the oldest version
+	for i, y := range at {
+		if y >= 0 {
+			owners[i] = newer
+		}
+	}
+	return lines, owners, err
+}
+
+// repoFile is the current file's repository and its path in it. History
+// buffers stand for the file they show the history of.
+func (e *Editor) repoFile() (repo *gitRepo, abs, rel string, ok bool) {
+	if e.filename == "" {
+		return nil, "", "", false
+	}
+	abs, _ = filepath.Abs(e.filename)
+	if e.gitView != nil {
+		abs = e.gitView.file
+	}
+	if repo = findGitRepo(filepath.Dir(abs)); repo == nil {
+		return nil, "", "", false
+	}
+	rel, ok = repo.relPath(abs)
+	return repo, abs, rel, ok
+}
+
+// blameView is the :blame panel of a buffer.
+type blameView struct {
+	head   []string     // the file in HEAD
+	owners []*gitCommit // the commit that last changed each line of head, nil until worked out
+	lines  []string     // the buffer as rows was worked out for
+	rows   []int        // each buffer line's line in head, or -1 if it's new
+}
+
+const blameWidth = 40
+
+// toggleBlame is :blame, which opens or closes the panel.
+func (e *Editor) toggleBlame() {
+	if e.blame != nil {
+		e.blame = nil
+		return
+	}
+	_, abs, rel, ok := e.repoFile()
+	if !ok {
+		e.setStatusMessage("Not a file in a git repository")
+		return
+	}
+	view := &blameView{}
+	e.blame = view
+	e.setStatusMessage("Blaming " + rel + "...")
+	if e.mainQueue == nil {
+		e.mainQueue = make(chan func(), 256)
+	}
+	go func() {
+		// A repository of its own, as it caches packs
+		var (
+			head   []string
+			owners []*gitCommit
+			err    = os.ErrNotExist
+		)
+		if repo := findGitRepo(filepath.Dir(abs)); repo != nil {
+			head, owners, err = repo.blame(rel)
+		}
+		e.post(func() {
+			if err != nil {
+				if e.blame == view {
+					e.blame = nil
+				}
+				if errors.Is(err, os.ErrNotExist) {
+					e.setStatusMessage(rel + " is not committed")
+				} else {
+					e.setStatusMessage(fmt.Sprintf("Blame failed: %v", err))
+				}
+				return
+			}
+			view.head, view.owners = head, owners
+			e.setStatusMessage("")
+		})
+	}()
+}
+
+// blamePanelWidth is the columns the panel takes, its separator included.
+func (e *Editor) blamePanelWidth() int {
+	if e.blame == nil {
+		return 0
+	}
+	return blameWidth + 1
+}
+
+// syncBlame matches the buffer's lines with HEAD's again if it changed.
+func (e *Editor) syncBlame() {
+	view := e.blame
+	if view == nil || view.owners == nil || (view.rows != nil && slices.Equal(view.lines, e.lines)) {
+		return
+	}
+	view.lines = slices.Clone(e.lines)
+	view.rows = matchLines(view.head, view.lines, diffLines(view.head, view.lines))
+}
+
+// blameText is the panel's text for line y.
+func (e *Editor) blameText(y int) string {
+	view := e.blame
+	if view.owners == nil || y >= len(view.rows) {
+		return ""
+	}
+	line := view.rows[y]
+	if line < 0 {
+		return "Not committed yet"
+	}
+	c := view.owners[line]
+	return fmt.Sprintf("%s %s %-10s %s", c.short(), c.time.Format("2006-01-02"), truncateText(c.author, 10), c.summary)
+}
+
+// drawBlame draws the panel's row sy, for the first row of line y or with
+// y -1 for the rows after.
+func (e *Editor) drawBlame(y, sy int) {
+	left, _ := e.textArea()
+	x := left - e.gutterWidth() - e.blamePanelWidth()
+	style := e.uiStyle("blame")
+	for i := range blameWidth {
+		e.screen.SetContent(x+i, sy, ' ', nil, style)
+	}
+	e.screen.SetContent(x+blameWidth, sy, '│', nil, e.uiStyle("tree.separator"))
+	if y >= 0 {
+		drawText(e.screen, x, sy, style, truncateText(e.blameText(y), blameWidth))
+	}
+}
+
+// truncateText cuts text to width columns.
+func truncateText(text string, width int) string {
+	if stringWidth(text) <= width {
+		return text
+	}
+	var b strings.Builder
+	used := 0
+	for text != "" {
+		var cluster string
+		cluster, text = firstGrapheme(text)
+		if used += clusterWidth(cluster); used > width {
+			break
+		}
+		b.WriteString(cluster)
+	}
+	return b.String()
+}
+
+// gitView is what a read-only history buffer shows.
+type gitView struct {
+	file    string       // absolute path of the file
+	path    string       // the file relative to the repository root
+	commits []*gitCommit // a log's commits, one a line
+	commit  *gitCommit   // the commit a revision is from
+}
+
+// showLog is :log, which lists the commits that changed the current file.
+func (e *Editor) showLog() {
+	_, abs, rel, ok := e.repoFile()
+	if !ok {
+		e.setStatusMessage("Not a file in a git repository")
+		return
+	}
+	e.setStatusMessage("Reading the history of " + rel + "...")
+	if e.mainQueue == nil {
+		e.mainQueue = make(chan func(), 256)
+	}
+	go func() {
+		var commits []*gitCommit
+		err := os.ErrNotExist
+		if repo := findGitRepo(filepath.Dir(abs)); repo != nil {
+			err = repo.fileHistory(rel, func(c *gitCommit, _ string) bool {
+				commits = append(commits, c)
+				return true
+			})
+		}
+		e.post(func() {
+			if err != nil {
+				if errors.Is(err, os.ErrNotExist) {
+					e.setStatusMessage(rel + " is not committed")
+				} else {
+					e.setStatusMessage(fmt.Sprintf("Log failed: %v", err))
+				}
+				return
+			}
+			lines := make([]string, len(commits))
+			for i, c := range commits {
+				lines[i] = fmt.Sprintf("%s %s %-16s %s", c.short(), c.time.Format("2006-01-02"), truncateText(c.author, 16), c.summary)
+			}
+			e.openView("[log] "+rel, lines, &gitView{file: abs, path: rel, commits: commits}, "")
+			e.setStatusMessage(fmt.Sprintf("%d commits, Enter opens the file as of one", len(commits)))
+		})
+	}()
+}
+
+// openLogEntry opens the file as of the commit on the cursor line of a
+// log.
+func (e *Editor) openLogEntry() {
+	view := e.gitView
+	if e.cursorY >= len(view.commits) {
+		return
+	}
+	c := view.commits[e.cursorY]
+	repo := findGitRepo(filepath.Dir(view.file))
+	if repo == nil {
+		e.setStatusMessage("Not a file in a git repository")
+		return
+	}
+	data, err := repo.fileAt(c.hash, view.path)
+	if err != nil {
+		e.setStatusMessage(fmt.Sprintf("Can't read %s at %s: %v", view.path, c.short(), err))
+		return
+	}
+	lines := e.blobLines(data)
+	fileType := LexerFor(view.file, lines).Config().Name
+	e.openView(view.path+"@"+c.short(), lines, &gitView{file: view.file, path: view.path, commit: c}, fileType)
+	e.setStatusMessage(fmt.Sprintf("%s %s", c.short(), c.summary))
+}
+
+// openView opens a read-only buffer named name, or switches to it if it's
+// open already. view is nil for one that isn't from git.
+func (e *Editor) openView(name string, lines []string, view *gitView, fileType string) {
+	if i := e.findBuffer(name); i >= 0 {
+		e.switchBuffer(i)
+		return
+	}
+	e.stashBuffer()
+	e.buffers = append(e.buffers, &Buffer{})
+	e.bufferIndex = len(e.buffers) - 1
+
+	e.SetFilename(name)
+	e.fileType = fileType
+	e.lines = lines
+	if len(e.lines) == 0 {
+		e.lines = []string{""}
+	}
+	e.newVersion()
+	e.gitView, e.readOnly, e.swap = view, true, nil
+	e.gitDiff, e.blame = nil, nil
+	e.cursorX, e.cursorY, e.scrollY = 0, 0, 0
+	e.scrollRow, e.scrollX = 0, 0
+	e.isDirty = false
+	e.autoSaved = time.Time{}
+	e.undoStack, e.redoStack = nil, nil
+	e.searchMatches = nil
+	e.completionActive = false
+	e.loadFileSettings()
+	e.resetFolds()
+	e.stashBuffer()
+}
@@ -0,0 +1,86 @@
+package editor
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestGitHistory(t *testing.T) {
+	dir := initTestRepo(t, map[string]string{"src/app.go": "a\nb\nc\n"})
+	commit := func(name, author, date, message, content string) {
+		writeTestFile(t, dir, name, content)
+		runGit(t, dir, "add", "-A")
+		runGit(t, dir, "-c", "user.name="+author, "commit", "-q", "--date="+date, "-m", message)
+	}
+	commit("src/app.go", "Alice", "2024-03-01T10:00:00+0000", "Change b\n\nAnd add d.", "a\nB\nc\nd\n")
+	commit("other.txt", "Carol", "2024-03-02T10:00:00+0000", "Unrelated", "x\n")
+	commit("src/app.go", "Bob", "2024-03-03T10:00:00+0000", "Append e", "a\nB\nc\nd\ne\n")
+
+	repo := findGitRepo(dir)
+	lines, owners, err := repo.blame("src/app.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for i, c := range owners {
+		got = append(got, lines[i]+" "+c.author+" "+c.summary)
+	}
+	want := []string{"a Test initial", "B Alice Change b", "c Test initial", "d Alice Change b", "e Bob Append e"}
+	if !slices.Equal(got, want) {
+		t.Errorf("blame %q, want %q", got, want)
+	}
+	if owners[1].time.Format("2006-01-02 15:04") != "2024-03-01 10:00" {
+		t.Errorf("date %v", owners[1].time)
+	}
+
+	// The panel follows lines added since HEAD
+	ed := &Editor{mode: "normal"}
+	ed.applySettings()
+	path := filepath.Join(dir, "src", "app.go")
+	if err := ed.openFile(path); err != nil {
+		t.Fatal(err)
+	}
+	ed.toggleBlame()
+	(<-ed.mainQueue)()
+	ed.lines = slices.Insert(ed.lines, 1, "new")
+	ed.syncBlame()
+	if text := ed.blameText(1); text != "Not committed yet" {
+		t.Errorf("new line blamed on %q", text)
+	}
+	if text := ed.blameText(5); !strings.HasPrefix(text, owners[4].short()+" 2024-03-03 Bob") {
+		t.Errorf("last line blamed on %q", text)
+	}
+	if left, _ := ed.textArea(); left < blameWidth {
+		t.Errorf("text starts at %d with the panel open", left)
+	}
+
+	// The log skips commits that didn't touch the file
+	ed.showLog()
+	(<-ed.mainQueue)()
+	if len(ed.lines) != 3 || !strings.Contains(ed.lines[0], "Append e") || !strings.Contains(ed.lines[2], "initial") {
+		t.Fatalf("log %q", ed.lines)
+	}
+	ed.cursorY = 1
+	typeKeys(ed, "<CR>")
+	if want := []string{"a", "B", "c", "d"}; !slices.Equal(ed.lines, want) {
+		t.Errorf("revision has %q, want %q", ed.lines, want)
+	}
+	if !strings.HasPrefix(ed.filename, "src/app.go@") || ed.languageName() != "Go" {
+		t.Errorf("revision buffer %q in %s", ed.filename, ed.languageName())
+	}
+	typeKeys(ed, "i")
+	if ed.mode != "normal" || ed.saveFile() == nil {
+		t.Errorf("revision buffer is writable")
+	}
+	for _, keys := range []string{"gqq", ":reflow 1<CR>", ":> 2<CR>", ":replace a b<CR>", ":nmap Q gqj<CR>Q"} {
+		typeKeys(ed, keys)
+		if ed.isDirty || !slices.Equal(ed.lines, []string{"a", "B", "c", "d"}) || len(ed.undoStack) > 0 {
+			t.Errorf("%s changed the revision buffer to %q", keys, ed.lines)
+		}
+	}
+	if len(ed.buffers) != 3 {
+		t.Errorf("%d buffers open", len(ed.buffers))
+	}
+}
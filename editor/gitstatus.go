@@ -0,0 +1,175 @@
+package editor
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// Git status decorations for the file tree
+
+type gitStatus int
+
+// Ordered by priority: a directory shows the highest status of its children.
+const (
+	gitStatusNone gitStatus = iota
+	gitStatusUntracked
+	gitStatusAdded
+	gitStatusModified
+	gitStatusConflicted
+)
+
+type gitStatusSnapshot struct {
+	files   map[string]gitStatus // slash separated path relative to the repo root
+	dirs    map[string]gitStatus // rolled up status of directories
+	tracked map[string]bool      // directories containing at least one tracked file
+	indexed map[string]bool      // every path in the index
+}
+
+// computeStatus compares HEAD, the index and the working tree.
+func (r *gitRepo) computeStatus() (*gitStatusSnapshot, error) {
+	snap := &gitStatusSnapshot{
+		files:   make(map[string]gitStatus),
+		dirs:    make(map[string]gitStatus),
+		tracked: make(map[string]bool),
+		indexed: make(map[string]bool),
+	}
+
+	entries, err := r.readIndex()
+	if err != nil {
+		return snap, err
+	}
+	head, err := r.headTree()
+	if err != nil {
+		return snap, err
+	}
+
+	for _, entry := range entries {
+		snap.indexed[entry.path] = true
+		for dir := path.Dir(entry.path); dir != "."; dir = path.Dir(dir) {
+			snap.tracked[dir] = true
+		}
+		if modeIsGitlink(entry.mode) {
+			continue
+		}
+
+		status := gitStatusNone
+		if entry.stage != 0 {
+			status = gitStatusConflicted
+		} else if headHash, ok := head[entry.path]; !ok {
+			status = gitStatusAdded
+		} else if headHash != entry.hash || r.worktreeChanged(entry) {
+			status = gitStatusModified
+		}
+
+		if status > snap.files[entry.path] {
+			snap.files[entry.path] = status
+		}
+	}
+
+	// Files deleted from the index still mark their directory as changed
+	for file := range head {
+		if !snap.indexed[file] {
+			snap.files[file] = gitStatusModified
+		}
+	}
+
+	for file, status := range snap.files {
+		snap.rollUp(file, status)
+	}
+	return snap, nil
+}
+
+func (s *gitStatusSnapshot) rollUp(file string, status gitStatus) {
+	if status == gitStatusNone {
+		return
+	}
+	for dir := path.Dir(file); dir != "."; dir = path.Dir(dir) {
+		if s.dirs[dir] >= status {
+			return
+		}
+		s.dirs[dir] = status
+	}
+}
+
+// worktreeChanged reports whether the file on disk differs from the index.
+// Matching size and mtime are trusted the same way git trusts its stat cache.
+func (r *gitRepo) worktreeChanged(entry gitIndexEntry) bool {
+	filename := filepath.Join(r.root, filepath.FromSlash(entry.path))
+	info, err := os.Lstat(filename)
+	if err != nil {
+		return true
+	}
+	if uint32(info.Size()) == entry.size &&
+		uint32(info.ModTime().Unix()) == entry.mtimeSec &&
+		uint32(info.ModTime().Nanosecond()) == entry.mtimeNsec {
+		return false
+	}
+
+	var data []byte
+	if modeIsSymlink(entry.mode) {
+		target, err := os.Readlink(filename)
+		if err != nil {
+			return true
+		}
+		data = []byte(target)
+	} else {
+		if data, err = os.ReadFile(filename); err != nil {
+			return true
+		}
+	}
+	return gitBlobHash(data) != entry.hash
+}
+
+// statusOf returns the decoration for a path in the tree. Paths not known
+// to the index are untracked unless they are ignored.
+func (s *gitStatusSnapshot) statusOf(rel string, isDir, ignored bool) gitStatus {
+	if ignored || rel == "" {
+		return gitStatusNone
+	}
+	if isDir {
+		if status, ok := s.dirs[rel]; ok {
+			return status
+		}
+		if !s.tracked[rel] {
+			return gitStatusUntracked
+		}
+		return gitStatusNone
+	}
+	if status, ok := s.files[rel]; ok {
+		return status
+	}
+	if s.indexed[rel] {
+		return gitStatusNone
+	}
+	return gitStatusUntracked
+}
+
+func (s gitStatus) mark() string {
+	switch s {
+	case gitStatusUntracked:
+		return "?"
+	case gitStatusAdded:
+		return "A"
+	case gitStatusModified:
+		return "M"
+	case gitStatusConflicted:
+		return "U"
+	}
+	return ""
+}
+
+// themeKey is the theme element the status is drawn with.
+func (s gitStatus) themeKey() string {
+	switch s {
+	case gitStatusUntracked:
+		return "git.untracked"
+	case gitStatusAdded:
+		return "git.added"
+	case gitStatusModified:
+		return "git.modified"
+	case gitStatusConflicted:
+		return "git.conflicted"
+	}
+	return ""
+}
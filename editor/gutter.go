@@ -0,0 +1,253 @@
+package editor
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The gutter.
+//
+// Left of the text is a list of columns describing each line: fold markers,
+// signs and line numbers. Each column works out its own width, 0 to hide
+// it, and textArea starts the text after them. The sign column shows one
+// sign a line, from the first of signSources with one there: breakpoints
+// first, then diagnostics, marks and git changes.
+//
+// screenPosition and screenLine translate between buffer and screen
+// positions; everything that places something on screen or reads a mouse
+// click goes through them and textArea.
+
+type gutterColumn struct {
+	name  string
+	width func(e *Editor) int
+	// draw fills the column on the first row of line y
+	draw func(e *Editor, y, x, sy, width int)
+}
+
+var gutterColumns = []gutterColumn{
+	{"fold", (*Editor).foldColumnWidth, (*Editor).drawFoldColumn},
+	{"sign", (*Editor).signColumnWidth, (*Editor).drawSignColumn},
+	{"number", (*Editor).numberColumnWidth, (*Editor).drawNumberColumn},
+}
+
+// gutterSign is what the sign column shows for a line.
+type gutterSign struct {
+	text  string // one or two cells
+	style string // UI element
+}
+
+type signSource struct {
+	name  string
+	signs func(e *Editor) map[int]gutterSign
+}
+
+// Sources in order of precedence
+var signSources = []signSource{
+	{"breakpoint", (*Editor).breakpointSigns},
+	{"diagnostic", (*Editor).diagnosticSigns},
+	{"mark", (*Editor).markSigns},
+	{"git", (*Editor).gitSigns},
+}
+
+const signWidth = 2
+
+func parseSignColumn(value string) (string, error) {
+	switch value {
+	case "auto", "yes", "no":
+		return value, nil
+	}
+	return "", fmt.Errorf("expected auto, yes or no, got %q", value)
+}
+
+// gutterWidth is the number of columns of the gutter.
+func (e *Editor) gutterWidth() int {
+	width := 0
+	for _, column := range gutterColumns {
+		width += column.width(e)
+	}
+	return width
+}
+
+// gutterColumnAt is the gutter column at screen column x and the line
+// number drawn on screen row sy, for mouse clicks.
+func (e *Editor) gutterColumnAt(x, sy int) (name string, y int, ok bool) {
+	left, _ := e.textArea()
+	x -= left - e.gutterWidth()
+	if x < 0 {
+		return "", 0, false
+	}
+	y, _, ok = e.screenLine(sy, 0)
+	for _, column := range gutterColumns {
+		width := column.width(e)
+		if x < width {
+			return column.name, y, ok
+		}
+		x -= width
+	}
+	return "", 0, false
+}
+
+// clickGutter handles a click at x, sy in the gutter: on the fold column
+// it opens or closes the fold, on the sign column it toggles a breakpoint
+// and on a line number it moves the cursor to the line.
+func (e *Editor) clickGutter(x, sy int) {
+	name, y, ok := e.gutterColumnAt(x, sy)
+	if !ok {
+		return
+	}
+	switch name {
+	case "fold":
+		e.cursorY, e.cursorX = y, 0
+		e.foldCommand('a')
+	case "sign":
+		e.toggleBreakpoint(y)
+	case "number":
+		e.cursorY, e.cursorX = y, 0
+	}
+}
+
+// drawGutter draws the gutter of line y on screen row sy.
+func (e *Editor) drawGutter(y, sy int) {
+	x, _ := e.textArea()
+	x -= e.gutterWidth()
+	for _, column := range gutterColumns {
+		if width := column.width(e); width > 0 {
+			column.draw(e, y, x, sy, width)
+			x += width
+		}
+	}
+}
+
+// cacheFrame works out the closed folds and signs once for drawing a
+// frame, and clearFrame forgets them after.
+func (e *Editor) cacheFrame() {
+	var all []fold
+	if e.foldColumnWidth() > 0 {
+		all = e.allFolds()
+	}
+	folds, signs := e.closedRanges(), e.lineSigns()
+	e.cachedFolds, e.cachedAllFolds, e.cachedSigns = folds, all, signs
+	e.frameCached = true
+}
+
+func (e *Editor) clearFrame() {
+	e.cachedFolds, e.cachedAllFolds, e.cachedSigns = nil, nil, nil
+	e.frameCached = false
+}
+
+func (e *Editor) foldColumnWidth() int {
+	if e.boolOption("foldColumn") {
+		return 1
+	}
+	return 0
+}
+
+func (e *Editor) drawFoldColumn(y, x, sy, width int) {
+	folds := e.cachedAllFolds
+	if !e.frameCached {
+		folds = e.allFolds()
+	}
+	e.screen.SetContent(x, sy, e.foldMarker(y, folds), nil, e.uiStyle("foldcolumn"))
+}
+
+// lineSigns is the sign shown on each line that has one.
+func (e *Editor) lineSigns() map[int]gutterSign {
+	if e.frameCached {
+		return e.cachedSigns
+	}
+	e.syncLines()
+	var signs map[int]gutterSign
+	for _, source := range signSources {
+		for y, sign := range source.signs(e) {
+			if _, ok := signs[y]; ok {
+				continue
+			}
+			if signs == nil {
+				signs = map[int]gutterSign{}
+			}
+			signs[y] = sign
+		}
+	}
+	return signs
+}
+
+func (e *Editor) signColumnWidth() int {
+	switch e.option("signColumn") {
+	case "yes":
+		return signWidth
+	case "auto":
+		if len(e.lineSigns()) > 0 {
+			return signWidth
+		}
+	}
+	return 0
+}
+
+func (e *Editor) drawSignColumn(y, x, sy, width int) {
+	if sign, ok := e.lineSigns()[y]; ok {
+		drawText(e.screen, x, sy, e.uiStyle(sign.style), sign.text)
+	}
+}
+
+// diagnosticSigns marks lines with a diagnostic with the first letter of
+// the worst one's severity.
+func (e *Editor) diagnosticSigns() map[int]gutterSign {
+	diagnostics := e.currentDiagnostics()
+	signs := map[int]gutterSign{}
+	for i := range diagnostics {
+		y := diagnostics[i].Range.Start.Line
+		if _, ok := signs[y]; ok {
+			continue
+		}
+		d := lineDiagnostic(diagnostics, y)
+		name := severityNames[severityRank(d.Severity)]
+		signs[y] = gutterSign{text: strings.ToUpper(name[:1]), style: "sign." + name}
+	}
+	return signs
+}
+
+// numberColumnWidth fits the largest line number and a space, and is at
+// least numberWidth.
+func (e *Editor) numberColumnWidth() int {
+	if !e.showLineNumbers && !e.boolOption("relativeNumber") {
+		return 0
+	}
+	return max(e.intOption("numberWidth"), len(strconv.Itoa(len(e.lines)))+1)
+}
+
+// drawNumberColumn shows the line number, or with relativeNumber the
+// distance from the cursor line. With both the cursor line shows its
+// number, left-aligned.
+func (e *Editor) drawNumberColumn(y, x, sy, width int) {
+	style := "linenumber"
+	cursorLine := y == e.visibleLine(e.cursorY)
+	text := fmt.Sprintf("%*d ", width-1, y+1)
+	switch {
+	case cursorLine && e.showLineNumbers && e.boolOption("relativeNumber"):
+		text = fmt.Sprintf("%-*d ", width-1, y+1)
+	case e.boolOption("relativeNumber"):
+		text = fmt.Sprintf("%*d ", width-1, e.relativeLine(y))
+	}
+	if cursorLine {
+		style = "linenumber.current"
+	}
+	drawText(e.screen, x, sy, e.uiStyle(style), text)
+}
+
+// relativeLine is how many lines on screen line y is from the cursor, a
+// closed fold counting as one.
+func (e *Editor) relativeLine(y int) int {
+	from, to := e.visibleLine(e.cursorY), e.visibleLine(y)
+	if from > to {
+		from, to = to, from
+	}
+	if len(e.closedRanges()) == 0 {
+		return to - from
+	}
+	n := 0
+	for ; from < to; from = e.nextLine(from) {
+		n++
+	}
+	return n
+}
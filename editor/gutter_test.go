@@ -0,0 +1,151 @@
+package editor
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// screenText is what row sy of a simulation screen shows.
+func screenText(screen tcell.SimulationScreen, sy int) string {
+	cells, width, _ := screen.GetContents()
+	var b strings.Builder
+	for _, cell := range cells[sy*width : (sy+1)*width] {
+		if len(cell.Runes) > 0 {
+			b.WriteString(string(cell.Runes))
+		}
+	}
+	return b.String()
+}
+
+func TestGutterColumns(t *testing.T) {
+	ed := &Editor{
+		mode:  "normal",
+		lines: make([]string, 1200),
+		settings: map[string]string{
+			"foldMethod": "manual",
+		},
+	}
+	ed.applySettings()
+	ed.resetFolds()
+
+	// Numbers grow past numberWidth for long files; signs only show when
+	// there are some
+	if left, _ := ed.textArea(); left != 1+5 {
+		t.Errorf("text starts at %d", left)
+	}
+	ed.lines = ed.lines[:9]
+	if left, _ := ed.textArea(); left != 1+4 {
+		t.Errorf("short file: text starts at %d", left)
+	}
+	ed.toggleBreakpoint(3)
+	if left, _ := ed.textArea(); left != 1+signWidth+4 {
+		t.Errorf("with a breakpoint: text starts at %d", left)
+	}
+	ed.setCommand("scl=no nonumber nofdc")
+	if left, _ := ed.textArea(); left != 0 {
+		t.Errorf("no gutter: text starts at %d", left)
+	}
+	ed.setCommand("rnu")
+	if left, _ := ed.textArea(); left != 4 {
+		t.Errorf("relative numbers: text starts at %d", left)
+	}
+
+	// Clicks land on the column under them
+	ed.setCommand("number fdc scl=yes")
+	for _, test := range []struct {
+		x, sy int
+		want  string
+	}{
+		{0, 2, "fold 2"},
+		{2, 3, "sign 3"},
+		{4, 0, "number 0"},
+	} {
+		name, y, ok := ed.gutterColumnAt(test.x, test.sy)
+		if got := fmt.Sprintf("%s %d", name, y); !ok || got != test.want {
+			t.Errorf("click at %d,%d: %s (%v), want %s", test.x, test.sy, got, ok, test.want)
+		}
+	}
+	ed.clickGutter(2, 5)
+	if !ed.breakpoints[5] {
+		t.Errorf("click on the sign column left breakpoints %v", ed.breakpoints)
+	}
+}
+
+func TestLineNumbers(t *testing.T) {
+	screen := tcell.NewSimulationScreen("")
+	screen.Init()
+	screen.SetSize(20, 7)
+	ed := &Editor{
+		mode:         "normal",
+		screen:       screen,
+		screenWidth:  20,
+		screenHeight: 7,
+		lines:        []string{"a", "b", "  c", "  d", "e", "f"},
+		settings:     map[string]string{"foldMethod": "indent", "foldColumn": "false", "relativeNumber": "true"},
+	}
+	ed.applySettings()
+	ed.resetFolds()
+	ed.cursorY = 1
+	typeKeys(ed, "zcj")
+	ed.Draw()
+
+	// Hybrid numbers count the closed fold as one line, and the cursor
+	// line keeps its number
+	if ed.cursorY != 4 {
+		t.Errorf("cursor on line %d", ed.cursorY)
+	}
+	for sy, want := range []string{"  2 a", "  1 +-- 3 lines: b ", "5   e", "  1 f"} {
+		if got := screenText(screen, sy); !strings.HasPrefix(got, want) {
+			t.Errorf("row %d is %q, want %q", sy, got, want)
+		}
+	}
+}
+
+func TestMarks(t *testing.T) {
+	ed := &Editor{
+		mode:  "normal",
+		lines: []string{"one", "  two", "three", "four"},
+	}
+	ed.applySettings()
+	ed.resetFolds()
+
+	ed.cursorY, ed.cursorX = 1, 4
+	typeKeys(ed, "ma")
+	ed.cursorY, ed.cursorX = 3, 0
+	typeKeys(ed, "mb")
+	ed.toggleBreakpoint(2)
+
+	typeKeys(ed, "'a")
+	if ed.cursorY != 1 || ed.cursorX != 2 {
+		t.Errorf("'a went to %d:%d", ed.cursorY, ed.cursorX)
+	}
+	typeKeys(ed, "`a")
+	if ed.cursorX != 4 {
+		t.Errorf("`a went to %d:%d", ed.cursorY, ed.cursorX)
+	}
+
+	// A line added above moves them down; deleting a marked line drops its
+	// mark
+	ed.cursorY, ed.cursorX = 0, 3
+	ed.lineSigns()
+	ed.insertNewLine()
+	signs := ed.lineSigns()
+	if signs[2].text != "a" || signs[3].text != "●" || signs[4].text != "b" {
+		t.Errorf("after a new line: %v", signs)
+	}
+	ed.cursorY = 3
+	ed.lineSigns()
+	ed.lines = append(ed.lines[:4], ed.lines[5:]...)
+	if _, ok := ed.lineSigns()[4]; ok || ed.marks['b'] != (Position{}) {
+		t.Errorf("after deleting line 4: %v, marks %v", ed.lineSigns(), ed.marks)
+	}
+
+	ed.commandBuffer = "delmarks a"
+	ed.handleCommand()
+	if len(ed.marks) != 0 {
+		t.Errorf(":delmarks left %v", ed.marks)
+	}
+}
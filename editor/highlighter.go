@@ -0,0 +1,219 @@
+package editor
+
+import (
+	"strings"
+
+	"github.com/alecthomas/chroma"
+	"github.com/gdamore/tcell/v2"
+)
+
+// Stateful, incremental syntax highlighting.
+//
+// Chroma lexes whole documents, so block comments, raw strings and
+// heredocs only colour correctly when a line is lexed together with the
+// lines before it. The highlighter keeps the results for a prefix of the
+// buffer together with the state each line ended in, approximated by the
+// token the line's newline belongs to. After an edit it restarts from the
+// closest line that ended in a clean (root) state and stops as soon as a
+// line after the edit ends in the same state as before, reusing the rest.
+
+// Number of lines handed to chroma at once
+const lexWindow = 2000
+
+type lineState struct {
+	token chroma.TokenType // token the newline at the end of the line belongs to
+	open  bool             // that token continues on the next line
+}
+
+// clean reports whether lexing can restart after this line as if it were
+// the start of the file.
+func (s lineState) clean() bool {
+	return s.token.Category() == chroma.Text || (s.token == chroma.CommentSingle && !s.open)
+}
+
+type lineHighlight struct {
+	text   string
+	styles []tcell.Style
+	end    lineState
+}
+
+type highlighter struct {
+	lexer chroma.Lexer
+	theme *Theme
+	lines []lineHighlight // highlighted prefix of the buffer
+	total int             // buffer length when lines was last synced
+
+	// Results from before an edit, reused once the lexer state converges
+	tail      []lineHighlight
+	tailStart int // buffer line of tail[0]
+
+	// Lexing in progress, resumed when more lines are needed
+	iter    chroma.Iterator
+	window  []string // buffer lines being lexed
+	winLine int      // buffer line of window[0]
+	token   chroma.Token
+	partial []tcell.Style
+}
+
+func newHighlighter(lexer chroma.Lexer, theme *Theme) *highlighter {
+	return &highlighter{lexer: lexer, theme: theme}
+}
+
+// ensure brings the first upTo lines of buf up to date.
+func (h *highlighter) ensure(buf []string, upTo int) {
+	if upTo > len(buf) {
+		upTo = len(buf)
+	}
+
+	// First line that differs from what was highlighted
+	p := 0
+	for p < len(h.lines) && p < len(buf) && h.lines[p].text == buf[p] {
+		p++
+	}
+	if p < len(h.lines) {
+		h.invalidate(buf, p)
+	} else if h.iter != nil && !h.windowCurrent(buf) {
+		h.iter = nil
+	}
+	h.total = len(buf)
+
+	for len(h.lines) < len(buf) && (len(h.lines) < upTo || h.tail != nil) {
+		h.step(buf)
+	}
+	if len(h.lines) >= len(buf) {
+		h.tail = nil
+		h.iter = nil
+	}
+}
+
+// windowCurrent checks that the text being lexed still matches the buffer.
+func (h *highlighter) windowCurrent(buf []string) bool {
+	if h.winLine+len(h.window) > len(buf) || len(buf) != h.total {
+		return false
+	}
+	for i, line := range h.window {
+		if buf[h.winLine+i] != line {
+			return false
+		}
+	}
+	return true
+}
+
+// invalidate drops the results from the first changed line p onwards,
+// keeping the unchanged lines after the edit as a tail to splice back in.
+func (h *highlighter) invalidate(buf []string, p int) {
+	old := h.lines
+	delta := len(buf) - h.total
+
+	// Walk back from the end of the cache over lines that only moved
+	i := len(old) - 1
+	for i >= p && i+delta >= p && i+delta < len(buf) && old[i].text == buf[i+delta] {
+		i--
+	}
+	h.tail = nil
+	if i+1 < len(old) {
+		h.tail = append([]lineHighlight(nil), old[i+1:]...)
+		h.tailStart = i + 1 + delta
+	}
+
+	h.lines = old[:p]
+	h.iter = nil
+}
+
+// step highlights one more line.
+func (h *highlighter) step(buf []string) {
+	if h.iter == nil {
+		start := len(h.lines)
+		for start > 0 && !h.lines[start-1].end.clean() {
+			start--
+		}
+		h.startWindow(buf, start)
+	}
+
+	line := len(h.lines)
+	for {
+		if h.token.Value == "" {
+			h.token = h.iter()
+			if h.token == chroma.EOF {
+				// Window exhausted; carry on from here in a new one
+				h.iter = nil
+				h.finishLine(buf, line, lineState{token: chroma.Text})
+				return
+			}
+		}
+
+		style := h.theme.tokenStyle(h.token.Type)
+		nl := strings.IndexByte(h.token.Value, '\n')
+		if nl < 0 {
+			for i := 0; i < len(h.token.Value); i++ {
+				h.partial = append(h.partial, style)
+			}
+			h.token.Value = ""
+			continue
+		}
+
+		for i := 0; i < nl; i++ {
+			h.partial = append(h.partial, style)
+		}
+		h.token.Value = h.token.Value[nl+1:]
+		h.finishLine(buf, line, lineState{token: h.token.Type, open: h.token.Value != ""})
+		return
+	}
+}
+
+func (h *highlighter) startWindow(buf []string, start int) {
+	end := start + lexWindow
+	if end > len(buf) {
+		end = len(buf)
+	}
+
+	h.lines = h.lines[:start]
+	h.window = append([]string(nil), buf[start:end]...)
+	h.winLine = start
+	h.token = chroma.Token{}
+	h.partial = nil
+
+	iter, err := h.lexer.Tokenise(nil, strings.Join(h.window, "\n")+"\n")
+	if err != nil {
+		// Fall back to unstyled text rather than failing to draw
+		iter = chroma.Literator(chroma.Token{Type: chroma.Text, Value: strings.Join(h.window, "\n") + "\n"})
+	}
+	h.iter = iter
+}
+
+// finishLine stores the styles collected for line and checks whether the
+// lexer has converged with the results from before the last edit.
+func (h *highlighter) finishLine(buf []string, line int, end lineState) {
+	text := buf[line]
+	styles := h.partial
+	h.partial = nil
+	for len(styles) < len(text) {
+		styles = append(styles, h.theme.Style("text"))
+	}
+	h.lines = append(h.lines, lineHighlight{text: text, styles: styles[:len(text)], end: end})
+
+	if h.iter != nil && line+1 >= h.winLine+len(h.window) {
+		h.iter = nil
+	}
+
+	if h.tail == nil || line < h.tailStart {
+		return
+	}
+	if k := line - h.tailStart; k < len(h.tail) {
+		if h.tail[k].end == end {
+			h.lines = append(h.lines, h.tail[k+1:]...)
+			h.tail = nil
+			h.iter = nil
+		}
+		return
+	}
+	h.tail = nil
+}
+
+// styles returns the highlighted styles of a line that ensure has covered.
+func (h *highlighter) styles(line int) []tcell.Style {
+	if line < len(h.lines) {
+		return h.lines[line].styles
+	}
+	return nil
+}
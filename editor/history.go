@@ -48,7 +48,7 @@ func (e *Editor) redo() {
 	e.lines[action.cursorY] = action.text
 	e.cursorX = action.cursorX
 	e.cursorY = action.cursorY
-	e.isDirty = true
+	e.markChanged()
 }
 
 // Initialize history stacks in editor.go's NewEditor function
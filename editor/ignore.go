@@ -0,0 +1,187 @@
+package editor
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// .gitignore handling for the file tree. Rules come from .git/info/exclude
+// and every .gitignore between the repository root and the path being
+// checked; as in git, the last matching rule wins and deeper files take
+// precedence over shallower ones.
+
+type ignoreRule struct {
+	base     string   // directory of the .gitignore, relative to the root
+	segments []string // pattern split on "/"
+	negate   bool
+	dirOnly  bool
+	anchored bool // pattern contained a slash, so it matches from base
+}
+
+type ignoreMatcher struct {
+	root    string
+	exclude []ignoreRule
+	rules   map[string][]ignoreRule // keyed by directory relative to root
+}
+
+func newIgnoreMatcher(repo *gitRepo) *ignoreMatcher {
+	m := &ignoreMatcher{
+		root:  repo.root,
+		rules: make(map[string][]ignoreRule),
+	}
+	m.exclude = parseIgnoreFile(filepath.Join(repo.commonDir(), "info", "exclude"), "")
+	return m
+}
+
+func parseIgnoreFile(filename, base string) []ignoreRule {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	var rules []ignoreRule
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if rule, ok := parseIgnoreLine(scanner.Text(), base); ok {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+func parseIgnoreLine(line, base string) (ignoreRule, bool) {
+	line = strings.TrimSuffix(line, "\r")
+	// Trailing spaces are ignored unless escaped with a backslash
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+		line = line[:len(line)-1]
+	}
+	if line == "" || line[0] == '#' {
+		return ignoreRule{}, false
+	}
+
+	rule := ignoreRule{base: base}
+	if line[0] == '!' {
+		rule.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, "\\!") || strings.HasPrefix(line, "\\#") {
+		line = line[1:]
+	}
+
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if strings.Contains(line, "/") {
+		rule.anchored = true
+		line = strings.TrimPrefix(line, "/")
+	}
+	if line == "" {
+		return ignoreRule{}, false
+	}
+
+	rule.segments = strings.Split(line, "/")
+	return rule, true
+}
+
+// rulesFor returns the rules of the .gitignore in dir, loading it on first use.
+func (m *ignoreMatcher) rulesFor(dir string) []ignoreRule {
+	if rules, ok := m.rules[dir]; ok {
+		return rules
+	}
+	rules := parseIgnoreFile(filepath.Join(m.root, filepath.FromSlash(dir), ".gitignore"), dir)
+	m.rules[dir] = rules
+	return rules
+}
+
+// isIgnored reports whether rel (slash separated, relative to the root) is
+// excluded, either directly or because one of its parent directories is.
+func (m *ignoreMatcher) isIgnored(rel string, isDir bool) bool {
+	if rel == "" {
+		return false
+	}
+	parts := strings.Split(rel, "/")
+	for i := 1; i < len(parts); i++ {
+		if m.matches(strings.Join(parts[:i], "/"), true) {
+			return true
+		}
+	}
+	return m.matches(rel, isDir)
+}
+
+// matches checks rel against the rules without looking at its parents.
+func (m *ignoreMatcher) matches(rel string, isDir bool) bool {
+	if rel == ".git" || strings.HasSuffix(rel, "/.git") {
+		return true
+	}
+
+	ignored := false
+	check := func(rules []ignoreRule) {
+		for _, rule := range rules {
+			if rule.match(rel, isDir) {
+				ignored = !rule.negate
+			}
+		}
+	}
+
+	check(m.exclude)
+	dir := ""
+	check(m.rulesFor(dir))
+	for _, part := range strings.Split(path.Dir(rel), "/") {
+		if part == "." {
+			break
+		}
+		dir = path.Join(dir, part)
+		check(m.rulesFor(dir))
+	}
+	return ignored
+}
+
+func (r ignoreRule) match(rel string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	if r.base != "" {
+		if !strings.HasPrefix(rel, r.base+"/") {
+			return false
+		}
+		rel = rel[len(r.base)+1:]
+	}
+
+	parts := strings.Split(rel, "/")
+	if !r.anchored {
+		// A pattern without a slash matches the name at any depth
+		ok, _ := path.Match(r.segments[0], parts[len(parts)-1])
+		return ok
+	}
+	return matchSegments(r.segments, parts)
+}
+
+// matchSegments matches glob segments against path segments, with "**"
+// standing for any number of directories.
+func matchSegments(pattern, parts []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(parts); i++ {
+				if matchSegments(pattern[1:], parts[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(parts) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], parts[0]); !ok {
+			return false
+		}
+		pattern, parts = pattern[1:], parts[1:]
+	}
+	return len(parts) == 0
+}
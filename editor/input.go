@@ -4,15 +4,24 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
-	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/gdamore/tcell/v2"
 )
 
 // Handle all input-related functions
 func (e *Editor) handleInput(ev *tcell.EventKey) {
+	if e.closePopup() {
+		return
+	}
+
 	if ev.Key() == tcell.KeyEscape {
-		if e.mode == "command" || e.mode == "search" || e.mode == "filename" || e.mode == "rename" || e.mode == "confirm" {
+		e.normalPrefix = ""
+		if e.mode == "treefilter" || (e.mode == "normal" && e.treeFilter != "") {
+			e.setTreeFilter("")
+		}
+		if e.mode == "command" || e.mode == "search" || e.mode == "filename" || e.mode == "rename" || e.mode == "confirm" || e.mode == "recover" || e.mode == "treefilter" {
 			e.mode = "normal"
 			e.commandBuffer = ""
 			e.searchTerm = ""
@@ -21,6 +30,8 @@ func (e *Editor) handleInput(ev *tcell.EventKey) {
 			e.SetStatusMessage("NORMAL")
 		} else if e.mode == "insert" {
 			e.mode = "normal"
+			e.completionActive = false
+			e.snippetSession = nil
 			e.SetStatusMessage("NORMAL")
 		}
 		return
@@ -59,15 +70,27 @@ func (e *Editor) handleInput(ev *tcell.EventKey) {
 		e.handleRenameMode(ev)
 	case "confirm":
 		e.handleConfirmMode(ev)
+	case "recover":
+		e.handleRecoverMode(ev)
+	case "treefilter":
+		e.handleTreeFilterMode(ev)
 	}
 }
 
 func (e *Editor) handleNormalMode(ev *tcell.EventKey) {
+	if prefix := e.normalPrefix; prefix != "" {
+		e.normalPrefix = ""
+		if ev.Key() == tcell.KeyRune {
+			e.handleNormalPrefix(prefix, ev.Rune())
+		}
+		return
+	}
+
 	if e.treeVisible {
 		switch ev.Key() {
 		case tcell.KeyRune:
 			switch ev.Rune() {
-			case 'j', 'k', 'h', 'l', 'n', 'd', 'r':
+			case 'j', 'k', 'h', 'l', 'n', 'd', 'r', 'I', '/', 'R', 'b', 'B':
 				e.handleTreeNavigation(ev)
 				return
 			case 't': // Toggle file tree
@@ -85,6 +108,9 @@ func (e *Editor) handleNormalMode(ev *tcell.EventKey) {
 	case tcell.KeyRune:
 		switch ev.Rune() {
 		case 'i':
+			if !e.editable() {
+				return
+			}
 			e.mode = "insert"
 			e.SetStatusMessage("-- INSERT MODE -- (Tab for completions, Esc to exit)")
 		case ':':
@@ -103,6 +129,8 @@ func (e *Editor) handleNormalMode(ev *tcell.EventKey) {
 			e.moveCursor(0, 1)
 		case 'k':
 			e.moveCursor(0, -1)
+		case 'g', 'z', 'm', '\'', '`', '[', ']', 'd', 'c':
+			e.normalPrefix = string(ev.Rune())
 		case 'u':
 			e.undo()
 		case 'r':
@@ -119,7 +147,109 @@ func (e *Editor) handleNormalMode(ev *tcell.EventKey) {
 		case '?':
 			e.showHelp()
 			e.SetStatusMessage("Press any key to exit help")
+		case 'K':
+			e.hover()
+		}
+	case tcell.KeyEnter:
+		if e.gitView != nil && e.gitView.commits != nil {
+			e.openLogEntry()
+		}
+	case tcell.KeyCtrlW:
+		// Ctrl-W w, h or l moves between diff sides
+		e.normalPrefix = "\x17"
+	case tcell.KeyCtrlRightSq:
+		e.gotoDefinition()
+	}
+}
+
+// handleNormalPrefix runs the normal mode command prefix+r, or waits for
+// the next key if it's the start of a longer one.
+func (e *Editor) handleNormalPrefix(prefix string, r rune) {
+	// Marks take any key after them
+	switch prefix {
+	case "m":
+		e.setMark(r)
+		return
+	case "'", "`":
+		e.jumpToMark(r, prefix == "`")
+		return
+	}
+
+	switch keys := prefix + string(r); keys {
+	case "]c", "[c":
+		dir := 1
+		if prefix == "[" {
+			dir = -1
+		}
+		if e.diff != nil {
+			e.jumpToDiffHunk(dir)
+		} else {
+			e.jumpToHunk(dir)
+		}
+	case "]x":
+		e.jumpToConflict(1)
+	case "[x":
+		e.jumpToConflict(-1)
+	case "co", "ct", "cb", "c0":
+		e.resolveConflict(map[rune]string{'o': "ours", 't': "theirs", 'b': "both", '0': "none"}[r])
+	case "do", "dp":
+		if e.diff == nil {
+			e.setStatusMessage("Not diffing, :diffsplit {file} starts")
+		} else if r == 'o' {
+			e.diffObtain()
+		} else {
+			e.diffPut()
+		}
+	case "\x17w", "\x17h", "\x17l":
+		if e.diff == nil {
+			return
+		}
+		switch r {
+		case 'w':
+			e.switchDiffSide(1 - e.diffSide())
+		case 'h':
+			e.switchDiffSide(0)
+		case 'l':
+			e.switchDiffSide(1)
 		}
+	case "gj":
+		e.moveDisplayRow(1)
+	case "gk":
+		e.moveDisplayRow(-1)
+	case "gq", "gqa", "gqi":
+		e.normalPrefix = keys
+	case "gqq":
+		e.reflowLines(e.cursorY, e.cursorY, e.textWidth())
+	case "gqj":
+		e.reflowLines(e.cursorY, e.cursorY+1, e.textWidth())
+	case "gqk":
+		e.reflowLines(e.cursorY-1, e.cursorY, e.textWidth())
+	case "gqG":
+		e.reflowLines(e.cursorY, len(e.lines)-1, e.textWidth())
+	case "za", "zo", "zc", "zR", "zM", "zd", "zE":
+		e.foldCommand(r)
+	case "zf", "zfa", "zfi":
+		e.normalPrefix = keys
+	case "zfj":
+		e.createFold(e.cursorY, e.nextLine(e.cursorY))
+	case "zfk":
+		e.createFold(e.prevLine(e.cursorY), e.cursorY)
+	case "zfG":
+		e.createFold(e.cursorY, len(e.lines)-1)
+	case "zf}", "zfap", "zfip":
+		from, to := e.paragraphRange(e.cursorY)
+		if keys == "zf}" {
+			from = e.cursorY
+		}
+		e.createFold(from, to)
+	case "gq}", "gqap", "gqip":
+		from, to := e.paragraphRange(e.cursorY)
+		if keys == "gq}" {
+			from = e.cursorY
+		}
+		e.reflowLines(from, to, e.textWidth())
+	default:
+		e.SetStatusMessage(fmt.Sprintf("Unknown command: %s%c", prefix, r))
 	}
 }
 
@@ -127,44 +257,93 @@ func (e *Editor) handleInsertMode(ev *tcell.EventKey) {
 	switch ev.Key() {
 	case tcell.KeyEscape:
 		e.mode = "normal"
+		e.completionActive = false
+		e.snippetSession = nil
 		e.SetStatusMessage("-- NORMAL MODE --")
 	case tcell.KeyTab:
+		if e.snippetSession != nil {
+			e.nextSnippetStop(1)
+			return
+		}
+		if e.completionActive {
+			e.nextCompletion()
+			return
+		}
+		if e.expandPrefixSnippet() {
+			return
+		}
+		// Nothing to complete after whitespace
+		if e.onlyWhitespaceBefore() {
+			e.insertTab()
+			return
+		}
 		completions := e.getCompletions()
-		if len(completions) > 0 {
-			// Insert the first completion
-			line := e.lines[e.cursorY]
-			start := e.cursorX
-			for start > 0 && isIdentChar(rune(line[start-1])) {
-				start--
-			}
-			prefix := line[start:e.cursorX]
-			completion := completions[0].Text
-
-			// Only insert the part of completion that's not already typed
-			if len(prefix) > 0 && strings.HasPrefix(completion, prefix) {
-				completion = completion[len(prefix):]
-			}
-
-			// Insert the completion
-			e.lines[e.cursorY] = line[:e.cursorX] + completion + line[e.cursorX:]
-			e.cursorX += len(completion)
-			e.isDirty = true
-
+		switch len(completions) {
+		case 0:
+			e.insertTab()
+		case 1:
+			// Nothing to choose from
+			e.setCompletions(completions)
+			e.applyCompletion()
 			e.SetStatusMessage(fmt.Sprintf("Completed: %s", completions[0].Text))
-			e.showCompletions()
+		default:
+			e.setCompletions(completions)
 		}
 		return
+	case tcell.KeyBacktab:
+		if e.snippetSession != nil {
+			e.nextSnippetStop(-1)
+			return
+		}
+		e.prevCompletion()
+	case tcell.KeyCtrlN, tcell.KeyCtrlP:
+		if !e.completionActive {
+			e.showCompletions()
+			if ev.Key() == tcell.KeyCtrlP {
+				e.prevCompletion()
+			}
+		} else if ev.Key() == tcell.KeyCtrlN {
+			e.nextCompletion()
+		} else {
+			e.prevCompletion()
+		}
 	case tcell.KeyEnter:
+		if e.completionActive {
+			e.applyCompletion()
+			return
+		}
+		e.snippetSession = nil
 		e.insertNewLine()
 		e.SetStatusMessage("-- INSERT MODE --")
 	case tcell.KeyBackspace, tcell.KeyBackspace2:
-		if e.cursorX > 0 {
+		if e.snippetBackspace() {
+			// Edited the snippet field
+		} else if e.softTabBackspace() {
+			// Deleted back to a soft tab stop
+		} else if e.cursorX > 0 {
 			e.deleteChar()
 		} else if e.cursorY > 0 {
 			e.joinLines()
 		}
+		if e.completionActive {
+			e.refreshCompletions()
+		}
 	case tcell.KeyRune:
-		e.insertRune(ev.Rune())
+		if !e.snippetInsert(ev.Rune()) {
+			e.insertRune(ev.Rune())
+			if r := ev.Rune(); r != ' ' && r != '\t' {
+				e.autoWrap()
+			}
+		}
+		if r := ev.Rune(); isIdentChar(r) || (r < utf8.RuneSelf && isPathChar(byte(r))) {
+			e.refreshCompletions()
+		} else {
+			e.completionActive = false
+		}
+		e.lspTriggerCompletion(ev.Rune())
+	default:
+		e.completionActive = false
+		e.snippetSession = nil
 	}
 }
 
@@ -180,6 +359,7 @@ func (e *Editor) handleFilenameMode(ev *tcell.EventKey) {
 				f.Close()
 				e.SetFilename(newPath)
 				e.lines = []string{""}
+				e.newVersion()
 				e.cursorX = 0
 				e.cursorY = 0
 				e.isDirty = false
@@ -249,87 +429,90 @@ func (e *Editor) handleConfirmMode(ev *tcell.EventKey) {
 func (e *Editor) handleMouseEvent(ev *tcell.EventMouse) {
 	x, y := ev.Position()
 	button := ev.Buttons()
+	pressed := button&tcell.Button1 != 0 && e.lastMouseButtons&tcell.Button1 == 0
+	e.lastMouseButtons = button
 
-	// Check if the click is within the file tree
-	if e.treeVisible && x <= e.treeWidth {
-		e.handleTreeMouseEvent(x, y, button)
+	// Dragging the separator resizes the tree
+	if e.treeResizing {
+		if button&tcell.Button1 == 0 {
+			e.treeResizing = false
+		} else {
+			e.setTreeWidth(x)
+		}
 		return
 	}
-
-	// Adjust coordinates for line numbers and tree
-	adjustedX := x
-	if e.showLineNumbers {
-		adjustedX -= 5
-	}
-	if e.treeVisible {
-		adjustedX -= e.treeWidth + 1
+	if e.treeVisible && x == e.treeWidth && pressed {
+		e.treeResizing = true
+		return
 	}
 
-	// Adjust y coordinate for scrolling
-	adjustedY := y + e.scrollY
+	// Check if the click is within the file tree
+	if e.treeVisible && x < e.treeWidth {
+		e.handleTreeMouseEvent(x, y, button, pressed)
+		return
+	}
 
-	// Handle clicks within the text area
-	if adjustedX >= 0 && adjustedY >= 0 && adjustedY < len(e.lines) {
-		e.cursorX = adjustedX
-		e.cursorY = adjustedY
+	if e.diff != nil {
+		e.diffMouse(x, y, button, pressed)
+		return
+	}
 
-		// Ensure cursor stays within line bounds
-		if e.cursorX > len(e.lines[e.cursorY]) {
-			e.cursorX = len(e.lines[e.cursorY])
-		}
+	// Adjust coordinates for line numbers and tree
+	left, _ := e.textArea()
 
-		// Handle different mouse buttons
-		switch button {
-		case tcell.Button1: // Left click
-			// Do something on left click
-			e.SetStatusMessage(fmt.Sprintf("Left click at x: %d, y: %d", adjustedX, adjustedY))
-		case tcell.Button2: // Middle click
-			// Do something on middle click
-			e.SetStatusMessage("Middle click")
-		case tcell.Button3: // Right click
-			// Do something on right click
-			e.SetStatusMessage("Right click")
-		case tcell.WheelUp: // Mouse wheel up
-			e.scrollUp()
-		case tcell.WheelDown: // Mouse wheel down
-			e.scrollDown()
+	switch {
+	case button&tcell.WheelUp != 0:
+		e.scrollRows(-1)
+	case button&tcell.WheelDown != 0:
+		e.scrollRows(1)
+	case pressed && x >= left && y < e.textHeight():
+		// Left click places the cursor on the character drawn there
+		if line, col, ok := e.screenLine(y, x-left); ok {
+			e.cursorY, e.cursorX = line, col
 		}
+	case pressed && y < e.textHeight():
+		e.clickGutter(x, y)
 	}
 }
 
-func (e *Editor) scrollUp() {
-	if e.scrollY > 0 {
-		e.scrollY--
+// handleTreeMouseEvent maps a mouse event in the tree panel to a row of
+// visibleTreeNodes, the same list keyboard navigation uses. A click selects
+// the row and toggles directories; a double click opens files.
+func (e *Editor) handleTreeMouseEvent(x, y int, button tcell.ButtonMask, pressed bool) {
+	switch {
+	case button&tcell.WheelUp != 0:
+		e.scrollTree(-3)
+		return
+	case button&tcell.WheelDown != 0:
+		e.scrollTree(3)
+		return
+	case !pressed:
+		return
 	}
-}
 
-func (e *Editor) scrollDown() {
-	if e.scrollY < len(e.lines)-1 {
-		e.scrollY++
+	rows := e.visibleTreeNodes()
+	index := e.treeScrollY + y
+	if y >= e.treeHeight() || index < 0 || index >= len(rows) {
+		return
 	}
-}
-
-func (e *Editor) handleTreeMouseEvent(x, y int, button tcell.ButtonMask) {
-	// Adjust y coordinate for scrolling
-	adjustedY := y + e.scrollY
 
-	// Check if the click is within the tree bounds
-	if adjustedY >= 0 && adjustedY < len(e.fileTree.children) {
-		e.treeSelectedLine = adjustedY
-		e.SetStatusMessage(fmt.Sprintf("Selected line in tree: %d", adjustedY))
+	doubleClick := index == e.lastTreeClickRow && time.Since(e.lastTreeClick) < doubleClickInterval
+	e.lastTreeClick = time.Now()
+	e.lastTreeClickRow = index
+	e.treeSelectedLine = index
 
-		// Handle different mouse buttons
-		switch button {
-		case tcell.Button1: // Left click
-			// Open the selected file or directory
-			selectedNode := e.fileTree.children[e.treeSelectedLine]
-			if selectedNode.isDir {
-				e.currentPath = selectedNode.name
-				e.initFileTree()
-			} else {
-				e.LoadFile(selectedNode.name)
+	node := rows[index].node
+	if node.isDir {
+		if node.parent != nil {
+			node.expanded = !node.expanded
+			if node.expanded && len(node.children) == 0 {
+				e.loadDirectory(node)
 			}
 		}
+	} else if doubleClick {
+		if err := e.openFile(node.name); err != nil {
+			e.SetStatusMessage(fmt.Sprintf("Error loading file: %v", err))
+		}
 	}
 }
 
@@ -342,27 +525,3 @@ func (e *Editor) scroll(amount int) {
 		e.cursorY = newY
 	}
 }
-
-// Handle clicks in the file tree
-func (e *Editor) handleTreeClick(x, y int) {
-	// Find which node was clicked
-	var clickedNode *FileNode
-
-	// Count visible nodes to find which one was clicked
-	e.treeSelectedLine = y
-	clickedNode = e.getSelectedNode()
-
-	if clickedNode != nil {
-		if clickedNode.isDir {
-			clickedNode.expanded = !clickedNode.expanded
-			if clickedNode.expanded && len(clickedNode.children) == 0 {
-				e.loadDirectory(clickedNode)
-			}
-		} else {
-			e.SetFilename(clickedNode.name)
-			if err := e.LoadFile(clickedNode.name); err == nil {
-				// Keep tree visible for now
-			}
-		}
-	}
-}
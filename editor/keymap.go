@@ -0,0 +1,558 @@
+package editor
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// Key mappings.
+//
+// Keys typed in normal, insert and file tree mode go through the mappings
+// for that mode before the built-in bindings see them. A mapping's keys use
+// vim's notation: "jk", "<leader>ff", "<C-s>", "<Esc>", "<CR>", "<Space>".
+// Its right-hand side is a key sequence typed in its place, which may start
+// with <Cmd> to run a command without going through command mode:
+//
+//	[keys.insert]
+//	jk = "<Esc>"
+//
+//	[keys.normal]
+//	"<leader>w" = "<Cmd>w<CR>"
+//	"<C-s>" = ":w<CR>"
+//
+// While the keys typed so far begin a longer mapping the editor waits up to
+// timeoutLen milliseconds for the rest. <leader> is the leader option when
+// the mapping is made.
+
+type keymap struct {
+	keys    []string
+	rhs     string
+	noremap bool // the right-hand side isn't mapped again
+}
+
+var keymapModes = []string{"normal", "insert", "tree"}
+
+// Mappings run inside mappings at most this deep
+const maxMappingDepth = 10
+
+// Names of special keys in the notation, lowercase
+var keyNames = map[string]tcell.Key{
+	"cr":       tcell.KeyEnter,
+	"enter":    tcell.KeyEnter,
+	"return":   tcell.KeyEnter,
+	"esc":      tcell.KeyEscape,
+	"tab":      tcell.KeyTab,
+	"s-tab":    tcell.KeyBacktab,
+	"bs":       tcell.KeyBackspace2,
+	"del":      tcell.KeyDelete,
+	"insert":   tcell.KeyInsert,
+	"up":       tcell.KeyUp,
+	"down":     tcell.KeyDown,
+	"left":     tcell.KeyLeft,
+	"right":    tcell.KeyRight,
+	"home":     tcell.KeyHome,
+	"end":      tcell.KeyEnd,
+	"pageup":   tcell.KeyPgUp,
+	"pagedown": tcell.KeyPgDn,
+}
+
+// The name each key is written with
+var keyTokens = map[tcell.Key]string{
+	tcell.KeyEnter:       "<CR>",
+	tcell.KeyEscape:      "<Esc>",
+	tcell.KeyTab:         "<Tab>",
+	tcell.KeyBacktab:     "<S-Tab>",
+	tcell.KeyBackspace:   "<BS>",
+	tcell.KeyBackspace2:  "<BS>",
+	tcell.KeyDelete:      "<Del>",
+	tcell.KeyInsert:      "<Insert>",
+	tcell.KeyUp:          "<Up>",
+	tcell.KeyDown:        "<Down>",
+	tcell.KeyLeft:        "<Left>",
+	tcell.KeyRight:       "<Right>",
+	tcell.KeyHome:        "<Home>",
+	tcell.KeyEnd:         "<End>",
+	tcell.KeyPgUp:        "<PageUp>",
+	tcell.KeyPgDn:        "<PageDown>",
+	tcell.KeyCtrlRightSq: "<C-]>",
+	tcell.KeyCtrlSpace:   "<C-Space>",
+}
+
+// keyToken names a key press.
+func keyToken(ev *tcell.EventKey) string {
+	if ev.Key() == tcell.KeyRune {
+		r := ev.Rune()
+		name := string(r)
+		switch r {
+		case ' ':
+			name = "Space"
+		case '<':
+			name = "lt"
+		}
+		if ev.Modifiers()&tcell.ModAlt != 0 {
+			return "<A-" + name + ">"
+		}
+		if len(name) > 1 {
+			return "<" + name + ">"
+		}
+		return name
+	}
+	if token, ok := keyTokens[ev.Key()]; ok {
+		return token
+	}
+	if ev.Key() >= tcell.KeyCtrlA && ev.Key() <= tcell.KeyCtrlZ {
+		return fmt.Sprintf("<C-%c>", 'a'+rune(ev.Key()-tcell.KeyCtrlA))
+	}
+	if ev.Key() >= tcell.KeyF1 && ev.Key() <= tcell.KeyF12 {
+		return fmt.Sprintf("<F%d>", ev.Key()-tcell.KeyF1+1)
+	}
+	return fmt.Sprintf("<%s>", ev.Name())
+}
+
+// tokenEvent is the key press a token stands for.
+func tokenEvent(token string) *tcell.EventKey {
+	if !strings.HasPrefix(token, "<") || len(token) == 1 {
+		r, _ := utf8.DecodeRuneInString(token)
+		return tcell.NewEventKey(tcell.KeyRune, r, tcell.ModNone)
+	}
+	name := token[1 : len(token)-1]
+	switch {
+	case name == "Space":
+		return tcell.NewEventKey(tcell.KeyRune, ' ', tcell.ModNone)
+	case name == "lt":
+		return tcell.NewEventKey(tcell.KeyRune, '<', tcell.ModNone)
+	case strings.HasPrefix(name, "A-"):
+		ev := tokenEvent(normalKeyToken(name[2:]))
+		return tcell.NewEventKey(ev.Key(), ev.Rune(), ev.Modifiers()|tcell.ModAlt)
+	case name == "C-]":
+		return tcell.NewEventKey(tcell.KeyCtrlRightSq, 0, tcell.ModCtrl)
+	case name == "C-Space":
+		return tcell.NewEventKey(tcell.KeyCtrlSpace, 0, tcell.ModCtrl)
+	case strings.HasPrefix(name, "C-"):
+		return tcell.NewEventKey(tcell.KeyCtrlA+tcell.Key(name[2]-'a'), 0, tcell.ModCtrl)
+	case name[0] == 'F':
+		var n int
+		fmt.Sscanf(name[1:], "%d", &n)
+		return tcell.NewEventKey(tcell.KeyF1+tcell.Key(n-1), 0, tcell.ModNone)
+	}
+	return tcell.NewEventKey(keyNames[strings.ToLower(name)], 0, tcell.ModNone)
+}
+
+// normalKeyToken turns a name such as "a", "cr" or "c-S" into a token, or
+// returns "" if it isn't a key.
+func normalKeyToken(name string) string {
+	lower := strings.ToLower(name)
+	switch {
+	case utf8.RuneCountInString(name) == 1:
+		if name == " " {
+			return "<Space>"
+		}
+		if name == "<" {
+			return "<lt>"
+		}
+		return name
+	case lower == "space" || lower == "lt":
+		return "<" + map[string]string{"space": "Space", "lt": "lt"}[lower] + ">"
+	case lower == "bar":
+		return "|"
+	case lower == "bslash":
+		return `\`
+	case strings.HasPrefix(lower, "a-") || strings.HasPrefix(lower, "m-"):
+		if key := normalKeyToken(name[2:]); key != "" {
+			return "<A-" + strings.Trim(key, "<>") + ">"
+		}
+	case lower == "c-]" || lower == "c-space":
+		return "<C-" + map[string]string{"c-]": "]", "c-space": "Space"}[lower] + ">"
+	case strings.HasPrefix(lower, "c-") && len(lower) == 3 && lower[2] >= 'a' && lower[2] <= 'z':
+		// <C-h>, <C-i> and <C-m> are the same keys as <BS>, <Tab> and <CR>
+		return keyToken(tcell.NewEventKey(tcell.KeyCtrlA+tcell.Key(lower[2]-'a'), 0, tcell.ModCtrl))
+	case lower[0] == 'f' && len(lower) <= 3:
+		var n int
+		if _, err := fmt.Sscanf(lower[1:], "%d", &n); err == nil && n >= 1 && n <= 12 {
+			return fmt.Sprintf("<F%d>", n)
+		}
+	default:
+		if key, ok := keyNames[lower]; ok {
+			return keyTokens[key]
+		}
+	}
+	return ""
+}
+
+// parseKeys splits a key sequence in the notation into tokens. <leader>
+// becomes the given leader keys.
+func parseKeys(s string, leader []string) ([]string, error) {
+	var keys []string
+	for len(s) > 0 {
+		if end := strings.IndexByte(s, '>'); s[0] == '<' && end > 2 && !strings.ContainsAny(s[1:end], " <") {
+			name := s[1:end]
+			s = s[end+1:]
+			switch token := normalKeyToken(name); {
+			case strings.EqualFold(name, "leader"):
+				keys = append(keys, leader...)
+			case strings.EqualFold(name, "cmd"):
+				keys = append(keys, "<Cmd>")
+			case token != "":
+				keys = append(keys, token)
+			default:
+				return nil, fmt.Errorf("unknown key <%s>", name)
+			}
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s)
+		keys = append(keys, normalKeyToken(string(r)))
+		s = s[size:]
+	}
+	return keys, nil
+}
+
+// leaderKeys are the keys <leader> stands for.
+func (e *Editor) leaderKeys() []string {
+	keys, err := parseKeys(e.option("leader"), nil)
+	if err != nil || len(keys) == 0 {
+		return []string{`\`}
+	}
+	return keys
+}
+
+// parseKeymapMode checks a mode name from the config file.
+func parseKeymapMode(mode string) (string, error) {
+	for _, m := range keymapModes {
+		if strings.EqualFold(m, mode) {
+			return m, nil
+		}
+	}
+	return "", fmt.Errorf("unknown mode %q (expected normal, insert or tree)", mode)
+}
+
+// mapKeys adds a mapping, replacing one for the same keys.
+func (e *Editor) mapKeys(mode, lhs, rhs string, noremap bool) error {
+	leader := e.leaderKeys()
+	keys, err := parseKeys(lhs, leader)
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("no keys to map")
+	}
+	if _, err := parseKeys(rhs, leader); err != nil {
+		return err
+	}
+	e.unmapKeys(mode, lhs)
+	if e.keymaps == nil {
+		e.keymaps = make(map[string][]*keymap)
+	}
+	e.keymaps[mode] = append(e.keymaps[mode], &keymap{keys: keys, rhs: expandLeader(rhs, leader), noremap: noremap})
+	return nil
+}
+
+// expandLeader replaces <leader> in a right-hand side now, as vim does.
+func expandLeader(rhs string, leader []string) string {
+	var out strings.Builder
+	for {
+		i := strings.Index(strings.ToLower(rhs), "<leader>")
+		if i < 0 {
+			out.WriteString(rhs)
+			return out.String()
+		}
+		out.WriteString(rhs[:i])
+		out.WriteString(strings.Join(leader, ""))
+		rhs = rhs[i+len("<leader>"):]
+	}
+}
+
+// unmapKeys removes a mapping, reporting whether there was one.
+func (e *Editor) unmapKeys(mode, lhs string) bool {
+	keys, err := parseKeys(lhs, e.leaderKeys())
+	if err != nil {
+		return false
+	}
+	maps := e.keymaps[mode]
+	for i, m := range maps {
+		if sameKeys(m.keys, keys) {
+			e.keymaps[mode] = append(maps[:i], maps[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func sameKeys(a, b []string) bool {
+	return len(a) == len(b) && hasKeyPrefix(a, b)
+}
+
+func hasKeyPrefix(keys, prefix []string) bool {
+	if len(prefix) > len(keys) {
+		return false
+	}
+	for i := range prefix {
+		if keys[i] != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// loadKeymaps makes the mappings in the config file, the keys.<mode>.<keys>
+// settings.
+func (e *Editor) loadKeymaps() {
+	e.keymaps = nil
+	var names []string
+	for key := range e.settings {
+		if strings.HasPrefix(key, "keys.") {
+			names = append(names, key)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		mode, lhs, _ := strings.Cut(strings.TrimPrefix(name, "keys."), ".")
+		if err := e.mapKeys(mode, lhs, e.settings[name], false); err != nil {
+			e.setStatusMessage(fmt.Sprintf("%s: %v", name, err))
+		}
+	}
+}
+
+// keymapMode is the mode mappings are looked up in.
+func (e *Editor) keymapMode() string {
+	switch {
+	case e.mode == "insert":
+		return "insert"
+	case e.mode == "normal" && e.treeVisible:
+		return "tree"
+	case e.mode == "normal":
+		return "normal"
+	}
+	return ""
+}
+
+// keymapsFor lists the mappings for a mode. In the file tree the normal
+// mode ones apply too, after the tree's own.
+func (e *Editor) keymapsFor(mode string) []*keymap {
+	if mode == "tree" {
+		return append(append([]*keymap{}, e.keymaps["tree"]...), e.keymaps["normal"]...)
+	}
+	return e.keymaps[mode]
+}
+
+// handleKey is where key presses come in. It applies the mappings, waiting
+// for more keys while the ones typed could still become a mapping.
+func (e *Editor) handleKey(ev *tcell.EventKey) {
+	mode := e.keymapMode()
+	if mode == "" || len(e.keymapsFor(mode)) == 0 {
+		e.flushPendingKeys()
+		e.handleInput(ev)
+		return
+	}
+	e.pendingKeys = append(e.pendingKeys, ev)
+	e.resolvePendingKeys(false)
+}
+
+// resolvePendingKeys runs a mapping for the keys waiting, or passes them on
+// if none can match. After a timeout the longest complete mapping is used.
+func (e *Editor) resolvePendingKeys(timedOut bool) {
+	for len(e.pendingKeys) > 0 {
+		mode := e.keymapMode()
+		tokens := make([]string, len(e.pendingKeys))
+		for i, ev := range e.pendingKeys {
+			tokens[i] = keyToken(ev)
+		}
+
+		var exact *keymap
+		var exactLen int
+		longer := false
+		for _, m := range e.keymapsFor(mode) {
+			switch {
+			case hasKeyPrefix(tokens, m.keys) && len(m.keys) > exactLen:
+				exact, exactLen = m, len(m.keys)
+			case len(m.keys) > len(tokens) && hasKeyPrefix(m.keys, tokens):
+				longer = true
+			}
+		}
+
+		if longer && !timedOut {
+			e.pendingGeneration++
+			generation := e.pendingGeneration
+			if e.mainQueue == nil {
+				e.mainQueue = make(chan func(), 256)
+			}
+			time.AfterFunc(time.Duration(e.intOption("timeoutLen"))*time.Millisecond, func() {
+				e.post(func() {
+					if e.pendingGeneration == generation {
+						e.resolvePendingKeys(true)
+					}
+				})
+			})
+			return
+		}
+		timedOut = false
+
+		if exact != nil && exactLen == len(tokens) {
+			e.pendingKeys = nil
+			e.runMapping(exact, 0)
+			return
+		}
+		if exact != nil {
+			// A mapping followed by keys that start nothing longer
+			e.pendingKeys = e.pendingKeys[exactLen:]
+			e.runMapping(exact, 0)
+			continue
+		}
+
+		// No mapping: the first key is typed as is, the rest looked at again
+		first := e.pendingKeys[0]
+		e.pendingKeys = e.pendingKeys[1:]
+		e.handleInput(first)
+	}
+}
+
+// flushPendingKeys types keys that were waiting for a mapping as they are.
+func (e *Editor) flushPendingKeys() {
+	pending := e.pendingKeys
+	e.pendingKeys = nil
+	e.pendingGeneration++
+	for _, ev := range pending {
+		e.handleInput(ev)
+	}
+}
+
+// runMapping types a mapping's right-hand side.
+func (e *Editor) runMapping(m *keymap, depth int) {
+	if depth >= maxMappingDepth {
+		e.setStatusMessage("Mapping is recursive: " + strings.Join(m.keys, ""))
+		return
+	}
+	keys, _ := parseKeys(m.rhs, nil)
+	e.feedKeys(keys, !m.noremap, depth+1)
+}
+
+// feedKeys types keys, through the mappings if remap is set. <Cmd>...<CR>
+// runs the command in between.
+func (e *Editor) feedKeys(keys []string, remap bool, depth int) {
+	for i := 0; i < len(keys); i++ {
+		if keys[i] == "<Cmd>" {
+			end := i + 1
+			for end < len(keys) && keys[end] != "<CR>" {
+				end++
+			}
+			var command strings.Builder
+			for _, key := range keys[i+1 : end] {
+				command.WriteRune(tokenEvent(key).Rune())
+			}
+			e.runCommand(command.String())
+			i = end
+			continue
+		}
+
+		if remap {
+			if m := e.longestMapping(keys[i:]); m != nil {
+				e.runMapping(m, depth)
+				i += len(m.keys) - 1
+				continue
+			}
+		}
+		e.handleInput(tokenEvent(keys[i]))
+	}
+}
+
+// longestMapping finds the longest mapping keys start with.
+func (e *Editor) longestMapping(keys []string) *keymap {
+	var best *keymap
+	for _, m := range e.keymapsFor(e.keymapMode()) {
+		if hasKeyPrefix(keys, m.keys) && (best == nil || len(m.keys) > len(best.keys)) {
+			best = m
+		}
+	}
+	return best
+}
+
+// runCommand runs an ex command, leaving the mode as it was unless the
+// command changes it.
+func (e *Editor) runCommand(command string) {
+	mode, buffer := e.mode, e.commandBuffer
+	e.mode = "command"
+	e.commandBuffer = command
+	e.handleCommand()
+	if e.mode == "command" {
+		e.mode = mode
+	}
+	e.commandBuffer = buffer
+}
+
+// mapCommand runs :map, :nmap, :imap, :tmap, their noremap forms and the
+// unmap ones. The first letter picks the mode, normal if there is none.
+func (e *Editor) mapCommand(command, args string) {
+	mode := "normal"
+	switch command[0] {
+	case 'i':
+		mode = "insert"
+	case 't':
+		mode = "tree"
+	}
+	if strings.HasSuffix(command, "unmap") {
+		e.unmapCommand(mode, args)
+		return
+	}
+	noremap := strings.HasSuffix(command, "noremap")
+
+	lhs, rhs, _ := strings.Cut(strings.TrimSpace(args), " ")
+	rhs = strings.TrimSpace(rhs)
+	if lhs == "" {
+		e.listMappings(mode)
+		return
+	}
+	if rhs == "" {
+		keys, err := parseKeys(lhs, e.leaderKeys())
+		if err != nil {
+			e.setStatusMessage(err.Error())
+			return
+		}
+		for _, m := range e.keymaps[mode] {
+			if sameKeys(m.keys, keys) {
+				e.setStatusMessage(fmt.Sprintf("%s %s", strings.Join(m.keys, ""), m.rhs))
+				return
+			}
+		}
+		e.setStatusMessage("No mapping found")
+		return
+	}
+	if err := e.mapKeys(mode, lhs, rhs, noremap); err != nil {
+		e.setStatusMessage(err.Error())
+		return
+	}
+	e.setStatusMessage(fmt.Sprintf("Mapped %s to %s in %s mode", lhs, rhs, mode))
+}
+
+// unmapCommand runs :unmap, :nunmap, :iunmap and :tunmap.
+func (e *Editor) unmapCommand(mode, args string) {
+	lhs := strings.TrimSpace(args)
+	if lhs == "" {
+		e.setStatusMessage("Usage: unmap <keys>")
+		return
+	}
+	if !e.unmapKeys(mode, lhs) {
+		e.setStatusMessage(fmt.Sprintf("No mapping for %s", lhs))
+		return
+	}
+	e.setStatusMessage(fmt.Sprintf("Unmapped %s", lhs))
+}
+
+func (e *Editor) listMappings(mode string) {
+	var lines []string
+	for _, m := range e.keymaps[mode] {
+		kind := ""
+		if m.noremap {
+			kind = "*"
+		}
+		lines = append(lines, fmt.Sprintf("%-12s %1s %s", strings.Join(m.keys, ""), kind, m.rhs))
+	}
+	if len(lines) == 0 {
+		e.setStatusMessage(fmt.Sprintf("No %s mode mappings", mode))
+		return
+	}
+	e.showPopup(fmt.Sprintf("Mappings (%s mode)", mode), lines)
+}
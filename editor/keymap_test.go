@@ -0,0 +1,161 @@
+package editor
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+func TestParseKeys(t *testing.T) {
+	tests := []struct {
+		keys string
+		want string
+	}{
+		{"jk", "j k"},
+		{"<leader>ff", "<Space> f f"},
+		{"<c-S><C-h><c-]>", "<C-s> <BS> <C-]>"},
+		{"<cr><Esc><S-Tab><F5>", "<CR> <Esc> <S-Tab> <F5>"},
+		{"<lt>a<", "<lt> a <lt>"},
+		{"<M-x>", "<A-x>"},
+		{"<Cmd>w<CR>", "<Cmd> w <CR>"},
+		{"a <b>", "a <Space> <lt> b >"},
+	}
+	for _, test := range tests {
+		keys, err := parseKeys(test.keys, []string{"<Space>"})
+		if err != nil || strings.Join(keys, " ") != test.want {
+			t.Errorf("parseKeys(%q) = %q, %v; want %q", test.keys, keys, err, test.want)
+		}
+		for _, key := range keys {
+			if key != "<Cmd>" && keyToken(tokenEvent(key)) != key {
+				t.Errorf("%s reads back as %s", key, keyToken(tokenEvent(key)))
+			}
+		}
+	}
+	if _, err := parseKeys("<Nope>", nil); err == nil {
+		t.Errorf("unknown key accepted")
+	}
+}
+
+func typeKeys(ed *Editor, keys string) {
+	parsed, _ := parseKeys(keys, nil)
+	for _, key := range parsed {
+		ed.handleKey(tokenEvent(key))
+	}
+}
+
+func TestKeymaps(t *testing.T) {
+	ed := &Editor{
+		filename: "notes.txt",
+		mode:     "insert",
+		lines:    []string{""},
+		settings: map[string]string{"autoComplete": "false", "timeoutLen": "20", "leader": "<Space>"},
+	}
+	ed.applySettings()
+	ed.mapCommand("imap", "jk <Esc>")
+	ed.mapCommand("nmap", "<leader>t <Cmd>set ts=2<CR>")
+	ed.mapCommand("nnoremap", "x i[<Esc>")
+
+	typeKeys(ed, "ajk")
+	if ed.mode != "normal" || ed.lines[0] != "a" {
+		t.Fatalf("jk: mode %s, line %q", ed.mode, ed.lines[0])
+	}
+
+	typeKeys(ed, "<Space>t")
+	if ed.tabSize != 2 || ed.mode != "normal" {
+		t.Errorf("<leader>t: tabSize %d, mode %s", ed.tabSize, ed.mode)
+	}
+
+	typeKeys(ed, "x")
+	if ed.mode != "normal" || ed.lines[0] != "a[" {
+		t.Errorf("x: mode %s, line %q", ed.mode, ed.lines[0])
+	}
+
+	// A key that starts no mapping goes through with the one before it
+	ed.mode = "insert"
+	ed.cursorX = 0
+	typeKeys(ed, "jx")
+	if ed.lines[0] != "jxa[" || len(ed.pendingKeys) != 0 {
+		t.Errorf("jx: line %q", ed.lines[0])
+	}
+
+	// A lone j is typed when the wait runs out
+	typeKeys(ed, "j")
+	if ed.lines[0] != "jxa[" {
+		t.Errorf("j typed before the timeout: %q", ed.lines[0])
+	}
+	time.Sleep(50 * time.Millisecond)
+	ed.runQueued()
+	if ed.lines[0] != "jxja[" {
+		t.Errorf("j after the timeout: %q", ed.lines[0])
+	}
+
+	ed.mapCommand("iunmap", "jk")
+	typeKeys(ed, "jk")
+	if ed.mode != "insert" || ed.lines[0] != "jxjjka[" {
+		t.Errorf("after iunmap: mode %s, line %q", ed.mode, ed.lines[0])
+	}
+
+	// Mappings that run each other stop
+	ed.mode = "normal"
+	ed.mapCommand("nmap", "a b")
+	ed.mapCommand("nmap", "b a")
+	typeKeys(ed, "a")
+	if !strings.HasPrefix(ed.statusMessage, "Mapping is recursive") {
+		t.Errorf("recursive mapping: %q", ed.statusMessage)
+	}
+}
+
+func TestKeymapConfig(t *testing.T) {
+	settings, errs := parseConfig(`
+[keys.insert]
+jk = "<Esc>"
+
+[keys.normal]
+"<leader>w" = "<Cmd>w<CR>"
+"g." = "<Nope>"
+
+[keys.visual]
+v = "V"
+`)
+	if settings["keys.insert.jk"] != "<Esc>" || settings["keys.normal.<leader>w"] != "<Cmd>w<CR>" {
+		t.Errorf("settings = %v", settings)
+	}
+	if len(errs) != 2 {
+		t.Errorf("errors = %v", errs)
+	}
+
+	ed := &Editor{settings: settings}
+	ed.loadKeymaps()
+	if len(ed.keymaps["insert"]) != 1 || strings.Join(ed.keymaps["normal"][0].keys, "") != `\w` {
+		t.Errorf("keymaps = %v", ed.keymaps)
+	}
+
+	// A project can't map keys
+	dir := t.TempDir()
+	writeTestFile(t, dir, projectConfigName, "[keys.normal]\nx = \"dd\"\n")
+	if _, errs := loadProjectConfig(filepath.Join(dir, projectConfigName)); len(errs) != 1 {
+		t.Errorf("project mapping allowed: %v", errs)
+	}
+}
+
+func TestKeyToken(t *testing.T) {
+	tests := []struct {
+		ev   *tcell.EventKey
+		want string
+	}{
+		{tcell.NewEventKey(tcell.KeyRune, 'x', tcell.ModNone), "x"},
+		{tcell.NewEventKey(tcell.KeyRune, ' ', tcell.ModNone), "<Space>"},
+		{tcell.NewEventKey(tcell.KeyRune, 'x', tcell.ModAlt), "<A-x>"},
+		{tcell.NewEventKey(tcell.KeyCtrlS, 0, tcell.ModCtrl), "<C-s>"},
+		{tcell.NewEventKey(tcell.KeyEnter, 0, tcell.ModNone), "<CR>"},
+		{tcell.NewEventKey(tcell.KeyF12, 0, tcell.ModNone), "<F12>"},
+	}
+	for _, test := range tests {
+		if got := keyToken(test.ev); got != test.want {
+			t.Errorf("keyToken(%s) = %s, want %s", test.ev.Name(), got, test.want)
+		}
+	}
+}
@@ -0,0 +1,438 @@
+package editor
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf16"
+)
+
+// Language Server Protocol client.
+//
+// Each language gets one server process, spoken to with JSON-RPC over its
+// stdin and stdout. A goroutine reads the server's messages and hands
+// responses and notifications to the editor's goroutine through post, so
+// all editor state is only touched from the main loop.
+
+// Servers started for each language unless the lsp.<language> setting
+// names another command (an empty setting turns the server off).
+var lspServers = map[string][]string{
+	"go":         {"gopls"},
+	"python":     {"pyright-langserver", "--stdio"},
+	"rust":       {"rust-analyzer"},
+	"javascript": {"typescript-language-server", "--stdio"},
+	"typescript": {"typescript-language-server", "--stdio"},
+	"c":          {"clangd"},
+	"cpp":        {"clangd"},
+}
+
+// LSP language ids for chroma lexer names
+var lspLanguageIDs = map[string]string{
+	"Go":         "go",
+	"Python":     "python",
+	"Python 3":   "python",
+	"Rust":       "rust",
+	"JavaScript": "javascript",
+	"TypeScript": "typescript",
+	"C":          "c",
+	"C++":        "cpp",
+}
+
+type lspError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *lspError) Error() string {
+	return fmt.Sprintf("%s (%d)", e.Message, e.Code)
+}
+
+type lspMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      *int            `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *lspError       `json:"error,omitempty"`
+}
+
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"` // UTF-16 code units
+}
+
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End   lspPosition `json:"end"`
+}
+
+type lspLocation struct {
+	URI   string   `json:"uri"`
+	Range lspRange `json:"range"`
+}
+
+type lspTextEdit struct {
+	Range   lspRange `json:"range"`
+	NewText string   `json:"newText"`
+}
+
+type lspDiagnostic struct {
+	Range    lspRange `json:"range"`
+	Severity int      `json:"severity"` // 1 error, 2 warning, 3 info, 4 hint
+	Source   string   `json:"source"`
+	Message  string   `json:"message"`
+}
+
+type lspClient struct {
+	language string
+	root     string
+	cmd      *exec.Cmd
+	stdin    io.WriteCloser
+	post     func(func()) // runs a function on the editor's goroutine
+
+	// Called on the editor's goroutine, set before start
+	onNotification func(method string, params json.RawMessage)
+	onExit         func(err error)
+
+	writeMu sync.Mutex // keeps messages to stdin whole; never held with mu
+
+	mu         sync.Mutex
+	nextID     int
+	pending    map[int]func(json.RawMessage, *lspError)
+	ready      bool     // initialize has completed
+	queued     [][]byte // messages held back until then
+	closed     bool
+	stopped    bool
+	shutdownID int
+	shutdown   chan struct{} // closed when stop's shutdown request is answered
+	exited     chan struct{} // closed when the process has gone
+
+	// From the initialize response
+	syncKind          int // 1 full, 2 incremental
+	triggerCharacters []string
+}
+
+// start launches the server and begins the initialize handshake. Requests
+// made before it completes are sent once it has.
+func (c *lspClient) start(command []string) error {
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Dir = c.root
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	c.cmd = cmd
+	c.stdin = stdin
+	c.pending = make(map[int]func(json.RawMessage, *lspError))
+	c.syncKind = 2
+	c.exited = make(chan struct{})
+	go c.readLoop(bufio.NewReader(stdout))
+
+	params := map[string]any{
+		"processId": os.Getpid(),
+		"rootUri":   fileURI(c.root),
+		"capabilities": map[string]any{
+			"textDocument": map[string]any{
+				"synchronization": map[string]any{"didSave": true},
+				"completion": map[string]any{
+					"completionItem": map[string]any{"snippetSupport": true},
+				},
+				"hover":              map[string]any{"contentFormat": []string{"plaintext", "markdown"}},
+				"definition":         map[string]any{},
+				"references":         map[string]any{},
+				"rename":             map[string]any{},
+				"formatting":         map[string]any{},
+				"publishDiagnostics": map[string]any{},
+			},
+			"general": map[string]any{"positionEncodings": []string{"utf-16"}},
+		},
+		"workspaceFolders": []map[string]any{{"uri": fileURI(c.root), "name": filepath.Base(c.root)}},
+	}
+	c.send("initialize", params, true, func(result json.RawMessage, err *lspError) {
+		if err != nil {
+			c.stop()
+			return
+		}
+		c.readCapabilities(result)
+		c.send("initialized", map[string]any{}, true, nil)
+
+		c.mu.Lock()
+		c.ready = true
+		queued := c.queued
+		c.queued = nil
+		c.mu.Unlock()
+		for _, msg := range queued {
+			c.write(msg)
+		}
+	})
+	return nil
+}
+
+func (c *lspClient) readCapabilities(result json.RawMessage) {
+	var init struct {
+		Capabilities struct {
+			TextDocumentSync   json.RawMessage `json:"textDocumentSync"`
+			CompletionProvider *struct {
+				TriggerCharacters []string `json:"triggerCharacters"`
+			} `json:"completionProvider"`
+		} `json:"capabilities"`
+	}
+	if json.Unmarshal(result, &init) != nil {
+		return
+	}
+
+	// textDocumentSync is either a kind or an options object
+	var kind int
+	var options struct {
+		Change int `json:"change"`
+	}
+	if json.Unmarshal(init.Capabilities.TextDocumentSync, &kind) == nil {
+		c.syncKind = kind
+	} else if json.Unmarshal(init.Capabilities.TextDocumentSync, &options) == nil {
+		c.syncKind = options.Change
+	}
+	if init.Capabilities.CompletionProvider != nil {
+		c.triggerCharacters = init.Capabilities.CompletionProvider.TriggerCharacters
+	}
+}
+
+// request sends a request; callback runs on the editor's goroutine.
+func (c *lspClient) request(method string, params any, callback func(json.RawMessage, *lspError)) {
+	c.send(method, params, false, callback)
+}
+
+// notify sends a notification.
+func (c *lspClient) notify(method string, params any) {
+	c.send(method, params, false, nil)
+}
+
+func (c *lspClient) send(method string, params any, immediate bool, callback func(json.RawMessage, *lspError)) {
+	msg := lspMessage{JSONRPC: "2.0", Method: method}
+	if params != nil {
+		raw, err := json.Marshal(params)
+		if err != nil {
+			return
+		}
+		msg.Params = raw
+	}
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return
+	}
+	if callback != nil {
+		c.nextID++
+		id := c.nextID
+		msg.ID = &id
+		c.pending[id] = callback
+	}
+	data, _ := json.Marshal(msg)
+	if !immediate && !c.ready {
+		c.queued = append(c.queued, data)
+		c.mu.Unlock()
+		return
+	}
+	c.mu.Unlock()
+	c.write(data)
+}
+
+// write sends one message. It may block until the server reads, so it
+// holds writeMu only; the read loop needs mu meanwhile to hand out
+// responses, or both pipes could fill up.
+func (c *lspClient) write(data []byte) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	fmt.Fprintf(c.stdin, "Content-Length: %d\r\n\r\n", len(data))
+	c.stdin.Write(data)
+}
+
+func (c *lspClient) readLoop(r *bufio.Reader) {
+	for {
+		data, err := readLSPMessage(r)
+		if err != nil {
+			c.mu.Lock()
+			c.closed = true
+			c.mu.Unlock()
+			if c.onExit != nil {
+				c.post(func() { c.onExit(err) })
+			}
+			// Everything has been read, so the process can be reaped
+			c.cmd.Wait()
+			close(c.exited)
+			return
+		}
+
+		var msg lspMessage
+		if json.Unmarshal(data, &msg) != nil {
+			continue
+		}
+		switch {
+		case msg.ID != nil && msg.Method != "":
+			// Not here, as the write can wait for the server to read
+			go c.replyToServer(*msg.ID, msg.Method, msg.Params)
+		case msg.ID != nil:
+			c.mu.Lock()
+			callback := c.pending[*msg.ID]
+			delete(c.pending, *msg.ID)
+			// stop waits for shutdown's answer here, as the main loop
+			// may not be running to take it
+			var answered chan struct{}
+			if c.shutdown != nil && *msg.ID == c.shutdownID {
+				answered, c.shutdown = c.shutdown, nil
+			}
+			c.mu.Unlock()
+			if answered != nil {
+				close(answered)
+			}
+			if callback != nil {
+				result, rpcErr := msg.Result, msg.Error
+				c.post(func() { callback(result, rpcErr) })
+			}
+		case c.onNotification != nil:
+			method, params := msg.Method, msg.Params
+			c.post(func() { c.onNotification(method, params) })
+		}
+	}
+}
+
+// replyToServer answers requests the server makes of us. We don't offer
+// any settings, so configuration requests get nulls.
+func (c *lspClient) replyToServer(id int, method string, params json.RawMessage) {
+	var result any
+	if method == "workspace/configuration" {
+		var p struct {
+			Items []json.RawMessage `json:"items"`
+		}
+		json.Unmarshal(params, &p)
+		result = make([]any, len(p.Items))
+	}
+	raw, _ := json.Marshal(result)
+	data, _ := json.Marshal(lspMessage{JSONRPC: "2.0", ID: &id, Result: raw})
+	c.write(data)
+}
+
+func readLSPMessage(r *bufio.Reader) ([]byte, error) {
+	length := -1
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		if name, value, ok := strings.Cut(line, ":"); ok && strings.EqualFold(name, "Content-Length") {
+			if length, err = strconv.Atoi(strings.TrimSpace(value)); err != nil {
+				return nil, fmt.Errorf("bad Content-Length %q", value)
+			}
+		}
+	}
+	if length < 0 {
+		return nil, fmt.Errorf("message without Content-Length")
+	}
+	data := make([]byte, length)
+	_, err := io.ReadFull(r, data)
+	return data, err
+}
+
+// stop asks the server to shut down and makes sure the process goes away.
+// It returns at once: exit is sent when shutdown is answered, or after a
+// second without an answer, and the process is killed if it hasn't gone
+// two seconds on.
+func (c *lspClient) stop() {
+	c.mu.Lock()
+	if c.stopped {
+		c.mu.Unlock()
+		return
+	}
+	c.stopped = true
+	ready := c.ready && !c.closed
+	c.closed = true
+	var shutdown []byte
+	var answered chan struct{}
+	if ready {
+		c.nextID++
+		id := c.nextID
+		c.shutdownID = id
+		shutdown, _ = json.Marshal(lspMessage{JSONRPC: "2.0", ID: &id, Method: "shutdown"})
+		answered = make(chan struct{})
+		c.shutdown = answered
+	}
+	c.mu.Unlock()
+
+	go func() {
+		if ready {
+			c.write(shutdown)
+			select {
+			case <-answered:
+			case <-c.exited:
+			case <-time.After(time.Second):
+			}
+			exit, _ := json.Marshal(lspMessage{JSONRPC: "2.0", Method: "exit"})
+			c.write(exit)
+		}
+		c.stdin.Close()
+	}()
+	go func() {
+		select {
+		case <-c.exited:
+		case <-time.After(2 * time.Second):
+			c.cmd.Process.Kill()
+		}
+	}()
+}
+
+func fileURI(path string) string {
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String()
+}
+
+func uriToPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return ""
+	}
+	return filepath.FromSlash(u.Path)
+}
+
+// utf16Column converts a byte offset in line to UTF-16 code units.
+func utf16Column(line string, col int) int {
+	if col > len(line) {
+		col = len(line)
+	}
+	n := 0
+	for _, r := range line[:col] {
+		n += utf16.RuneLen(r)
+	}
+	return n
+}
+
+// byteColumn converts a UTF-16 column in line to a byte offset.
+func byteColumn(line string, character int) int {
+	units := 0
+	for i, r := range line {
+		if units >= character {
+			return i
+		}
+		units += utf16.RuneLen(r)
+	}
+	return len(line)
+}
@@ -0,0 +1,169 @@
+package editor
+
+import (
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// startFakeLSP opens src as main.go with the fake server from
+// testdata/fakelsp as its language server.
+func startFakeLSP(t *testing.T, src string) *Editor {
+	t.Helper()
+	dir := t.TempDir()
+	bin := filepath.Join(dir, "fakelsp")
+	if out, err := exec.Command("go", "build", "-o", bin, "./testdata/fakelsp").CombinedOutput(); err != nil {
+		t.Skipf("cannot build fake server: %v\n%s", err, out)
+	}
+	writeTestFile(t, dir, "main.go", src)
+
+	ed := &Editor{lines: []string{""}, tabSize: 4, settings: map[string]string{"lsp.go": bin}}
+	if err := ed.openFile(filepath.Join(dir, "main.go")); err != nil {
+		t.Fatal(err)
+	}
+	if ed.syncLSP() == nil {
+		t.Fatalf("server did not start: %s", ed.statusMessage)
+	}
+	t.Cleanup(ed.stopLSP)
+	return ed
+}
+
+// waitFor runs the server's responses until cond holds.
+func waitFor(t *testing.T, ed *Editor, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+		ed.runQueued()
+	}
+}
+
+func TestLSPClient(t *testing.T) {
+	ed := startFakeLSP(t, "package main\n\nfunc helper() {}\n\nfunc main() {\n\thelper() // TODO\n}\n")
+
+	waitFor(t, ed, "diagnostics", func() bool { return len(ed.currentDiagnostics()) == 1 })
+	if d := lineDiagnostic(ed.currentDiagnostics(), 5); d == nil || d.Severity != 2 {
+		t.Errorf("expected a warning on line 6, got %+v", ed.currentDiagnostics())
+	}
+
+	// A trigger character asks the server; typing more filters its answer
+	ed.mode = "insert"
+	ed.lines = append(ed.lines[:6], append([]string{"\tx"}, ed.lines[6:]...)...)
+	ed.cursorY, ed.cursorX = 6, 2
+	ed.handleInsertMode(tcell.NewEventKey(tcell.KeyRune, '.', tcell.ModNone))
+	waitFor(t, ed, "completions", func() bool { return ed.completionActive })
+	ed.handleInsertMode(tcell.NewEventKey(tcell.KeyRune, 'B', tcell.ModNone))
+	if texts := completionTexts(ed.completions); len(texts) == 0 || texts[0] != "fakeBeta" {
+		t.Fatalf("completions = %v", texts)
+	}
+	ed.handleInsertMode(tcell.NewEventKey(tcell.KeyEnter, 0, tcell.ModNone))
+	if ed.lines[6] != "\tx.fakeBeta" {
+		t.Errorf("accepted completion: %q", ed.lines[6])
+	}
+	ed.mode = "normal"
+
+	ed.cursorY, ed.cursorX = 2, 6
+	ed.hover()
+	waitFor(t, ed, "hover", func() bool { return ed.popup != nil })
+	if ed.popup.lines[0] != "hover: helper" {
+		t.Errorf("hover = %q", ed.popup.lines)
+	}
+
+	ed.cursorY, ed.cursorX = 5, 3
+	ed.gotoDefinition()
+	waitFor(t, ed, "definition", func() bool { return ed.cursorY == 2 })
+	if ed.cursorX != 5 {
+		t.Errorf("definition at column %d, want 5", ed.cursorX)
+	}
+
+	ed.findReferences()
+	waitFor(t, ed, "references", func() bool { return len(ed.locations) == 2 })
+	ed.nextLocation(1)
+	if ed.cursorY != 5 || ed.cursorX != 1 {
+		t.Errorf(":cn moved to %d,%d", ed.cursorY, ed.cursorX)
+	}
+
+	ed.rename("assist")
+	waitFor(t, ed, "rename", func() bool { return strings.Contains(ed.lines[2], "assist") })
+	if ed.lines[5] != "\tassist() // TODO" || !ed.isDirty {
+		t.Errorf("rename left %q", ed.lines[5])
+	}
+
+	ed.lines[0] = "package main   "
+	ed.format()
+	waitFor(t, ed, "formatting", func() bool { return ed.lines[0] == "package main" })
+	ed.undo()
+	if ed.lines[0] != "package main   " {
+		t.Errorf("undo after formatting: %q", ed.lines[0])
+	}
+}
+
+func TestLSPStop(t *testing.T) {
+	ed := startFakeLSP(t, "package main\n")
+	c := ed.lspClients["go"]
+	waitFor(t, ed, "initialize", func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.ready
+	})
+
+	// stop doesn't wait for the server, which exits by itself after
+	// shutdown and exit rather than being killed
+	start := time.Now()
+	ed.stopLSP()
+	if d := time.Since(start); d > 100*time.Millisecond {
+		t.Errorf("stop took %v", d)
+	}
+	select {
+	case <-c.exited:
+	case <-time.After(5 * time.Second):
+		t.Fatal("server still running")
+	}
+	if !c.cmd.ProcessState.Success() {
+		t.Errorf("server %v", c.cmd.ProcessState)
+	}
+}
+
+func TestPostOverflow(t *testing.T) {
+	ed := &Editor{mainQueue: make(chan func(), 4)}
+	var got []int
+	done := make(chan struct{})
+	go func() {
+		for i := range 10 {
+			ed.post(func() { got = append(got, i) })
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("post blocked on a full queue")
+	}
+	ed.runQueued()
+	if len(got) != 10 || !slices.IsSorted(got) {
+		t.Errorf("ran %v", got)
+	}
+}
+
+func TestApplyTextEdits(t *testing.T) {
+	lines := []string{"héllo wörld", "second"}
+	edits := []lspTextEdit{
+		{Range: lspRange{Start: lspPosition{0, 6}, End: lspPosition{0, 11}}, NewText: "there"},
+		{Range: lspRange{Start: lspPosition{0, 0}, End: lspPosition{0, 0}}, NewText: "// "},
+		{Range: lspRange{Start: lspPosition{0, 0}, End: lspPosition{0, 0}}, NewText: "x "},
+		{Range: lspRange{Start: lspPosition{0, 11}, End: lspPosition{1, 0}}, NewText: "\nnew\n"},
+	}
+	got := applyTextEdits(lines, edits)
+	want := []string{"// x héllo there", "new", "second"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("applyTextEdits = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,845 @@
+package editor
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// Editor side of the LSP client: opening documents and keeping the server's
+// copy in sync, and the features built on top (completion, diagnostics,
+// hover, definition, references, rename and formatting).
+
+// lspDocument is a file the server has been told about.
+type lspDocument struct {
+	uri     string
+	client  *lspClient
+	version int
+	text    []string // lines as last sent to the server
+}
+
+// lspCompletionCache holds the server's answer for one completion position,
+// so typing more of the word filters it locally instead of asking again.
+type lspCompletionCache struct {
+	uri         string
+	line, start int
+	items       []Completion
+	ready       bool // the response has arrived but the popup hasn't seen it
+	forced      bool // open the popup with the results
+}
+
+// post runs fn on the editor's goroutine, waking the event loop up. It
+// never blocks: a server's reader waiting on a full queue while the editor
+// waits to write to that server would hang both, so once the queue is full
+// work goes on the overflow list, in order, until the main loop drains it.
+func (e *Editor) post(fn func()) {
+	e.overflowMu.Lock()
+	queued := false
+	if len(e.overflow) == 0 {
+		select {
+		case e.mainQueue <- fn:
+			queued = true
+		default:
+		}
+	}
+	if !queued {
+		e.overflow = append(e.overflow, fn)
+	}
+	e.overflowMu.Unlock()
+	if e.screen != nil {
+		e.screen.PostEvent(tcell.NewEventInterrupt(nil))
+	}
+}
+
+// runQueued runs the functions posted by the language servers.
+func (e *Editor) runQueued() {
+	for {
+		select {
+		case fn := <-e.mainQueue:
+			fn()
+			continue
+		default:
+		}
+		e.overflowMu.Lock()
+		overflow := e.overflow
+		e.overflow = nil
+		e.overflowMu.Unlock()
+		if len(overflow) == 0 {
+			e.updateLSPCompletions()
+			return
+		}
+		for _, fn := range overflow {
+			fn()
+		}
+	}
+}
+
+// lspClientFor returns the server for path's language, starting it the
+// first time.
+func (e *Editor) lspClientFor(path string) (*lspClient, string) {
+	lexer := e.currentLexer()
+	if lexer == nil {
+		return nil, ""
+	}
+	language := lspLanguageIDs[lexer.Config().Name]
+	if language == "" {
+		return nil, ""
+	}
+	if c := e.lspClients[language]; c != nil {
+		return c, language
+	}
+	if e.lspFailed[language] {
+		return nil, ""
+	}
+
+	command := lspServers[language]
+	if value, ok := e.settings["lsp."+language]; ok {
+		command = strings.Fields(value)
+	}
+	if e.lspFailed == nil {
+		e.lspFailed = make(map[string]bool)
+	}
+	if len(command) == 0 {
+		e.lspFailed[language] = true
+		return nil, ""
+	}
+	if _, err := exec.LookPath(command[0]); err != nil {
+		e.lspFailed[language] = true
+		return nil, ""
+	}
+
+	root := filepath.Dir(path)
+	if repo := findGitRepo(root); repo != nil {
+		root = repo.root
+	}
+	if e.mainQueue == nil {
+		e.mainQueue = make(chan func(), 256)
+	}
+	c := &lspClient{language: language, root: root, post: e.post}
+	c.onNotification = e.handleLSPNotification
+	c.onExit = func(error) { e.lspExited(c) }
+	if err := c.start(command); err != nil {
+		e.lspFailed[language] = true
+		e.setStatusMessage(fmt.Sprintf("Could not start %s: %v", command[0], err))
+		return nil, ""
+	}
+	if e.lspClients == nil {
+		e.lspClients = make(map[string]*lspClient)
+	}
+	e.lspClients[language] = c
+	return c, language
+}
+
+// lspExited forgets a server that went away, along with its documents.
+func (e *Editor) lspExited(c *lspClient) {
+	if e.lspClients[c.language] != c {
+		return
+	}
+	delete(e.lspClients, c.language)
+	e.lspFailed[c.language] = true
+	for path, doc := range e.lspDocs {
+		if doc.client == c {
+			delete(e.lspDocs, path)
+			delete(e.diagnostics, doc.uri)
+		}
+	}
+	e.setStatusMessage(fmt.Sprintf("Language server for %s exited", c.language))
+}
+
+// stopLSP shuts all servers down.
+func (e *Editor) stopLSP() {
+	for _, c := range e.lspClients {
+		c.stop()
+	}
+	e.lspClients = nil
+	e.lspDocs = nil
+}
+
+func documentText(lines []string) string {
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// currentDocument returns the current buffer's document if it is open.
+func (e *Editor) currentDocument() *lspDocument {
+	if e.filename == "" {
+		return nil
+	}
+	path, _ := filepath.Abs(e.filename)
+	return e.lspDocs[path]
+}
+
+// syncLSP opens the current buffer with its language server and sends any
+// changes made since the last call. It returns nil if there is no server.
+func (e *Editor) syncLSP() *lspDocument {
+	if e.filename == "" || e.readOnly {
+		return nil
+	}
+	path, _ := filepath.Abs(e.filename)
+	if doc := e.lspDocs[path]; doc != nil {
+		e.syncDocument(doc)
+		return doc
+	}
+
+	client, language := e.lspClientFor(path)
+	if client == nil {
+		return nil
+	}
+	doc := &lspDocument{uri: fileURI(path), client: client, version: 1, text: append([]string{}, e.lines...)}
+	if e.lspDocs == nil {
+		e.lspDocs = make(map[string]*lspDocument)
+	}
+	e.lspDocs[path] = doc
+	client.notify("textDocument/didOpen", map[string]any{
+		"textDocument": map[string]any{
+			"uri":        doc.uri,
+			"languageId": language,
+			"version":    doc.version,
+			"text":       documentText(e.lines),
+		},
+	})
+	return doc
+}
+
+// syncDocument sends the lines that changed between the server's copy and
+// the buffer as a single edit.
+func (e *Editor) syncDocument(doc *lspDocument) {
+	old, lines := doc.text, e.lines
+	prefix := 0
+	for prefix < len(old) && prefix < len(lines) && old[prefix] == lines[prefix] {
+		prefix++
+	}
+	if prefix == len(old) && prefix == len(lines) {
+		return
+	}
+	suffix := 0
+	for suffix < len(old)-prefix && suffix < len(lines)-prefix &&
+		old[len(old)-1-suffix] == lines[len(lines)-1-suffix] {
+		suffix++
+	}
+
+	var change map[string]any
+	switch doc.client.syncKind {
+	case 0:
+		return
+	case 1:
+		change = map[string]any{"text": documentText(lines)}
+	default:
+		var text strings.Builder
+		for _, line := range lines[prefix : len(lines)-suffix] {
+			text.WriteString(line + "\n")
+		}
+		change = map[string]any{
+			"range": lspRange{
+				Start: lspPosition{Line: prefix},
+				End:   lspPosition{Line: len(old) - suffix},
+			},
+			"text": text.String(),
+		}
+	}
+
+	doc.version++
+	doc.text = append([]string{}, lines...)
+	doc.client.notify("textDocument/didChange", map[string]any{
+		"textDocument":   map[string]any{"uri": doc.uri, "version": doc.version},
+		"contentChanges": []any{change},
+	})
+}
+
+// lspSaved tells the server the current buffer was written.
+func (e *Editor) lspSaved() {
+	if doc := e.syncLSP(); doc != nil {
+		doc.client.notify("textDocument/didSave", map[string]any{
+			"textDocument": map[string]any{"uri": doc.uri},
+		})
+	}
+}
+
+// lspSavedFile tells the server a buffer other than the current one was
+// saved.
+func (e *Editor) lspSavedFile(filename string) {
+	path, _ := filepath.Abs(filename)
+	if doc := e.lspDocs[path]; doc != nil {
+		doc.client.notify("textDocument/didSave", map[string]any{
+			"textDocument": map[string]any{"uri": doc.uri},
+		})
+	}
+}
+
+// lspClose tells the server a file is no longer being edited.
+func (e *Editor) lspClose(filename string) {
+	if filename == "" {
+		return
+	}
+	path, _ := filepath.Abs(filename)
+	doc := e.lspDocs[path]
+	if doc == nil {
+		return
+	}
+	delete(e.lspDocs, path)
+	delete(e.diagnostics, doc.uri)
+	doc.client.notify("textDocument/didClose", map[string]any{
+		"textDocument": map[string]any{"uri": doc.uri},
+	})
+}
+
+func (e *Editor) handleLSPNotification(method string, params json.RawMessage) {
+	switch method {
+	case "textDocument/publishDiagnostics":
+		var p struct {
+			URI         string          `json:"uri"`
+			Diagnostics []lspDiagnostic `json:"diagnostics"`
+		}
+		if json.Unmarshal(params, &p) != nil {
+			return
+		}
+		if e.diagnostics == nil {
+			e.diagnostics = make(map[string][]lspDiagnostic)
+		}
+		if len(p.Diagnostics) == 0 {
+			delete(e.diagnostics, p.URI)
+			return
+		}
+		// Most severe last, so it is drawn on top
+		sort.SliceStable(p.Diagnostics, func(i, j int) bool {
+			return severityRank(p.Diagnostics[i].Severity) > severityRank(p.Diagnostics[j].Severity)
+		})
+		e.diagnostics[p.URI] = p.Diagnostics
+	case "window/showMessage":
+		var p struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(params, &p) == nil {
+			e.setStatusMessage(p.Message)
+		}
+	}
+}
+
+// severityRank orders severities with errors first; servers may leave the
+// severity out, which counts as an error.
+func severityRank(severity int) int {
+	if severity < 1 || severity > 4 {
+		return 1
+	}
+	return severity
+}
+
+var severityNames = map[int]string{1: "error", 2: "warning", 3: "info", 4: "hint"}
+
+// currentDiagnostics returns the diagnostics for the current buffer.
+func (e *Editor) currentDiagnostics() []lspDiagnostic {
+	if len(e.diagnostics) == 0 || e.filename == "" {
+		return nil
+	}
+	path, _ := filepath.Abs(e.filename)
+	return e.diagnostics[fileURI(path)]
+}
+
+// lineDiagnostic returns the most severe diagnostic starting on line y.
+func lineDiagnostic(diagnostics []lspDiagnostic, y int) *lspDiagnostic {
+	var found *lspDiagnostic
+	for i := range diagnostics {
+		if diagnostics[i].Range.Start.Line == y {
+			found = &diagnostics[i]
+		}
+	}
+	return found
+}
+
+// markDiagnostics underlines the ranges diagnostics cover on line y.
+func (e *Editor) markDiagnostics(diagnostics []lspDiagnostic, y int, styles []tcell.Style) []tcell.Style {
+	line := e.lines[y]
+	theme := e.currentTheme()
+	for _, d := range diagnostics {
+		if y < d.Range.Start.Line || y > d.Range.End.Line {
+			continue
+		}
+		from, to := 0, len(line)
+		if y == d.Range.Start.Line {
+			from = byteColumn(line, d.Range.Start.Character)
+		}
+		if y == d.Range.End.Line {
+			to = byteColumn(line, d.Range.End.Character)
+		}
+		if to <= from {
+			// Empty ranges mark the character after them
+			to = min(from+1, len(line))
+		}
+		key := "diagnostic." + severityNames[severityRank(d.Severity)]
+		for x := from; x < to && x < len(styles); x++ {
+			styles[x] = theme.Over(key, styles[x])
+		}
+	}
+	return styles
+}
+
+func diagnosticText(d *lspDiagnostic) string {
+	text := severityNames[severityRank(d.Severity)] + ": " + strings.ReplaceAll(d.Message, "\n", " ")
+	if d.Source != "" {
+		text = d.Source + " " + text
+	}
+	return text
+}
+
+// lspCompletions offers the server's completions. The first call for a
+// position sends a request; the popup is refreshed when the answer comes.
+func (e *Editor) lspCompletions(ctx *completionContext) []Completion {
+	doc := e.currentDocument()
+	if doc == nil {
+		return nil
+	}
+	cache := e.lspCompletion
+	if cache == nil || cache.uri != doc.uri || cache.line != e.cursorY || cache.start != ctx.wordStart {
+		if ctx.word != "" {
+			e.requestLSPCompletion(doc, ctx.wordStart, false)
+		}
+		return nil
+	}
+	ctx.afterTrigger = cache.forced
+	return cache.items
+}
+
+// lspTriggerCompletion asks for completions after a character the server
+// listed as a trigger, such as '.'.
+func (e *Editor) lspTriggerCompletion(r rune) {
+	doc := e.currentDocument()
+	if doc == nil {
+		return
+	}
+	for _, trigger := range doc.client.triggerCharacters {
+		if trigger == string(r) {
+			e.requestLSPCompletion(doc, e.cursorX, true)
+			return
+		}
+	}
+}
+
+func (e *Editor) requestLSPCompletion(doc *lspDocument, start int, forced bool) {
+	cache := &lspCompletionCache{uri: doc.uri, line: e.cursorY, start: start, forced: forced}
+	e.lspCompletion = cache
+	e.syncDocument(doc)
+	doc.client.request("textDocument/completion", e.positionParams(doc), func(result json.RawMessage, err *lspError) {
+		if err != nil || e.lspCompletion != cache {
+			return
+		}
+		cache.items = parseCompletionItems(result, start)
+		cache.ready = true
+	})
+}
+
+// updateLSPCompletions shows completions that arrived while typing.
+func (e *Editor) updateLSPCompletions() {
+	cache := e.lspCompletion
+	if cache == nil || !cache.ready {
+		return
+	}
+	cache.ready = false
+	if e.mode != "insert" || cache.line != e.cursorY || len(cache.items) == 0 {
+		return
+	}
+	if cache.forced || e.completionActive {
+		e.showCompletions()
+	} else {
+		e.refreshCompletions()
+	}
+}
+
+func parseCompletionItems(result json.RawMessage, start int) []Completion {
+	type item struct {
+		Label            string       `json:"label"`
+		Detail           string       `json:"detail"`
+		InsertText       string       `json:"insertText"`
+		InsertTextFormat int          `json:"insertTextFormat"`
+		TextEdit         *lspTextEdit `json:"textEdit"`
+		SortText         string       `json:"sortText"`
+	}
+	// Either a list of items or a CompletionList
+	var items []item
+	if json.Unmarshal(result, &items) != nil {
+		var list struct {
+			Items []item `json:"items"`
+		}
+		json.Unmarshal(result, &list)
+		items = list.Items
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		return sortKey(items[i].SortText, items[i].Label) < sortKey(items[j].SortText, items[j].Label)
+	})
+
+	var completions []Completion
+	for i, it := range items {
+		text := it.Label
+		if it.TextEdit != nil {
+			text = it.TextEdit.NewText
+		} else if it.InsertText != "" {
+			text = it.InsertText
+		}
+		c := Completion{
+			Text:        text,
+			Description: it.Detail,
+			start:       start,
+			score:       max(1, 6-i/10),
+		}
+		if it.InsertTextFormat == 2 {
+			// Shown by label, expanded as a snippet
+			c.Text = it.Label
+			c.snippet = &snippet{trigger: it.Label, description: it.Detail, body: text}
+		}
+		if c.Text == "" || strings.Contains(c.Text, "\n") {
+			continue
+		}
+		completions = append(completions, c)
+	}
+	return completions
+}
+
+func sortKey(sortText, label string) string {
+	if sortText != "" {
+		return sortText
+	}
+	return label
+}
+
+// positionParams identifies the cursor position in doc.
+func (e *Editor) positionParams(doc *lspDocument) map[string]any {
+	return map[string]any{
+		"textDocument": map[string]any{"uri": doc.uri},
+		"position":     lspPosition{Line: e.cursorY, Character: utf16Column(e.lines[e.cursorY], e.cursorX)},
+	}
+}
+
+// lspRequest syncs the current buffer and sends a request about the cursor
+// position, reporting errors in the status bar.
+func (e *Editor) lspRequest(method string, extra map[string]any, callback func(doc *lspDocument, result json.RawMessage)) {
+	doc := e.syncLSP()
+	if doc == nil {
+		e.setStatusMessage("No language server for this file")
+		return
+	}
+	params := e.positionParams(doc)
+	for k, v := range extra {
+		params[k] = v
+	}
+	doc.client.request(method, params, func(result json.RawMessage, err *lspError) {
+		if err != nil {
+			e.setStatusMessage(fmt.Sprintf("%s: %v", method, err))
+			return
+		}
+		callback(doc, result)
+	})
+}
+
+// hover shows the server's description of the symbol under the cursor.
+func (e *Editor) hover() {
+	e.lspRequest("textDocument/hover", nil, func(_ *lspDocument, result json.RawMessage) {
+		var h struct {
+			Contents json.RawMessage `json:"contents"`
+		}
+		json.Unmarshal(result, &h)
+		var lines []string
+		for _, line := range strings.Split(strings.TrimSpace(hoverText(h.Contents)), "\n") {
+			if !strings.HasPrefix(line, "```") {
+				lines = append(lines, line)
+			}
+		}
+		if len(lines) == 0 || (len(lines) == 1 && lines[0] == "") {
+			e.setStatusMessage("No information")
+			return
+		}
+		e.showPopup("", lines)
+	})
+}
+
+// hoverText flattens hover contents, which may be a string, a MarkupContent,
+// a MarkedString or a list of those.
+func hoverText(raw json.RawMessage) string {
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return s
+	}
+	var markup struct {
+		Value string `json:"value"`
+	}
+	if json.Unmarshal(raw, &markup) == nil && markup.Value != "" {
+		return markup.Value
+	}
+	var list []json.RawMessage
+	if json.Unmarshal(raw, &list) == nil {
+		var parts []string
+		for _, item := range list {
+			parts = append(parts, hoverText(item))
+		}
+		return strings.Join(parts, "\n")
+	}
+	return ""
+}
+
+// parseLocations reads a Location, a list of them or a list of LocationLinks.
+func parseLocations(result json.RawMessage) []lspLocation {
+	var raw []struct {
+		lspLocation
+		TargetURI            string    `json:"targetUri"`
+		TargetSelectionRange *lspRange `json:"targetSelectionRange"`
+	}
+	if json.Unmarshal(result, &raw) != nil {
+		var single lspLocation
+		if json.Unmarshal(result, &single) != nil || single.URI == "" {
+			return nil
+		}
+		return []lspLocation{single}
+	}
+	locations := make([]lspLocation, 0, len(raw))
+	for _, r := range raw {
+		loc := r.lspLocation
+		if r.TargetURI != "" {
+			loc.URI = r.TargetURI
+			if r.TargetSelectionRange != nil {
+				loc.Range = *r.TargetSelectionRange
+			}
+		}
+		locations = append(locations, loc)
+	}
+	return locations
+}
+
+// gotoDefinition jumps to the definition of the symbol under the cursor.
+func (e *Editor) gotoDefinition() {
+	e.lspRequest("textDocument/definition", nil, func(_ *lspDocument, result json.RawMessage) {
+		locations := parseLocations(result)
+		if len(locations) == 0 {
+			e.setStatusMessage("No definition found")
+			return
+		}
+		e.setLocations(locations)
+	})
+}
+
+// findReferences lists the uses of the symbol under the cursor.
+func (e *Editor) findReferences() {
+	params := map[string]any{"context": map[string]any{"includeDeclaration": true}}
+	e.lspRequest("textDocument/references", params, func(_ *lspDocument, result json.RawMessage) {
+		locations := parseLocations(result)
+		if len(locations) == 0 {
+			e.setStatusMessage("No references found")
+			return
+		}
+		e.setLocations(locations)
+	})
+}
+
+// setLocations fills the location list and jumps to its first entry.
+func (e *Editor) setLocations(locations []lspLocation) {
+	e.locations = locations
+	e.locationIndex = 0
+	e.jumpToLocation(0)
+}
+
+// nextLocation moves through the location list (dir 1 or -1).
+func (e *Editor) nextLocation(dir int) {
+	if len(e.locations) == 0 {
+		e.setStatusMessage("No locations")
+		return
+	}
+	i := e.locationIndex + dir
+	if i < 0 || i >= len(e.locations) {
+		e.setStatusMessage("No more locations")
+		return
+	}
+	e.jumpToLocation(i)
+}
+
+func (e *Editor) jumpToLocation(i int) {
+	loc := e.locations[i]
+	e.locationIndex = i
+	path := uriToPath(loc.URI)
+	if current, _ := filepath.Abs(e.filename); path != current {
+		if err := e.openFile(path); err != nil {
+			e.setStatusMessage(fmt.Sprintf("Error opening file: %v", err))
+			return
+		}
+	}
+	e.cursorY = max(0, min(loc.Range.Start.Line, len(e.lines)-1))
+	e.cursorX = byteColumn(e.lines[e.cursorY], loc.Range.Start.Character)
+	e.scrollToCursor()
+	if len(e.locations) > 1 {
+		e.setStatusMessage(fmt.Sprintf("(%d of %d) %s:%d", i+1, len(e.locations), filepath.Base(path), e.cursorY+1))
+	}
+}
+
+// scrollToCursor scrolls so the cursor line is in view, centering it if it
+// was off screen.
+func (e *Editor) scrollToCursor() {
+	height := max(1, e.screenHeight-2)
+	if e.cursorY < e.scrollY || e.cursorY >= e.scrollY+height {
+		e.scrollY, e.scrollRow = max(0, e.cursorY-height/2), 0
+	}
+}
+
+// listDiagnostics shows the current buffer's diagnostics and puts them in
+// the location list.
+func (e *Editor) listDiagnostics() {
+	diagnostics := e.currentDiagnostics()
+	if len(diagnostics) == 0 {
+		e.setStatusMessage("No diagnostics")
+		return
+	}
+	sorted := append([]lspDiagnostic{}, diagnostics...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Range.Start.Line < sorted[j].Range.Start.Line
+	})
+	path, _ := filepath.Abs(e.filename)
+	var lines []string
+	e.locations = nil
+	for i := range sorted {
+		d := &sorted[i]
+		lines = append(lines, fmt.Sprintf("%d: %s", d.Range.Start.Line+1, diagnosticText(d)))
+		e.locations = append(e.locations, lspLocation{URI: fileURI(path), Range: d.Range})
+	}
+	e.locationIndex = -1
+	e.showPopup(fmt.Sprintf("%d diagnostics (:cn to visit)", len(sorted)), lines)
+}
+
+// rename renames the symbol under the cursor across the workspace.
+func (e *Editor) rename(newName string) {
+	params := map[string]any{"newName": newName}
+	e.lspRequest("textDocument/rename", params, func(_ *lspDocument, result json.RawMessage) {
+		var edit struct {
+			Changes         map[string][]lspTextEdit `json:"changes"`
+			DocumentChanges []struct {
+				TextDocument struct {
+					URI string `json:"uri"`
+				} `json:"textDocument"`
+				Edits []lspTextEdit `json:"edits"`
+			} `json:"documentChanges"`
+		}
+		if json.Unmarshal(result, &edit) != nil {
+			e.setStatusMessage("Rename failed")
+			return
+		}
+		changes := edit.Changes
+		if len(edit.DocumentChanges) > 0 {
+			changes = map[string][]lspTextEdit{}
+			for _, dc := range edit.DocumentChanges {
+				changes[dc.TextDocument.URI] = append(changes[dc.TextDocument.URI], dc.Edits...)
+			}
+		}
+		if len(changes) == 0 {
+			e.setStatusMessage("Nothing to rename")
+			return
+		}
+
+		// Edit each file in its buffer, then come back
+		e.syncBuffers()
+		original := e.bufferIndex
+		count := 0
+		for uri, edits := range changes {
+			if err := e.openFile(uriToPath(uri)); err != nil {
+				e.setStatusMessage(fmt.Sprintf("Error opening file: %v", err))
+				continue
+			}
+			e.applyEdits(edits)
+			count += len(edits)
+		}
+		e.switchBuffer(original)
+		e.setStatusMessage(fmt.Sprintf("Renamed %d occurrences in %d files", count, len(changes)))
+	})
+}
+
+// format replaces the buffer with the server's formatting of it.
+func (e *Editor) format() {
+	doc := e.syncLSP()
+	if doc == nil {
+		e.setStatusMessage("No language server for this file")
+		return
+	}
+	version := doc.version
+	params := map[string]any{"options": map[string]any{"tabSize": e.shiftWidth(), "insertSpaces": e.boolOption("expandTab")}}
+	e.lspRequest("textDocument/formatting", params, func(doc *lspDocument, result json.RawMessage) {
+		var edits []lspTextEdit
+		json.Unmarshal(result, &edits)
+		if e.syncLSP() != doc || doc.version != version {
+			e.setStatusMessage("Buffer changed, formatting skipped")
+			return
+		}
+		if len(edits) == 0 {
+			e.setStatusMessage("Already formatted")
+			return
+		}
+		e.applyEdits(edits)
+		e.setStatusMessage("Formatted")
+	})
+}
+
+// applyEdits applies text edits to the current buffer as one undo step.
+func (e *Editor) applyEdits(edits []lspTextEdit) {
+	if !e.editable() {
+		return
+	}
+	e.addUndo(Action{
+		Type:    "edit",
+		action:  "edit",
+		lines:   append([]string{}, e.lines...),
+		cursorX: e.cursorX,
+		cursorY: e.cursorY,
+	})
+	e.lines = applyTextEdits(e.lines, edits)
+	e.markChanged()
+	e.cursorY = min(e.cursorY, len(e.lines)-1)
+	e.cursorX = min(e.cursorX, len(e.lines[e.cursorY]))
+}
+
+// applyTextEdits returns lines with the edits made. All edit positions refer
+// to the original text.
+func applyTextEdits(lines []string, edits []lspTextEdit) []string {
+	text := documentText(lines)
+	starts := make([]int, len(lines)+1)
+	for i, line := range lines {
+		starts[i+1] = starts[i] + len(line) + 1
+	}
+	offset := func(p lspPosition) int {
+		if p.Line >= len(lines) {
+			return len(text)
+		}
+		return starts[p.Line] + byteColumn(lines[p.Line], p.Character)
+	}
+
+	// From the end, so earlier offsets stay valid; edits at the same
+	// place keep their order
+	order := make([]int, len(edits))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		oa, ob := offset(edits[order[a]].Range.Start), offset(edits[order[b]].Range.Start)
+		if oa != ob {
+			return oa > ob
+		}
+		return order[a] > order[b]
+	})
+	for _, i := range order {
+		from, to := offset(edits[i].Range.Start), offset(edits[i].Range.End)
+		if to < from {
+			continue
+		}
+		text = text[:from] + edits[i].NewText + text[to:]
+	}
+
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// lspStatus describes the running servers.
+func (e *Editor) lspStatus() string {
+	if len(e.lspClients) == 0 {
+		return "No language servers running"
+	}
+	var parts []string
+	for language, c := range e.lspClients {
+		parts = append(parts, fmt.Sprintf("%s: %s (%s)", language, filepath.Base(c.cmd.Path), c.root))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ", ")
+}
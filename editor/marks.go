@@ -0,0 +1,152 @@
+package editor
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Marks and breakpoints.
+//
+// m{a-z} marks the cursor position in the buffer; '{a-z} goes back to the
+// marked line and `{a-z} to the exact spot. :breakpoint, or a click in the
+// sign column, toggles a breakpoint on a line. Both show in the sign
+// column and move with their lines as the buffer is edited (see syncLines).
+
+func isMarkName(r rune) bool {
+	return r >= 'a' && r <= 'z'
+}
+
+// setMark sets mark name at the cursor.
+func (e *Editor) setMark(name rune) {
+	if !isMarkName(name) {
+		e.setStatusMessage(fmt.Sprintf("Invalid mark: %c (use a-z)", name))
+		return
+	}
+	e.syncLines()
+	if e.marks == nil {
+		e.marks = map[rune]Position{}
+	}
+	e.marks[name] = Position{line: e.cursorY, col: e.cursorX}
+}
+
+// jumpToMark goes to the first non-blank of the marked line, or with exact
+// to where the mark was set.
+func (e *Editor) jumpToMark(name rune, exact bool) {
+	e.syncLines()
+	pos, ok := e.marks[name]
+	if !ok {
+		e.setStatusMessage(fmt.Sprintf("Mark not set: %c", name))
+		return
+	}
+	e.cursorY = min(pos.line, len(e.lines)-1)
+	line := e.lines[e.cursorY]
+	if exact {
+		e.cursorX = min(pos.col, len(line))
+	} else {
+		e.cursorX = len(line) - len(strings.TrimLeft(line, " \t"))
+	}
+}
+
+// listMarks is :marks.
+func (e *Editor) listMarks() {
+	e.syncLines()
+	if len(e.marks) == 0 {
+		e.setStatusMessage("No marks (m{a-z} sets one)")
+		return
+	}
+	var list []string
+	for name, pos := range e.marks {
+		list = append(list, fmt.Sprintf("%c %d:%d", name, pos.line+1, pos.col+1))
+	}
+	sort.Strings(list)
+	e.setStatusMessage("Marks: " + strings.Join(list, ", "))
+}
+
+// deleteMarks is :delmarks {names} and :delmarks! for all of them.
+func (e *Editor) deleteMarks(args string, all bool) {
+	if all {
+		e.marks = nil
+		return
+	}
+	names := strings.Join(strings.Fields(args), "")
+	if names == "" {
+		e.setStatusMessage("Usage: delmarks {a-z}... or delmarks!")
+		return
+	}
+	for _, name := range names {
+		delete(e.marks, name)
+	}
+}
+
+func (e *Editor) markSigns() map[int]gutterSign {
+	names := make([]rune, 0, len(e.marks))
+	for name := range e.marks {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	signs := map[int]gutterSign{}
+	for _, name := range names {
+		y := e.marks[name].line
+		if _, ok := signs[y]; !ok {
+			signs[y] = gutterSign{text: string(name), style: "sign.mark"}
+		}
+	}
+	return signs
+}
+
+// toggleBreakpoint sets or clears the breakpoint on line y.
+func (e *Editor) toggleBreakpoint(y int) {
+	e.syncLines()
+	if e.breakpoints[y] {
+		delete(e.breakpoints, y)
+		return
+	}
+	if e.breakpoints == nil {
+		e.breakpoints = map[int]bool{}
+	}
+	e.breakpoints[y] = true
+}
+
+// breakpointCommand is :breakpoint, which toggles one on the cursor line,
+// and :breakpoint clear.
+func (e *Editor) breakpointCommand(args string) {
+	switch strings.TrimSpace(args) {
+	case "":
+		e.toggleBreakpoint(e.cursorY)
+	case "clear":
+		e.breakpoints = nil
+	default:
+		e.setStatusMessage("Usage: breakpoint [clear]")
+	}
+}
+
+func (e *Editor) breakpointSigns() map[int]gutterSign {
+	signs := make(map[int]gutterSign, len(e.breakpoints))
+	for y := range e.breakpoints {
+		signs[y] = gutterSign{text: "●", style: "sign.breakpoint"}
+	}
+	return signs
+}
+
+// shiftSigns moves marks and breakpoints after delta lines were added or
+// removed after line at.
+func (e *Editor) shiftSigns(at, delta int) {
+	for name, pos := range e.marks {
+		if y, ok := shiftLine(pos.line, at, delta, len(e.lines)); ok {
+			e.marks[name] = Position{line: y, col: pos.col}
+		} else {
+			delete(e.marks, name)
+		}
+	}
+	if len(e.breakpoints) > 0 {
+		moved := make(map[int]bool, len(e.breakpoints))
+		for y := range e.breakpoints {
+			if y, ok := shiftLine(y, at, delta, len(e.lines)); ok {
+				moved[y] = true
+			}
+		}
+		e.breakpoints = moved
+	}
+}
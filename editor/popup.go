@@ -0,0 +1,73 @@
+package editor
+
+import (
+	"strings"
+
+	"github.com/mattn/go-runewidth"
+)
+
+// Popup window shown next to the cursor for things like hover information.
+// The next key press closes it.
+
+type popup struct {
+	title string
+	lines []string
+}
+
+const maxPopupWidth = 80
+
+func (e *Editor) showPopup(title string, lines []string) {
+	e.popup = &popup{title: title, lines: lines}
+}
+
+func (e *Editor) closePopup() bool {
+	if e.popup == nil {
+		return false
+	}
+	e.popup = nil
+	return true
+}
+
+func (e *Editor) drawPopup() {
+	p := e.popup
+	rows := []string{}
+	if p.title != "" {
+		rows = append(rows, p.title)
+	}
+	for _, line := range p.lines {
+		rows = append(rows, strings.ReplaceAll(line, "\t", "    "))
+	}
+
+	width := 0
+	for _, row := range rows {
+		width = max(width, runewidth.StringWidth(row)+2)
+	}
+	width = min(width, maxPopupWidth, e.screenWidth)
+	height := min(len(rows), max(1, (e.screenHeight-2)/2))
+	if height < len(rows) {
+		rows = append(rows[:height-1], "...")
+	}
+
+	x, cursorY, _ := e.screenPosition(e.cursorY, e.cursorX)
+	if x+width > e.screenWidth {
+		x = max(0, e.screenWidth-width)
+	}
+
+	// Below the cursor if it fits, otherwise above
+	y := cursorY + 1
+	if y+height > e.screenHeight-2 {
+		y = max(0, cursorY-height)
+	}
+
+	style := e.uiStyle("completion")
+	for i, row := range rows {
+		rowStyle := style
+		if i == 0 && p.title != "" {
+			rowStyle = e.uiStyle("completion.selected")
+		}
+		for dx := 0; dx < width; dx++ {
+			e.screen.SetContent(x+dx, y+i, ' ', nil, rowStyle)
+		}
+		drawText(e.screen, x+1, y+i, rowStyle, runewidth.Truncate(row, width-2, "…"))
+	}
+}
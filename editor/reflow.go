@@ -0,0 +1,231 @@
+package editor
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Reflowing text.
+//
+// gq{motion} and :reflow rewrap paragraphs to textWidth columns. Each line
+// keeps its indentation and comment leader (//, # or >), and a list item's
+// later lines line up after its bullet. Paragraphs end at blank lines, at a
+// change of leader and before a bullet. With autoWrap, typing past
+// textWidth in insert mode breaks the line at the last space.
+
+const defaultTextWidth = 79 // what gq uses when textWidth is 0
+
+var (
+	leaderPattern         = regexp.MustCompile(`^[ \t]*(?:(?://+|#+|>+)(?:[ \t]+|$))*`)
+	markdownLeaderPattern = regexp.MustCompile(`^[ \t]*(?:>+(?:[ \t]+|$))*`)
+	bulletPattern         = regexp.MustCompile(`^(?:[-*+]|[0-9]+[.)])[ \t]+`)
+)
+
+// textLine is a line split into its leader, list bullet and text.
+type textLine struct {
+	lead, bullet, text string
+}
+
+// splitTextLine splits line for reflowing. Markdown uses # for headings
+// rather than comments.
+func splitTextLine(line string, markdown bool) textLine {
+	pattern := leaderPattern
+	if markdown {
+		pattern = markdownLeaderPattern
+	}
+	lead := pattern.FindString(line)
+	rest := line[len(lead):]
+	bullet := bulletPattern.FindString(rest)
+	return textLine{lead: lead, bullet: bullet, text: strings.TrimSpace(rest[len(bullet):])}
+}
+
+// keepLine reports whether a line is left alone and not joined to others:
+// blank lines, and Markdown headings and code fences.
+func keepLine(tl textLine, markdown bool) bool {
+	if tl.text == "" && tl.bullet == "" {
+		return true
+	}
+	return markdown && (strings.HasPrefix(tl.text, "#") || strings.HasPrefix(tl.text, "```"))
+}
+
+// textWidth is the width to reflow to.
+func (e *Editor) textWidth() int {
+	if tw := e.intOption("textWidth"); tw > 0 {
+		return tw
+	}
+	return defaultTextWidth
+}
+
+func (e *Editor) isMarkdown() bool {
+	return slices.Contains(e.fileTypeNames(), "markdown")
+}
+
+// reflowLines rewraps the paragraphs in lines from..to to width columns.
+func (e *Editor) reflowLines(from, to, width int) {
+	from, to = max(from, 0), min(to, len(e.lines)-1)
+	if from > to || !e.editable() {
+		return
+	}
+	e.addUndo(Action{
+		Type:    "reflow",
+		action:  "reflow",
+		lines:   append([]string{}, e.lines...),
+		cursorX: e.cursorX,
+		cursorY: e.cursorY,
+	})
+
+	markdown := e.isMarkdown()
+	var out []string
+	fence := false
+	for y := from; y <= to; {
+		tl := splitTextLine(e.lines[y], markdown)
+		if markdown && strings.HasPrefix(tl.text, "```") {
+			fence = !fence
+		}
+		if fence || keepLine(tl, markdown) {
+			out = append(out, strings.TrimRight(e.lines[y], " \t"))
+			y++
+			continue
+		}
+
+		// The paragraph runs while the leader stays the same
+		words := strings.Fields(tl.text)
+		y++
+		for ; y <= to; y++ {
+			next := splitTextLine(e.lines[y], markdown)
+			if next.bullet != "" || keepLine(next, markdown) ||
+				strings.TrimSpace(next.lead) != strings.TrimSpace(tl.lead) {
+				break
+			}
+			words = append(words, strings.Fields(next.text)...)
+		}
+		first := tl.lead + tl.bullet
+		rest := tl.lead + strings.Repeat(" ", stringWidth(tl.bullet))
+		if len(words) == 0 {
+			// A bullet with nothing after it stays as it is
+			out = append(out, strings.TrimRight(first, " \t"))
+			continue
+		}
+		out = append(out, fillWords(words, first, rest, width, e.tabSize)...)
+	}
+
+	e.lines = slices.Concat(e.lines[:from], out, e.lines[to+1:])
+	e.cursorY = min(max(from+len(out)-1, 0), len(e.lines)-1)
+	line := e.lines[e.cursorY]
+	e.cursorX = len(splitTextLine(line, markdown).lead)
+	e.markChanged()
+}
+
+// fillWords puts words on lines of at most width columns, the first line
+// starting with first and the others with rest. A word longer than a line
+// gets a line of its own.
+func fillWords(words []string, first, rest string, width, tabSize int) []string {
+	var lines []string
+	prefix := first
+	line := ""
+	col := 0
+	for _, word := range words {
+		w := stringWidth(word)
+		if line != "" && col+1+w > width {
+			lines = append(lines, line)
+			prefix, line = rest, ""
+		}
+		if line == "" {
+			line = prefix + word
+			col = displayColumn(prefix, len(prefix), tabSize) + w
+		} else {
+			line += " " + word
+			col += 1 + w
+		}
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// paragraphRange is the paragraph around line y: the lines around it that
+// aren't blank.
+func (e *Editor) paragraphRange(y int) (from, to int) {
+	markdown := e.isMarkdown()
+	blank := func(y int) bool {
+		return keepLine(splitTextLine(e.lines[y], markdown), markdown)
+	}
+	from, to = y, y
+	for from > 0 && !blank(from-1) {
+		from--
+	}
+	for to < len(e.lines)-1 && !blank(to+1) {
+		to++
+	}
+	return from, to
+}
+
+// reflowCommand is :reflow [width], which rewraps the paragraph at the
+// cursor.
+func (e *Editor) reflowCommand(args string) {
+	width := e.textWidth()
+	if args = strings.TrimSpace(args); args != "" {
+		n, err := strconv.Atoi(args)
+		if err != nil || n < 1 {
+			e.setStatusMessage(fmt.Sprintf("Usage: reflow [width], not %q", args))
+			return
+		}
+		width = n
+	}
+	from, to := e.paragraphRange(e.cursorY)
+	e.reflowLines(from, to, width)
+}
+
+// autoWrap breaks the cursor line at the last space that keeps it within
+// textWidth, after typing past it in insert mode.
+func (e *Editor) autoWrap() {
+	width := e.intOption("textWidth")
+	if width == 0 || !e.boolOption("autoWrap") || e.cursorColumn() <= width {
+		return
+	}
+	line := e.lines[e.cursorY]
+	tl := splitTextLine(line, e.isMarkdown())
+	textStart := len(tl.lead) + len(tl.bullet)
+
+	// The last space before the cursor where the text before it fits, or
+	// the first one if none do
+	at := -1
+	for i := textStart; i < e.cursorX; i++ {
+		if line[i] != ' ' && line[i] != '\t' {
+			continue
+		}
+		if at >= 0 && displayColumn(line, i, e.tabSize) > width {
+			break
+		}
+		at = i
+	}
+	if at < 0 {
+		return
+	}
+	end := at
+	for end < len(line) && (line[end] == ' ' || line[end] == '\t') {
+		end++
+	}
+	if end > e.cursorX {
+		// Only spaces after it so far
+		return
+	}
+
+	e.addUndo(Action{
+		Type:    "insert",
+		action:  "insert",
+		lines:   append([]string{}, e.lines...),
+		cursorX: e.cursorX,
+		cursorY: e.cursorY,
+	})
+	prefix := tl.lead + strings.Repeat(" ", stringWidth(tl.bullet))
+	e.lines[e.cursorY] = strings.TrimRight(line[:at], " \t")
+	e.lines = slices.Insert(e.lines, e.cursorY+1, prefix+line[end:])
+	e.cursorY++
+	e.cursorX = len(prefix) + e.cursorX - end
+	e.markChanged()
+}
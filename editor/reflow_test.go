@@ -0,0 +1,110 @@
+package editor
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReflow(t *testing.T) {
+	tests := []struct {
+		name     string
+		filename string
+		text     string
+		want     string
+	}{
+		{
+			"plain", "notes.txt",
+			"one two three\nfour five six seven eight nine ten\n\neleven twelve",
+			"one two three four\nfive six seven eight\nnine ten\n\neleven twelve",
+		},
+		{
+			"indented comment", "main.go",
+			"\t// a comment that goes on and\n\t// on\n\tcode()",
+			"\t// a comment\n\t// that goes on\n\t// and on\n\tcode()",
+		},
+		{
+			"list", "notes.md",
+			"- first item with several words\n- second\n  item\n1. numbered item here",
+			"- first item with\n  several words\n- second item\n1. numbered item\n   here",
+		},
+		{
+			"quote and heading", "notes.md",
+			"# A heading that is long\n> quoted text that wraps\n> around\n```\nkeep   this line as it is\n```",
+			"# A heading that is long\n> quoted text that\n> wraps around\n```\nkeep   this line as it is\n```",
+		},
+		{
+			"long word", "notes.txt",
+			"a https://example.com/a/very/long/path b",
+			"a\nhttps://example.com/a/very/long/path\nb",
+		},
+		{
+			"only an empty bullet", "notes.txt",
+			"- ",
+			"-",
+		},
+		{
+			"empty bullet", "notes.md",
+			"- \n1. \n- item",
+			"-\n1.\n- item",
+		},
+	}
+	for _, test := range tests {
+		ed := &Editor{filename: test.filename, lines: strings.Split(test.text, "\n")}
+		ed.applySettings()
+		ed.reflowLines(0, len(ed.lines)-1, 20)
+		if got := strings.Join(ed.lines, "\n"); got != test.want {
+			t.Errorf("%s:\n%s\nwant:\n%s", test.name, got, test.want)
+		}
+		ed.undo()
+		if got := strings.Join(ed.lines, "\n"); got != test.text {
+			t.Errorf("%s: undo gave\n%s", test.name, got)
+		}
+	}
+}
+
+func TestReflowCommands(t *testing.T) {
+	ed := &Editor{
+		mode:     "normal",
+		lines:    []string{"intro", "", "a b c d e f", "g h", "", "end"},
+		settings: map[string]string{"textWidth": "5"},
+	}
+	ed.applySettings()
+	ed.cursorY = 3
+	typeKeys(ed, "gqap")
+	if got := strings.Join(ed.lines, "|"); got != "intro||a b c|d e f|g h||end" {
+		t.Errorf("gqap: %s", got)
+	}
+	if ed.cursorY != 4 || ed.normalPrefix != "" {
+		t.Errorf("gqap left the cursor on line %d, prefix %q", ed.cursorY, ed.normalPrefix)
+	}
+
+	ed.cursorY = 2
+	ed.commandBuffer = "reflow 40"
+	ed.handleCommand()
+	if got := strings.Join(ed.lines, "|"); got != "intro||a b c d e f g h||end" {
+		t.Errorf(":reflow 40: %s", got)
+	}
+}
+
+func TestAutoWrap(t *testing.T) {
+	ed := &Editor{
+		mode:     "insert",
+		filename: "main.go",
+		lines:    []string{""},
+		settings: map[string]string{"textWidth": "16", "autoComplete": "false"},
+	}
+	ed.applySettings()
+	typeKeys(ed, "  // hello there world")
+	if got := strings.Join(ed.lines, "|"); got != "  // hello there|  // world" {
+		t.Errorf("wrapped to %q", got)
+	}
+	if ed.cursorY != 1 || ed.cursorX != len(ed.lines[1]) {
+		t.Errorf("cursor at %d:%d", ed.cursorY, ed.cursorX)
+	}
+
+	ed.setCommand("noautoWrap")
+	typeKeys(ed, " and more words")
+	if len(ed.lines) != 2 {
+		t.Errorf("wrapped with autoWrap off: %q", ed.lines)
+	}
+}
@@ -0,0 +1,178 @@
+package editor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Live configuration reloading.
+//
+// A goroutine checks the config file, the files of the theme in use and the
+// current file's .kiki.toml every configPollInterval. When one changes the
+// whole configuration is read again and applied; if anything in it is
+// wrong the error is shown and the last good configuration stays.
+
+const configPollInterval = time.Second
+
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+	exists  bool
+}
+
+func stampFile(path string) fileStamp {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}
+	}
+	return fileStamp{modTime: info.ModTime(), size: info.Size(), exists: true}
+}
+
+type configWatcher struct {
+	mu     sync.Mutex
+	stamps map[string]fileStamp
+	done   chan struct{}
+}
+
+// watch sets the files to check. Files already watched keep their stamps so
+// a change isn't missed.
+func (w *configWatcher) watch(paths []string) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	stamps := make(map[string]fileStamp, len(paths))
+	for _, path := range paths {
+		if stamp, ok := w.stamps[path]; ok {
+			stamps[path] = stamp
+		} else {
+			stamps[path] = stampFile(path)
+		}
+	}
+	w.stamps = stamps
+}
+
+// changed reports whether any file changed since the last check.
+func (w *configWatcher) changed() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	changed := false
+	for path, stamp := range w.stamps {
+		if now := stampFile(path); now != stamp {
+			w.stamps[path] = now
+			changed = true
+		}
+	}
+	return changed
+}
+
+// startConfigWatcher starts checking the configuration files.
+func (e *Editor) startConfigWatcher() {
+	if e.mainQueue == nil {
+		e.mainQueue = make(chan func(), 256)
+	}
+	w := &configWatcher{done: make(chan struct{})}
+	e.configWatcher = w
+	e.watchConfigFiles()
+
+	go func() {
+		ticker := time.NewTicker(configPollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-w.done:
+				return
+			case <-ticker.C:
+				if w.changed() {
+					e.post(e.reloadConfig)
+				}
+			}
+		}
+	}()
+}
+
+func (e *Editor) stopConfigWatcher() {
+	if e.configWatcher != nil {
+		close(e.configWatcher.done)
+		e.configWatcher = nil
+	}
+}
+
+// watchConfigFiles updates the list of files to check, which depends on the
+// theme and the current file. applySettings calls it.
+func (e *Editor) watchConfigFiles() {
+	if e.configWatcher == nil {
+		return
+	}
+	paths := []string{configPath()}
+	paths = append(paths, themeFiles(e.option("theme"))...)
+	if e.filename != "" {
+		abs, _ := filepath.Abs(e.filename)
+		if project := findUp(filepath.Dir(abs), projectConfigName); project != "" {
+			paths = append(paths, project)
+		}
+	}
+	e.configWatcher.watch(paths)
+}
+
+// themeFiles lists the files a theme could be read from, following what it
+// inherits. "default" is always included as every theme falls back on it.
+func themeFiles(name string) []string {
+	var paths []string
+	seen := map[string]bool{}
+	for _, name := range []string{name, "default"} {
+		for name != "" && !seen[name] {
+			seen[name] = true
+			for _, ext := range []string{".toml", ".json"} {
+				paths = append(paths, filepath.Join(themesDir(), name+ext))
+			}
+			theme, err := readThemeFile(name)
+			if err != nil || theme == nil {
+				break
+			}
+			name = theme.Inherits
+		}
+	}
+	return paths
+}
+
+// reloadConfig reads the configuration again and applies it, unless it has
+// errors. Mappings and :set changes made since it was loaded are replaced.
+func (e *Editor) reloadConfig() {
+	data, err := os.ReadFile(configPath())
+	if err != nil && !os.IsNotExist(err) {
+		e.setStatusMessage(fmt.Sprintf("Config not reloaded: %v", err))
+		return
+	}
+	settings, errs := parseConfig(string(data))
+	if len(errs) > 0 {
+		msg := fmt.Sprintf("Config not reloaded: %v", errs[0])
+		if len(errs) > 1 {
+			msg += fmt.Sprintf(" (and %d more errors)", len(errs)-1)
+		}
+		e.setStatusMessage(msg)
+		return
+	}
+
+	// The theme's files may have changed without its name changing
+	old := e.settings
+	e.settings = settings
+	if err := e.setTheme(e.option("theme")); err != nil {
+		e.settings = old
+		e.setStatusMessage(fmt.Sprintf("Config not reloaded: %v", err))
+		return
+	}
+
+	e.setStatusMessage("Configuration reloaded")
+	e.loadKeymaps()
+	for i, b := range e.buffers {
+		if i != e.bufferIndex {
+			b.settings = e.bufferSettings(b)
+		}
+	}
+	e.reloadFileSettings()
+}
@@ -0,0 +1,103 @@
+package editor
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+func TestReloadConfig(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	writeTestFile(t, configDir(), "config.toml", "tabSize = 2\ntheme = \"night\"\n[keys.insert]\njk = \"<Esc>\"\n")
+	writeTestFile(t, configDir(), "themes/night.toml", "inherits = \"gruvbox\"\n[ui]\ntext = \"red\"\n")
+
+	ed := &Editor{}
+	if errs := ed.loadConfig(); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	ed.configWatcher = &configWatcher{}
+	ed.watchConfigFiles()
+	if ed.configWatcher.changed() {
+		t.Errorf("changed before anything was written")
+	}
+
+	write := func(rel, content string) {
+		t.Helper()
+		writeTestFile(t, configDir(), rel, content)
+		if !ed.configWatcher.changed() {
+			t.Fatalf("change to %s not seen", rel)
+		}
+		ed.reloadConfig()
+	}
+
+	write("config.toml", "tabSize = 8\nwordWrap = true\ntheme = \"night\"\n")
+	if ed.tabSize != 8 || !ed.wordWrap || len(ed.keymaps["insert"]) != 0 {
+		t.Errorf("reloaded: tabSize=%d wrap=%v keymaps=%v", ed.tabSize, ed.wordWrap, ed.keymaps)
+	}
+
+	// A broken file keeps what was there
+	write("config.toml", "tabSize = 3\nwordWrap = maybe\n")
+	if ed.tabSize != 8 || !strings.HasPrefix(ed.statusMessage, "Config not reloaded: line 2") {
+		t.Errorf("broken config: tabSize=%d message=%q", ed.tabSize, ed.statusMessage)
+	}
+	write("config.toml", "tabSize = 99\ntheme = \"night\"\n")
+	if ed.tabSize != 8 || !strings.Contains(ed.statusMessage, "tabSize: must be between") {
+		t.Errorf("invalid option: tabSize=%d message=%q", ed.tabSize, ed.statusMessage)
+	}
+
+	// Editing the theme in use changes the colors
+	write("config.toml", "tabSize = 8\ntheme = \"night\"\n")
+	write("themes/night.toml", "inherits = \"gruvbox\"\n[ui]\ntext = \"blue\"\n")
+	if fg, _, _ := ed.currentTheme().Style("text").Decompose(); fg != tcell.GetColor("blue") {
+		t.Errorf("theme not reloaded: text is %v", fg)
+	}
+	write("themes/night.toml", "inherits = \"gruvbox\"\n[ui\n")
+	if fg, _, _ := ed.currentTheme().Style("text").Decompose(); fg != tcell.GetColor("blue") {
+		t.Errorf("broken theme replaced the old one: text is %v", fg)
+	}
+
+	// Removing the file goes back to the defaults
+	os.Remove(filepath.Join(configDir(), "config.toml"))
+	if !ed.configWatcher.changed() {
+		t.Fatal("removal not seen")
+	}
+	ed.reloadConfig()
+	if ed.tabSize != 4 || ed.wordWrap || ed.currentTheme().Name != "default" {
+		t.Errorf("after removal: tabSize=%d wrap=%v theme=%s", ed.tabSize, ed.wordWrap, ed.currentTheme().Name)
+	}
+}
+
+func TestReloadKeepsFileSettings(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	writeTestFile(t, configDir(), "config.toml", "saveOnFocusLost = true\n")
+	dir := t.TempDir()
+	writeTestFile(t, dir, ".editorconfig", "root = true\n[*]\nend_of_line = crlf\n")
+	writeTestFile(t, dir, "a.txt", "\ufeffa\r\n")
+	writeTestFile(t, dir, "b.txt", "\ufeffb\r\n")
+
+	ed := &Editor{mode: "normal"}
+	if errs := ed.loadConfig(); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	for _, name := range []string{"b.txt", "a.txt"} {
+		if err := ed.openFile(filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+		typeKeys(ed, "i1<Esc>")
+	}
+
+	// Both the buffer in the background and the current one keep the byte
+	// order mark they were loaded with, and get the .editorconfig's line
+	// endings
+	ed.reloadConfig()
+	ed.autoSaveOn("saveOnFocusLost")
+	for _, name := range []string{"a.txt", "b.txt"} {
+		data, _ := os.ReadFile(filepath.Join(dir, name))
+		if want := "\ufeff1" + name[:1] + "\r\n"; string(data) != want {
+			t.Errorf("%s saved as %q, want %q", name, data, want)
+		}
+	}
+}
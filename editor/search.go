@@ -45,6 +45,7 @@ func (e *Editor) findMatches() {
 		match := e.searchMatches[0]
 		e.cursorY = match.y
 		e.cursorX = match.x
+		e.openFoldsAt(match.y)
 		e.setStatusMessage(fmt.Sprintf("Match %d of %d", e.currentMatch+1, len(e.searchMatches)))
 	} else {
 		e.setStatusMessage("No matches found")
@@ -59,6 +60,7 @@ func (e *Editor) nextMatch() {
 	match := e.searchMatches[e.currentMatch]
 	e.cursorY = match.y
 	e.cursorX = match.x
+	e.openFoldsAt(match.y)
 	e.setStatusMessage(fmt.Sprintf("Match %d of %d", e.currentMatch+1, len(e.searchMatches)))
 }
 
@@ -73,6 +75,7 @@ func (e *Editor) previousMatch() {
 	match := e.searchMatches[e.currentMatch]
 	e.cursorY = match.y
 	e.cursorX = match.x
+	e.openFoldsAt(match.y)
 	e.setStatusMessage(fmt.Sprintf("Match %d of %d", e.currentMatch+1, len(e.searchMatches)))
 }
 
@@ -89,6 +92,7 @@ func (e *Editor) findNext() bool {
 			if pos := strings.Index(line[x:], e.searchTerm); pos >= 0 {
 				e.cursorY = y
 				e.cursorX = x + pos
+				e.openFoldsAt(y)
 				return true
 			}
 		}
@@ -99,6 +103,7 @@ func (e *Editor) findNext() bool {
 		if pos := strings.Index(e.lines[y], e.searchTerm); pos >= 0 {
 			e.cursorY = y
 			e.cursorX = pos
+			e.openFoldsAt(y)
 			return true
 		}
 	}
@@ -134,3 +139,31 @@ func (e *Editor) buildSearchIndex() {
 	}
 	e.searchIndex.dirty = false
 }
+
+// markSearchMatches lays the search match styles over a line's styles.
+// Matches left stale by edits since the search are skipped.
+func (e *Editor) markSearchMatches(y int, styles []tcell.Style) []tcell.Style {
+	if e.searchTerm == "" || len(e.searchMatches) == 0 || e.mode == "search" {
+		return styles
+	}
+	line := e.lines[y]
+	theme := e.currentTheme()
+	marked := false
+	for i, m := range e.searchMatches {
+		if m.y != y || m.x > len(line) || !strings.HasPrefix(line[m.x:], e.searchTerm) {
+			continue
+		}
+		if !marked {
+			styles = append([]tcell.Style(nil), styles...)
+			marked = true
+		}
+		key := "search.match"
+		if i == e.currentMatch {
+			key = "selection"
+		}
+		for x := m.x; x < m.x+len(e.searchTerm) && x < len(styles); x++ {
+			styles[x] = theme.Over(key, styles[x])
+		}
+	}
+	return styles
+}
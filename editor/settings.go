@@ -1,115 +1,394 @@
 package editor
 
 import (
-	"bufio"
 	"fmt"
-	"os"
+	"sort"
+	"strconv"
 	"strings"
 )
 
-// Default settings
+// Options.
 //
-//nolint:unused
-var defaultSettings = map[string]string{
-	"tabSize":         "4",
-	"showLineNumbers": "true",
-	"syntaxHighlight": "true",
-	"autoIndent":      "true",
-	"autoComplete":    "true",
-	"theme":           "default",
-	"saveOnExit":      "false",
-	"backupFiles":     "true",
-	"smartIndent":     "true",
-	"wordWrap":        "false",
-}
-
-// Load settings from config file
-func (e *Editor) loadSettings() {
-	// Start with defaults
-	for k, v := range defaultSettings {
-		e.settings[k] = v
-	}
-
-	// Try to open config file
-	file, err := os.Open(e.configFile)
-	if err != nil {
-		// Config file doesn't exist, create with defaults
-		e.saveSettings()
-		return
+// Every setting has a typed entry in optionSpecs. e.settings holds the ones
+// set in the config file or with :set, as canonical strings ("true", "4",
+// "gruvbox"), and e.localSettings the ones the current file overrides (see
+// loadFileSettings); the others have their default. Options are read
+// through option, boolOption and intOption.
+
+type optionKind int
+
+const (
+	optionBool optionKind = iota
+	optionInt
+	optionString
+)
+
+type optionSpec struct {
+	name     string
+	aliases  []string // other names accepted by :set, case doesn't matter
+	kind     optionKind
+	def      string
+	min, max int                          // range of a number
+	parse    func(string) (string, error) // validates a string, returning its canonical form
+	global   bool                         // can't be changed for a single file
+	help     string
+}
+
+var optionSpecs = []*optionSpec{
+	{name: "tabSize", aliases: []string{"tabstop", "ts"}, kind: optionInt, def: "4", min: 1, max: 16, help: "columns a tab character takes up"},
+	{name: "showLineNumbers", aliases: []string{"number", "nu"}, kind: optionBool, def: "true", help: "show line numbers"},
+	{name: "relativeNumber", aliases: []string{"relativenumber", "rnu"}, kind: optionBool, def: "false", help: "number lines from the cursor line"},
+	{name: "numberWidth", aliases: []string{"numberwidth", "nuw"}, kind: optionInt, def: "4", min: 2, max: 20, help: "least columns for line numbers"},
+	{name: "signColumn", aliases: []string{"signcolumn", "scl"}, kind: optionString, def: "auto", parse: parseSignColumn, help: "show the sign column: auto (when there are signs), yes or no"},
+	{name: "syntaxHighlight", aliases: []string{"syntax"}, kind: optionBool, def: "true", help: "highlight syntax"},
+	{name: "autoIndent", aliases: []string{"ai"}, kind: optionBool, def: "true", help: "keep indentation on new lines"},
+	{name: "smartIndent", aliases: []string{"si"}, kind: optionBool, def: "true", help: "indent after an opening brace"},
+	{name: "autoComplete", aliases: []string{"ac"}, kind: optionBool, def: "true", help: "open completions while typing"},
+	{name: "wordWrap", aliases: []string{"wrap"}, kind: optionBool, def: "false", help: "wrap long lines"},
+	{name: "breakIndent", aliases: []string{"breakindent", "bri"}, kind: optionBool, def: "true", help: "indent wrapped rows like their line"},
+	{name: "showBreak", aliases: []string{"showbreak", "sbr"}, kind: optionString, def: "↪ ", help: "shown at the start of wrapped rows"},
+	{name: "textWidth", aliases: []string{"textwidth", "tw"}, kind: optionInt, def: "0", min: 0, max: 1000, help: "width gq and :reflow wrap to (0 means 79)"},
+	{name: "autoWrap", kind: optionBool, def: "true", help: "break lines typed past textWidth when it's set"},
+	{name: "gitSigns", kind: optionBool, def: "true", help: "mark lines changed since the file in HEAD"},
+	{name: "foldMethod", aliases: []string{"foldmethod", "fdm"}, kind: optionString, def: "auto", parse: parseFoldMethod, help: "folds from: auto, indent, brace or manual"},
+	{name: "foldColumn", aliases: []string{"foldcolumn", "fdc"}, kind: optionBool, def: "true", help: "show fold markers next to the text"},
+	{name: "sideScroll", aliases: []string{"sidescroll", "ss"}, kind: optionInt, def: "0", min: 0, max: 100, help: "columns to scroll sideways without wrap (0 centers the cursor)"},
+	{name: "backupFiles", aliases: []string{"backup"}, kind: optionBool, def: "true", help: "keep a backup when saving"},
+	{name: "expandTab", aliases: []string{"et"}, kind: optionBool, def: "true", help: "indent with spaces instead of tabs"},
+	{name: "shiftWidth", aliases: []string{"shiftwidth", "sw"}, kind: optionInt, def: "0", min: 0, max: 16, help: "columns per indentation level (0 uses tabSize)"},
+	{name: "softTabStop", aliases: []string{"softtabstop", "sts"}, kind: optionInt, def: "0", min: -1, max: 16, help: "columns Tab and Backspace move in insert mode (-1 uses shiftWidth)"},
+	{name: "endOfLine", aliases: []string{"fileformat", "ff"}, kind: optionString, def: "lf", parse: parseEndOfLine, help: "line endings written: lf, crlf or cr"},
+	{name: "charset", aliases: []string{"fileencoding", "fenc"}, kind: optionString, def: "utf-8", parse: parseCharset, help: "encoding: utf-8, utf-8-bom or latin1"},
+	{name: "trimTrailingWhitespace", kind: optionBool, def: "false", help: "remove spaces at line ends when saving"},
+	{name: "insertFinalNewline", aliases: []string{"fixendofline", "fixeol"}, kind: optionBool, def: "true", help: "end the file with a newline"},
+	{name: "leader", aliases: []string{"mapleader"}, kind: optionString, def: `\`, parse: parseLeader, global: true, help: "what <leader> means in key mappings"},
+	{name: "timeoutLen", aliases: []string{"timeoutlen", "tm"}, kind: optionInt, def: "1000", min: 0, max: 10000, global: true, help: "milliseconds to wait for the rest of a mapping"},
+	{name: "swapFile", aliases: []string{"swapfile", "swf"}, kind: optionBool, def: "true", global: true, help: "keep unsaved changes in a swap file to recover after a crash"},
+	{name: "updateTime", aliases: []string{"updatetime", "ut"}, kind: optionInt, def: "4000", min: 100, max: 60000, global: true, help: "milliseconds without typing before the swap file is written"},
+	{name: "autoSaveDelay", kind: optionInt, def: "0", min: 0, max: 3600, global: true, help: "seconds without typing before changed files are saved (0 turns it off)"},
+	{name: "saveOnFocusLost", kind: optionBool, def: "false", global: true, help: "save changed files when the terminal loses focus"},
+	{name: "saveOnSwitch", aliases: []string{"autowrite", "aw"}, kind: optionBool, def: "false", global: true, help: "save a changed file when going to another buffer"},
+	{name: "saveOnExit", kind: optionBool, def: "false", global: true, help: "save changed files when quitting, except with :q!"},
+	{name: "swapInterval", kind: optionInt, def: "30", min: 1, max: 3600, global: true, help: "seconds between swap file writes while typing"},
+	{name: "theme", aliases: []string{"colorscheme"}, kind: optionString, def: "default", parse: parseThemeName, global: true, help: "color theme"},
+}
+
+// lsp.<language> options name the command for a language server.
+var lspOptionSpec = &optionSpec{name: "lsp.", kind: optionString, global: true, help: "language server command (empty turns it off)"}
+
+func parseThemeName(name string) (string, error) {
+	_, err := loadTheme(name)
+	return name, err
+}
+
+func parseLeader(value string) (string, error) {
+	keys, err := parseKeys(value, nil)
+	if err == nil && len(keys) == 0 {
+		err = fmt.Errorf("no keys given")
 	}
-	defer file.Close()
+	return value, err
+}
 
-	// Read settings
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := scanner.Text()
-		line = strings.TrimSpace(line)
+// parseEndOfLine also takes vim's fileformat names.
+func parseEndOfLine(value string) (string, error) {
+	switch strings.ToLower(value) {
+	case "lf", "unix":
+		return "lf", nil
+	case "crlf", "dos":
+		return "crlf", nil
+	case "cr", "mac":
+		return "cr", nil
+	}
+	return "", fmt.Errorf("expected lf, crlf or cr, got %q", value)
+}
 
-		// Skip comments and empty lines
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
+func parseCharset(value string) (string, error) {
+	switch value = strings.ToLower(value); value {
+	case "utf-8", "utf8":
+		return "utf-8", nil
+	case "utf-8-bom", "latin1":
+		return value, nil
+	}
+	return "", fmt.Errorf("unsupported charset %q", value)
+}
+
+// lookupOption finds an option by name or alias, returning its key in
+// e.settings.
+func lookupOption(name string) (*optionSpec, string) {
+	if language, ok := strings.CutPrefix(name, "lsp."); ok && language != "" {
+		return lspOptionSpec, "lsp." + strings.ToLower(language)
+	}
+	for _, spec := range optionSpecs {
+		if strings.EqualFold(spec.name, name) {
+			return spec, spec.name
+		}
+		for _, alias := range spec.aliases {
+			if strings.EqualFold(alias, name) {
+				return spec, spec.name
+			}
 		}
+	}
+	return nil, ""
+}
 
-		// Parse key=value
-		parts := strings.SplitN(line, "=", 2)
-		if len(parts) == 2 {
-			key := strings.TrimSpace(parts[0])
-			value := strings.TrimSpace(parts[1])
-			e.settings[key] = value
+// defaultValue is what an option is when it hasn't been set.
+func (spec *optionSpec) defaultValue(key string) string {
+	if spec == lspOptionSpec {
+		return strings.Join(lspServers[strings.TrimPrefix(key, "lsp.")], " ")
+	}
+	return spec.def
+}
+
+// parseValue validates value for the option and returns it in canonical
+// form.
+func (spec *optionSpec) parseValue(value string) (string, error) {
+	switch spec.kind {
+	case optionBool:
+		switch strings.ToLower(value) {
+		case "true", "on", "yes", "1":
+			return "true", nil
+		case "false", "off", "no", "0":
+			return "false", nil
+		}
+		return "", fmt.Errorf("expected on or off, got %q", value)
+	case optionInt:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return "", fmt.Errorf("expected a number, got %q", value)
+		}
+		if n < spec.min || n > spec.max {
+			return "", fmt.Errorf("must be between %d and %d", spec.min, spec.max)
+		}
+		return strconv.Itoa(n), nil
+	default:
+		if spec.parse != nil {
+			return spec.parse(value)
 		}
+		return value, nil
 	}
+}
 
-	// Apply settings
-	e.applySettings()
+// option returns an option's value.
+func (e *Editor) option(name string) string {
+	return e.bufferOption(e.localSettings, name)
 }
 
-// Save settings to config file
-func (e *Editor) saveSettings() {
-	file, err := os.Create(e.configFile)
-	if err != nil {
-		e.setStatusMessage(fmt.Sprintf("Error saving settings: %v", err))
-		return
+// bufferOption returns an option's value for a buffer whose own settings
+// are local.
+func (e *Editor) bufferOption(local map[string]string, name string) string {
+	spec, key := lookupOption(name)
+	if spec == nil {
+		return ""
+	}
+	if value, ok := local[key]; ok {
+		return value
+	}
+	if value, ok := e.settings[key]; ok {
+		return value
 	}
-	defer file.Close()
+	return spec.defaultValue(key)
+}
+
+func (e *Editor) boolOption(name string) bool {
+	return e.option(name) == "true"
+}
 
-	// Write header
-	file.WriteString("# Kiki's Text Editor Configuration\n\n")
+func (e *Editor) intOption(name string) int {
+	n, _ := strconv.Atoi(e.option(name))
+	return n
+}
 
-	// Write settings
-	for k, v := range e.settings {
-		file.WriteString(fmt.Sprintf("%s = %s\n", k, v))
+// setOption validates and sets an option, then applies the settings. As in
+// vim, a value the current file overrides is changed too.
+func (e *Editor) setOption(name, value string) error {
+	spec, key := lookupOption(name)
+	if spec == nil {
+		return fmt.Errorf("unknown option: %s", name)
+	}
+	value, err := spec.parseValue(value)
+	if err != nil {
+		return fmt.Errorf("%s: %v", key, err)
 	}
+	if e.settings == nil {
+		e.settings = make(map[string]string)
+	}
+	e.settings[key] = value
+	if _, ok := e.localSettings[key]; ok {
+		e.localSettings[key] = value
+	}
+	e.applySettings()
+	return nil
+}
 
-	e.setStatusMessage("Settings saved")
+// resetOption puts an option back to its default.
+func (e *Editor) resetOption(name string) error {
+	spec, key := lookupOption(name)
+	if spec == nil {
+		return fmt.Errorf("unknown option: %s", name)
+	}
+	delete(e.settings, key)
+	delete(e.localSettings, key)
+	e.applySettings()
+	return nil
 }
 
 // Apply loaded settings to editor
 func (e *Editor) applySettings() {
-	// Apply tab size
-	if val, ok := e.settings["tabSize"]; ok {
-		var tabSize int
-		fmt.Sscan(val, &tabSize)
-		if tabSize > 0 {
-			e.tabSize = tabSize
+	e.tabSize = e.intOption("tabSize")
+	e.showLineNumbers = e.boolOption("showLineNumbers")
+	e.syntaxHighlight = e.boolOption("syntaxHighlight")
+	if wrap := e.boolOption("wordWrap"); wrap != e.wordWrap {
+		e.wordWrap = wrap
+		e.keepCursorVisible()
+	}
+
+	// Apply color theme
+	if name := e.option("theme"); e.theme == nil || e.theme.Name != name {
+		if err := e.setTheme(name); err != nil {
+			e.setStatusMessage(fmt.Sprintf("Theme error: %v", err))
 		}
 	}
+	e.watchConfigFiles()
+}
+
+// formatOption shows an option as :set would take it.
+func formatOption(key, value string) string {
+	return key + "=" + value
+}
 
-	// Apply line numbers
-	if val, ok := e.settings["showLineNumbers"]; ok {
-		e.showLineNumbers = (val == "true")
+// setCommand runs :set with the vim forms:
+//
+//	:set               options that differ from their defaults
+//	:set all           every option
+//	:set opt=value     also "opt value" and "opt:value"
+//	:set opt           turn a boolean on, or show any other option
+//	:set noopt         turn a boolean off; opt! or invopt toggle it
+//	:set opt?          show the value
+//	:set opt&          reset to the default
+func (e *Editor) setCommand(args string) {
+	fields := strings.Fields(args)
+	if len(fields) == 0 {
+		e.showOptions(false)
+		return
+	}
+	if len(fields) == 1 && fields[0] == "all" {
+		e.showOptions(true)
+		return
 	}
 
-	// Apply syntax highlighting
-	if val, ok := e.settings["syntaxHighlight"]; ok {
-		e.syntaxHighlight = (val == "true")
+	// The older "set tabsize 4" and "set syntax on"
+	if len(fields) == 2 && !strings.ContainsAny(args, "=:?&!") {
+		if spec, _ := lookupOption(fields[0]); spec != nil {
+			fields = []string{fields[0] + "=" + fields[1]}
+		}
 	}
 
-	// Other settings can be applied as needed
+	var shown []string
+	for _, arg := range fields {
+		msg, err := e.setArgument(arg)
+		if err != nil {
+			e.setStatusMessage(err.Error())
+			return
+		}
+		shown = append(shown, msg)
+	}
+	e.setStatusMessage(strings.Join(shown, "  "))
 }
 
-// Set a setting and save
-func (e *Editor) setSetting(key, value string) {
-	e.settings[key] = value
-	e.applySettings()
-	e.saveSettings()
+func (e *Editor) setArgument(arg string) (string, error) {
+	show := func(name string) (string, error) {
+		_, key := lookupOption(name)
+		return formatOption(key, e.option(key)), nil
+	}
+	toggle := func(name string) (string, error) {
+		if spec, _ := lookupOption(name); spec == nil || spec.kind != optionBool {
+			return "", fmt.Errorf("not a boolean option: %s", name)
+		}
+		if err := e.setOption(name, strconv.FormatBool(!e.boolOption(name))); err != nil {
+			return "", err
+		}
+		return show(name)
+	}
+
+	if i := strings.IndexAny(arg, "=:"); i > 0 {
+		name, value := arg[:i], arg[i+1:]
+		if err := e.setOption(name, value); err != nil {
+			return "", err
+		}
+		return show(name)
+	}
+
+	switch {
+	case strings.HasSuffix(arg, "?"):
+		name := strings.TrimSuffix(arg, "?")
+		if spec, _ := lookupOption(name); spec == nil {
+			return "", fmt.Errorf("unknown option: %s", name)
+		}
+		return show(name)
+	case strings.HasSuffix(arg, "&"):
+		name := strings.TrimSuffix(arg, "&")
+		if err := e.resetOption(name); err != nil {
+			return "", err
+		}
+		return show(name)
+	case strings.HasSuffix(arg, "!"):
+		return toggle(strings.TrimSuffix(arg, "!"))
+	}
+
+	if spec, _ := lookupOption(arg); spec != nil {
+		if spec.kind != optionBool {
+			return show(arg)
+		}
+		if err := e.setOption(arg, "true"); err != nil {
+			return "", err
+		}
+		return show(arg)
+	}
+	if name, ok := strings.CutPrefix(arg, "no"); ok {
+		if spec, _ := lookupOption(name); spec != nil && spec.kind == optionBool {
+			if err := e.setOption(name, "false"); err != nil {
+				return "", err
+			}
+			return show(name)
+		}
+	}
+	if name, ok := strings.CutPrefix(arg, "inv"); ok {
+		if spec, _ := lookupOption(name); spec != nil {
+			return toggle(name)
+		}
+	}
+	return "", fmt.Errorf("unknown option: %s", arg)
+}
+
+// showOptions lists the options in a popup, all of them or only the ones
+// that differ from their defaults.
+func (e *Editor) showOptions(all bool) {
+	var lines []string
+	for _, spec := range optionSpecs {
+		value := e.option(spec.name)
+		if all || value != spec.def {
+			lines = append(lines, fmt.Sprintf("%-22s %-10s %s", spec.name, value, spec.help))
+		}
+	}
+	// lsp.<language> and [filetype.<name>] settings
+	var others []string
+	for key := range e.settings {
+		if strings.HasPrefix(key, "lsp.") || strings.HasPrefix(key, "filetype.") {
+			others = append(others, key)
+		}
+	}
+	sort.Strings(others)
+	for _, key := range others {
+		lines = append(lines, fmt.Sprintf("%-22s %q", key, e.settings[key]))
+	}
+
+	if len(lines) == 0 {
+		e.setStatusMessage("All options have their defaults (:set all to list them)")
+		return
+	}
+	title := "Options changed from their defaults"
+	if all {
+		title = "Options"
+	}
+	e.showPopup(title, lines)
 }
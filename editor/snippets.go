@@ -0,0 +1,543 @@
+package editor
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Snippets expand a short prefix into a block of code, written in the
+// syntax described in snippetsyntax.go. In a body, "\t" is one level of
+// indentation and new lines keep the indentation of the line the snippet is
+// expanded on.
+//
+// Besides the built-in ones, snippets are read from VS Code style JSON
+// files in snippets/ in the config directory, named after the language
+// (go.json, python.json, ...) or global.json for every language:
+//
+//	{
+//	  "Print": {"prefix": "pr", "body": ["fmt.Println($1)", "$0"], "description": "print"}
+//	}
+
+type snippet struct {
+	trigger     string
+	description string
+	body        string
+}
+
+var builtinSnippets = map[int][]*snippet{
+	LangGo: {
+		{"iferr", "if err != nil", "if err != nil {\n\treturn ${1:err}\n}$0"},
+		{"fori", "indexed for loop", "for ${1:i} := 0; $1 < ${2:n}; $1++ {\n\t$0\n}"},
+		{"forr", "for range loop", "for ${1:_}, ${2:v} := range ${3:items} {\n\t$0\n}"},
+		{"funcm", "func main", "func main() {\n\t$0\n}"},
+		{"errorf", "fmt.Errorf", "fmt.Errorf(\"${1:message}: %w\", err)$0"},
+	},
+	LangPython: {
+		{"ifmain", "if __name__ == \"__main__\"", "if __name__ == \"__main__\":\n\t${0:main()}"},
+		{"defi", "method with self", "def ${1:name}(self$2):\n\t${0:pass}"},
+		{"withopen", "with open", "with open(${1:path}, \"${2|r,w,a,rb,wb|}\") as ${3:f}:\n\t$0"},
+	},
+	LangJavaScript: {
+		{"arrow", "arrow function", "(${1:args}) => {\n\t$0\n}"},
+		{"clog", "console.log", "console.log($1);$0"},
+		{"forof", "for...of loop", "for (const ${1:item} of ${2:items}) {\n\t$0\n}"},
+	},
+	LangRust: {
+		{"fnmain", "fn main", "fn main() {\n\t$0\n}"},
+		{"test", "test function", "#[test]\nfn ${1:name}() {\n\t$0\n}"},
+		{"matchres", "match on Result", "match ${1:expr} {\n\tOk(${2:v}) => $2,\n\tErr(e) => return Err(e.into()),\n}$0"},
+	},
+}
+
+func snippetsDir() string {
+	return filepath.Join(configDir(), "snippets")
+}
+
+// snippets returns the snippets for the open file's language, the user's
+// first.
+func (e *Editor) snippets() []*snippet {
+	var names []string
+	if lexer := e.currentLexer(); lexer != nil {
+		config := lexer.Config()
+		names = append(names, strings.ToLower(config.Name))
+		names = append(names, config.Aliases...)
+	}
+	names = append(names, "global")
+
+	var list []*snippet
+	seen := map[string]bool{}
+	for _, name := range names {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		list = append(list, e.userSnippets(name)...)
+	}
+	return append(list, builtinSnippets[e.detectLanguage()]...)
+}
+
+// userSnippets reads a snippet file once, reporting errors in the status bar.
+func (e *Editor) userSnippets(name string) []*snippet {
+	if list, ok := e.snippetFiles[name]; ok {
+		return list
+	}
+	if e.snippetFiles == nil {
+		e.snippetFiles = make(map[string][]*snippet)
+	}
+	var list []*snippet
+	path := filepath.Join(snippetsDir(), name+".json")
+	if data, err := os.ReadFile(path); err == nil {
+		if list, err = parseSnippetFile(data); err != nil {
+			e.setStatusMessage(fmt.Sprintf("%s: %v", filepath.Base(path), err))
+		}
+	}
+	e.snippetFiles[name] = list
+	return list
+}
+
+// parseSnippetFile reads a VS Code snippet file. Comments are allowed, as
+// they are there.
+func parseSnippetFile(data []byte) ([]*snippet, error) {
+	var file map[string]struct {
+		Prefix      json.RawMessage `json:"prefix"`
+		Body        json.RawMessage `json:"body"`
+		Description string          `json:"description"`
+	}
+	if err := json.Unmarshal(stripJSONComments(data), &file); err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(file))
+	for name := range file {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var list []*snippet
+	for _, name := range names {
+		def := file[name]
+		prefixes, err := stringOrList(def.Prefix)
+		if err != nil || len(prefixes) == 0 {
+			return nil, fmt.Errorf("snippet %q: prefix must be a string or a list of strings", name)
+		}
+		body, err := stringOrList(def.Body)
+		if err != nil {
+			return nil, fmt.Errorf("snippet %q: body must be a string or a list of strings", name)
+		}
+		description := def.Description
+		if description == "" {
+			description = name
+		}
+		for _, prefix := range prefixes {
+			list = append(list, &snippet{prefix, description, strings.Join(body, "\n")})
+		}
+	}
+	return list, nil
+}
+
+func stringOrList(raw json.RawMessage) ([]string, error) {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return []string{s}, nil
+	}
+	var list []string
+	err := json.Unmarshal(raw, &list)
+	return list, err
+}
+
+// stripJSONComments blanks out // and /* */ comments outside strings.
+func stripJSONComments(data []byte) []byte {
+	out := append([]byte{}, data...)
+	inString := false
+	for i := 0; i < len(out); i++ {
+		switch {
+		case inString:
+			if out[i] == '\\' {
+				i++
+			} else if out[i] == '"' {
+				inString = false
+			}
+		case out[i] == '"':
+			inString = true
+		case out[i] == '/' && i+1 < len(out) && out[i+1] == '/':
+			for ; i < len(out) && out[i] != '\n'; i++ {
+				out[i] = ' '
+			}
+		case out[i] == '/' && i+1 < len(out) && out[i+1] == '*':
+			for ; i < len(out) && !(out[i] == '*' && i+1 < len(out) && out[i+1] == '/'); i++ {
+				if out[i] != '\n' {
+					out[i] = ' '
+				}
+			}
+			if i+1 < len(out) {
+				out[i], out[i+1] = ' ', ' '
+				i++
+			}
+		}
+	}
+	return out
+}
+
+// snippetVariable returns the value of a snippet variable such as
+// TM_FILENAME; ok is false for names it doesn't know.
+func (e *Editor) snippetVariable(name string) (string, bool) {
+	now := time.Now()
+	path, _ := filepath.Abs(e.filename)
+	line := ""
+	if e.cursorY < len(e.lines) {
+		line = e.lines[e.cursorY]
+	}
+	comment := "//"
+	if lang := e.detectLanguage(); lang == LangPython {
+		comment = "#"
+	}
+
+	switch name {
+	case "TM_SELECTED_TEXT", "CLIPBOARD":
+		return "", true
+	case "TM_CURRENT_LINE":
+		return line, true
+	case "TM_CURRENT_WORD":
+		if ctx := e.completionContext(); ctx != nil {
+			return ctx.word, true
+		}
+		return "", true
+	case "TM_LINE_INDEX":
+		return strconv.Itoa(e.cursorY), true
+	case "TM_LINE_NUMBER":
+		return strconv.Itoa(e.cursorY + 1), true
+	case "TM_FILENAME":
+		return filepath.Base(e.filename), e.filename != ""
+	case "TM_FILENAME_BASE":
+		base := filepath.Base(e.filename)
+		return strings.TrimSuffix(base, filepath.Ext(base)), e.filename != ""
+	case "TM_DIRECTORY":
+		return filepath.Dir(path), e.filename != ""
+	case "TM_FILEPATH":
+		return path, e.filename != ""
+	case "RELATIVE_FILEPATH", "WORKSPACE_NAME", "WORKSPACE_FOLDER":
+		root := filepath.Dir(path)
+		if repo := findGitRepo(root); repo != nil {
+			root = repo.root
+		}
+		switch name {
+		case "WORKSPACE_NAME":
+			return filepath.Base(root), true
+		case "WORKSPACE_FOLDER":
+			return root, true
+		}
+		rel, err := filepath.Rel(root, path)
+		return rel, err == nil && e.filename != ""
+	case "CURRENT_YEAR":
+		return now.Format("2006"), true
+	case "CURRENT_YEAR_SHORT":
+		return now.Format("06"), true
+	case "CURRENT_MONTH":
+		return now.Format("01"), true
+	case "CURRENT_MONTH_NAME":
+		return now.Format("January"), true
+	case "CURRENT_MONTH_NAME_SHORT":
+		return now.Format("Jan"), true
+	case "CURRENT_DATE":
+		return now.Format("02"), true
+	case "CURRENT_DAY_NAME":
+		return now.Format("Monday"), true
+	case "CURRENT_DAY_NAME_SHORT":
+		return now.Format("Mon"), true
+	case "CURRENT_HOUR":
+		return now.Format("15"), true
+	case "CURRENT_MINUTE":
+		return now.Format("04"), true
+	case "CURRENT_SECOND":
+		return now.Format("05"), true
+	case "CURRENT_SECONDS_UNIX":
+		return strconv.FormatInt(now.Unix(), 10), true
+	case "RANDOM":
+		return fmt.Sprintf("%06d", rand.Intn(1000000)), true
+	case "RANDOM_HEX":
+		return fmt.Sprintf("%06x", rand.Intn(1<<24)), true
+	case "UUID":
+		b := make([]byte, 16)
+		for i := range b {
+			b[i] = byte(rand.Intn(256))
+		}
+		b[6], b[8] = b[6]&0x0f|0x40, b[8]&0x3f|0x80
+		return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), true
+	case "LINE_COMMENT":
+		return comment, true
+	case "BLOCK_COMMENT_START":
+		return "/*", true
+	case "BLOCK_COMMENT_END":
+		return "*/", true
+	}
+	return "", false
+}
+
+// snippetSession tracks the tab stops of an expanded snippet while its
+// fields are filled in. Offsets count bytes from the start of line y, with
+// lines joined by "\n".
+type snippetSession struct {
+	y         int
+	lineCount int
+	stops     []*snippetStop
+	current   int
+	selected  bool // typing replaces the placeholder
+}
+
+// prefixSnippet returns the snippet whose prefix is the word before the
+// cursor, and where the word starts.
+func (e *Editor) prefixSnippet() (*snippet, int) {
+	ctx := e.completionContext()
+	if ctx == nil || ctx.word == "" {
+		return nil, 0
+	}
+	for _, s := range e.snippets() {
+		if s.trigger == ctx.word {
+			return s, ctx.wordStart
+		}
+	}
+	return nil, 0
+}
+
+// expandPrefixSnippet expands the snippet named by the word before the
+// cursor, for prefix+Tab.
+func (e *Editor) expandPrefixSnippet() bool {
+	s, start := e.prefixSnippet()
+	if s == nil {
+		return false
+	}
+	e.addUndo(Action{
+		Type:    "insert",
+		action:  "insert",
+		lines:   append([]string{}, e.lines...),
+		cursorX: e.cursorX,
+		cursorY: e.cursorY,
+		text:    s.trigger,
+	})
+	e.expandSnippet(s, start, min(e.cursorX, len(e.lines[e.cursorY])))
+	return true
+}
+
+// expandSnippet replaces line bytes [start, end) on the cursor line with
+// the snippet's body and moves to its first tab stop.
+func (e *Editor) expandSnippet(s *snippet, start, end int) {
+	line := e.lines[e.cursorY]
+	indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+	unit := e.indentUnit()
+
+	body, stops := renderSnippet(parseSnippet(s.body), e.snippetVariable, indent, unit)
+	for _, stop := range stops {
+		for _, r := range stop.ranges {
+			r.start += start
+			r.end += start
+		}
+	}
+
+	text := line[:start] + body + line[end:]
+	newLines := strings.Split(text, "\n")
+	e.lines = append(e.lines[:e.cursorY], append(newLines, e.lines[e.cursorY+1:]...)...)
+	e.markChanged()
+
+	e.snippetSession = &snippetSession{y: e.cursorY, lineCount: len(newLines), stops: stops}
+	e.selectSnippetStop(0)
+}
+
+// selectSnippetStop moves to tab stop i; reaching $0 ends the session.
+func (e *Editor) selectSnippetStop(i int) {
+	session := e.snippetSession
+	session.current = i
+	stop := session.stops[i]
+	r := stop.ranges[0]
+	e.cursorY, e.cursorX = session.position(e.lines, r.start)
+	session.selected = r.end > r.start
+
+	e.completionActive = false
+	if stop.index == 0 {
+		e.snippetSession = nil
+		return
+	}
+	if len(stop.choices) > 0 {
+		var choices []Completion
+		for _, choice := range stop.choices {
+			choices = append(choices, Completion{Text: choice, Description: "choice", kind: "choice", start: e.cursorX})
+		}
+		e.setCompletions(choices)
+	}
+}
+
+// nextSnippetStop moves to the next (1) or previous (-1) tab stop.
+func (e *Editor) nextSnippetStop(dir int) {
+	session := e.snippetSession
+	if session == nil {
+		return
+	}
+	i := session.current + dir
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(session.stops) {
+		i = len(session.stops) - 1
+	}
+	e.selectSnippetStop(i)
+}
+
+// snippetField returns the range being edited, ending the session if the
+// cursor has left it.
+func (e *Editor) snippetField() *snippetRange {
+	session := e.snippetSession
+	if session == nil {
+		return nil
+	}
+	r := session.stops[session.current].ranges[0]
+	offset, ok := session.offset(e.lines, e.cursorY, e.cursorX)
+	if !ok || offset < r.start || offset > r.end {
+		e.snippetSession = nil
+		return nil
+	}
+	return r
+}
+
+// snippetInsert types ch into the current field and its mirrors. It returns
+// false when no field is being edited.
+func (e *Editor) snippetInsert(ch rune) bool {
+	r := e.snippetField()
+	if r == nil {
+		return false
+	}
+	session := e.snippetSession
+	text := session.text(e.lines)[r.start:r.end]
+	pos, _ := session.offset(e.lines, e.cursorY, e.cursorX)
+	pos -= r.start
+	if session.selected {
+		text, pos = "", 0
+	}
+	e.setSnippetField(text[:pos]+string(ch)+text[pos:], pos+len(string(ch)))
+	return true
+}
+
+// snippetBackspace deletes before the cursor inside the current field.
+func (e *Editor) snippetBackspace() bool {
+	r := e.snippetField()
+	if r == nil {
+		return false
+	}
+	session := e.snippetSession
+	text := session.text(e.lines)[r.start:r.end]
+	pos, _ := session.offset(e.lines, e.cursorY, e.cursorX)
+	pos -= r.start
+	switch {
+	case session.selected:
+		e.setSnippetField("", 0)
+	case pos > 0:
+		e.setSnippetField(text[:pos-1]+text[pos:], pos-1)
+	default:
+		e.snippetSession = nil
+		return false
+	}
+	return true
+}
+
+// setSnippetField sets the current field's text, updating its mirrors, and
+// puts the cursor at byte cursor of the field.
+func (e *Editor) setSnippetField(value string, cursor int) {
+	session := e.snippetSession
+	stop := session.stops[session.current]
+
+	// From the end, so the earlier ranges stay put
+	ranges := append([]*snippetRange{}, stop.ranges...)
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].start > ranges[j].start })
+	for _, r := range ranges {
+		e.lines = session.replace(e.lines, r, value)
+	}
+
+	// Fields containing this one have mirrors to update too
+	for round := 0; round < 10; round++ {
+		changed := false
+		text := session.text(e.lines)
+		for _, other := range session.stops {
+			primary := text[other.ranges[0].start:other.ranges[0].end]
+			for _, r := range other.ranges[1:] {
+				if text[r.start:r.end] != primary {
+					e.lines = session.replace(e.lines, r, primary)
+					changed = true
+					break
+				}
+			}
+			if changed {
+				break
+			}
+		}
+		if !changed {
+			break
+		}
+	}
+
+	session.selected = false
+	e.cursorY, e.cursorX = session.position(e.lines, stop.ranges[0].start+cursor)
+	e.markChanged()
+}
+
+// text returns the lines the snippet covers, joined.
+func (s *snippetSession) text(lines []string) string {
+	end := min(s.y+s.lineCount, len(lines))
+	return strings.Join(lines[s.y:end], "\n")
+}
+
+// replace puts value in range r and moves the other ranges to match.
+func (s *snippetSession) replace(lines []string, r *snippetRange, value string) []string {
+	text := s.text(lines)
+	from, to := r.start, r.end
+	delta := len(value) - (to - from)
+	for _, stop := range s.stops {
+		for _, other := range stop.ranges {
+			switch {
+			case other == r:
+				other.end = from + len(value)
+			case other.end <= from:
+			case other.start >= to:
+				other.start += delta
+				other.end += delta
+			case other.start <= from && other.end >= to:
+				other.end += delta
+			default:
+				// Overwritten by the edit
+				other.start, other.end = from, from
+			}
+		}
+	}
+
+	newLines := strings.Split(text[:from]+value+text[to:], "\n")
+	end := min(s.y+s.lineCount, len(lines))
+	s.lineCount = len(newLines)
+	return append(lines[:s.y:s.y], append(newLines, lines[end:]...)...)
+}
+
+// position converts an offset to a line and column.
+func (s *snippetSession) position(lines []string, offset int) (int, int) {
+	y := s.y
+	for y < len(lines)-1 && offset > len(lines[y]) {
+		offset -= len(lines[y]) + 1
+		y++
+	}
+	return y, min(offset, len(lines[y]))
+}
+
+// offset converts a line and column to an offset, if they are inside the
+// snippet.
+func (s *snippetSession) offset(lines []string, y, x int) (int, bool) {
+	if y < s.y || y >= s.y+s.lineCount {
+		return 0, false
+	}
+	offset := x
+	for i := s.y; i < y; i++ {
+		offset += len(lines[i]) + 1
+	}
+	return offset, true
+}
@@ -0,0 +1,123 @@
+package editor
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+func TestParseSnippet(t *testing.T) {
+	vars := func(name string) (string, bool) {
+		if name == "TM_FILENAME" {
+			return "main.go", true
+		}
+		return "", name == "TM_SELECTED_TEXT"
+	}
+	body := `${1:name} = ${2|a,b|} // $1 in $TM_FILENAME ${TM_SELECTED_TEXT:none} $NOPE \$1${0}`
+	text, stops := renderSnippet(parseSnippet(body), vars, "", "    ")
+	if want := "name = a // name in main.go none NOPE $1"; text != want {
+		t.Fatalf("rendered %q, want %q", text, want)
+	}
+	if len(stops) != 3 || stops[0].index != 1 || stops[1].index != 2 || stops[2].index != 0 {
+		t.Fatalf("unexpected stops %+v", stops)
+	}
+	if len(stops[0].ranges) != 2 || text[stops[0].ranges[1].start:stops[0].ranges[1].end] != "name" {
+		t.Errorf("expected $1 to be mirrored")
+	}
+	if strings.Join(stops[1].choices, ",") != "a,b" {
+		t.Errorf("choices = %q", stops[1].choices)
+	}
+
+	// Unfinished syntax is kept as text; nested placeholders are stops too
+	text, stops = renderSnippet(parseSnippet("${1:outer ${2:inner}} ${x"), vars, "", "    ")
+	if text != "outer inner ${x" || len(stops) != 3 {
+		t.Errorf("rendered %q with %d stops", text, len(stops))
+	}
+}
+
+func TestSnippetSession(t *testing.T) {
+	ed := &Editor{filename: "main.go", mode: "insert", tabSize: 4, settings: map[string]string{}}
+	ed.lines = []string{"    fori"}
+	ed.cursorX = len(ed.lines[0])
+	key := func(k tcell.Key, r rune) {
+		ed.handleInsertMode(tcell.NewEventKey(k, r, tcell.ModNone))
+	}
+	typeRunes := func(s string) {
+		for _, r := range s {
+			key(tcell.KeyRune, r)
+		}
+	}
+
+	// prefix+Tab expands with the first placeholder selected
+	key(tcell.KeyTab, 0)
+	if ed.lines[0] != "    for i := 0; i < n; i++ {" || ed.snippetSession == nil {
+		t.Fatalf("expanded to %q", ed.lines)
+	}
+
+	// Typing replaces the placeholder and updates its mirrors
+	typeRunes("idx")
+	if ed.lines[0] != "    for idx := 0; idx < n; idx++ {" {
+		t.Fatalf("after typing: %q", ed.lines[0])
+	}
+	key(tcell.KeyBackspace2, 0)
+	key(tcell.KeyTab, 0)
+	typeRunes("len(s)")
+	if ed.lines[0] != "    for id := 0; id < len(s); id++ {" {
+		t.Fatalf("second field: %q", ed.lines[0])
+	}
+
+	// The last Tab goes to $0 and ends the session
+	key(tcell.KeyTab, 0)
+	if ed.snippetSession != nil || ed.cursorY != 1 || ed.cursorX != 8 {
+		t.Errorf("expected to finish inside the loop, at %d,%d", ed.cursorY, ed.cursorX)
+	}
+	ed.undo()
+	if ed.lines[0] != "    fori" {
+		t.Errorf("undo should remove the whole expansion, got %q", ed.lines)
+	}
+}
+
+func TestSnippetChoice(t *testing.T) {
+	ed := &Editor{filename: "script.py", mode: "insert", tabSize: 4, settings: map[string]string{}}
+	ed.lines = []string{"withopen"}
+	ed.cursorX = len(ed.lines[0])
+	ed.handleInsertMode(tcell.NewEventKey(tcell.KeyTab, 0, tcell.ModNone))
+	ed.handleInsertMode(tcell.NewEventKey(tcell.KeyTab, 0, tcell.ModNone))
+	if !ed.completionActive || len(ed.completions) != 5 {
+		t.Fatalf("expected the choices in the popup, got %v", completionTexts(ed.completions))
+	}
+	ed.handleInsertMode(tcell.NewEventKey(tcell.KeyCtrlN, 0, tcell.ModNone))
+	ed.handleInsertMode(tcell.NewEventKey(tcell.KeyEnter, 0, tcell.ModNone))
+	if ed.lines[0] != `with open(path, "w") as f:` {
+		t.Errorf("chose %q", ed.lines[0])
+	}
+}
+
+func TestSnippetFiles(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", home)
+	writeTestFile(t, home, "kiki-editor/snippets/go.json", `{
+	// Comments are allowed, as in VS Code
+	"Package": {
+		"prefix": ["pkg", "package"],
+		"body": ["// Package for ${TM_FILENAME_BASE}", "package ${1:main}$0"],
+		"description": "package clause"
+	}
+}`)
+
+	ed := &Editor{filename: filepath.Join(home, "server.go"), tabSize: 4}
+	ed.lines = []string{"package"}
+	ed.cursorX = len(ed.lines[0])
+	if !ed.expandPrefixSnippet() {
+		t.Fatal("expected the snippet from go.json")
+	}
+	if strings.Join(ed.lines, "\n") != "// Package for server\npackage main" {
+		t.Errorf("expanded to %q", ed.lines)
+	}
+
+	if _, err := parseSnippetFile([]byte(`{"x": {"body": "y"}}`)); err == nil {
+		t.Errorf("expected an error for a snippet without a prefix")
+	}
+}
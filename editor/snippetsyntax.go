@@ -0,0 +1,339 @@
+package editor
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Snippet bodies use the TextMate/VS Code syntax:
+//
+//	$1, ${1}            tab stop; $0 is where the cursor ends up
+//	${1:default}        placeholder, may contain other tab stops
+//	${1|one,two,three|} choice
+//	$NAME, ${NAME:def}  variable such as $TM_FILENAME
+//
+// A tab stop used more than once is mirrored: typing in the first one
+// changes the others. "\" escapes "$", "}" and "\". Transforms
+// (${1/regex/format/}) are accepted but the text is used unchanged.
+
+type snippetNode struct {
+	text     string // literal text
+	stop     int    // tab stop number, -1 if this is not a tab stop
+	variable string
+	children []snippetNode // placeholder or variable default
+	choices  []string
+}
+
+type snippetRange struct {
+	start, end int
+}
+
+// snippetStop is a tab stop in expanded text. The first range is the one
+// being edited, the others mirror it.
+type snippetStop struct {
+	index   int
+	ranges  []*snippetRange
+	choices []string
+}
+
+type snippetParser struct {
+	s   string
+	pos int
+}
+
+func parseSnippet(body string) []snippetNode {
+	p := &snippetParser{s: body}
+	return p.parseNodes(false)
+}
+
+// parseNodes reads up to the end of the body, or the "}" closing a
+// placeholder when nested.
+func (p *snippetParser) parseNodes(nested bool) []snippetNode {
+	var nodes []snippetNode
+	var text strings.Builder
+	flush := func() {
+		if text.Len() > 0 {
+			nodes = append(nodes, snippetNode{text: text.String(), stop: -1})
+			text.Reset()
+		}
+	}
+	for p.pos < len(p.s) {
+		c := p.s[p.pos]
+		switch {
+		case c == '\\' && p.pos+1 < len(p.s) && strings.IndexByte(`$}\`, p.s[p.pos+1]) >= 0:
+			text.WriteByte(p.s[p.pos+1])
+			p.pos += 2
+		case c == '}' && nested:
+			flush()
+			return nodes
+		case c == '$':
+			if node, ok := p.parseDollar(); ok {
+				flush()
+				nodes = append(nodes, node)
+			} else {
+				text.WriteByte('$')
+				p.pos++
+			}
+		default:
+			text.WriteByte(c)
+			p.pos++
+		}
+	}
+	flush()
+	return nodes
+}
+
+// parseDollar reads a tab stop, placeholder, choice or variable at "$",
+// leaving the position alone if there isn't a valid one.
+func (p *snippetParser) parseDollar() (snippetNode, bool) {
+	start := p.pos
+	p.pos++
+	node := snippetNode{stop: -1}
+
+	if n, ok := p.readInt(); ok {
+		node.stop = n
+		return node, true
+	}
+	if name := p.readName(); name != "" {
+		node.variable = name
+		return node, true
+	}
+	if !p.consume('{') {
+		p.pos = start
+		return node, false
+	}
+
+	if n, ok := p.readInt(); ok {
+		node.stop = n
+	} else if name := p.readName(); name != "" {
+		node.variable = name
+	} else {
+		p.pos = start
+		return node, false
+	}
+
+	switch {
+	case p.consume('}'):
+		return node, true
+	case p.consume(':'):
+		node.children = p.parseNodes(true)
+		if p.consume('}') {
+			return node, true
+		}
+	case node.stop >= 0 && p.consume('|'):
+		if choices, ok := p.readChoices(); ok {
+			node.choices = choices
+			return node, true
+		}
+	case p.consume('/'):
+		if p.skipTransform() {
+			return node, true
+		}
+	}
+	p.pos = start
+	return node, false
+}
+
+func (p *snippetParser) consume(c byte) bool {
+	if p.pos < len(p.s) && p.s[p.pos] == c {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *snippetParser) readInt() (int, bool) {
+	start := p.pos
+	for p.pos < len(p.s) && p.s[p.pos] >= '0' && p.s[p.pos] <= '9' {
+		p.pos++
+	}
+	if p.pos == start {
+		return 0, false
+	}
+	n, err := strconv.Atoi(p.s[start:p.pos])
+	return n, err == nil
+}
+
+func (p *snippetParser) readName() string {
+	start := p.pos
+	for p.pos < len(p.s) {
+		c := p.s[p.pos]
+		if c != '_' && !(c >= 'a' && c <= 'z') && !(c >= 'A' && c <= 'Z') &&
+			!(p.pos > start && c >= '0' && c <= '9') {
+			break
+		}
+		p.pos++
+	}
+	return p.s[start:p.pos]
+}
+
+// readChoices reads "one,two|}" after the opening "|".
+func (p *snippetParser) readChoices() ([]string, bool) {
+	var choices []string
+	var choice strings.Builder
+	for p.pos < len(p.s) {
+		c := p.s[p.pos]
+		switch {
+		case c == '\\' && p.pos+1 < len(p.s) && strings.IndexByte(`$}\,|`, p.s[p.pos+1]) >= 0:
+			choice.WriteByte(p.s[p.pos+1])
+			p.pos += 2
+		case c == ',':
+			choices = append(choices, choice.String())
+			choice.Reset()
+			p.pos++
+		case c == '|':
+			p.pos++
+			if !p.consume('}') {
+				return nil, false
+			}
+			return append(choices, choice.String()), true
+		default:
+			choice.WriteByte(c)
+			p.pos++
+		}
+	}
+	return nil, false
+}
+
+// skipTransform skips "regex/format/options}" after the first "/".
+func (p *snippetParser) skipTransform() bool {
+	slashes := 1
+	for p.pos < len(p.s) {
+		c := p.s[p.pos]
+		p.pos++
+		switch {
+		case c == '\\':
+			p.pos++
+		case c == '/':
+			slashes++
+		case c == '}' && slashes >= 3:
+			return true
+		}
+	}
+	return false
+}
+
+// snippetRender turns parsed nodes into text, recording where each tab stop
+// ends up.
+type snippetRender struct {
+	out       strings.Builder
+	stops     map[int]*snippetStop
+	defining  map[int]*snippetNode // the occurrence each tab stop is edited in
+	variable  func(name string) (string, bool)
+	indent    string // indentation of the line the snippet is expanded on
+	unit      string // one level of indentation
+	recording bool
+}
+
+// renderSnippet expands nodes, returning the text and its tab stops in the
+// order Tab visits them, with $0 last.
+func renderSnippet(nodes []snippetNode, variable func(string) (string, bool), indent, unit string) (string, []*snippetStop) {
+	r := &snippetRender{
+		stops:     map[int]*snippetStop{},
+		defining:  map[int]*snippetNode{},
+		variable:  variable,
+		indent:    indent,
+		unit:      unit,
+		recording: true,
+	}
+	r.findDefinitions(nodes)
+	r.render(nodes)
+	text := r.out.String()
+
+	if r.stops[0] == nil {
+		r.stops[0] = &snippetStop{index: 0, ranges: []*snippetRange{{len(text), len(text)}}}
+	}
+	var stops []*snippetStop
+	for _, stop := range r.stops {
+		stops = append(stops, stop)
+	}
+	sort.Slice(stops, func(i, j int) bool {
+		a, b := stops[i].index, stops[j].index
+		if a == 0 || b == 0 {
+			return b == 0 && a != 0
+		}
+		return a < b
+	})
+	return text, stops
+}
+
+// findDefinitions picks the occurrence of each tab stop that holds its
+// placeholder or choices; the others copy its text.
+func (r *snippetRender) findDefinitions(nodes []snippetNode) {
+	for i := range nodes {
+		n := &nodes[i]
+		if n.stop >= 0 {
+			if d := r.defining[n.stop]; d == nil || (len(d.children) == 0 && len(d.choices) == 0 && (len(n.children) > 0 || len(n.choices) > 0)) {
+				r.defining[n.stop] = n
+			}
+		}
+		r.findDefinitions(n.children)
+	}
+}
+
+func (r *snippetRender) write(text string) {
+	text = strings.ReplaceAll(text, "\t", r.unit)
+	text = strings.ReplaceAll(text, "\n", "\n"+r.indent)
+	r.out.WriteString(text)
+}
+
+func (r *snippetRender) render(nodes []snippetNode) {
+	for i := range nodes {
+		n := &nodes[i]
+		switch {
+		case n.stop >= 0:
+			r.renderStop(n)
+		case n.variable != "":
+			value, ok := r.variable(n.variable)
+			switch {
+			case value != "":
+				r.write(value)
+			case len(n.children) > 0:
+				r.render(n.children)
+			case !ok:
+				// Unknown variables are inserted by name
+				r.write(n.variable)
+			}
+		default:
+			r.write(n.text)
+		}
+	}
+}
+
+func (r *snippetRender) renderStop(n *snippetNode) {
+	def := r.defining[n.stop]
+	start := r.out.Len()
+	if def == n {
+		if len(n.choices) > 0 {
+			r.write(n.choices[0])
+		} else {
+			r.render(n.children)
+		}
+	} else {
+		// A mirror: the defining occurrence's text, without its tab stops
+		recording := r.recording
+		r.recording = false
+		if len(def.choices) > 0 {
+			r.write(def.choices[0])
+		} else {
+			r.render(def.children)
+		}
+		r.recording = recording
+	}
+	if !r.recording {
+		return
+	}
+
+	stop := r.stops[n.stop]
+	if stop == nil {
+		stop = &snippetStop{index: n.stop, choices: def.choices}
+		r.stops[n.stop] = stop
+	}
+	rng := &snippetRange{start, r.out.Len()}
+	if def == n {
+		stop.ranges = append([]*snippetRange{rng}, stop.ranges...)
+	} else {
+		stop.ranges = append(stop.ranges, rng)
+	}
+}
@@ -0,0 +1,301 @@
+package editor
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// Swap files.
+//
+// Unsaved changes are kept in a swap file under the data directory, one
+// per buffer, so they survive the editor or its terminal dying. It is
+// written once typing stops for updateTime milliseconds, and every
+// swapInterval seconds while it doesn't, and removed once the changes are
+// saved or thrown away on purpose. Ctrl-C leaves them in place.
+//
+// Opening a file that has a swap file from another run asks what to do:
+// recover its contents, compare them with the file, or delete it. A swap
+// file whose editor is still running is left alone until then.
+
+// swapFile is what a swap file holds. Undo only describes the changes;
+// the buffer before them is the file itself.
+type swapFile struct {
+	Path    string
+	PID     int
+	Host    string
+	Session string // tells this run from an earlier one with the same pid
+	Saved   time.Time
+	CursorX int
+	CursorY int
+	Lines   []string
+	Undo    []swapChange
+}
+
+type swapChange struct {
+	Action string
+	Line   int
+	Time   time.Time
+}
+
+// swapState is a buffer's swap file.
+type swapState struct {
+	path    string
+	ours    bool      // there's a file of ours
+	version int       // the buffer's version it was last written at
+	written time.Time // when
+	foreign *swapFile // another run's, which is kept until dealt with
+}
+
+// swapSession identifies this run of the editor in its swap files. Pids
+// are reused, after a reboot or in a new container, so they aren't enough
+// to tell which swap files are ours.
+var swapSession = func() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}()
+
+// swapPath is where the swap file of the file at abs goes.
+func swapPath(abs string) string {
+	name := strings.ReplaceAll(filepath.ToSlash(abs), "/", "%")
+	return filepath.Join(dataDir(), "swap", name+".swp")
+}
+
+func readSwap(path string) (*swapFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var sf swapFile
+	if err := json.Unmarshal(data, &sf); err != nil {
+		return nil, err
+	}
+	return &sf, nil
+}
+
+// ours tells whether sf was written by this run of the editor.
+func (sf *swapFile) ours() bool {
+	host, _ := os.Hostname()
+	return sf.Host == host && sf.Session == swapSession
+}
+
+// running tells whether the editor that wrote sf is still running. One on
+// another host might be.
+func (sf *swapFile) running() bool {
+	if host, _ := os.Hostname(); sf.Host != host {
+		return true
+	}
+	if sf.PID == os.Getpid() {
+		return sf.Session == swapSession
+	}
+	p, err := os.FindProcess(sf.PID)
+	if err != nil {
+		return false
+	}
+	err = p.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// loadSwap looks for the loaded file's swap file and asks what to do with
+// one left by another run.
+func (e *Editor) loadSwap() {
+	e.swap = nil
+	if e.filename == "" || e.readOnly || !e.boolOption("swapFile") {
+		return
+	}
+	abs, err := filepath.Abs(e.filename)
+	if err != nil {
+		return
+	}
+	s := &swapState{path: swapPath(abs)}
+	e.swap = s
+	sf, err := readSwap(s.path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return
+	case err != nil:
+		// Not worth keeping, it's written over with the next change
+		e.setStatusMessage(fmt.Sprintf("Unreadable swap file %s: %v", s.path, err))
+		return
+	case sf.ours():
+		// Ours, from before the buffer was closed or reloaded
+		s.ours = true
+		return
+	case !sf.running() && slices.Equal(sf.Lines, e.lines):
+		// Nothing in it that the file doesn't have
+		os.Remove(s.path)
+		return
+	}
+	s.foreign = sf
+	e.promptRecovery()
+}
+
+// promptRecovery asks what to do with the current buffer's foreign swap
+// file.
+func (e *Editor) promptRecovery() {
+	if e.swap == nil || e.swap.foreign == nil {
+		e.setStatusMessage("No swap file to recover")
+		return
+	}
+	sf := e.swap.foreign
+	owner := "not running"
+	if sf.running() {
+		owner = "still running"
+	}
+	changes := ""
+	if n := len(sf.Undo); n > 0 {
+		changes = fmt.Sprintf(", %d changes up to line %d", n, sf.Undo[n-1].Line+1)
+	}
+	newer := ""
+	if info, err := os.Stat(e.filename); err == nil && info.ModTime().After(sf.Saved) {
+		newer = ", the file is newer"
+	}
+	e.mode = "recover"
+	e.setStatusMessage(fmt.Sprintf("Swap file from %s by pid %d (%s)%s%s",
+		sf.Saved.Format("2006-01-02 15:04"), sf.PID, owner, changes, newer))
+}
+
+func (e *Editor) handleRecoverMode(ev *tcell.EventKey) {
+	s := e.swap
+	if s == nil || s.foreign == nil {
+		e.mode = "normal"
+		return
+	}
+	if ev.Key() != tcell.KeyRune {
+		return
+	}
+	switch ev.Rune() {
+	case 'r':
+		e.recoverSwap()
+	case 'd':
+		e.mode = "normal"
+		e.diffSwap()
+	case 'x':
+		e.mode = "normal"
+		if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+			e.setStatusMessage(fmt.Sprintf("Error deleting swap file: %v", err))
+			return
+		}
+		s.foreign = nil
+		e.setStatusMessage("Swap file deleted")
+	}
+}
+
+// recoverSwap replaces the buffer with the swap file's contents, which can
+// be undone. The swap file is ours from then on.
+func (e *Editor) recoverSwap() {
+	s := e.swap
+	sf := s.foreign
+	e.addUndo(Action{
+		Type:    "recover",
+		action:  "recover",
+		lines:   append([]string{}, e.lines...),
+		cursorX: e.cursorX,
+		cursorY: e.cursorY,
+	})
+	e.lines = append([]string{}, sf.Lines...)
+	if len(e.lines) == 0 {
+		e.lines = []string{""}
+	}
+	e.cursorY = min(max(sf.CursorY, 0), len(e.lines)-1)
+	e.cursorX = min(max(sf.CursorX, 0), len(e.lines[e.cursorY]))
+	e.markChanged()
+	s.foreign, s.ours = nil, false
+	e.mode = "normal"
+	e.setStatusMessage("Recovered, :w to keep it or u to go back to the file")
+}
+
+// diffSwap compares the buffer with the swap file's contents, which open
+// as a read-only buffer. The choice can be made later with :recover.
+func (e *Editor) diffSwap() {
+	e.syncBuffers()
+	current := e.bufferIndex
+	lines := append([]string{}, e.swap.foreign.Lines...)
+	e.openView("[swap] "+filepath.Base(e.filename), lines, nil, e.languageName())
+	other := e.bufferIndex
+	e.switchBuffer(current)
+	e.startDiff(current, other)
+}
+
+// syncSwap writes the swap files that are due and removes those of
+// buffers with nothing unsaved.
+func (e *Editor) syncSwap() {
+	if !e.boolOption("swapFile") {
+		return
+	}
+	e.stashBuffer()
+	idle := time.Duration(e.intOption("updateTime")) * time.Millisecond
+	interval := time.Duration(e.intOption("swapInterval")) * time.Second
+	for i, b := range e.buffers {
+		s := b.swap
+		switch {
+		case s == nil || s.foreign != nil:
+		case !b.isDirty:
+			e.removeSwap(s)
+		case s.ours && s.version == b.version:
+		case i != e.bufferIndex || time.Since(e.lastInput) >= idle || time.Since(s.written) >= interval:
+			e.writeSwap(b)
+		default:
+			e.wakeAfter(idle - time.Since(e.lastInput))
+		}
+	}
+}
+
+func (e *Editor) writeSwap(b *Buffer) {
+	s := b.swap
+	abs, _ := filepath.Abs(b.filename)
+	host, _ := os.Hostname()
+	sf := swapFile{
+		Path:    abs,
+		PID:     os.Getpid(),
+		Host:    host,
+		Session: swapSession,
+		Saved:   time.Now(),
+		CursorX: b.cursorX,
+		CursorY: b.cursorY,
+		Lines:   b.lines,
+	}
+	for _, a := range b.undoStack[max(len(b.undoStack)-100, 0):] {
+		sf.Undo = append(sf.Undo, swapChange{Action: a.action, Line: a.cursorY, Time: a.timestamp})
+	}
+	// Failing or not, it's not tried again until the next change
+	s.ours, s.version, s.written = true, b.version, time.Now()
+	data, err := json.Marshal(sf)
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(s.path), 0700)
+	}
+	if err == nil {
+		err = writeFileAtomic(s.path, data, 0600)
+	}
+	if err != nil {
+		e.setStatusMessage(fmt.Sprintf("Error writing swap file: %v", err))
+	}
+}
+
+// removeSwap removes a swap file of ours.
+func (e *Editor) removeSwap(s *swapState) {
+	if s == nil || !s.ours {
+		return
+	}
+	os.Remove(s.path)
+	s.ours = false
+}
+
+// removeSwaps removes the swap files of every buffer, when quitting.
+func (e *Editor) removeSwaps() {
+	e.stashBuffer()
+	for _, b := range e.buffers {
+		e.removeSwap(b.swap)
+	}
+}
@@ -0,0 +1,118 @@
+package editor
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestSwapFiles(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+	dir := t.TempDir()
+	writeTestFile(t, dir, "notes.txt", "one\ntwo\n")
+	path := filepath.Join(dir, "notes.txt")
+	open := func() *Editor {
+		ed := &Editor{mode: "normal"}
+		ed.applySettings()
+		if err := ed.openFile(path); err != nil {
+			t.Fatal(err)
+		}
+		return ed
+	}
+
+	ed := open()
+	typeKeys(ed, "ji2<CR><Esc>")
+	ed.syncSwap()
+	swap := swapPath(path)
+	sf, err := readSwap(swap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(sf.Lines, []string{"one", "2", "two"}) || sf.CursorY != 2 || len(sf.Undo) == 0 {
+		t.Errorf("swap file has %q at line %d with %d changes", sf.Lines, sf.CursorY, len(sf.Undo))
+	}
+	if !sf.running() {
+		t.Errorf("own swap file is from a dead editor")
+	}
+
+	// It's written again only once the buffer changes
+	written := ed.swap.written
+	ed.lastInput = time.Time{}
+	ed.syncSwap()
+	if !ed.swap.written.Equal(written) {
+		t.Errorf("unchanged buffer's swap file written again")
+	}
+	typeKeys(ed, "ix<BS><Esc>")
+	ed.syncSwap()
+	if ed.swap.written.Equal(written) {
+		t.Errorf("changed buffer's swap file not written")
+	}
+
+	// An earlier run with the same pid isn't this one
+	sf.Session = "earlier"
+	data, _ := json.Marshal(sf)
+	os.WriteFile(swap, data, 0600)
+	if sf.ours() || sf.running() {
+		t.Errorf("swap file of an earlier run with pid %d taken for ours", sf.PID)
+	}
+	if ed = open(); ed.mode != "recover" || ed.swap.foreign == nil {
+		t.Errorf("no recovery prompt for an earlier run's swap file")
+	}
+
+	// As if that editor died
+	sf.PID = 1 << 30
+	data, _ = json.Marshal(sf)
+	os.WriteFile(swap, data, 0600)
+	if sf.running() {
+		t.Errorf("pid %d is running", sf.PID)
+	}
+
+	ed = open()
+	if ed.mode != "recover" || ed.swap.foreign == nil {
+		t.Fatalf("no recovery prompt, mode %s", ed.mode)
+	}
+	typeKeys(ed, "r")
+	if !slices.Equal(ed.lines, []string{"one", "2", "two"}) || !ed.isDirty || ed.mode != "normal" {
+		t.Errorf("recovered %q", ed.lines)
+	}
+	ed.undo()
+	if !slices.Equal(ed.lines, []string{"one", "two"}) {
+		t.Errorf("undo left %q", ed.lines)
+	}
+
+	ed = open()
+	typeKeys(ed, "d")
+	if ed.diff == nil || !ed.buffers[ed.diff.buffers[1]].readOnly || ed.filename != path {
+		t.Errorf("d doesn't diff the file with the swap file")
+	}
+	ed.commandBuffer = "recover"
+	ed.handleCommand()
+	typeKeys(ed, "x")
+	if _, err := os.Stat(swap); !os.IsNotExist(err) {
+		t.Errorf("x left the swap file")
+	}
+
+	// Saving removes the swap file, and a stale one with nothing new in
+	// it goes without asking
+	ed = open()
+	typeKeys(ed, "i<CR><Esc>")
+	ed.syncSwap()
+	ed.commandBuffer = "w"
+	ed.handleCommand()
+	ed.syncSwap()
+	if _, err := os.Stat(swap); !os.IsNotExist(err) {
+		t.Errorf("saving left the swap file")
+	}
+	sf.Lines = ed.lines
+	data, _ = json.Marshal(sf)
+	os.WriteFile(swap, data, 0600)
+	if ed = open(); ed.mode != "normal" {
+		t.Errorf("asked about a swap file like the file")
+	}
+	if _, err := os.Stat(swap); !os.IsNotExist(err) {
+		t.Errorf("stale swap file kept")
+	}
+}
@@ -1,137 +1,172 @@
 package editor
 
 import (
+	"path/filepath"
 	"regexp"
-	"time"
+	"strings"
 
+	"github.com/alecthomas/chroma"
+	"github.com/alecthomas/chroma/lexers"
 	"github.com/gdamore/tcell/v2"
 )
 
-// Syntax highlighting patterns
+// Syntax highlighting is done by chroma's lexers. The language is picked
+// from a modeline, the file name, or a shebang line, in that order, with
+// chroma's content analysis as a last resort.
+
 var (
-	// Go patterns
-	goKeywords = []string{
-		"break", "default", "func", "interface", "select", "case", "defer",
-		"go", "map", "struct", "chan", "else", "goto", "package", "switch",
-		"const", "fallthrough", "if", "range", "type", "continue", "for",
-		"import", "return", "var",
+	// vim: set ft=go:  /  vi: filetype=python  /  ex: syntax=sh
+	vimModeline = regexp.MustCompile(`(?:^|\s)(?:vim?|ex):.*?\b(?:ft|filetype|syntax)=([\w+#.-]+)`)
+	// -*- mode: python -*-  /  -*- c++ -*-
+	emacsModeline = regexp.MustCompile(`-\*-\s*(?:.*?\bmode:\s*([\w+#.-]+)|([\w+#.-]+))\s*(?:;.*?)?-\*-`)
+)
+
+// LexerFor picks a chroma lexer for a file from its name and contents.
+// It never returns nil; unknown files get the plain text lexer.
+func LexerFor(filename string, lines []string) chroma.Lexer {
+	if lexer := lexerFromModeline(lines); lexer != nil {
+		return lexer
 	}
-	goTypes = []string{"string", "int", "bool", "byte", "rune", "float", "error"}
-
-	// Python patterns
-	pythonKeywords = []string{
-		"and", "as", "assert", "break", "class", "continue", "def", "del",
-		"elif", "else", "except", "False", "finally", "for", "from", "global",
-		"if", "import", "in", "is", "lambda", "None", "nonlocal", "not", "or",
-		"pass", "raise", "return", "True", "try", "while", "with", "yield",
+	if filename != "" {
+		if lexer := lexers.Match(filepath.Base(filename)); lexer != nil {
+			return lexer
+		}
 	}
-
-	// JavaScript patterns
-	jsKeywords = []string{
-		"break", "case", "catch", "class", "const", "continue", "debugger",
-		"default", "delete", "do", "else", "export", "extends", "finally",
-		"for", "function", "if", "import", "in", "instanceof", "new", "return",
-		"super", "switch", "this", "throw", "try", "typeof", "var", "void",
-		"while", "with", "yield", "let", "static", "async", "await",
+	if len(lines) > 0 {
+		if lexer := lexerFromShebang(lines[0]); lexer != nil {
+			return lexer
+		}
+		sample := lines
+		if len(sample) > 100 {
+			sample = sample[:100]
+		}
+		if lexer := lexers.Analyse(strings.Join(sample, "\n")); lexer != nil {
+			return lexer
+		}
 	}
-
-	// Rust patterns
-	rustKeywords = []string{
-		"as", "break", "const", "continue", "crate", "else", "enum", "extern",
-		"false", "fn", "for", "if", "impl", "in", "let", "loop", "match", "mod",
-		"move", "mut", "pub", "ref", "return", "self", "Self", "static", "struct",
-		"super", "trait", "true", "type", "unsafe", "use", "where", "while",
+	if lexer := lexers.Get("plaintext"); lexer != nil {
+		return lexer
 	}
-
-	// Common patterns
-	numberPattern  = regexp.MustCompile(`\b\d+(\.\d+)?\b`)
-	stringPattern  = regexp.MustCompile(`"[^"]*"`)
-	commentPattern = regexp.MustCompile(`//.*$|/\*[\s\S]*?\*/|#.*$`)
-)
-
-// Highlight styles
-var (
-	keywordStyle = tcell.StyleDefault.Foreground(tcell.ColorAqua)
-	typeStyle    = tcell.StyleDefault.Foreground(tcell.ColorGreen)
-	stringStyle  = tcell.StyleDefault.Foreground(tcell.ColorYellow)
-	numberStyle  = tcell.StyleDefault.Foreground(tcell.ColorPurple)
-	commentStyle = tcell.StyleDefault.Foreground(tcell.ColorGray)
-	defaultStyle = tcell.StyleDefault
-)
-
-type SyntaxCache struct {
-	line      string
-	styles    []tcell.Style
-	timestamp time.Time
+	return lexers.Fallback
 }
 
-func (e *Editor) highlightSyntax(line string) []tcell.Style {
-	// Check cache first
-	if cache, ok := e.syntaxCache[line]; ok {
-		return cache
+// lexerFromModeline looks for vim or emacs modelines in the first and last
+// five lines of the file.
+func lexerFromModeline(lines []string) chroma.Lexer {
+	candidates := lines
+	if len(lines) > 10 {
+		candidates = append(append([]string{}, lines[:5]...), lines[len(lines)-5:]...)
 	}
 
-	// Calculate styles
-	styles := make([]tcell.Style, len(line))
-
-	// Fill with default style
-	for i := range styles {
-		styles[i] = defaultStyle
+	for _, line := range candidates {
+		if m := vimModeline.FindStringSubmatch(line); m != nil {
+			if lexer := lexers.Get(m[1]); lexer != nil {
+				return lexer
+			}
+		}
+		if m := emacsModeline.FindStringSubmatch(line); m != nil {
+			name := m[1]
+			if name == "" {
+				name = m[2]
+			}
+			if lexer := lexers.Get(name); lexer != nil {
+				return lexer
+			}
+		}
 	}
+	return nil
+}
 
-	// Language-specific highlighting
-	switch e.detectLanguage() {
-	case LangGo:
-		highlightGo(line, styles)
-	case LangPython:
-		highlightPython(line, styles)
-	case LangJavaScript:
-		highlightJavaScript(line, styles)
-	case LangRust:
-		highlightRust(line, styles)
+// lexerFromShebang maps "#!/usr/bin/env python3" and friends to a lexer.
+func lexerFromShebang(line string) chroma.Lexer {
+	if !strings.HasPrefix(line, "#!") {
+		return nil
+	}
+	fields := strings.Fields(line[2:])
+	if len(fields) == 0 {
+		return nil
 	}
 
-	// Common patterns (numbers, strings, comments)
-	highlightPattern(line, styles, numberPattern, numberStyle)
-	highlightPattern(line, styles, stringPattern, stringStyle)
-	highlightPattern(line, styles, commentPattern, commentStyle)
-
-	// Cache the result
-	e.syntaxCache[line] = styles
+	interpreter := filepath.Base(fields[0])
+	if interpreter == "env" {
+		// Skip env's own flags, e.g. "env -S node --flag"
+		interpreter = ""
+		for _, f := range fields[1:] {
+			if !strings.HasPrefix(f, "-") {
+				interpreter = filepath.Base(f)
+				break
+			}
+		}
+	}
 
-	return styles
+	// python3.11 -> python3 -> python
+	for name := interpreter; name != ""; name = name[:len(name)-1] {
+		if lexer := lexers.Get(name); lexer != nil {
+			return lexer
+		}
+		if !strings.ContainsAny(name[len(name)-1:], "0123456789.") {
+			break
+		}
+	}
+	return nil
 }
 
-func highlightGo(line string, styles []tcell.Style) {
-	highlightKeywords(line, styles, goKeywords, keywordStyle)
-	highlightKeywords(line, styles, goTypes, typeStyle)
+// currentLexer returns the lexer for the open buffer, detecting it the first
+// time it is needed after a file is loaded or renamed.
+func (e *Editor) currentLexer() chroma.Lexer {
+	if e.lexer == nil {
+		if e.fileType != "" {
+			e.lexer = lexers.Get(e.fileType)
+		}
+		if e.lexer == nil {
+			e.lexer = LexerFor(e.filename, e.lines)
+		}
+		e.highlight = nil
+	}
+	return e.lexer
 }
 
-func highlightPython(line string, styles []tcell.Style) {
-	highlightKeywords(line, styles, pythonKeywords, keywordStyle)
+// resetLexer forces language detection to run again.
+func (e *Editor) resetLexer() {
+	e.lexer = nil
+	e.highlight = nil
 }
 
-func highlightJavaScript(line string, styles []tcell.Style) {
-	highlightKeywords(line, styles, jsKeywords, keywordStyle)
+// languageName is the display name of the detected language.
+func (e *Editor) languageName() string {
+	return e.currentLexer().Config().Name
 }
 
-func highlightRust(line string, styles []tcell.Style) {
-	highlightKeywords(line, styles, rustKeywords, keywordStyle)
+// prepareHighlight lexes the buffer up to line upTo so that lineStyles can
+// be called for every line on screen.
+func (e *Editor) prepareHighlight(upTo int) {
+	if !e.syntaxHighlight {
+		return
+	}
+	lexer := e.currentLexer()
+	if e.highlight == nil {
+		e.highlight = newHighlighter(lexer, e.currentTheme())
+	}
+	e.highlight.ensure(e.lines, upTo)
 }
 
-func highlightKeywords(line string, styles []tcell.Style, keywords []string, style tcell.Style) {
-	for _, keyword := range keywords {
-		pattern := regexp.MustCompile(`\b` + keyword + `\b`)
-		highlightPattern(line, styles, pattern, style)
+// lineStyles returns one style per byte of buffer line y.
+func (e *Editor) lineStyles(y int) []tcell.Style {
+	line := e.lines[y]
+	if e.syntaxHighlight && e.highlight != nil {
+		if styles := e.highlight.styles(y); len(styles) == len(line) {
+			return styles
+		}
 	}
+	return e.plainStyles(line)
 }
 
-func highlightPattern(line string, styles []tcell.Style, pattern *regexp.Regexp, style tcell.Style) {
-	matches := pattern.FindAllStringIndex(line, -1)
-	for _, match := range matches {
-		start, end := match[0], match[1]
-		for i := start; i < end && i < len(styles); i++ {
-			styles[i] = style
-		}
+// plainStyles styles a line as unhighlighted text.
+func (e *Editor) plainStyles(line string) []tcell.Style {
+	text := e.uiStyle("text")
+	styles := make([]tcell.Style, len(line))
+	for i := range styles {
+		styles[i] = text
 	}
+	return styles
 }
@@ -0,0 +1,90 @@
+package editor
+
+import (
+	"testing"
+
+	"github.com/alecthomas/chroma"
+)
+
+func TestLexerFor(t *testing.T) {
+	tests := []struct {
+		filename string
+		lines    []string
+		want     string
+	}{
+		{"main.go", []string{"package main"}, "Go"},
+		{"Dockerfile", []string{"FROM alpine"}, "Docker"},
+		{"script", []string{"#!/usr/bin/env python3", "print(1)"}, "Python"},
+		{"run", []string{"#!/bin/bash", "echo hi"}, "Bash"},
+		{"notes.txt", []string{"# vim: set ft=yaml:", "a: 1"}, "YAML"},
+		{"build", []string{"# -*- mode: ruby -*-", "puts 1"}, "Ruby"},
+		{"unknown.zzz", []string{"hello"}, "plaintext"},
+	}
+	for _, tt := range tests {
+		if got := LexerFor(tt.filename, tt.lines).Config().Name; got != tt.want {
+			t.Errorf("LexerFor(%q) = %s, want %s", tt.filename, got, tt.want)
+		}
+	}
+}
+
+func TestHighlightMultiLine(t *testing.T) {
+	ed := &Editor{filename: "main.go", syntaxHighlight: true}
+	ed.lines = []string{
+		"package main",
+		"/* start",
+		"func inside() {}",
+		"end */",
+		"func after() {}",
+	}
+	comment := ed.currentTheme().tokenStyle(chroma.CommentMultiline)
+	keyword := ed.currentTheme().tokenStyle(chroma.KeywordDeclaration)
+
+	ed.prepareHighlight(len(ed.lines))
+	if ed.lineStyles(2)[0] != comment {
+		t.Errorf("line inside block comment not styled as comment")
+	}
+	if ed.lineStyles(4)[0] != keyword {
+		t.Errorf("line after block comment not styled as code")
+	}
+
+	// Closing the comment early re-lexes the following lines
+	ed.lines[1] = "/* start */"
+	ed.prepareHighlight(len(ed.lines))
+	if ed.lineStyles(2)[0] != keyword {
+		t.Errorf("line after closed comment still styled as comment")
+	}
+
+	// Inserting a line keeps later lines aligned with their styles
+	ed.lines = append([]string{"// header"}, ed.lines...)
+	ed.prepareHighlight(len(ed.lines))
+	for y, line := range ed.lines {
+		if len(ed.lineStyles(y)) != len(line) {
+			t.Fatalf("line %d has %d styles for %d bytes", y, len(ed.lineStyles(y)), len(line))
+		}
+	}
+	if ed.lineStyles(5)[0] != keyword {
+		t.Errorf("shifted line lost its highlighting")
+	}
+}
+
+func TestHighlighterConvergesAfterEdit(t *testing.T) {
+	lexer := LexerFor("main.go", nil)
+	buf := []string{"package main", ""}
+	for i := 0; i < 500; i++ {
+		buf = append(buf, "var x = 1")
+	}
+
+	h := newHighlighter(lexer, builtinThemes["default"])
+	h.ensure(buf, len(buf))
+
+	// An edit that doesn't change the lexer state only re-lexes a few lines
+	buf = append([]string(nil), buf...)
+	buf[10] = "var y = 2"
+	h.ensure(buf, 20)
+	if len(h.lines) != len(buf) {
+		t.Fatalf("expected the unchanged tail to be reused, have %d of %d lines", len(h.lines), len(buf))
+	}
+	if h.lines[10].text != "var y = 2" {
+		t.Errorf("edited line not re-highlighted")
+	}
+}
@@ -0,0 +1,171 @@
+package editor
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// Tabs and indentation.
+//
+// A tab character moves to the next multiple of tabSize on screen, so the
+// screen column of a byte offset depends on the tabs before it. Indentation
+// uses shiftWidth columns per level (tabSize when it's 0), made of spaces
+// with expandTab and of as many tabs as fit otherwise. softTabStop makes
+// Tab and Backspace in insert mode move by that many columns, as vim does.
+
+// cursorColumn is the cursor's screen column in its line.
+func (e *Editor) cursorColumn() int {
+	if e.cursorY >= len(e.lines) {
+		return e.cursorX
+	}
+	return displayColumn(e.lines[e.cursorY], e.cursorX, e.tabSize)
+}
+
+// shiftWidth is the width of one indentation level.
+func (e *Editor) shiftWidth() int {
+	if sw := e.intOption("shiftWidth"); sw > 0 {
+		return sw
+	}
+	return e.tabSize
+}
+
+// softTabStop is how far Tab and Backspace move in insert mode, 0 if they
+// insert and delete single characters.
+func (e *Editor) softTabStop() int {
+	sts := e.intOption("softTabStop")
+	if sts < 0 {
+		return e.shiftWidth()
+	}
+	return sts
+}
+
+// whitespace fills the columns from col to target with tabs and spaces, or
+// only spaces with expandTab.
+func (e *Editor) whitespace(col, target int) string {
+	var sb strings.Builder
+	for col < target {
+		next := nextColumn(col, "\t", e.tabSize)
+		if !e.boolOption("expandTab") && next <= target {
+			sb.WriteByte('\t')
+			col = next
+		} else {
+			sb.WriteByte(' ')
+			col++
+		}
+	}
+	return sb.String()
+}
+
+// indentUnit is one level of indentation.
+func (e *Editor) indentUnit() string {
+	return e.whitespace(0, e.shiftWidth())
+}
+
+// insertTab inserts whitespace up to the next tab stop, or soft tab stop.
+func (e *Editor) insertTab() {
+	width := e.softTabStop()
+	if width == 0 {
+		if !e.boolOption("expandTab") {
+			e.insertRune('\t')
+			return
+		}
+		width = e.tabSize
+	}
+	col := e.cursorColumn()
+	target := (col/width + 1) * width
+
+	// Spaces just before the cursor are redone as tabs where they can be
+	line := e.lines[e.cursorY]
+	start := e.cursorX
+	for start > 0 && line[start-1] == ' ' {
+		start--
+	}
+	from := displayColumn(line, start, e.tabSize)
+	text := e.whitespace(from, target)
+	e.lines[e.cursorY] = line[:start] + text + line[e.cursorX:]
+	e.cursorX = start + len(text)
+	e.markChanged()
+}
+
+// softTabBackspace deletes the spaces back to the previous soft tab stop in
+// insert mode, reporting whether it did.
+func (e *Editor) softTabBackspace() bool {
+	width := e.softTabStop()
+	if width == 0 || e.cursorX == 0 || e.cursorY >= len(e.lines) {
+		return false
+	}
+	line := e.lines[e.cursorY]
+	if e.cursorX > len(line) || line[e.cursorX-1] != ' ' {
+		return false
+	}
+	col := e.cursorColumn()
+	target := (col - 1) / width * width
+	start := e.cursorX
+	for start > 0 && line[start-1] == ' ' && displayColumn(line, start-1, e.tabSize) >= target {
+		start--
+	}
+	if e.cursorX-start < 2 {
+		return false
+	}
+	e.addUndo(Action{
+		Type:    "delete",
+		action:  "delete",
+		lines:   append([]string{}, e.lines...),
+		cursorX: e.cursorX,
+		cursorY: e.cursorY,
+	})
+	e.lines[e.cursorY] = line[:start] + line[e.cursorX:]
+	e.cursorX = start
+	e.markChanged()
+	return true
+}
+
+// shiftLines indents lines from..to one level more, or less if dir is
+// negative, like vim's > and <.
+func (e *Editor) shiftLines(from, to, dir int) {
+	if !e.editable() {
+		return
+	}
+	e.addUndo(Action{
+		Type:    "shift",
+		action:  "shift",
+		lines:   append([]string{}, e.lines...),
+		cursorX: e.cursorX,
+		cursorY: e.cursorY,
+	})
+	sw := e.shiftWidth()
+	for y := max(from, 0); y <= to && y < len(e.lines); y++ {
+		line := e.lines[y]
+		rest := strings.TrimLeft(line, " \t")
+		if rest == "" {
+			continue
+		}
+		indent := displayColumn(line, len(line)-len(rest), e.tabSize)
+		if dir > 0 {
+			indent = (indent/sw + 1) * sw
+		} else {
+			indent = max(0, (indent-1)/sw*sw)
+		}
+		e.lines[y] = e.whitespace(0, indent) + rest
+	}
+	if e.cursorY >= from && e.cursorY <= to {
+		line := e.lines[e.cursorY]
+		e.cursorX = len(line) - len(strings.TrimLeft(line, " \t"))
+	}
+	e.markChanged()
+}
+
+// onlyWhitespaceBefore reports whether the text before the cursor is blank
+// or ends in whitespace, where Tab indents rather than completes.
+func (e *Editor) onlyWhitespaceBefore() bool {
+	if e.cursorY >= len(e.lines) {
+		return true
+	}
+	line := e.lines[e.cursorY]
+	x := min(e.cursorX, len(line))
+	if x == 0 {
+		return true
+	}
+	r, _ := utf8.DecodeLastRuneInString(line[:x])
+	return r == ' ' || r == '\t'
+}
@@ -0,0 +1,112 @@
+package editor
+
+import "testing"
+
+func TestDisplayColumn(t *testing.T) {
+	tests := []struct {
+		line string
+		x    int
+		want int
+	}{
+		{"abc", 2, 2},
+		{"\tx", 1, 4},
+		{"ab\tx", 3, 4},
+		{"abcd\tx", 5, 8},
+		{"\t\tx", 2, 8},
+		{"é\tx", 3, 4},
+	}
+	for _, test := range tests {
+		if got := displayColumn(test.line, test.x, 4); got != test.want {
+			t.Errorf("displayColumn(%q, %d) = %d, want %d", test.line, test.x, got, test.want)
+		}
+		if got := byteForColumn(test.line, test.want, 4); got != test.x {
+			t.Errorf("byteForColumn(%q, %d) = %d, want %d", test.line, test.want, got, test.x)
+		}
+	}
+	// A column inside a tab is on the tab
+	if got := byteForColumn("a\tb", 2, 4); got != 1 {
+		t.Errorf("byteForColumn inside a tab = %d", got)
+	}
+}
+
+func TestIndentUnit(t *testing.T) {
+	tests := []struct {
+		settings map[string]string
+		want     string
+	}{
+		{map[string]string{}, "    "},
+		{map[string]string{"expandTab": "false"}, "\t"},
+		{map[string]string{"expandTab": "false", "tabSize": "8", "shiftWidth": "4"}, "    "},
+		{map[string]string{"expandTab": "false", "tabSize": "4", "shiftWidth": "6"}, "\t  "},
+		{map[string]string{"shiftWidth": "2"}, "  "},
+	}
+	for _, test := range tests {
+		ed := &Editor{settings: test.settings}
+		ed.applySettings()
+		if got := ed.indentUnit(); got != test.want {
+			t.Errorf("%v: indentUnit() = %q, want %q", test.settings, got, test.want)
+		}
+	}
+}
+
+func TestInsertModeTab(t *testing.T) {
+	ed := &Editor{
+		mode:     "insert",
+		lines:    []string{"", "x"},
+		settings: map[string]string{"autoComplete": "false", "expandTab": "false", "tabSize": "8", "softTabStop": "-1", "shiftWidth": "4"},
+	}
+	ed.applySettings()
+
+	typeKeys(ed, "<Tab>")
+	if ed.lines[0] != "    " || ed.cursorColumn() != 4 {
+		t.Errorf("one tab: %q", ed.lines[0])
+	}
+	// Two soft tab stops make a real tab
+	typeKeys(ed, "<Tab>")
+	if ed.lines[0] != "\t" || ed.cursorColumn() != 8 {
+		t.Errorf("two tabs: %q", ed.lines[0])
+	}
+	typeKeys(ed, "<Tab><BS>")
+	if ed.lines[0] != "\t" {
+		t.Errorf("backspace over a soft tab: %q", ed.lines[0])
+	}
+	typeKeys(ed, "ab<Tab>")
+	if ed.lines[0] != "\tab  " || ed.cursorColumn() != 12 {
+		t.Errorf("tab after text: %q at %d", ed.lines[0], ed.cursorColumn())
+	}
+
+	// Moving down keeps the screen column
+	ed.lines = []string{"\tfoo", "12345678"}
+	ed.cursorY, ed.cursorX = 0, 1
+	ed.moveCursor(0, 1)
+	if ed.cursorX != 8 {
+		t.Errorf("moved down to %d", ed.cursorX)
+	}
+}
+
+func TestShiftLines(t *testing.T) {
+	ed := &Editor{
+		lines:    []string{"a", "  b", "", "\tc"},
+		settings: map[string]string{"shiftWidth": "2"},
+	}
+	ed.applySettings()
+
+	ed.commandBuffer = "> 4"
+	ed.handleCommand()
+	want := []string{"  a", "    b", "", "      c"}
+	for i := range want {
+		if ed.lines[i] != want[i] {
+			t.Errorf(":> 4 line %d = %q, want %q", i, ed.lines[i], want[i])
+		}
+	}
+	ed.cursorY = 1
+	ed.commandBuffer = "<"
+	ed.handleCommand()
+	if ed.lines[1] != "  b" || ed.cursorX != 2 {
+		t.Errorf(":< gave %q at %d", ed.lines[1], ed.cursorX)
+	}
+	ed.undo()
+	if ed.lines[1] != "    b" {
+		t.Errorf("undo gave %q", ed.lines[1])
+	}
+}
@@ -0,0 +1,258 @@
+// Command fakelsp is a tiny language server used by the editor's tests.
+//
+// It keeps the text of open documents and answers from it: completion
+// offers two fixed items, hover returns the word under the cursor,
+// definition and references find the word's occurrences, rename replaces
+// them, formatting trims trailing spaces, and every line containing "TODO"
+// gets a warning.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+type position struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type textRange struct {
+	Start position `json:"start"`
+	End   position `json:"end"`
+}
+
+type message struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      *int            `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  any             `json:"result,omitempty"`
+}
+
+var (
+	docs = map[string][]string{}
+	out  = bufio.NewWriter(os.Stdout)
+)
+
+// Like a real server, it exits with 0 only after shutdown and then exit.
+func main() {
+	in := bufio.NewReader(os.Stdin)
+	shutdown := false
+	for {
+		data, err := read(in)
+		if err != nil {
+			os.Exit(1)
+		}
+		var msg message
+		if json.Unmarshal(data, &msg) != nil {
+			continue
+		}
+		result, reply := handle(msg.Method, msg.Params)
+		if msg.Method == "exit" {
+			if !shutdown {
+				os.Exit(1)
+			}
+			return
+		}
+		shutdown = shutdown || msg.Method == "shutdown"
+		if msg.ID != nil && reply {
+			if result == nil {
+				result = json.RawMessage("null")
+			}
+			send(message{ID: msg.ID, Result: result})
+		}
+	}
+}
+
+func read(r *bufio.Reader) ([]byte, error) {
+	length := 0
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		if value, ok := strings.CutPrefix(line, "Content-Length:"); ok {
+			length, _ = strconv.Atoi(strings.TrimSpace(value))
+		}
+	}
+	data := make([]byte, length)
+	_, err := io.ReadFull(r, data)
+	return data, err
+}
+
+func send(msg message) {
+	msg.JSONRPC = "2.0"
+	data, _ := json.Marshal(msg)
+	fmt.Fprintf(out, "Content-Length: %d\r\n\r\n%s", len(data), data)
+	out.Flush()
+}
+
+func handle(method string, raw json.RawMessage) (any, bool) {
+	var p struct {
+		TextDocument struct {
+			URI  string `json:"uri"`
+			Text string `json:"text"`
+		} `json:"textDocument"`
+		ContentChanges []struct {
+			Range *textRange `json:"range"`
+			Text  string     `json:"text"`
+		} `json:"contentChanges"`
+		Position position `json:"position"`
+		NewName  string   `json:"newName"`
+	}
+	json.Unmarshal(raw, &p)
+	uri := p.TextDocument.URI
+
+	switch method {
+	case "initialize":
+		return map[string]any{"capabilities": map[string]any{
+			"textDocumentSync":           map[string]any{"openClose": true, "change": 2},
+			"completionProvider":         map[string]any{"triggerCharacters": []string{"."}},
+			"hoverProvider":              true,
+			"definitionProvider":         true,
+			"referencesProvider":         true,
+			"renameProvider":             true,
+			"documentFormattingProvider": true,
+		}}, true
+	case "shutdown":
+		return nil, true
+	case "textDocument/didOpen":
+		docs[uri] = strings.Split(p.TextDocument.Text, "\n")
+		publish(uri)
+	case "textDocument/didChange":
+		for _, change := range p.ContentChanges {
+			docs[uri] = apply(docs[uri], change.Range, change.Text)
+		}
+		publish(uri)
+	case "textDocument/completion":
+		return map[string]any{"isIncomplete": false, "items": []map[string]any{
+			{"label": "fakeAlpha", "detail": "func()"},
+			{"label": "fakeBeta", "detail": "int"},
+		}}, true
+	case "textDocument/hover":
+		return map[string]any{"contents": map[string]any{
+			"kind": "markdown", "value": "hover: " + wordAt(docs[uri], p.Position),
+		}}, true
+	case "textDocument/definition":
+		if refs := occurrences(uri, wordAt(docs[uri], p.Position)); len(refs) > 0 {
+			return refs[0], true
+		}
+		return nil, true
+	case "textDocument/references":
+		return occurrences(uri, wordAt(docs[uri], p.Position)), true
+	case "textDocument/rename":
+		var edits []map[string]any
+		for _, loc := range occurrences(uri, wordAt(docs[uri], p.Position)) {
+			edits = append(edits, map[string]any{"range": loc["range"], "newText": p.NewName})
+		}
+		return map[string]any{"changes": map[string]any{uri: edits}}, true
+	case "textDocument/formatting":
+		edits := []map[string]any{}
+		for i, line := range docs[uri] {
+			if trimmed := strings.TrimRight(line, " \t"); trimmed != line {
+				edits = append(edits, map[string]any{
+					"range":   textRange{position{i, len(trimmed)}, position{i, len(line)}},
+					"newText": "",
+				})
+			}
+		}
+		return edits, true
+	}
+	return nil, true
+}
+
+// apply makes an incremental change, positions are taken as bytes.
+func apply(lines []string, r *textRange, text string) []string {
+	if r == nil {
+		return strings.Split(text, "\n")
+	}
+	joined := strings.Join(lines, "\n")
+	offset := func(p position) int {
+		n := 0
+		for i := 0; i < p.Line && i < len(lines); i++ {
+			n += len(lines[i]) + 1
+		}
+		if p.Line < len(lines) {
+			n += min(p.Character, len(lines[p.Line]))
+		}
+		return min(n, len(joined))
+	}
+	from, to := offset(r.Start), offset(r.End)
+	return strings.Split(joined[:from]+text+joined[to:], "\n")
+}
+
+func publish(uri string) {
+	diagnostics := []map[string]any{}
+	for i, line := range docs[uri] {
+		if col := strings.Index(line, "TODO"); col >= 0 {
+			diagnostics = append(diagnostics, map[string]any{
+				"range":    textRange{position{i, col}, position{i, col + 4}},
+				"severity": 2,
+				"source":   "fake",
+				"message":  "unfinished work",
+			})
+		}
+	}
+	send(message{Method: "textDocument/publishDiagnostics", Params: mustJSON(map[string]any{
+		"uri": uri, "diagnostics": diagnostics,
+	})})
+}
+
+func mustJSON(v any) json.RawMessage {
+	data, _ := json.Marshal(v)
+	return data
+}
+
+func isWordChar(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+func wordAt(lines []string, p position) string {
+	if p.Line >= len(lines) {
+		return ""
+	}
+	line := lines[p.Line]
+	start, end := min(p.Character, len(line)), min(p.Character, len(line))
+	for start > 0 && isWordChar(line[start-1]) {
+		start--
+	}
+	for end < len(line) && isWordChar(line[end]) {
+		end++
+	}
+	return line[start:end]
+}
+
+func occurrences(uri, word string) []map[string]any {
+	locations := []map[string]any{}
+	if word == "" {
+		return locations
+	}
+	for i, line := range docs[uri] {
+		for col := 0; ; {
+			n := strings.Index(line[col:], word)
+			if n < 0 {
+				break
+			}
+			start := col + n
+			end := start + len(word)
+			if (start == 0 || !isWordChar(line[start-1])) && (end == len(line) || !isWordChar(line[end])) {
+				locations = append(locations, map[string]any{
+					"uri":   uri,
+					"range": textRange{position{i, start}, position{i, end}},
+				})
+			}
+			col = end
+		}
+	}
+	return locations
+}
@@ -0,0 +1,695 @@
+package editor
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/alecthomas/chroma"
+	"github.com/gdamore/tcell/v2"
+)
+
+// Color themes.
+//
+// A theme maps syntax token classes and UI elements to style specs such as
+// "#fb4934 bold" or "white on darkblue". Syntax classes are chroma's token
+// names in lowercase dotted form without the "literal" prefix (keyword.type,
+// string.escape, number, name.function, comment.preproc, ...); a class that
+// isn't defined falls back to its parent (string.escape -> string).
+// Themes are built in or loaded from <name>.toml / <name>.json files in
+// themes/ in the config directory, and anything a theme leaves out is taken
+// from the theme it inherits from, "default" unless it says otherwise.
+
+type Theme struct {
+	Name     string
+	Inherits string
+	Syntax   map[string]string
+	UI       map[string]string
+
+	parent *Theme
+	colors int // colors the terminal supports, RGB values are fitted to it
+	tokens map[chroma.TokenType]tcell.Style
+	styles map[string]themeStyle
+}
+
+// themeStyle is a parsed style spec. Unset colors are ColorDefault so a
+// style can be laid over another one.
+type themeStyle struct {
+	fg, bg tcell.Color
+	attrs  tcell.AttrMask
+}
+
+var builtinThemes = map[string]*Theme{
+	"default": {
+		Name: "default",
+		Syntax: map[string]string{
+			"keyword":            "purple",
+			"keyword.type":       "teal",
+			"keyword.constant":   "red",
+			"name.function":      "yellow",
+			"name.class":         "teal",
+			"name.namespace":     "teal",
+			"name.builtin":       "aqua",
+			"name.tag":           "purple",
+			"name.attribute":     "yellow",
+			"name.decorator":     "yellow",
+			"literal":            "green",
+			"string":             "green",
+			"string.escape":      "orange",
+			"string.interpol":    "orange",
+			"number":             "red",
+			"comment":            "gray",
+			"comment.preproc":    "purple",
+			"operator":           "orange",
+			"generic.inserted":   "green",
+			"generic.deleted":    "red",
+			"generic.error":      "red",
+			"generic.heading":    "aqua bold",
+			"generic.subheading": "aqua bold",
+			"generic.strong":     "bold",
+			"generic.emph":       "italic",
+			"error":              "red",
+		},
+		UI: map[string]string{
+			"text":                   "default",
+			"linenumber":             "darkgray",
+			"linenumber.current":     "yellow",
+			"statusbar":              "white on darkblue",
+			"message":                "default",
+			"hint":                   "darkgray",
+			"tree":                   "default",
+			"tree.directory":         "yellow",
+			"tree.file":              "white",
+			"tree.selected":          "on darkblue",
+			"tree.ignored":           "darkgray",
+			"tree.separator":         "default",
+			"git.untracked":          "teal",
+			"git.added":              "green",
+			"git.modified":           "orange",
+			"git.conflicted":         "red",
+			"completion":             "white on darkblue",
+			"completion.selected":    "white on blue",
+			"completion.description": "lightgray",
+			"selection":              "black on aqua",
+			"search.match":           "black on yellow",
+			"title":                  "yellow bold",
+			"heading":                "green bold",
+			"muted":                  "gray",
+			"diagnostic.error":       "underline red",
+			"diagnostic.warning":     "underline yellow",
+			"diagnostic.info":        "underline aqua",
+			"diagnostic.hint":        "underline",
+			"sign.error":             "red bold",
+			"sign.warning":           "yellow bold",
+			"sign.info":              "aqua",
+			"sign.hint":              "darkgray",
+			"sign.mark":              "aqua",
+			"sign.breakpoint":        "red",
+			"sign.added":             "green",
+			"sign.changed":           "yellow",
+			"sign.removed":           "red",
+			"fold":                   "aqua on darkgray",
+			"foldcolumn":             "darkgray",
+			"blame":                  "gray on black",
+			"diff.added":             "on #002800",
+			"diff.changed":           "on #202000",
+			"diff.removed":           "darkgray on black",
+			"diff.text":              "on #505000",
+			"conflict.marker":        "darkgray bold",
+			"conflict.ours":          "on #002840",
+			"conflict.base":          "on #282828",
+			"conflict.theirs":        "on #280028",
+		},
+	},
+	"gruvbox": {
+		Name: "gruvbox",
+		Syntax: map[string]string{
+			"keyword":          "#fb4934",
+			"keyword.type":     "#fabd2f",
+			"keyword.constant": "#d3869b",
+			"name.function":    "#b8bb26 bold",
+			"name.class":       "#fabd2f",
+			"name.namespace":   "#83a598",
+			"name.builtin":     "#fe8019",
+			"name.tag":         "#8ec07c",
+			"name.attribute":   "#b8bb26",
+			"name.decorator":   "#8ec07c",
+			"literal":          "#d3869b",
+			"string":           "#b8bb26",
+			"string.escape":    "#fe8019",
+			"number":           "#d3869b",
+			"comment":          "#928374 italic",
+			"comment.preproc":  "#8ec07c",
+			"operator":         "#fe8019",
+			"generic.inserted": "#b8bb26",
+			"generic.deleted":  "#fb4934",
+			"generic.heading":  "#83a598 bold",
+			"error":            "#fb4934",
+		},
+		UI: map[string]string{
+			"text":                   "#ebdbb2",
+			"linenumber":             "#7c6f64",
+			"linenumber.current":     "#fabd2f",
+			"statusbar":              "#ebdbb2 on #504945",
+			"message":                "#ebdbb2",
+			"hint":                   "#928374",
+			"tree":                   "#ebdbb2",
+			"tree.directory":         "#83a598 bold",
+			"tree.file":              "#ebdbb2",
+			"tree.selected":          "#fbf1c7 on #504945",
+			"tree.ignored":           "#665c54",
+			"tree.separator":         "#504945",
+			"git.untracked":          "#8ec07c",
+			"git.added":              "#b8bb26",
+			"git.modified":           "#fe8019",
+			"git.conflicted":         "#fb4934",
+			"completion":             "#ebdbb2 on #3c3836",
+			"completion.selected":    "#282828 on #83a598",
+			"completion.description": "#a89984",
+			"selection":              "#282828 on #d79921",
+			"search.match":           "#282828 on #fabd2f",
+			"title":                  "#fabd2f bold",
+			"heading":                "#b8bb26 bold",
+			"muted":                  "#928374",
+			"diagnostic.error":       "underline #fb4934",
+			"diagnostic.warning":     "underline #fabd2f",
+			"diagnostic.info":        "underline #83a598",
+			"sign.error":             "#fb4934 bold",
+			"sign.warning":           "#fabd2f bold",
+			"sign.info":              "#83a598",
+			"sign.hint":              "#928374",
+			"sign.mark":              "#8ec07c",
+			"sign.breakpoint":        "#fb4934",
+			"sign.added":             "#b8bb26",
+			"sign.changed":           "#fabd2f",
+			"sign.removed":           "#fb4934",
+			"fold":                   "#928374 on #3c3836",
+			"foldcolumn":             "#928374",
+			"blame":                  "#a89984 on #282828",
+			"diff.added":             "on #34381b",
+			"diff.changed":           "on #3c3418",
+			"diff.removed":           "#504945 on #282828",
+			"diff.text":              "on #5a4a1a bold",
+			"conflict.marker":        "#928374 bold",
+			"conflict.ours":          "on #1d3b45",
+			"conflict.base":          "on #32302f",
+			"conflict.theirs":        "on #3b2940",
+		},
+	},
+	"solarized-light": {
+		Name: "solarized-light",
+		Syntax: map[string]string{
+			"keyword":          "#859900",
+			"keyword.type":     "#b58900",
+			"keyword.constant": "#cb4b16",
+			"name.function":    "#268bd2",
+			"name.class":       "#b58900",
+			"name.builtin":     "#cb4b16",
+			"name.tag":         "#268bd2",
+			"name.attribute":   "#93a1a1",
+			"literal":          "#2aa198",
+			"string":           "#2aa198",
+			"string.escape":    "#cb4b16",
+			"number":           "#d33682",
+			"comment":          "#93a1a1 italic",
+			"comment.preproc":  "#cb4b16",
+			"operator":         "#657b83",
+			"generic.inserted": "#859900",
+			"generic.deleted":  "#dc322f",
+			"generic.heading":  "#268bd2 bold",
+			"error":            "#dc322f",
+		},
+		UI: map[string]string{
+			"text":                   "#657b83 on #fdf6e3",
+			"linenumber":             "#93a1a1 on #eee8d5",
+			"linenumber.current":     "#586e75 on #eee8d5",
+			"statusbar":              "#fdf6e3 on #657b83",
+			"message":                "#586e75 on #fdf6e3",
+			"hint":                   "#93a1a1 on #fdf6e3",
+			"tree":                   "#586e75 on #eee8d5",
+			"tree.directory":         "#268bd2 bold",
+			"tree.file":              "#586e75",
+			"tree.selected":          "#fdf6e3 on #268bd2",
+			"tree.ignored":           "#93a1a1",
+			"tree.separator":         "#93a1a1 on #eee8d5",
+			"git.untracked":          "#2aa198",
+			"git.added":              "#859900",
+			"git.modified":           "#cb4b16",
+			"git.conflicted":         "#dc322f",
+			"completion":             "#586e75 on #eee8d5",
+			"completion.selected":    "#fdf6e3 on #268bd2",
+			"completion.description": "#93a1a1",
+			"selection":              "#fdf6e3 on #6c71c4",
+			"search.match":           "#073642 on #b58900",
+			"title":                  "#b58900 bold",
+			"heading":                "#859900 bold",
+			"muted":                  "#93a1a1",
+			"diagnostic.error":       "underline #dc322f",
+			"diagnostic.warning":     "underline #b58900",
+			"diagnostic.info":        "underline #268bd2",
+			"sign.error":             "#dc322f bold",
+			"sign.warning":           "#b58900 bold",
+			"sign.info":              "#268bd2",
+			"sign.hint":              "#93a1a1",
+			"sign.mark":              "#2aa198",
+			"sign.breakpoint":        "#dc322f",
+			"sign.added":             "#859900",
+			"sign.changed":           "#b58900",
+			"sign.removed":           "#dc322f",
+			"fold":                   "#586e75 on #eee8d5",
+			"foldcolumn":             "#93a1a1 on #eee8d5",
+			"blame":                  "#657b83 on #eee8d5",
+			"diff.added":             "on #e6f0c8",
+			"diff.changed":           "on #f5e9c4",
+			"diff.removed":           "#d6cfb8 on #fdf6e3",
+			"diff.text":              "on #edd9a0 bold",
+			"conflict.marker":        "#93a1a1 bold",
+			"conflict.ours":          "on #dce9f0",
+			"conflict.base":          "on #eee8d5",
+			"conflict.theirs":        "on #f0e0ec",
+		},
+	},
+}
+
+func themesDir() string {
+	return filepath.Join(configDir(), "themes")
+}
+
+// loadTheme finds a theme by name, preferring files in the themes directory
+// over the built-in themes, and resolves what it inherits from.
+func loadTheme(name string) (*Theme, error) {
+	return loadThemeChain(name, map[string]bool{})
+}
+
+func loadThemeChain(name string, seen map[string]bool) (*Theme, error) {
+	if seen[name] {
+		return nil, fmt.Errorf("theme %q inherits from itself", name)
+	}
+	seen[name] = true
+
+	theme, err := readThemeFile(name)
+	if err != nil {
+		return nil, err
+	}
+	if theme == nil {
+		builtin, ok := builtinThemes[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown theme %q", name)
+		}
+		copied := *builtin
+		theme = &copied
+	}
+
+	parent := theme.Inherits
+	if parent == "" && name != "default" {
+		parent = "default"
+	}
+	if parent != "" && parent != "none" {
+		if theme.parent, err = loadThemeChain(parent, seen); err != nil {
+			return nil, err
+		}
+	}
+	return theme, nil
+}
+
+// readThemeFile loads <name>.toml or <name>.json from the themes directory.
+// It returns nil without an error when neither exists.
+func readThemeFile(name string) (*Theme, error) {
+	if strings.ContainsAny(name, `/\`) {
+		return nil, fmt.Errorf("invalid theme name %q", name)
+	}
+	for _, ext := range []string{".toml", ".json"} {
+		path := filepath.Join(themesDir(), name+ext)
+		data, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		theme, err := parseTheme(name, string(data), ext == ".json")
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", filepath.Base(path), err)
+		}
+		return theme, nil
+	}
+	return nil, nil
+}
+
+// parseTheme reads a theme file:
+//
+//	inherits = "default"
+//	[syntax]
+//	keyword = "#fb4934 bold"
+//	"string.escape" = "#fe8019"
+//	[ui]
+//	statusbar = "#ebdbb2 on #504945"
+//
+// JSON files use the same layout as an object.
+func parseTheme(name, data string, isJSON bool) (*Theme, error) {
+	var doc map[string]any
+	if isJSON {
+		if err := json.Unmarshal([]byte(data), &doc); err != nil {
+			return nil, err
+		}
+	} else {
+		var err error
+		if doc, err = parseTOML(data); err != nil {
+			return nil, err
+		}
+	}
+
+	theme := &Theme{Name: name, Syntax: map[string]string{}, UI: map[string]string{}}
+	for key, value := range doc {
+		switch key {
+		case "name":
+			// The file name is the theme's name
+		case "inherits":
+			s, ok := value.(string)
+			if !ok {
+				return nil, fmt.Errorf("inherits must be a string")
+			}
+			theme.Inherits = s
+		case "syntax", "ui":
+			table, ok := value.(map[string]any)
+			if !ok {
+				return nil, fmt.Errorf("%s must be a table", key)
+			}
+			target := theme.Syntax
+			if key == "ui" {
+				target = theme.UI
+			}
+			if err := flattenThemeTable(table, "", target); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("unknown key %q", key)
+		}
+	}
+
+	for key, spec := range theme.Syntax {
+		if _, err := parseStyleSpec(spec); err != nil {
+			return nil, fmt.Errorf("syntax.%s: %v", key, err)
+		}
+	}
+	for key, spec := range theme.UI {
+		if _, err := parseStyleSpec(spec); err != nil {
+			return nil, fmt.Errorf("ui.%s: %v", key, err)
+		}
+	}
+	return theme, nil
+}
+
+// flattenThemeTable turns nested tables, which is what dotted keys like
+// keyword.type = "..." produce in TOML, back into dotted names.
+func flattenThemeTable(table map[string]any, prefix string, out map[string]string) error {
+	for key, value := range table {
+		name := prefix + key
+		switch v := value.(type) {
+		case string:
+			out[name] = v
+		case map[string]any:
+			if err := flattenThemeTable(v, name+".", out); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("%s: style must be a string", name)
+		}
+	}
+	return nil
+}
+
+// availableThemes lists built-in themes and theme files.
+func availableThemes() []string {
+	seen := map[string]bool{}
+	for name := range builtinThemes {
+		seen[name] = true
+	}
+	entries, _ := os.ReadDir(themesDir())
+	for _, entry := range entries {
+		ext := filepath.Ext(entry.Name())
+		if !entry.IsDir() && (ext == ".toml" || ext == ".json") {
+			seen[strings.TrimSuffix(entry.Name(), ext)] = true
+		}
+	}
+
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// parseStyleSpec parses "fg [on bg] [attributes...]". Colors are names,
+// "#rrggbb", palette numbers 0-255 or "default"; fg:<color> and bg:<color>
+// may be used instead of position.
+func parseStyleSpec(spec string) (themeStyle, error) {
+	var s themeStyle
+	fields := strings.Fields(spec)
+	for i := 0; i < len(fields); i++ {
+		field := strings.ToLower(fields[i])
+		switch {
+		case field == "bold":
+			s.attrs |= tcell.AttrBold
+		case field == "italic":
+			s.attrs |= tcell.AttrItalic
+		case field == "underline":
+			s.attrs |= tcell.AttrUnderline
+		case field == "reverse":
+			s.attrs |= tcell.AttrReverse
+		case field == "dim":
+			s.attrs |= tcell.AttrDim
+		case field == "strikethrough":
+			s.attrs |= tcell.AttrStrikeThrough
+		case field == "on":
+			if i+1 >= len(fields) {
+				return s, fmt.Errorf("missing color after \"on\"")
+			}
+			i++
+			c, err := parseThemeColor(fields[i])
+			if err != nil {
+				return s, err
+			}
+			s.bg = c
+		case strings.HasPrefix(field, "bg:"):
+			c, err := parseThemeColor(field[3:])
+			if err != nil {
+				return s, err
+			}
+			s.bg = c
+		default:
+			c, err := parseThemeColor(strings.TrimPrefix(field, "fg:"))
+			if err != nil {
+				return s, err
+			}
+			s.fg = c
+		}
+	}
+	return s, nil
+}
+
+func parseThemeColor(name string) (tcell.Color, error) {
+	name = strings.ToLower(name)
+	switch name {
+	case "default", "none", "reset":
+		return tcell.ColorReset, nil
+	}
+	if n, err := strconv.Atoi(name); err == nil && n >= 0 && n < 256 {
+		return tcell.PaletteColor(n), nil
+	}
+	if strings.HasPrefix(name, "#") && len(name) == 4 {
+		// #rgb shorthand
+		name = "#" + strings.Repeat(name[1:2], 2) + strings.Repeat(name[2:3], 2) + strings.Repeat(name[3:4], 2)
+	}
+	if c := tcell.GetColor(name); c != tcell.ColorDefault {
+		return c, nil
+	}
+	return tcell.ColorDefault, fmt.Errorf("unknown color %q", name)
+}
+
+// apply lays the style over base, keeping what the spec doesn't set.
+func (s themeStyle) apply(base tcell.Style, colors int) tcell.Style {
+	if s.fg != tcell.ColorDefault {
+		base = base.Foreground(fitColor(s.fg, colors))
+	}
+	if s.bg != tcell.ColorDefault {
+		base = base.Background(fitColor(s.bg, colors))
+	}
+	if s.attrs != 0 {
+		_, _, attrs := base.Decompose()
+		base = base.Attributes(attrs | s.attrs)
+	}
+	return base
+}
+
+var colorPalettes = map[int][]tcell.Color{}
+
+// fitColor maps a color to the nearest one the terminal can show. Truecolor
+// terminals get RGB values as they are, others the closest entry of their
+// 256, 16 or 8 color palette.
+func fitColor(c tcell.Color, colors int) tcell.Color {
+	if colors >= 1<<24 || colors <= 0 || c&tcell.ColorSpecial != 0 || !c.Valid() {
+		return c
+	}
+	size := 256
+	switch {
+	case colors < 16:
+		size = 8
+	case colors < 256:
+		size = 16
+	}
+	if !c.IsRGB() && int(c&^tcell.ColorValid) < size {
+		return c
+	}
+
+	palette, ok := colorPalettes[size]
+	if !ok {
+		palette = make([]tcell.Color, size)
+		for i := range palette {
+			palette[i] = tcell.PaletteColor(i)
+		}
+		colorPalettes[size] = palette
+	}
+	return tcell.FindColor(tcell.NewRGBColor(c.RGB()), palette)
+}
+
+// setColors prepares the theme for a terminal with the given color count.
+func (t *Theme) setColors(colors int) {
+	t.colors = colors
+	t.tokens = make(map[chroma.TokenType]tcell.Style)
+	t.styles = make(map[string]themeStyle)
+}
+
+// parsed returns the parsed spec of a UI element.
+func (t *Theme) parsed(key string) themeStyle {
+	if t.styles == nil {
+		t.setColors(1 << 24)
+	}
+	if s, ok := t.styles[key]; ok {
+		return s
+	}
+	var s themeStyle
+	for theme := t; theme != nil; theme = theme.parent {
+		if spec, ok := theme.UI[key]; ok {
+			s, _ = parseStyleSpec(spec)
+			break
+		}
+	}
+	t.styles[key] = s
+	return s
+}
+
+// Style returns the style of a UI element such as "statusbar" or
+// "tree.selected".
+func (t *Theme) Style(key string) tcell.Style {
+	return t.parsed(key).apply(tcell.StyleDefault, t.colors)
+}
+
+// Over lays the style of a UI element over base.
+func (t *Theme) Over(key string, base tcell.Style) tcell.Style {
+	return t.parsed(key).apply(base, t.colors)
+}
+
+// tokenStyle returns the style for a chroma token type on top of the
+// theme's text style.
+func (t *Theme) tokenStyle(tt chroma.TokenType) tcell.Style {
+	if t.tokens == nil {
+		t.setColors(1 << 24)
+	}
+	if style, ok := t.tokens[tt]; ok {
+		return style
+	}
+
+	// A theme's own classes win over more specific ones it inherits, so
+	// defining "string" recolors every kind of string.
+	style := t.Style("text")
+	classes := tokenClasses(tt)
+search:
+	for theme := t; theme != nil; theme = theme.parent {
+		for _, key := range classes {
+			if spec, ok := theme.Syntax[key]; ok {
+				s, _ := parseStyleSpec(spec)
+				style = s.apply(style, t.colors)
+				break search
+			}
+		}
+	}
+	t.tokens[tt] = style
+	return style
+}
+
+// tokenClasses lists the theme classes for a token type, most specific
+// first: LiteralStringEscape -> string.escape, string, literal.
+func tokenClasses(tt chroma.TokenType) []string {
+	var words []string
+	start := 0
+	name := tt.String()
+	for i, r := range name {
+		if i > 0 && unicode.IsUpper(r) {
+			words = append(words, strings.ToLower(name[start:i]))
+			start = i
+		}
+	}
+	words = append(words, strings.ToLower(name[start:]))
+
+	var classes []string
+	for n := len(words); n > 0; n-- {
+		if words[0] == "literal" && n > 1 {
+			classes = append(classes, strings.Join(words[1:n], "."))
+		} else {
+			classes = append(classes, strings.Join(words[:n], "."))
+		}
+	}
+	return classes
+}
+
+// currentTheme returns the active theme, loading the default one the first
+// time it's needed.
+func (e *Editor) currentTheme() *Theme {
+	if e.theme == nil {
+		theme, err := loadTheme("default")
+		if err != nil {
+			// A broken default.toml shouldn't leave the editor without colors
+			copied := *builtinThemes["default"]
+			theme = &copied
+		}
+		theme.setColors(e.screenColors())
+		e.theme = theme
+	}
+	return e.theme
+}
+
+// screenColors is the number of colors the terminal can show.
+func (e *Editor) screenColors() int {
+	if e.screen == nil {
+		return 1 << 24
+	}
+	return e.screen.Colors()
+}
+
+// uiStyle returns the themed style of a UI element.
+func (e *Editor) uiStyle(key string) tcell.Style {
+	return e.currentTheme().Style(key)
+}
+
+// setTheme switches to another theme and redraws with it.
+func (e *Editor) setTheme(name string) error {
+	theme, err := loadTheme(name)
+	if err != nil {
+		return err
+	}
+	theme.setColors(e.screenColors())
+	e.theme = theme
+	e.highlight = nil
+	if e.screen != nil {
+		e.screen.SetStyle(theme.Style("text"))
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package editor
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alecthomas/chroma"
+	"github.com/gdamore/tcell/v2"
+)
+
+func TestParseTOML(t *testing.T) {
+	doc, err := parseTOML(`
+# comment
+title = "kiki" # trailing
+count = 1_000
+ratio = 0.5
+enabled = true
+list = [1, 2,
+  3]
+point = { x = 1, y = "two" }
+
+[ui]
+"tree.selected" = 'on #504945'
+status.bar = "white"
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc["title"] != "kiki" || doc["count"] != int64(1000) || doc["ratio"] != 0.5 || doc["enabled"] != true {
+		t.Errorf("scalars parsed wrong: %v", doc)
+	}
+	if list, ok := doc["list"].([]any); !ok || len(list) != 3 {
+		t.Errorf("array parsed wrong: %v", doc["list"])
+	}
+	if point := doc["point"].(map[string]any); point["y"] != "two" {
+		t.Errorf("inline table parsed wrong: %v", point)
+	}
+	ui := doc["ui"].(map[string]any)
+	if ui["tree.selected"] != "on #504945" || ui["status"].(map[string]any)["bar"] != "white" {
+		t.Errorf("table parsed wrong: %v", ui)
+	}
+
+	if _, err := parseTOML("a = 1\nb = \n"); err == nil || err.Error() != "line 2: missing value" {
+		t.Errorf("expected error on line 2, got %v", err)
+	}
+}
+
+func TestThemeFiles(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", home)
+	dir := filepath.Join(home, "kiki-editor", "themes")
+	os.MkdirAll(dir, 0755)
+	os.WriteFile(filepath.Join(dir, "night.toml"), []byte(`
+inherits = "gruvbox"
+[syntax]
+string = "#00ff00 italic"
+[ui]
+statusbar = "fg:black bg:#ffffff"
+`), 0644)
+	os.WriteFile(filepath.Join(dir, "day.json"), []byte(`{"ui": {"linenumber": "red"}}`), 0644)
+
+	ed := &Editor{}
+	if err := ed.setTheme("night"); err != nil {
+		t.Fatal(err)
+	}
+	theme := ed.currentTheme()
+
+	escape := theme.tokenStyle(chroma.LiteralStringEscape)
+	if fg, _, attrs := escape.Decompose(); fg != tcell.NewHexColor(0x00ff00) || attrs&tcell.AttrItalic == 0 {
+		t.Errorf("string.escape should fall back to the theme's string style")
+	}
+	if fg, _, _ := theme.tokenStyle(chroma.Keyword).Decompose(); fg != tcell.NewHexColor(0xfb4934) {
+		t.Errorf("keyword should be inherited from gruvbox, got %v", fg)
+	}
+	if fg, bg, _ := theme.Style("statusbar").Decompose(); fg != tcell.ColorBlack || bg != tcell.NewHexColor(0xffffff) {
+		t.Errorf("statusbar = %v on %v", fg, bg)
+	}
+
+	if err := ed.setTheme("day"); err != nil {
+		t.Fatal(err)
+	}
+	if fg, _, _ := ed.uiStyle("linenumber").Decompose(); fg != tcell.ColorRed {
+		t.Errorf("json theme not applied")
+	}
+	if fg, _, _ := ed.uiStyle("tree.directory").Decompose(); fg != tcell.ColorYellow {
+		t.Errorf("missing keys should come from the default theme")
+	}
+
+	os.WriteFile(filepath.Join(dir, "bad.toml"), []byte("[ui]\ntext = \"#zzzzzz\"\n"), 0644)
+	if err := ed.setTheme("bad"); err == nil {
+		t.Errorf("expected an error for an invalid color")
+	}
+	if ed.currentTheme().Name != "day" {
+		t.Errorf("failed switch should keep the current theme")
+	}
+}
+
+func TestColorFallback(t *testing.T) {
+	red := tcell.NewHexColor(0xff0000)
+	if got := fitColor(red, 1<<24); got != red {
+		t.Errorf("truecolor should keep RGB colors")
+	}
+	if got := fitColor(red, 256); got.IsRGB() || got != tcell.ColorRed {
+		t.Errorf("256 colors: got %v", got)
+	}
+	if got := fitColor(red, 16); got != tcell.ColorRed {
+		t.Errorf("16 colors: got %v", got)
+	}
+	if got := fitColor(tcell.ColorOrange, 16); int(got&^tcell.ColorValid) >= 16 {
+		t.Errorf("palette colors beyond 16 should be mapped down, got %v", got)
+	}
+}
@@ -0,0 +1,192 @@
+package editor
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// File tree filtering and "reveal current file"
+
+// Upper bound on nodes loaded when a filter has to search the whole tree
+const maxTreeFilterNodes = 20000
+
+func (e *Editor) startTreeFilter() {
+	e.mode = "treefilter"
+	e.commandBuffer = e.treeFilter
+	e.SetStatusMessage(fmt.Sprintf("Filter: %s", e.commandBuffer))
+}
+
+// handleTreeFilterMode narrows the tree as the user types. Enter keeps the
+// filter so the matches can be navigated, Esc (in handleInput) clears it.
+func (e *Editor) handleTreeFilterMode(ev *tcell.EventKey) {
+	switch ev.Key() {
+	case tcell.KeyEnter:
+		e.mode = "normal"
+		e.commandBuffer = ""
+		if e.treeFilter != "" {
+			e.SetStatusMessage(fmt.Sprintf("Filter: %s (Esc to clear)", e.treeFilter))
+		}
+		return
+	case tcell.KeyBackspace, tcell.KeyBackspace2:
+		_, size := utf8.DecodeLastRuneInString(e.commandBuffer)
+		e.commandBuffer = e.commandBuffer[:len(e.commandBuffer)-size]
+	case tcell.KeyRune:
+		e.commandBuffer += string(ev.Rune())
+	default:
+		return
+	}
+	e.setTreeFilter(e.commandBuffer)
+	e.SetStatusMessage(fmt.Sprintf("Filter: %s", e.commandBuffer))
+}
+
+// setTreeFilter shows only nodes whose path (relative to the tree root)
+// contains filter, together with their ancestors, which are expanded.
+// The directories expanded before filtering are put back when it ends.
+func (e *Editor) setTreeFilter(filter string) {
+	e.treeFilter = filter
+	e.treeMatches = nil
+	e.treeSelectedLine = 0
+	if e.fileTree == nil {
+		e.treeExpanded = nil
+		return
+	}
+	if e.treeExpanded == nil {
+		e.treeExpanded = make(map[*FileNode]bool)
+		walkTree(e.fileTree, func(node *FileNode) {
+			if node.expanded {
+				e.treeExpanded[node] = true
+			}
+		})
+	} else {
+		walkTree(e.fileTree, func(node *FileNode) { node.expanded = e.treeExpanded[node] })
+	}
+	if filter == "" {
+		e.treeExpanded = nil
+		return
+	}
+
+	budget := maxTreeFilterNodes
+	e.loadTreeRecursive(e.fileTree, &budget)
+
+	needle := strings.ToLower(filter)
+	e.treeMatches = make(map[*FileNode]bool)
+	var first *FileNode
+
+	var walk func(node *FileNode)
+	walk = func(node *FileNode) {
+		for _, child := range node.children {
+			if child.ignored && !e.showIgnored {
+				continue
+			}
+			rel, err := filepath.Rel(e.fileTree.name, child.name)
+			if err == nil && strings.Contains(strings.ToLower(filepath.ToSlash(rel)), needle) {
+				if first == nil {
+					first = child
+				}
+				e.treeMatches[child] = true
+				for n := child.parent; n != nil && !(e.treeMatches[n] && n.expanded); n = n.parent {
+					e.treeMatches[n] = true
+					n.expanded = true
+				}
+			}
+			walk(child)
+		}
+	}
+	walk(e.fileTree)
+
+	if first == nil {
+		e.SetStatusMessage(fmt.Sprintf("Filter: %s (no matches)", filter))
+		return
+	}
+	e.selectTreeNode(first)
+}
+
+// walkTree calls fn for node and every node loaded below it.
+func walkTree(node *FileNode, fn func(*FileNode)) {
+	fn(node)
+	for _, child := range node.children {
+		walkTree(child, fn)
+	}
+}
+
+// loadTreeRecursive loads every directory below node so a filter can find
+// files in folders that were never expanded. Ignored directories are only
+// descended into when ignored files are shown.
+func (e *Editor) loadTreeRecursive(node *FileNode, budget *int) {
+	if !node.isDir || *budget <= 0 {
+		return
+	}
+	if len(node.children) == 0 {
+		e.loadDirectory(node)
+		*budget -= len(node.children)
+	}
+	for _, child := range node.children {
+		if child.isDir && (!child.ignored || e.showIgnored) {
+			e.loadTreeRecursive(child, budget)
+		}
+	}
+}
+
+// selectTreeNode moves the tree selection to node if it is visible.
+func (e *Editor) selectTreeNode(node *FileNode) bool {
+	for i, row := range e.visibleTreeNodes() {
+		if row.node == node {
+			e.treeSelectedLine = i
+			return true
+		}
+	}
+	return false
+}
+
+// revealFile expands the directories leading to filename and selects it.
+func (e *Editor) revealFile(filename string) {
+	if filename == "" || e.fileTree == nil {
+		e.SetStatusMessage("No file to reveal")
+		return
+	}
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		e.SetStatusMessage(fmt.Sprintf("Error revealing file: %v", err))
+		return
+	}
+	rel, err := filepath.Rel(e.fileTree.name, abs)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		e.SetStatusMessage(fmt.Sprintf("%s is outside the tree root", filepath.Base(filename)))
+		return
+	}
+
+	e.treeVisible = true
+	if e.treeFilter != "" {
+		e.setTreeFilter("")
+	}
+
+	node := e.fileTree
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		if len(node.children) == 0 {
+			e.loadDirectory(node)
+		}
+		node.expanded = true
+
+		var next *FileNode
+		for _, child := range node.children {
+			if filepath.Base(child.name) == part {
+				next = child
+				break
+			}
+		}
+		if next == nil {
+			e.SetStatusMessage(fmt.Sprintf("%s not found in tree", filepath.Base(filename)))
+			return
+		}
+		node = next
+	}
+
+	if node.ignored && !e.showIgnored {
+		e.showIgnored = true
+	}
+	e.selectTreeNode(node)
+}
@@ -0,0 +1,112 @@
+package editor
+
+import (
+	"unicode/utf8"
+
+	"github.com/mattn/go-runewidth"
+	"github.com/rivo/uniseg"
+)
+
+// Characters on screen.
+//
+// The cursor sits between grapheme clusters, what a reader sees as one
+// character: a letter with its combining accents, an emoji with its
+// modifiers, or a CRLF. Each cluster takes one or two screen cells (CJK and
+// most emoji take two), as tcell draws it, except a tab which reaches the
+// next tab stop. Cursor offsets stay byte offsets into the line.
+
+// firstGrapheme splits the first grapheme cluster off s.
+func firstGrapheme(s string) (cluster, rest string) {
+	if len(s) > 0 && s[0] < utf8.RuneSelf && (len(s) == 1 || s[1] < utf8.RuneSelf) && s[0] != '\r' {
+		// Plain ASCII is a cluster per byte
+		return s[:1], s[1:]
+	}
+	cluster, rest, _, _ = uniseg.FirstGraphemeClusterInString(s, -1)
+	return cluster, rest
+}
+
+// nextColumn is the column after cluster when it starts at col.
+func nextColumn(col int, cluster string, tabSize int) int {
+	if cluster == "\t" && tabSize > 0 {
+		return (col/tabSize + 1) * tabSize
+	}
+	return col + clusterWidth(cluster)
+}
+
+// clusterWidth is the number of cells a grapheme cluster takes. Zero-width
+// characters on their own still take a cell, as in tcell.
+func clusterWidth(cluster string) int {
+	if len(cluster) == 1 {
+		return 1
+	}
+	return max(runewidth.StringWidth(cluster), 1)
+}
+
+// displayColumn is the screen column, from the start of the line, of byte
+// offset x.
+func displayColumn(line string, x, tabSize int) int {
+	col, i := 0, 0
+	for rest := line; rest != "" && i < x; {
+		var cluster string
+		cluster, rest = firstGrapheme(rest)
+		col = nextColumn(col, cluster, tabSize)
+		i += len(cluster)
+	}
+	return col
+}
+
+// byteForColumn is the byte offset of the character at screen column col,
+// or the end of the line if it is shorter. A column in the middle of a wide
+// character or a tab is on that character.
+func byteForColumn(line string, col, tabSize int) int {
+	c, i := 0, 0
+	for rest := line; rest != ""; {
+		var cluster string
+		cluster, rest = firstGrapheme(rest)
+		next := nextColumn(c, cluster, tabSize)
+		if next > col {
+			return i
+		}
+		c = next
+		i += len(cluster)
+	}
+	return len(line)
+}
+
+// nextGrapheme is the byte offset of the character after the one at x.
+func nextGrapheme(line string, x int) int {
+	if x >= len(line) {
+		return len(line)
+	}
+	start := graphemeStart(line, x)
+	cluster, _ := firstGrapheme(line[start:])
+	return start + len(cluster)
+}
+
+// prevGrapheme is the byte offset of the character before x.
+func prevGrapheme(line string, x int) int {
+	if x <= 0 {
+		return 0
+	}
+	return graphemeStart(line, min(x, len(line))-1)
+}
+
+// graphemeStart is the start of the character that byte x is part of.
+func graphemeStart(line string, x int) int {
+	// Clusters can't span a break before an ASCII character other than
+	// \n, so the scan can start there
+	from := x
+	for from > 0 && !(line[from] < utf8.RuneSelf && line[from] != '\n') {
+		from--
+	}
+	i := from
+	for rest := line[from:]; rest != ""; {
+		cluster, r := firstGrapheme(rest)
+		if i+len(cluster) > x {
+			return i
+		}
+		i += len(cluster)
+		rest = r
+	}
+	return len(line)
+}
@@ -0,0 +1,106 @@
+package editor
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestGraphemes(t *testing.T) {
+	// Each string is split into what a reader sees as characters
+	tests := []struct {
+		line   string
+		chars  []string
+		widths []int
+	}{
+		{"abc", []string{"a", "b", "c"}, []int{1, 1, 1}},
+		{"e\u0301t\u00e9", []string{"e\u0301", "t", "\u00e9"}, []int{1, 1, 1}},
+		{"日本x", []string{"日", "本", "x"}, []int{2, 2, 1}},
+		{"a👍🏽b", []string{"a", "👍🏽", "b"}, []int{1, 2, 1}},
+		{"👨‍👩‍👧!", []string{"👨‍👩‍👧", "!"}, []int{2, 1}},
+		{"\u0301a", []string{"\u0301", "a"}, []int{1, 1}},
+	}
+	for _, test := range tests {
+		x, col := 0, 0
+		for i, char := range test.chars {
+			next := nextGrapheme(test.line, x)
+			if got := test.line[x:next]; got != char {
+				t.Errorf("%q: character %d is %q, want %q", test.line, i, got, char)
+				break
+			}
+			if got := prevGrapheme(test.line, next); got != x {
+				t.Errorf("%q: back from %d goes to %d, want %d", test.line, next, got, x)
+			}
+			if got := displayColumn(test.line, x, 4); got != col {
+				t.Errorf("%q: character %d at column %d, want %d", test.line, i, got, col)
+			}
+			// Both halves of a wide character click onto it
+			for c := col; c < col+test.widths[i]; c++ {
+				if got := byteForColumn(test.line, c, 4); got != x {
+					t.Errorf("%q: column %d is byte %d, want %d", test.line, c, got, x)
+				}
+			}
+			x, col = next, col+test.widths[i]
+		}
+		if x != len(test.line) {
+			t.Errorf("%q: %d bytes left over", test.line, len(test.line)-x)
+		}
+	}
+}
+
+func TestGraphemeEditing(t *testing.T) {
+	ed := &Editor{mode: "insert", lines: []string{"x日é👍🏽"}}
+	ed.applySettings()
+
+	ed.cursorX = len(ed.lines[0])
+	var stops []int
+	for range 5 {
+		ed.moveCursor(-1, 0)
+		stops = append(stops, ed.cursorColumn())
+	}
+	if want := []int{4, 3, 1, 0, 0}; !slices.Equal(stops, want) {
+		t.Errorf("h stops at columns %v, want %v", stops, want)
+	}
+	ed.moveCursor(2, 0)
+	if ed.cursorX != len("x日") {
+		t.Errorf("l l ends at byte %d", ed.cursorX)
+	}
+
+	ed.cursorX = len(ed.lines[0])
+	ed.backspace()
+	if ed.lines[0] != "x日é" {
+		t.Errorf("backspace left %q", ed.lines[0])
+	}
+	ed.deleteChar()
+	if ed.lines[0] != "x日" || ed.cursorX != len("x日") {
+		t.Errorf("deleteChar left %q at %d", ed.lines[0], ed.cursorX)
+	}
+	ed.insertRune('é')
+	ed.insertRune('!')
+	if ed.lines[0] != "x日é!" || ed.cursorColumn() != 5 {
+		t.Errorf("typed %q, cursor at column %d", ed.lines[0], ed.cursorColumn())
+	}
+
+	// Moving down keeps the screen column past wide characters
+	ed.lines = append(ed.lines, "abcdefgh")
+	ed.cursorX = len("x日")
+	ed.moveCursor(0, 1)
+	if ed.cursorX != 3 {
+		t.Errorf("moved down to byte %d", ed.cursorX)
+	}
+}
+
+func TestCompletionPrefix(t *testing.T) {
+	ed := &Editor{lines: []string{"naïve café cafétéria", "caf"}}
+	ed.cursorY, ed.cursorX = 0, len("naïve café")
+	if ctx := ed.completionContext(); ctx.word != "café" || ctx.wordStart != len("naïve ") {
+		t.Errorf("word before the cursor is %q at %d", ctx.word, ctx.wordStart)
+	}
+
+	words := map[string]*wordInfo{}
+	collectWords(ed.lines, 1, words, -1, -1)
+	for _, word := range []string{"naïve", "café", "cafétéria", "caf"} {
+		if words[word] == nil {
+			t.Errorf("%q not collected from %v", word, words)
+		}
+	}
+}
@@ -0,0 +1,363 @@
+package editor
+
+import (
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// Soft wrapping and horizontal scrolling.
+//
+// With wordWrap a line is laid out over as many screen rows as it needs,
+// breaking after whitespace where it can. Rows after the first are indented
+// like the line with breakIndent and start with showBreak. The top of the
+// window is then a line and one of its rows (scrollY, scrollRow). Without
+// wordWrap each line is one row and scrollX columns are scrolled off to the
+// left, sideScroll at a time.
+
+// wrapRow is one screen row of a line: the bytes start..end, drawn after
+// indent columns of breakIndent and showBreak.
+type wrapRow struct {
+	start, end int
+	indent     int
+}
+
+// textArea is the screen column where text starts and how many columns it
+// has.
+func (e *Editor) textArea() (x, width int) {
+	if e.diff != nil {
+		return e.diffTextArea(-1)
+	}
+	if e.treeVisible {
+		x += e.treeWidth + 1
+	}
+	x += e.blamePanelWidth() + e.gutterWidth()
+	return x, max(1, e.screenWidth-x)
+}
+
+// textHeight is the number of screen rows for text.
+func (e *Editor) textHeight() int {
+	return max(1, e.screenHeight-2)
+}
+
+// lineRows lays out line y in rows of the text area. The summary of a
+// closed fold is one row.
+func (e *Editor) lineRows(y int) []wrapRow {
+	line := e.lines[y]
+	if !e.wordWrap || e.isFoldSummary(y) {
+		return []wrapRow{{0, len(line), 0}}
+	}
+	_, width := e.textArea()
+	return wrapLine(line, width, e.tabSize, e.breakPrefix(line, width))
+}
+
+// breakPrefix is the width of what starts a continuation row of line.
+func (e *Editor) breakPrefix(line string, width int) int {
+	indent := 0
+	if e.boolOption("breakIndent") {
+		rest := strings.TrimLeft(line, " \t")
+		indent = displayColumn(line, len(line)-len(rest), e.tabSize)
+	}
+	indent += stringWidth(e.option("showBreak"))
+	// Leave at least half the row for text
+	if indent > width/2 {
+		return 0
+	}
+	return indent
+}
+
+// stringWidth is the number of cells s takes on screen.
+func stringWidth(s string) int {
+	width := 0
+	for s != "" {
+		var cluster string
+		cluster, s = firstGrapheme(s)
+		width += clusterWidth(cluster)
+	}
+	return width
+}
+
+// wrapLine splits line into rows of width columns, continuation rows
+// starting after prefix columns.
+func wrapLine(line string, width, tabSize, prefix int) []wrapRow {
+	var rows []wrapRow
+	row := wrapRow{}
+	col := 0
+	lastBreak := -1 // after the last whitespace in the row
+	for x, rest := 0, line; rest != ""; {
+		cluster, r := firstGrapheme(rest)
+		blank := cluster == " " || cluster == "\t"
+		next := nextColumn(col, cluster, tabSize)
+		// Whitespace may hang past the edge rather than start a row
+		if next > width && !blank && col > row.indent {
+			end := x
+			if lastBreak > row.start {
+				end = lastBreak
+			}
+			row.end = end
+			rows = append(rows, row)
+			row = wrapRow{start: end, indent: prefix}
+			col, lastBreak = prefix, -1
+			x, rest = end, line[end:]
+			continue
+		}
+		col = next
+		x += len(cluster)
+		rest = r
+		if blank {
+			lastBreak = x
+		}
+	}
+	row.end = len(line)
+	return append(rows, row)
+}
+
+// rowOf is the index of the row that byte x is on.
+func rowOf(rows []wrapRow, x int) int {
+	for i := len(rows) - 1; i > 0; i-- {
+		if x >= rows[i].start {
+			return i
+		}
+	}
+	return 0
+}
+
+// rowColumn is the screen column of byte x in row, counted from the left
+// of the text area.
+func rowColumn(line string, row wrapRow, x, tabSize int) int {
+	col := row.indent
+	for i, rest := row.start, line[row.start:row.end]; rest != "" && i < x; {
+		var cluster string
+		cluster, rest = firstGrapheme(rest)
+		col = nextColumn(col, cluster, tabSize)
+		i += len(cluster)
+	}
+	return col
+}
+
+// rowByte is the byte offset of the character at screen column col in row.
+func rowByte(line string, row wrapRow, col, tabSize int) int {
+	c := row.indent
+	for i, rest := row.start, line[row.start:row.end]; rest != ""; {
+		var cluster string
+		cluster, rest = firstGrapheme(rest)
+		next := nextColumn(c, cluster, tabSize)
+		if next > col {
+			return i
+		}
+		c = next
+		i += len(cluster)
+	}
+	return row.end
+}
+
+// screenPosition is where byte x of line y is drawn, if it's in view.
+func (e *Editor) screenPosition(y, x int) (sx, sy int, ok bool) {
+	if e.diff != nil {
+		return e.diffScreenPosition(y, x)
+	}
+	if y < e.scrollY || y >= len(e.lines) {
+		return 0, 0, false
+	}
+	left, width := e.textArea()
+	y = e.visibleLine(y)
+	line := e.lines[y]
+	x = min(x, len(line))
+	if e.isFoldSummary(y) {
+		x = 0
+	}
+
+	sy = -e.scrollRow
+	for i := e.scrollY; i < y && sy < e.textHeight(); i = e.nextLine(i) {
+		sy += len(e.lineRows(i))
+	}
+	if !e.wordWrap {
+		col := displayColumn(line, x, e.tabSize) - e.scrollX
+		return left + col, sy, sy < e.textHeight() && col >= 0 && col < width
+	}
+	rows := e.lineRows(y)
+	r := rowOf(rows, x)
+	sy += r
+	col := min(rowColumn(line, rows[r], x, e.tabSize), width-1)
+	return left + col, sy, sy >= 0 && sy < e.textHeight()
+}
+
+// screenLine is the line and byte offset drawn at screen row sy and text
+// area column col, for mouse clicks.
+func (e *Editor) screenLine(sy, col int) (y, x int, ok bool) {
+	row := e.scrollRow + sy
+	for y = e.scrollY; y < len(e.lines); y = e.nextLine(y) {
+		rows := e.lineRows(y)
+		if row >= len(rows) {
+			row -= len(rows)
+			continue
+		}
+		switch {
+		case e.isFoldSummary(y):
+			return y, 0, true
+		case !e.wordWrap:
+			return y, byteForColumn(e.lines[y], col+e.scrollX, e.tabSize), true
+		}
+		return y, rowByte(e.lines[y], rows[row], col, e.tabSize), true
+	}
+	return 0, 0, false
+}
+
+// keepCursorVisible scrolls as little as it can to bring the cursor into
+// view.
+func (e *Editor) keepCursorVisible() {
+	if e.cursorY >= len(e.lines) {
+		return
+	}
+	if e.wordWrap {
+		e.scrollX = 0
+	} else {
+		e.scrollRow = 0
+		e.sideScrollToCursor()
+	}
+	e.scrollY = e.visibleLine(e.scrollY)
+
+	y := e.visibleLine(e.cursorY)
+	row := rowOf(e.lineRows(y), e.cursorX)
+	if y < e.scrollY || (y == e.scrollY && row < e.scrollRow) {
+		e.scrollY, e.scrollRow = y, row
+		return
+	}
+	if _, _, ok := e.screenPosition(e.cursorY, e.cursorX); ok {
+		return
+	}
+	// Count back height-1 rows from the cursor for the new top
+	for n := e.textHeight() - 1; n > 0; n-- {
+		if row > 0 {
+			row--
+		} else if prev := e.prevLine(y); prev >= 0 {
+			y = prev
+			row = len(e.lineRows(y)) - 1
+		} else {
+			break
+		}
+	}
+	e.scrollY, e.scrollRow = y, row
+}
+
+// sideScrollToCursor scrolls sideways so the cursor column is in view,
+// by sideScroll columns at least, or to the middle when it's 0.
+func (e *Editor) sideScrollToCursor() {
+	_, width := e.textArea()
+	col := e.cursorColumn()
+	step := e.intOption("sideScroll")
+	switch {
+	case col < e.scrollX:
+		if step == 0 {
+			e.scrollX = col - width/2
+		} else {
+			e.scrollX = min(col, e.scrollX-step)
+		}
+	case col >= e.scrollX+width:
+		if step == 0 {
+			e.scrollX = col - width/2
+		} else {
+			e.scrollX = max(col-width+1, e.scrollX+step)
+		}
+	}
+	e.scrollX = max(0, e.scrollX)
+}
+
+// scrollRows scrolls the window n screen rows, down if n is positive.
+func (e *Editor) scrollRows(n int) {
+	e.scrollY = e.visibleLine(e.scrollY)
+	for ; n > 0; n-- {
+		if e.scrollRow+1 < len(e.lineRows(e.scrollY)) {
+			e.scrollRow++
+		} else if next := e.nextLine(e.scrollY); next < len(e.lines) {
+			e.scrollY, e.scrollRow = next, 0
+		}
+	}
+	for ; n < 0; n++ {
+		if e.scrollRow > 0 {
+			e.scrollRow--
+		} else if prev := e.prevLine(e.scrollY); prev >= 0 {
+			e.scrollY = prev
+			e.scrollRow = len(e.lineRows(e.scrollY)) - 1
+		}
+	}
+}
+
+// moveDisplayRow moves the cursor up or down a screen row, which is a
+// line unless wrapping, keeping its screen column (gj and gk).
+func (e *Editor) moveDisplayRow(dir int) {
+	if !e.wordWrap {
+		e.moveCursor(0, dir)
+		return
+	}
+	rows := e.lineRows(e.cursorY)
+	r := rowOf(rows, e.cursorX)
+	col := rowColumn(e.lines[e.cursorY], rows[r], e.cursorX, e.tabSize)
+	y := e.cursorY
+	switch next, prev := e.nextLine(y), e.prevLine(y); {
+	case r+dir >= 0 && r+dir < len(rows):
+		r += dir
+	case dir > 0 && next < len(e.lines):
+		y, r = next, 0
+		rows = e.lineRows(y)
+	case dir < 0 && prev >= 0:
+		y = prev
+		rows = e.lineRows(y)
+		r = len(rows) - 1
+	default:
+		return
+	}
+	e.cursorY = y
+	e.cursorX = rowByte(e.lines[y], rows[r], col, e.tabSize)
+	e.keepCursorVisible()
+}
+
+// drawRow draws one row of line y at screen row sy.
+func (e *Editor) drawRow(line string, row wrapRow, styles []tcell.Style, sy int) {
+	left, width := e.textArea()
+	textStyle := e.uiStyle("text")
+	scrollX := e.scrollX
+	if e.wordWrap {
+		scrollX = 0
+	}
+
+	// Continuation rows start with the indent and showBreak
+	col := 0
+	if row.start > 0 && row.indent > 0 {
+		showBreak := e.option("showBreak")
+		col = row.indent - stringWidth(showBreak)
+		drawText(e.screen, left+col, sy, e.uiStyle("linenumber"), showBreak)
+		col = row.indent
+	}
+
+	for x, rest := row.start, line[row.start:row.end]; rest != ""; {
+		var cluster string
+		cluster, rest = firstGrapheme(rest)
+		style := textStyle
+		if x < len(styles) {
+			style = styles[x]
+		}
+		next := nextColumn(col, cluster, e.tabSize)
+		start, end := col-scrollX, next-scrollX
+		x += len(cluster)
+		col = next
+		if end <= 0 {
+			continue
+		}
+		if start >= width {
+			break
+		}
+		// Tabs, and wide characters cut off at either edge, are drawn as
+		// spaces
+		if cluster == "\t" || start < 0 || end > width {
+			for c := max(start, 0); c < min(end, width); c++ {
+				e.screen.SetContent(left+c, sy, ' ', nil, style)
+			}
+		} else if len(cluster) == 1 {
+			e.screen.SetContent(left+start, sy, rune(cluster[0]), nil, style)
+		} else {
+			runes := []rune(cluster)
+			e.screen.SetContent(left+start, sy, runes[0], runes[1:], style)
+		}
+	}
+}
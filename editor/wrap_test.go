@@ -0,0 +1,138 @@
+package editor
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestWrapLine(t *testing.T) {
+	rowText := func(line string, rows []wrapRow) string {
+		text := ""
+		for i, row := range rows {
+			if i > 0 {
+				text += "|"
+			}
+			text += line[row.start:row.end]
+		}
+		return text
+	}
+	tests := []struct {
+		line   string
+		width  int
+		prefix int
+		want   string
+	}{
+		{"short", 10, 0, "short"},
+		{"the quick brown fox", 10, 0, "the quick |brown fox"},
+		{"aaaaaaaaaaaaaaa", 10, 0, "aaaaaaaaaa|aaaaa"},
+		{"a b c d e f g h", 6, 2, "a b c |d e |f g |h"},
+		// Spaces hang at the edge instead of starting a row
+		{"abcdefghij   k", 10, 0, "abcdefghij   |k"},
+		{"日本語の文章です", 10, 0, "日本語の文|章です"},
+	}
+	for _, test := range tests {
+		rows := wrapLine(test.line, test.width, 4, test.prefix)
+		if got := rowText(test.line, rows); got != test.want {
+			t.Errorf("wrapLine(%q, %d) = %q, want %q", test.line, test.width, got, test.want)
+		}
+		for _, row := range rows[1:] {
+			if row.indent != test.prefix {
+				t.Errorf("%q: continuation indent %d", test.line, row.indent)
+			}
+		}
+	}
+}
+
+func TestWrapScrolling(t *testing.T) {
+	ed := &Editor{
+		screenWidth:  20,
+		screenHeight: 5, // three rows of text
+		lines: []string{
+			"  one two three four five six",
+			"x",
+			"y",
+		},
+		settings: map[string]string{"wordWrap": "true", "showLineNumbers": "false", "foldColumn": "false", "showBreak": "> "},
+	}
+	ed.applySettings()
+
+	// Continuation rows are indented by the line's indent and showBreak
+	rows := ed.lineRows(0)
+	if len(rows) != 2 || rows[1].indent != 4 {
+		t.Fatalf("rows = %v", rows)
+	}
+	ed.cursorX = len(ed.lines[0])
+	x, y, ok := ed.screenPosition(0, ed.cursorX)
+	if !ok || y != 1 || x != 4+len("five six") {
+		t.Errorf("cursor at %d,%d (%v)", x, y, ok)
+	}
+
+	// gj goes to the next screen row, keeping the column
+	ed.cursorX = len("  one")
+	ed.moveDisplayRow(1)
+	if ed.cursorY != 0 || ed.lines[0][ed.cursorX:] != "ive six" {
+		t.Errorf("gj went to %d:%q", ed.cursorY, ed.lines[0][ed.cursorX:])
+	}
+	ed.moveDisplayRow(1)
+	ed.moveDisplayRow(1)
+	if ed.cursorY != 2 || ed.scrollY != 0 || ed.scrollRow != 1 {
+		t.Errorf("gj gj: line %d, top %d/%d", ed.cursorY, ed.scrollY, ed.scrollRow)
+	}
+	ed.moveDisplayRow(-1)
+	ed.moveDisplayRow(-1)
+	if ed.cursorY != 0 || ed.scrollRow != 1 {
+		t.Errorf("gk gk: line %d, top %d/%d", ed.cursorY, ed.scrollY, ed.scrollRow)
+	}
+	ed.moveDisplayRow(-1)
+	if ed.scrollRow != 0 {
+		t.Errorf("gk to the first row left the top at row %d", ed.scrollRow)
+	}
+
+	// A click on the second row lands in the line's wrapped part
+	if y, x, ok := ed.screenLine(1, 4); !ok || y != 0 || ed.lines[0][x:] != "five six" {
+		t.Errorf("click on row 1: %d:%d", y, x)
+	}
+	if y, _, ok := ed.screenLine(2, 0); !ok || y != 1 {
+		t.Errorf("click on row 2: line %d", y)
+	}
+
+	ed.scrollRows(2)
+	if ed.scrollY != 1 || ed.scrollRow != 0 {
+		t.Errorf("scrolled to %d/%d", ed.scrollY, ed.scrollRow)
+	}
+	ed.scrollRows(-1)
+	if ed.scrollY != 0 || ed.scrollRow != 1 {
+		t.Errorf("scrolled back to %d/%d", ed.scrollY, ed.scrollRow)
+	}
+}
+
+func TestSideScroll(t *testing.T) {
+	for _, test := range []struct {
+		sideScroll string
+		want       []int
+	}{
+		{"0", []int{0, 15, 15, 5}},
+		{"1", []int{0, 11, 12, 10}},
+		{"5", []int{0, 11, 16, 10}},
+	} {
+		ed := &Editor{
+			screenWidth:  10,
+			screenHeight: 5,
+			lines:        []string{"0123456789abcdefghijklmnopqrstuvwxyz"},
+			settings:     map[string]string{"showLineNumbers": "false", "foldColumn": "false", "sideScroll": test.sideScroll},
+		}
+		ed.applySettings()
+		var got []int
+		for _, x := range []int{9, 20, 21, 10} {
+			ed.cursorX = x
+			ed.keepCursorVisible()
+			got = append(got, ed.scrollX)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(test.want) {
+			t.Errorf("sideScroll=%s scrolled to %v, want %v", test.sideScroll, got, test.want)
+		}
+		if sx, _, ok := ed.screenPosition(0, 10); !ok || sx != 10-ed.scrollX {
+			t.Errorf("sideScroll=%s: cursor drawn at %d", test.sideScroll, sx)
+		}
+	}
+}
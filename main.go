@@ -14,6 +14,8 @@ func main() {
 	debug := flag.Bool("debug", false, "Enable debug mode")
 	version := flag.Bool("version", false, "Show version")
 	help := flag.Bool("help", false, "Show help")
+	diff := flag.Bool("d", false, "Show two files side by side with their differences")
+	merge := flag.Bool("merge", false, "Resolve a merge as git mergetool: LOCAL BASE REMOTE MERGED")
 	flag.Parse()
 
 	if *version {
@@ -26,6 +28,15 @@ func main() {
 		return
 	}
 
+	if *diff && flag.NArg() != 2 {
+		fmt.Fprintln(os.Stderr, "Usage: kiki-editor -d <file> <file>")
+		os.Exit(2)
+	}
+	if *merge && flag.NArg() != 4 {
+		fmt.Fprintln(os.Stderr, "Usage: kiki-editor -merge <LOCAL> <BASE> <REMOTE> <MERGED>")
+		os.Exit(2)
+	}
+
 	ed, err := editor.NewEditor()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error creating editor: %v\n", err)
@@ -38,7 +49,15 @@ func main() {
 
 	// If a file path is provided, open it
 	args := flag.Args()
-	if len(args) > 0 {
+	if *diff {
+		if err := ed.DiffFiles(args[0], args[1]); err != nil {
+			ed.SetStatusMessage(fmt.Sprintf("Error loading file: %v", err))
+		}
+	} else if *merge {
+		if err := ed.MergeFiles(args[0], args[1], args[2], args[3]); err != nil {
+			ed.SetStatusMessage(fmt.Sprintf("Error loading file: %v", err))
+		}
+	} else if len(args) > 0 {
 		ed.SetFilename(args[0])
 		if err := ed.LoadFile(args[0]); err != nil {
 			ed.SetStatusMessage(fmt.Sprintf("Error loading file: %v", err))
@@ -49,14 +68,22 @@ func main() {
 
 	// Run the editor (will show file tree by default)
 	ed.Run()
+	if code := ed.ExitCode(); code != 0 {
+		os.Exit(code)
+	}
 }
 
 func printHelp() {
 	fmt.Println(`Kiki's Text Editor
 
 Usage: kiki-editor [options] [file]
+       kiki-editor -d <file> <file>
+       kiki-editor -merge <LOCAL> <BASE> <REMOTE> <MERGED>
 
 Options:
+  -d          Show two files side by side with their differences
+  -merge      Resolve a merge as git mergetool, exiting with 1 if
+              conflicts are left
   --debug     Enable debug mode
   --version   Show version
   --help      Show this help message